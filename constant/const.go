@@ -25,6 +25,14 @@ const (
 	// StreamerConfig, used in case the property is 0 (e.g. when undefined).
 	DefaultWorkerCount = 2
 
+	// DefaultScaleUpThreshold is used as the default for the ScaleUpThreshold property of
+	// StreamerConfig, used in case the property is <= 0 (e.g. when undefined) while autoscaling is enabled.
+	DefaultScaleUpThreshold = 0.75
+
+	// DefaultScaleDownCooldown is used as the default for the ScaleDownCooldown property of
+	// StreamerConfig, used in case the property is <= 0 (e.g. when undefined) while autoscaling is enabled.
+	DefaultScaleDownCooldown = 30 * time.Second
+
 	// DefaultMaxRetries is used as the default for the MaxRetries property of
 	// the StorageClientConfig, used in case the property is 0 (e.g. when undefined).
 	DefaultMaxRetries = 3
@@ -51,6 +59,35 @@ const (
 	// will collect prior to writing it to BQ. Used in case the property is 0 (e.g. when undefined).
 	DefaultBatchSize = 200
 
+	// DefaultDedupWindow is used as the default for the Window property of the DedupConfig,
+	// used in case no value was defined.
+	DefaultDedupWindow = time.Minute
+
+	// DefaultDedupMaxKeys is used as the default for the MaxKeys property of the DedupConfig,
+	// used in case no value was defined.
+	DefaultDedupMaxKeys = 100000
+
+	// DefaultMinAdaptiveBatchSize is used as the default for the MinBatchSize property
+	// of the AdaptiveBatchSizeConfig, used in case no value was defined.
+	DefaultMinAdaptiveBatchSize = 10
+
+	// DefaultMaxAdaptiveBatchSize is used as the default for the MaxBatchSize property
+	// of the AdaptiveBatchSizeConfig, used in case no value was defined.
+	//
+	// Default based on the maximum rows per request recommended in https://cloud.google.com/bigquery/quotas#streaming_inserts.
+	DefaultMaxAdaptiveBatchSize = 500
+
+	// DefaultTargetFlushLatency is used as the default for the TargetFlushLatency property
+	// of the AdaptiveBatchSizeConfig, used in case no value was defined.
+	DefaultTargetFlushLatency = time.Second
+
+	// DefaultMaxAdaptiveBatchBytes is used as the default for the MaxBatchBytes property
+	// of the AdaptiveBatchSizeConfig, used in case no value was defined.
+	//
+	// Default is half of the maximum HTTP request size of the insertAll API,
+	// as the size of a batch can only be estimated.
+	DefaultMaxAdaptiveBatchBytes = 5 * 1024 * 1024
+
 	// DefaultMaxBatchDelay defines the max amount of time a worker batches rows, prior to writing the batched rows,
 	// even when not yet full. Used in case the property is 0 (e.g. when undefined).
 	DefaultMaxBatchDelay = 5 * time.Second
@@ -68,4 +105,28 @@ const (
 	// that the BatchClient uses.
 	// Used when WriteDisposition is "" (e.g. when undefined)
 	DefaultWriteDisposition = bigquery.WriteAppend
+
+	// DefaultCompression defines the default Compression used by the BatchClient
+	// for the blocks of the Avro files it writes.
+	// Used when Compression is "" (e.g. when undefined)
+	DefaultCompression = bigquery.Snappy
+
+	// DefaultBatchLoadSize defines the amount of rows a worker of the BatchClient
+	// buffers, prior to loading the buffered rows into BigQuery as a single file.
+	// Used in case the BatchSize property is 0 (e.g. when undefined).
+	DefaultBatchLoadSize = 10000
+
+	// DefaultMaxInFlightLoadJobs defines the maximum amount of load jobs of the BatchClient
+	// which can be in flight at once for a streamer, shared by all its workers.
+	// Used in case the MaxInFlightJobs property is 0 (e.g. when undefined).
+	DefaultMaxInFlightLoadJobs = 4
+
+	// DefaultJobIDPrefix defines the prefix of the IDs of the load jobs of the BatchClient,
+	// in case these are derived from the loaded files as part of idempotent loads.
+	// Used when JobIDPrefix is "" (e.g. when undefined)
+	DefaultJobIDPrefix = "bqwriter_"
+
+	// DefaultStagingPrefix defines the prefix of the names of the objects staged by the BatchClient.
+	// Used when Prefix is "" (e.g. when undefined)
+	DefaultStagingPrefix = "bqwriter/"
 )
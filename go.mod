@@ -1,6 +1,6 @@
 module github.com/OTA-Insight/bqwriter
 
-go 1.15
+go 1.18
 
 require (
 	cloud.google.com/go/bigquery v1.24.0
@@ -8,6 +8,15 @@ require (
 	google.golang.org/grpc v1.42.0
 )
 
+require (
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/google/go-cmp v0.5.6 // indirect
+	go.opencensus.io v0.23.0 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+)
+
 require (
 	cloud.google.com/go v0.97.0
 	github.com/census-instrumentation/opencensus-proto v0.3.0 // indirect
@@ -19,7 +28,7 @@ require (
 	golang.org/x/net v0.0.0-20211111160137-58aab5ef257a // indirect
 	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8 // indirect
 	golang.org/x/sys v0.0.0-20211110154304-99a53858aa08 // indirect
-	google.golang.org/api v0.60.0 // indirect
+	google.golang.org/api v0.60.0
 	google.golang.org/genproto v0.0.0-20211111162719-482062a4217b // indirect
 	google.golang.org/protobuf v1.27.1
 )
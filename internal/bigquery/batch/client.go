@@ -15,12 +15,25 @@
 package batch
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"sync"
+	"time"
 
+	"github.com/OTA-Insight/bqwriter/constant"
+	"github.com/OTA-Insight/bqwriter/internal"
+	bqbase "github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/batch/encoding"
 	"github.com/OTA-Insight/bqwriter/log"
+	"github.com/OTA-Insight/bqwriter/objectstore"
 
 	"cloud.google.com/go/bigquery"
 )
@@ -29,12 +42,92 @@ var (
 	errCouldNotConvertReader = errors.New("BQ batch client: could not convert data into io.Reader")
 )
 
+// LoadOptions defines the (source format specific) options of the load jobs of a Client.
+type LoadOptions struct {
+	// Staging defines how the files are staged as objects prior to loading them, if defined,
+	// rather than uploading them inline as part of the load job.
+	Staging *StagingOptions
+	// CSVOptions are the options used to load CSV files, if defined.
+	CSVOptions *bigquery.CSVOptions
+	// ParquetOptions are the options used to load Parquet files, if defined.
+	ParquetOptions *bigquery.ParquetOptions
+	// IgnoreAvroLogicalTypes ignores the logical types of Avro files (read from an io.Reader),
+	// loading the values as their underlying types instead. The Avro files written by
+	// the row writer of the client always use the logical types.
+	IgnoreAvroLogicalTypes bool
+	// DecimalTargetTypes defines the types Avro and Parquet decimal values are converted to, if defined.
+	DecimalTargetTypes []bigquery.DecimalTargetType
+
+	// CreateDisposition defines whether the load jobs can create the table, if defined.
+	CreateDisposition bigquery.TableCreateDisposition
+	// TimePartitioning, RangePartitioning and Clustering define the partitioning
+	// and clustering of the table, if defined.
+	TimePartitioning  *bigquery.TimePartitioning
+	RangePartitioning *bigquery.RangePartitioning
+	Clustering        *bigquery.Clustering
+	// SchemaUpdateOptions defines how the load jobs can update the schema of the table, if defined.
+	SchemaUpdateOptions []string
+	// DestinationEncryptionConfig defines the encryption of the table, if defined.
+	DestinationEncryptionConfig *bigquery.EncryptionConfig
+	// Labels are attached to each load job, if defined.
+	Labels map[string]string
+	// MaxBadRecords defines the maximum amount of bad records a load job can skip.
+	MaxBadRecords int64
+	// JobIDPrefix is used as the prefix of the (otherwise random) IDs of the load jobs, if defined.
+	JobIDPrefix string
+	// IdempotentLoads derives the IDs of the load jobs from the load key of a reader (see loadKeyer),
+	// or the content of the file otherwise, such that the same file is loaded only once,
+	// retrying transient failures to submit a load job.
+	IdempotentLoads bool
+	// MaxRetryDeadlineOffset is the max amount of time transient failures of a job are retried,
+	// defaults to constant.DefaultMaxRetryDeadlineOffset if 0.
+	MaxRetryDeadlineOffset time.Duration
+	// Ordered awaits the load jobs of the client in flight prior to submitting a new one,
+	// such that the files of the client are loaded in the order they were written,
+	// with a flush awaiting all jobs of the client in flight as well.
+	Ordered bool
+}
+
+// loadKeyer can be implemented by an io.Reader in order to define the key used to derive the ID
+// of its load job, in case idempotent loads are enabled, rather than the digest of its content.
+type loadKeyer interface {
+	io.Reader
+	LoadKey() string
+}
+
+// StagingOptions defines how the files loaded by a Client are staged as objects.
+type StagingOptions struct {
+	// Store is the object store the files are staged in.
+	Store objectstore.ObjectStore
+	// Prefix is prepended to the names of the staged objects.
+	Prefix string
+	// DeleteAfterLoad deletes the staged objects once they are loaded successfully,
+	// objects which failed to load are kept either way.
+	DeleteAfterLoad bool
+}
+
+// stagedFileExtensions defines the extensions of the names of staged objects, per source format.
+var stagedFileExtensions = map[bigquery.DataFormat]string{
+	bigquery.Avro:    ".avro",
+	bigquery.CSV:     ".csv",
+	bigquery.JSON:    ".json",
+	bigquery.ORC:     ".orc",
+	bigquery.Parquet: ".parquet",
+}
+
 // Client implements the standard/official BQ (cloud) Client,
-// using the regular insertAll API with retry logic added on top of that. By default
-// the workers will also batch its received rows rather than writing them one by one,
-// this can be disabled by setting the batchSize value to the value of 1.
+// using the batch (load) API in order to load the data as files into BigQuery.
+//
+// Data which is an io.Reader is loaded as-is, as a single file. In case a RowWriter is defined,
+// any other data is written as a row into a buffered file instead, which is loaded once
+// the batch size is reached or when the client is flushed.
+//
+// Load jobs are submitted asynchronously, bounded by the JobLimiter of the client, and awaited in the background,
+// with failed jobs being logged as well as returned by the next Flush. Only Close waits for all jobs in flight,
+// as well as Flush in case the loads are ordered, such that a periodic flush does not block the worker.
 type Client struct {
-	client *bigquery.Client
+	client      *bigquery.Client
+	closeClient func() error
 
 	dataSetID string
 	tableID   string
@@ -43,31 +136,78 @@ type Client struct {
 	sourceFormat        bigquery.DataFormat
 	ignoreUnknownValues bool
 	writeDisposition    bigquery.TableWriteDisposition
+	options             LoadOptions
+
+	newRowWriter encoding.NewRowWriterFunc
+	batchSize    int
+	buffer       bytes.Buffer
+	rowWriter    encoding.RowWriter
+	rowCount     int
+
+	jobs       *JobLimiter
+	jobsWg     sync.WaitGroup
+	failuresMu sync.Mutex
+	failures   jobFailures
+
+	// throttler is waited on prior to each load, nil in case the client is not throttled
+	throttler bqbase.Throttler
+
+	// load the file (data) read from the given reader into the BigQuery table, where encoded is true
+	// in case the file was written by the row writer, defined as a property to allow it to be swapped out in tests
+	load func(ctx context.Context, reader io.Reader, encoded bool) error
+	// run the loader, returning its submitted job,
+	// defined as a property to allow it to be swapped out in tests
+	run func(ctx context.Context, loader *bigquery.Loader) (loadJob, error)
+	// lookup the existing job with the given ID,
+	// defined as a property to allow it to be swapped out in tests
+	lookupJob func(ctx context.Context, id string) (loadJob, error)
+	// initialRetryDelay of the retryer of load jobs,
+	// defined as a property to allow it to be shortened in tests
+	initialRetryDelay time.Duration
 
 	logger log.Logger
 }
 
-// NewClient creates a new Client.
-func NewClient(projectID, dataSetID, tableID string, ignoreUnknownValues bool, sourceFormat bigquery.DataFormat, writeDisposition bigquery.TableWriteDisposition, schema *bigquery.Schema, logger log.Logger) (*Client, error) {
+// NewClient creates a new Client. The JobLimiter bounds the amount of load jobs in flight and can be shared
+// with other clients, a nil JobLimiter limits the client to a single load job in flight at once.
+//
+// In case a pool is defined, the BigQuery client of the pool is used (and released once closed),
+// rather than creating a new BigQuery client.
+func NewClient(projectID, dataSetID, tableID string, ignoreUnknownValues bool, sourceFormat bigquery.DataFormat, writeDisposition bigquery.TableWriteDisposition, schema *bigquery.Schema, options LoadOptions, newRowWriter encoding.NewRowWriterFunc, batchSize int, jobs *JobLimiter, pool bqbase.ClientPool, logger log.Logger) (*Client, error) {
 	// NOTE: we are using the background Context,
 	// as to ensure that we can always write to the client,
 	// even when the actual parent context is already done.
 	// This is a requirement given the streamer will batch its rows.
-	client, err := bigquery.NewClient(context.Background(), projectID)
+	client, closeClient, err := bqbase.NewBigQueryClient(context.Background(), pool, projectID)
 	if err != nil {
 		return nil, fmt.Errorf("BQ batch client: creation failed: %w", err)
 	}
 
-	return newClient(
+	bqc, err := newClient(
 		client, dataSetID, tableID,
 		ignoreUnknownValues,
 		sourceFormat, writeDisposition,
-		schema, logger,
+		schema, options,
+		newRowWriter, batchSize,
+		jobs,
+		logger,
 	)
+	if err != nil {
+		if err := closeClient(); err != nil {
+			logger.Errorf("BQ batch client: close BQ client of client that failed to be created: %v", err)
+		}
+		return nil, err
+	}
+	bqc.closeClient = closeClient
+	return bqc, nil
 }
-func newClient(client *bigquery.Client, dataSetID, tableID string, ignoreUnknownValues bool, sourceFormat bigquery.DataFormat, writeDisposition bigquery.TableWriteDisposition, schema *bigquery.Schema, logger log.Logger) (*Client, error) {
-	return &Client{
-		client: client,
+func newClient(client *bigquery.Client, dataSetID, tableID string, ignoreUnknownValues bool, sourceFormat bigquery.DataFormat, writeDisposition bigquery.TableWriteDisposition, schema *bigquery.Schema, options LoadOptions, newRowWriter encoding.NewRowWriterFunc, batchSize int, jobs *JobLimiter, logger log.Logger) (*Client, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("BQ batch client: validate batchSize: %w: %d", internal.ErrInvalidParam, batchSize)
+	}
+	bqc := &Client{
+		client:      client,
+		closeClient: client.Close,
 
 		dataSetID: dataSetID,
 		tableID:   tableID,
@@ -76,65 +216,461 @@ func newClient(client *bigquery.Client, dataSetID, tableID string, ignoreUnknown
 		sourceFormat:        sourceFormat,
 		ignoreUnknownValues: ignoreUnknownValues,
 		writeDisposition:    writeDisposition,
+		options:             options,
+
+		newRowWriter: newRowWriter,
+		batchSize:    batchSize,
+
+		jobs: jobs,
 
 		logger: logger,
-	}, nil
+	}
+	if bqc.jobs == nil {
+		bqc.jobs = NewJobLimiter(1)
+	}
+	if options.Staging != nil && options.Staging.Store == nil {
+		return nil, fmt.Errorf("BQ batch client: validate staging options: %w: missing object store", internal.ErrInvalidParam)
+	}
+	bqc.load = bqc.loadReader
+	if bqc.options.MaxRetryDeadlineOffset == 0 {
+		bqc.options.MaxRetryDeadlineOffset = constant.DefaultMaxRetryDeadlineOffset
+	}
+	bqc.run = bqc.runLoader
+	bqc.lookupJob = bqc.lookupExistingJob
+	bqc.initialRetryDelay = constant.DefaultInitialRetryDelay
+	return bqc, nil
 }
 
 // Put implements bqClient::Put
 func (bqc *Client) Put(data interface{}) (bool, error) {
-	reader, ok := data.(io.Reader)
-	if !ok {
+	if reader, ok := data.(io.Reader); ok {
+		// load the buffered rows first, as to respect the order in which the data was received
+		if err := bqc.flushRows(); err != nil {
+			return false, err
+		}
+		// the rows of a reader are unknown, so it is throttled as a single row
+		size := 0
+		if sized, ok := reader.(interface{ Len() int }); ok {
+			size = sized.Len()
+		}
+		bqc.throttle(1, size)
+		if err := bqc.load(context.Background(), reader, false); err != nil {
+			return false, err
+		}
+		// We flush every time when we load a reader.
+		return true, nil
+	}
+
+	if bqc.newRowWriter == nil {
 		return false, errCouldNotConvertReader
 	}
+	if bqc.rowWriter == nil {
+		rowWriter, err := bqc.newRowWriter(&bqc.buffer)
+		if err != nil {
+			return false, fmt.Errorf("BQ batch client: create row writer: %w", err)
+		}
+		bqc.rowWriter = rowWriter
+	}
+	if err := bqc.rowWriter.WriteRow(data); err != nil {
+		return false, fmt.Errorf("BQ batch client: write row: %w", err)
+	}
+	bqc.rowCount++
+	if bqc.rowCount < bqc.batchSize {
+		return false, nil
+	}
+	if err := bqc.flushRows(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	ctx := context.Background()
-	source := bigquery.NewReaderSource(reader)
-	source.SourceFormat = bqc.sourceFormat
-	source.IgnoreUnknownValues = bqc.ignoreUnknownValues
+// PutBatch implements bqClient::PutBatch
+//
+// Each row is put the same way as Put would, as the rows are buffered by the row writer regardless.
+// Rows which could not be put are rejected, without affecting the other rows.
+func (bqc *Client) PutBatch(rows []interface{}) (bool, error) {
+	var (
+		flushed bool
+		rowErrs bqbase.RowErrors
+	)
+	for i, row := range rows {
+		rowFlushed, err := bqc.Put(row)
+		flushed = flushed || rowFlushed
+		if err != nil {
+			rowErrs = append(rowErrs, bqbase.RowError{Index: i, Err: err})
+		}
+	}
+	if len(rowErrs) > 0 {
+		return flushed, fmt.Errorf("BQ batch client: put batch: %w", rowErrs)
+	}
+	return flushed, nil
+}
+
+// loadReader loads the file read from the given reader into the BigQuery table,
+// staging it as an object first in case staging is enabled. The load job is submitted
+// once a slot is available for it, and is awaited in the background.
+//
+// In case idempotent loads are enabled the ID of the load job is derived from the load key of the reader,
+// or its content otherwise, and failures to submit the job are retried (as the same job).
+func (bqc *Client) loadReader(ctx context.Context, reader io.Reader, encoded bool) error {
+	if bqc.options.Ordered {
+		// failures are still reported by the next flush
+		bqc.jobsWg.Wait()
+	}
+	if err := bqc.jobs.acquire(ctx); err != nil {
+		return fmt.Errorf("BQ batch client: acquire load job slot: %w", err)
+	}
+	submitted := false
+	defer func() {
+		if !submitted {
+			bqc.jobs.release()
+		}
+	}()
+
+	var (
+		jobID       string
+		contentHash hash.Hash
+	)
+	if bqc.options.IdempotentLoads {
+		if keyed, ok := reader.(loadKeyer); ok {
+			jobID = bqc.idempotentJobID(jobIDKindKey, []byte(keyed.LoadKey()))
+		} else {
+			contentHash = sha256.New()
+		}
+	}
+
+	var (
+		newSource  func() (bigquery.LoadSource, *bigquery.FileConfig)
+		objectName string
+	)
+	switch {
+	case bqc.options.Staging != nil:
+		objectName = bqc.stagedObjectName()
+		stagedReader := reader
+		if contentHash != nil {
+			stagedReader = io.TeeReader(reader, contentHash)
+		}
+		if err := bqc.options.Staging.Store.Put(ctx, objectName, stagedReader); err != nil {
+			return fmt.Errorf("BQ batch client: stage file: %w", err)
+		}
+		uri := bqc.options.Staging.Store.URI(objectName)
+		newSource = func() (bigquery.LoadSource, *bigquery.FileConfig) {
+			gcsRef := bigquery.NewGCSReference(uri)
+			return gcsRef, &gcsRef.FileConfig
+		}
+	case bqc.options.IdempotentLoads:
+		// the file is buffered, such that its content can be hashed
+		// and uploaded again in case submitting the job is retried
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("BQ batch client: read file: %w", err)
+		}
+		if contentHash != nil {
+			_, _ = contentHash.Write(b)
+		}
+		newSource = func() (bigquery.LoadSource, *bigquery.FileConfig) {
+			readerSource := bigquery.NewReaderSource(bytes.NewReader(b))
+			return readerSource, &readerSource.FileConfig
+		}
+	default:
+		newSource = func() (bigquery.LoadSource, *bigquery.FileConfig) {
+			readerSource := bigquery.NewReaderSource(reader)
+			return readerSource, &readerSource.FileConfig
+		}
+	}
+	if contentHash != nil {
+		jobID = bqc.idempotentJobID(jobIDKindContent, contentHash.Sum(nil))
+	}
+
+	newLoader := func() *bigquery.Loader {
+		return bqc.newLoader(newSource, encoded, jobID)
+	}
+	job, err := bqc.submitJob(ctx, newLoader, jobID)
+	if err != nil {
+		return err
+	}
+	submitted = true
+
+	bqc.jobsWg.Add(1)
+	go bqc.awaitJob(job, objectName)
+	return nil
+}
+
+// newLoader creates a new loader for the (new) source, configured using the options of the client,
+// using the given job ID as-is if defined, or the (optional) job ID prefix otherwise.
+func (bqc *Client) newLoader(newSource func() (bigquery.LoadSource, *bigquery.FileConfig), encoded bool, jobID string) *bigquery.Loader {
+	source, fileConfig := newSource()
+	fileConfig.SourceFormat = bqc.sourceFormat
+	fileConfig.IgnoreUnknownValues = bqc.ignoreUnknownValues
+	if bqc.options.CSVOptions != nil {
+		fileConfig.CSVOptions = *bqc.options.CSVOptions
+	}
+	fileConfig.ParquetOptions = bqc.options.ParquetOptions
+	fileConfig.MaxBadRecords = bqc.options.MaxBadRecords
 
 	if bqc.schema == nil {
-		source.AutoDetect = true
+		fileConfig.AutoDetect = true
 	} else {
-		source.Schema = *bqc.schema
+		fileConfig.Schema = *bqc.schema
 	}
 
 	table := bqc.client.Dataset(bqc.dataSetID).Table(bqc.tableID)
 	loader := table.LoaderFrom(source)
 	loader.WriteDisposition = bqc.writeDisposition
+	// the Avro files written by the row writer use the Avro logical types for
+	// temporal and numeric values, which are only respected when explicitly enabled
+	loader.UseAvroLogicalTypes = bqc.sourceFormat == bigquery.Avro && (encoded || !bqc.options.IgnoreAvroLogicalTypes)
+	loader.DecimalTargetTypes = bqc.options.DecimalTargetTypes
+	loader.CreateDisposition = bqc.options.CreateDisposition
+	loader.TimePartitioning = bqc.options.TimePartitioning
+	loader.RangePartitioning = bqc.options.RangePartitioning
+	loader.Clustering = bqc.options.Clustering
+	loader.SchemaUpdateOptions = bqc.options.SchemaUpdateOptions
+	loader.DestinationEncryptionConfig = bqc.options.DestinationEncryptionConfig
+	loader.Labels = bqc.options.Labels
+	if jobID != "" {
+		loader.JobID = jobID
+	} else if bqc.options.JobIDPrefix != "" {
+		loader.JobID = bqc.options.JobIDPrefix
+		loader.AddJobIDSuffix = true
+	}
+	return loader
+}
+
+// submitJob runs a loader created using the given function, returning its submitted job.
+//
+// A deterministic job ID allows to retry transient failures safely, as BigQuery rejects a job
+// which was submitted already (e.g. by a previous attempt of which the response was lost),
+// in which case the existing job is returned instead, such that its status is awaited as any other job.
+func (bqc *Client) submitJob(ctx context.Context, newLoader func() *bigquery.Loader, jobID string) (loadJob, error) {
+	job, err := bqc.run(ctx, newLoader())
+	if jobID == "" {
+		// the data of a (random) job could be loaded already, so it cannot be retried
+		return job, err
+	}
+	if err != nil && retryableLoadError(err) {
+		err = bqc.newRetryer(ctx).RetryOp(func(context.Context) error {
+			var runErr error
+			job, runErr = bqc.run(ctx, newLoader())
+			return runErr
+		})
+	}
+	if err != nil && jobExistsError(err) {
+		existingJob, lookupErr := bqc.lookupJob(ctx, jobID)
+		if lookupErr != nil {
+			return nil, fmt.Errorf("BQ batch client: lookup existing job %s: %w", jobID, lookupErr)
+		}
+		log.Log(
+			bqc.logger, log.LevelInfo,
+			fmt.Sprintf("BQ batch client: load job %s exists already: awaiting existing job", jobID),
+		)
+		return existingJob, nil
+	}
+	return job, err
+}
+
+// idempotentJobID returns the deterministic ID of the load job of the file identified by the given key,
+// which is either its load key or the digest of its content, depending on the given kind.
+func (bqc *Client) idempotentJobID(kind string, key []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, bqc.dataSetID+"\x00"+bqc.tableID+"\x00"+kind+"\x00")
+	_, _ = h.Write(key)
+	prefix := bqc.options.JobIDPrefix
+	if prefix == "" {
+		prefix = constant.DefaultJobIDPrefix
+	}
+	return prefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// newRetryer creates a new retryer for the transient failures of submitting or awaiting a load job.
+func (bqc *Client) newRetryer(ctx context.Context) *bqbase.Retryer {
+	return bqbase.NewRetryer(
+		ctx,
+		constant.DefaultMaxRetries,
+		bqc.initialRetryDelay,
+		bqc.options.MaxRetryDeadlineOffset,
+		constant.DefaultRetryDelayMultiplier,
+		retryableLoadError,
+	)
+}
+
+// lookupExistingJob returns the (existing) job with the given ID.
+func (bqc *Client) lookupExistingJob(ctx context.Context, id string) (loadJob, error) {
+	return bqc.client.JobFromID(ctx, id)
+}
+
+// runLoader runs the loader, returning its submitted job.
+func (bqc *Client) runLoader(ctx context.Context, loader *bigquery.Loader) (loadJob, error) {
 	job, err := loader.Run(ctx)
 	if err != nil {
-		return false, fmt.Errorf("BQ batch client: failed to run loader: %w", err)
+		return nil, fmt.Errorf("BQ batch client: failed to run loader: %w", err)
 	}
+	return job, nil
+}
+
+// awaitJob waits until the given job is finished, releasing its slot afterwards,
+// logging and collecting its failure, or deleting its staged object (if enabled) once it is loaded.
+func (bqc *Client) awaitJob(job loadJob, objectName string) {
+	defer bqc.jobsWg.Done()
+	defer bqc.jobs.release()
+
+	// NOTE: we are using the background Context, for the same reason as the client itself,
+	// as the job is in flight already and should be awaited until it is finished either way
+	ctx := context.Background()
+	if err := bqc.waitForJob(ctx, job); err != nil {
+		log.Log(
+			bqc.logger, log.LevelError,
+			fmt.Sprintf("BQ batch client: load job failed: %v", err),
+			log.F(log.FieldError, err),
+		)
+		bqc.failuresMu.Lock()
+		bqc.failures.add(err)
+		bqc.failuresMu.Unlock()
+		return
+	}
+
+	if objectName != "" && bqc.options.Staging.DeleteAfterLoad {
+		// the data is loaded already, so only log a failure to clean up the staged object
+		if err := bqc.options.Staging.Store.Delete(ctx, objectName); err != nil {
+			log.Log(
+				bqc.logger, log.LevelWarn,
+				fmt.Sprintf("BQ batch client: delete loaded staged object: %v", err),
+				log.F(log.FieldError, err),
+			)
+		}
+	}
+}
+
+// waitForJob waits until the given job is finished, logging all errors of its status.
+// Transient failures to retrieve the status of the job are retried.
+func (bqc *Client) waitForJob(ctx context.Context, job loadJob) error {
 	status, err := job.Wait(ctx)
+	if err != nil && retryableLoadError(err) {
+		// the retryer is only created now, as its deadline is not meant to bound the job itself
+		err = bqc.newRetryer(ctx).RetryOp(func(context.Context) error {
+			var waitErr error
+			status, waitErr = job.Wait(ctx)
+			return waitErr
+		})
+	}
 	if err != nil {
-		return false, fmt.Errorf("BQ batch client: job failed while waiting: %w", err)
+		return fmt.Errorf("BQ batch client: job %s failed while waiting: %w", job.ID(), err)
 	}
 
-	if err := status.Err(); err != nil {
-		for _, statErr := range status.Errors {
-			bqc.logger.Errorf("BQ batch client: status error: %v", statErr)
-		}
-		return false, fmt.Errorf("BQ batch client: job returned an error status: %w", err)
+	// the status can contain errors for a successful job as well,
+	// e.g. for the bad records which were skipped, so these are logged as warnings only
+	statusErr := status.Err()
+	level := log.LevelWarn
+	if statusErr != nil {
+		level = log.LevelError
 	}
+	for _, statErr := range status.Errors {
+		log.Log(
+			bqc.logger, level,
+			fmt.Sprintf("BQ batch client: job %s: status error: %v", job.ID(), statErr),
+			log.F(log.FieldError, statErr),
+		)
+	}
+	if statusErr != nil {
+		return fmt.Errorf("BQ batch client: job %s returned an error status: %w", job.ID(), statusErr)
+	}
+	return nil
+}
 
-	// We flush every time when we write data.
-	return true, nil
+// waitForJobs waits until all jobs of the client in flight are finished,
+// returning the (first) error of the jobs which failed since the failures were last taken.
+func (bqc *Client) waitForJobs() error {
+	bqc.jobsWg.Wait()
+	return bqc.takeFailures()
+}
+
+// takeFailures returns the (first) error of the jobs which failed since the failures were last taken,
+// without waiting for the jobs of the client in flight.
+func (bqc *Client) takeFailures() error {
+	bqc.failuresMu.Lock()
+	defer bqc.failuresMu.Unlock()
+	err := bqc.failures.err()
+	bqc.failures = jobFailures{}
+	return err
 }
 
-// Flush implements bigquery.Client::Flush
+// stagedObjectName returns a new (unique) name for a staged object of the client,
+// e.g. <prefix>my-dataset/my-table/20211018T150405.123456789Z-0123456789abcdef.avro
+func (bqc *Client) stagedObjectName() string {
+	var suffix [8]byte
+	// the time already makes the name (practically) unique, so the suffix is best-effort
+	_, _ = rand.Read(suffix[:])
+	return bqc.options.Staging.Prefix + bqc.dataSetID + "/" + bqc.tableID + "/" +
+		time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix[:]) +
+		stagedFileExtensions[bqc.sourceFormat]
+}
+
+// Flush implements bigquery.Client::Flush,
+// loading the buffered rows and returning the failures of the load jobs finished since the previous flush.
+// It only waits until all load jobs of the client in flight are finished in case the loads are ordered,
+// as the client is flushed periodically by its worker.
 func (bqc *Client) Flush() error {
-	// NOTE: The data is always flushed instantly upon putting the data.
+	flushErr := bqc.flushRows()
+	// the failures of the jobs are logged already, so in case both fail
+	// it is sufficient to only return the error of loading the buffered rows
+	var jobsErr error
+	if bqc.options.Ordered {
+		jobsErr = bqc.waitForJobs()
+	} else {
+		jobsErr = bqc.takeFailures()
+	}
+	if flushErr != nil {
+		return flushErr
+	}
+	return jobsErr
+}
+
+// flushRows loads the buffered rows, if any, without waiting for the load job to finish.
+func (bqc *Client) flushRows() error {
+	if bqc.rowCount == 0 {
+		// NOTE: Any reader is always flushed instantly upon putting the data.
+		return nil
+	}
+	// the buffered rows are dropped even if they failed to load,
+	// the same way as the other clients drop their batched rows
+	defer func() {
+		bqc.buffer.Reset()
+		bqc.rowWriter = nil
+		bqc.rowCount = 0
+	}()
+	if err := bqc.rowWriter.Close(); err != nil {
+		return fmt.Errorf("BQ batch client: close row writer: %w", err)
+	}
+	bqc.throttle(bqc.rowCount, bqc.buffer.Len())
+	if err := bqc.load(context.Background(), bytes.NewReader(bqc.buffer.Bytes()), true); err != nil {
+		return fmt.Errorf("BQ batch client: load %d buffered rows: %w", bqc.rowCount, err)
+	}
 	return nil
 }
 
+// SetThrottler implements bigquery.Throttled::SetThrottler
+func (bqc *Client) SetThrottler(throttler bqbase.Throttler) {
+	bqc.throttler = throttler
+}
+
+// throttle waits until the given amount of rows, of the given size in bytes, can be loaded,
+// in case the client is throttled.
+func (bqc *Client) throttle(rows, size int) {
+	if bqc.throttler != nil {
+		bqc.throttler.Throttle(rows, size)
+	}
+}
+
 // Close implements bqClient::Close
 func (bqc *Client) Close() error {
 	// no need to flush first,
 	// as this is an internal client used by Streamer only,
 	// which does flush prior to closing it :)
-	if err := bqc.client.Close(); err != nil {
+	// It does however wait for any jobs still in flight, as these require the client,
+	// returning the failures of the jobs not yet returned by a flush.
+	jobsErr := bqc.waitForJobs()
+	if err := bqc.closeClient(); err != nil {
 		return fmt.Errorf("BQ batch client: failed while closing: %w", err)
 	}
-	return nil
+	return jobsErr
 }
@@ -15,17 +15,37 @@
 package batch
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/OTA-Insight/bqwriter/constant"
+	"github.com/OTA-Insight/bqwriter/internal"
+	bqbase "github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/batch/encoding"
 	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/objectstore"
 
 	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
 )
 
 type TestClientConfig struct {
 	BigQuerySchema   *bigquery.Schema
 	SourceFormat     bigquery.DataFormat
 	WriteDisposition bigquery.TableWriteDisposition
+	NewRowWriter     encoding.NewRowWriterFunc
+	BatchSize        int
+	LoadOptions      LoadOptions
+	Jobs             *JobLimiter
 }
 
 func newTestClient(t *testing.T, cfg *TestClientConfig) (*Client, error) {
@@ -36,10 +56,17 @@ func newTestClient(t *testing.T, cfg *TestClientConfig) (*Client, error) {
 	if cfg == nil {
 		cfg = new(TestClientConfig)
 	}
+	batchSize := cfg.BatchSize
+	if batchSize == 0 {
+		batchSize = 1
+	}
 	client, err := newClient(
 		bqClient, "test", "test",
 		false, cfg.SourceFormat, cfg.WriteDisposition,
-		cfg.BigQuerySchema, test.Logger{})
+		cfg.BigQuerySchema, cfg.LoadOptions,
+		cfg.NewRowWriter, batchSize,
+		cfg.Jobs,
+		test.Logger{})
 	return client, err
 }
 
@@ -67,3 +94,622 @@ func TestBatchClientFlushNop(t *testing.T) {
 	flushErr := client.Flush()
 	test.AssertNoError(t, flushErr)
 }
+
+func TestBatchClientInvalidBatchSize(t *testing.T) {
+	_, err := newClient(new(bigquery.Client), "test", "test", false, bigquery.JSON, bigquery.WriteAppend, nil, LoadOptions{}, nil, 0, nil, test.Logger{})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+}
+
+// testLineWriter is a RowWriter writing each (string) row as a line,
+// failing for any other row, and writing a footer when closed.
+type testLineWriter struct {
+	w io.Writer
+}
+
+func (w *testLineWriter) WriteRow(row interface{}) error {
+	s, ok := row.(string)
+	if !ok {
+		return encoding.ErrInvalidData
+	}
+	_, err := io.WriteString(w.w, s+"\n")
+	return err
+}
+
+func (w *testLineWriter) Close() error {
+	_, err := io.WriteString(w.w, "EOF\n")
+	return err
+}
+
+func newTestLineWriter(w io.Writer) (encoding.RowWriter, error) {
+	return &testLineWriter{w: w}, nil
+}
+
+func newTestBufferingClient(t *testing.T, batchSize int) (*Client, *[]string) {
+	t.Helper()
+	client, err := newTestClient(t, &TestClientConfig{
+		SourceFormat: bigquery.Avro,
+		NewRowWriter: newTestLineWriter,
+		BatchSize:    batchSize,
+	})
+	test.AssertNoErrorFatal(t, err)
+	var loaded []string
+	client.load = func(_ context.Context, reader io.Reader, encoded bool) error {
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if !encoded {
+			// mark files which were not written by the row writer
+			b = append([]byte("reader:"), b...)
+		}
+		loaded = append(loaded, string(b))
+		return nil
+	}
+	return client, &loaded
+}
+
+func TestBatchClientBufferedRows(t *testing.T) {
+	client, loaded := newTestBufferingClient(t, 3)
+
+	for _, row := range []string{"a", "b"} {
+		flushed, err := client.Put(row)
+		test.AssertNoError(t, err)
+		test.AssertFalse(t, flushed)
+	}
+	// an invalid row is not buffered
+	flushed, err := client.Put(42)
+	test.AssertIsError(t, err, encoding.ErrInvalidData)
+	test.AssertFalse(t, flushed)
+	test.AssertEqual(t, 0, len(*loaded))
+
+	// reaching the batch size loads all buffered rows as a single file
+	flushed, err = client.Put("c")
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, flushed)
+	test.AssertEqual(t, []string{"a\nb\nc\nEOF\n"}, *loaded)
+
+	// flushing loads the remaining rows, and is a no-op without any buffered rows
+	_, err = client.Put("d")
+	test.AssertNoError(t, err)
+	test.AssertNoError(t, client.Flush())
+	test.AssertNoError(t, client.Flush())
+	test.AssertEqual(t, []string{"a\nb\nc\nEOF\n", "d\nEOF\n"}, *loaded)
+}
+
+func TestBatchClientThrottledOnLoad(t *testing.T) {
+	client, loaded := newTestBufferingClient(t, 3)
+	throttler := new(test.Throttler)
+	client.SetThrottler(throttler)
+
+	// buffered rows are only throttled once loaded, by the size of their file
+	for _, row := range []string{"a", "b"} {
+		_, err := client.Put(row)
+		test.AssertNoError(t, err)
+	}
+	test.AssertEqual(t, 0, len(throttler.Calls))
+	test.AssertNoError(t, client.Flush())
+	// a reader is throttled as a single row
+	_, err := client.Put(strings.NewReader("file"))
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, []string{"a\nb\nEOF\n", "reader:file"}, *loaded)
+	test.AssertEqual(t, []test.Throttle{{Rows: 2, Bytes: 8}, {Rows: 1, Bytes: 4}}, throttler.Calls)
+}
+
+func TestBatchClientPutBatch(t *testing.T) {
+	client, loaded := newTestBufferingClient(t, 3)
+
+	// invalid rows are rejected, without affecting the other rows
+	flushed, err := client.PutBatch([]interface{}{"a", 42, "b", "c", true, "d"})
+	test.AssertTrue(t, flushed)
+	var rowErrs bqbase.RowErrors
+	test.AssertTrue(t, errors.As(err, &rowErrs))
+	test.AssertEqual(t, 2, len(rowErrs))
+	test.AssertEqual(t, 1, rowErrs[0].Index)
+	test.AssertIsError(t, rowErrs[0].Err, encoding.ErrInvalidData)
+	test.AssertEqual(t, 4, rowErrs[1].Index)
+	test.AssertEqual(t, []string{"a\nb\nc\nEOF\n"}, *loaded)
+
+	test.AssertNoError(t, client.Flush())
+	test.AssertEqual(t, []string{"a\nb\nc\nEOF\n", "d\nEOF\n"}, *loaded)
+}
+
+func TestBatchClientBufferedRowsBeforeReader(t *testing.T) {
+	client, loaded := newTestBufferingClient(t, 10)
+
+	_, err := client.Put("a")
+	test.AssertNoError(t, err)
+	flushed, err := client.Put(strings.NewReader("file"))
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, flushed)
+	test.AssertEqual(t, []string{"a\nEOF\n", "reader:file"}, *loaded)
+}
+
+func TestBatchClientFailedLoadDropsRows(t *testing.T) {
+	client, _ := newTestBufferingClient(t, 10)
+	errLoad := errors.New("load failed")
+	var loaded [][]byte
+	client.load = func(_ context.Context, reader io.Reader, _ bool) error {
+		b, _ := ioutil.ReadAll(reader)
+		loaded = append(loaded, b)
+		return errLoad
+	}
+
+	_, err := client.Put("a")
+	test.AssertNoError(t, err)
+	test.AssertIsError(t, client.Flush(), errLoad)
+	_, err = client.Put("b")
+	test.AssertNoError(t, err)
+	test.AssertIsError(t, client.Flush(), errLoad)
+	test.AssertEqual(t, 2, len(loaded))
+	test.AssertTrue(t, bytes.Equal([]byte("b\nEOF\n"), loaded[1]))
+}
+
+func TestBatchClientStagingRequiresStore(t *testing.T) {
+	_, err := newTestClient(t, &TestClientConfig{
+		SourceFormat: bigquery.JSON,
+		LoadOptions:  LoadOptions{Staging: &StagingOptions{}},
+	})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+}
+
+func TestBatchClientStagedLoads(t *testing.T) {
+	for _, deleteAfterLoad := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "bqwriter-staging")
+		test.AssertNoErrorFatal(t, err)
+		defer os.RemoveAll(dir)
+		store, err := objectstore.NewLocalStore(dir)
+		test.AssertNoErrorFatal(t, err)
+
+		client, err := newTestClient(t, &TestClientConfig{
+			SourceFormat: bigquery.CSV,
+			NewRowWriter: newTestLineWriter,
+			BatchSize:    2,
+			LoadOptions: LoadOptions{
+				CSVOptions: &bigquery.CSVOptions{SkipLeadingRows: 1},
+				Staging: &StagingOptions{
+					Store:           store,
+					Prefix:          "staging/",
+					DeleteAfterLoad: deleteAfterLoad,
+				},
+			},
+		})
+		test.AssertNoErrorFatal(t, err)
+		var (
+			uris   []string
+			staged []string
+		)
+		errLoad := errors.New("load failed")
+		client.run = func(_ context.Context, loader *bigquery.Loader) (loadJob, error) {
+			gcsRef, ok := loader.Src.(*bigquery.GCSReference)
+			test.AssertTrue(t, ok)
+			test.AssertEqual(t, bigquery.CSV, gcsRef.SourceFormat)
+			test.AssertEqual(t, int64(1), gcsRef.SkipLeadingRows)
+			test.AssertEqual(t, 1, len(gcsRef.URIs))
+			uris = append(uris, gcsRef.URIs[0])
+			b, err := ioutil.ReadFile(strings.TrimPrefix(gcsRef.URIs[0], "file://"))
+			test.AssertNoError(t, err)
+			staged = append(staged, string(b))
+			if len(uris) == 3 {
+				return &testLoadJob{id: "3", err: errLoad}, nil
+			}
+			return &testLoadJob{id: "1"}, nil
+		}
+
+		for _, row := range []string{"a", "b"} {
+			_, err := client.Put(row)
+			test.AssertNoError(t, err)
+		}
+		_, err = client.Put(strings.NewReader("file"))
+		test.AssertNoError(t, err)
+		// the third load fails, its object is kept either way
+		_, err = client.Put("c")
+		test.AssertNoError(t, err)
+		test.AssertIsError(t, flushAndWait(client), errLoad)
+
+		test.AssertEqual(t, []string{"a\nb\nEOF\n", "file", "c\nEOF\n"}, staged)
+		for i, uri := range uris {
+			test.AssertTrue(t, strings.HasPrefix(uri, store.URI("staging/test/test/")))
+			test.AssertTrue(t, strings.HasSuffix(uri, ".csv"))
+			_, err := os.Stat(strings.TrimPrefix(uri, "file://"))
+			if deleteAfterLoad && i < 2 {
+				test.AssertTrue(t, os.IsNotExist(err))
+			} else {
+				test.AssertNoError(t, err)
+			}
+		}
+	}
+}
+
+// flushAndWait flushes the client and waits until all its jobs in flight are finished,
+// as the client does when closed, returning the first error of either.
+func flushAndWait(client *Client) error {
+	flushErr := client.Flush()
+	jobsErr := client.waitForJobs()
+	if flushErr != nil {
+		return flushErr
+	}
+	return jobsErr
+}
+
+// testLoadJob is a loadJob which finishes (with the defined status or error)
+// once its done channel is closed, if defined.
+type testLoadJob struct {
+	id     string
+	done   chan struct{}
+	status bigquery.JobStatus
+	err    error
+}
+
+func (job *testLoadJob) ID() string {
+	return job.id
+}
+
+func (job *testLoadJob) Wait(ctx context.Context) (*bigquery.JobStatus, error) {
+	if job.done != nil {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if job.err != nil {
+		return nil, job.err
+	}
+	return &job.status, nil
+}
+
+func TestBatchClientAsyncLoadJobs(t *testing.T) {
+	// the limiter is shared by both clients
+	jobs := NewJobLimiter(2)
+	newAsyncClient := func() (*Client, chan *testLoadJob) {
+		client, err := newTestClient(t, &TestClientConfig{
+			SourceFormat: bigquery.JSON,
+			Jobs:         jobs,
+		})
+		test.AssertNoErrorFatal(t, err)
+		submitted := make(chan *testLoadJob, 10)
+		client.run = func(_ context.Context, _ *bigquery.Loader) (loadJob, error) {
+			job := &testLoadJob{
+				id:   "job",
+				done: make(chan struct{}),
+				status: bigquery.JobStatus{
+					State: bigquery.Done,
+					// a non-fatal error which is only logged
+					Errors: []*bigquery.Error{{Reason: "invalid", Message: "bad record"}},
+				},
+			}
+			submitted <- job
+			return job, nil
+		}
+		return client, submitted
+	}
+	clientA, submittedA := newAsyncClient()
+	clientB, submittedB := newAsyncClient()
+
+	// the put returns as soon as the job is submitted
+	flushed, err := clientA.Put(strings.NewReader("a"))
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, flushed)
+	_, err = clientB.Put(strings.NewReader("b"))
+	test.AssertNoError(t, err)
+	jobA, jobB := <-submittedA, <-submittedB
+
+	// a third job blocks until a slot is available
+	putDone := make(chan error)
+	go func() {
+		_, err := clientA.Put(strings.NewReader("c"))
+		putDone <- err
+	}()
+	select {
+	case <-submittedA:
+		t.Fatal("job submitted while the maximum amount of jobs is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(jobB.done)
+	test.AssertNoError(t, <-putDone)
+	jobC := <-submittedA
+
+	// flushing does not wait for the jobs of the client in flight,
+	// while closing the client does
+	test.AssertNoError(t, clientA.Flush())
+	waitDone := make(chan error)
+	go func() {
+		waitDone <- clientA.waitForJobs()
+	}()
+	close(jobA.done)
+	select {
+	case <-waitDone:
+		t.Fatal("wait returned while a job of the client is still in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(jobC.done)
+	test.AssertNoError(t, <-waitDone)
+	test.AssertNoError(t, clientB.waitForJobs())
+}
+
+func TestBatchClientOrderedLoadJobs(t *testing.T) {
+	client, err := newTestClient(t, &TestClientConfig{
+		SourceFormat: bigquery.JSON,
+		LoadOptions:  LoadOptions{Ordered: true},
+		Jobs:         NewJobLimiter(2),
+	})
+	test.AssertNoErrorFatal(t, err)
+	submitted := make(chan *testLoadJob, 2)
+	client.run = func(_ context.Context, _ *bigquery.Loader) (loadJob, error) {
+		job := &testLoadJob{
+			id:     "job",
+			done:   make(chan struct{}),
+			status: bigquery.JobStatus{State: bigquery.Done},
+		}
+		submitted <- job
+		return job, nil
+	}
+
+	_, err = client.Put(strings.NewReader("a"))
+	test.AssertNoError(t, err)
+	jobA := <-submitted
+
+	// the second job is only submitted once the first one is done,
+	// even though a slot is available for it
+	putDone := make(chan error)
+	go func() {
+		_, err := client.Put(strings.NewReader("b"))
+		putDone <- err
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("ordered job submitted while a previous job is still in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(jobA.done)
+	test.AssertNoError(t, <-putDone)
+	jobB := <-submitted
+
+	// flushing waits for the jobs of the client in flight as well
+	flushDone := make(chan error)
+	go func() {
+		flushDone <- client.Flush()
+	}()
+	select {
+	case <-flushDone:
+		t.Fatal("ordered flush returned while a job of the client is still in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(jobB.done)
+	test.AssertNoError(t, <-flushDone)
+}
+
+func TestBatchClientFailedLoadJobs(t *testing.T) {
+	client, err := newTestClient(t, &TestClientConfig{
+		SourceFormat: bigquery.JSON,
+		Jobs:         NewJobLimiter(3),
+	})
+	test.AssertNoErrorFatal(t, err)
+	errJob := errors.New("job failed")
+	var count int
+	client.run = func(_ context.Context, _ *bigquery.Loader) (loadJob, error) {
+		count++
+		if count == 2 {
+			return &testLoadJob{id: "2"}, nil
+		}
+		return &testLoadJob{id: strconv.Itoa(count), err: errJob}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Put(strings.NewReader("data"))
+		test.AssertNoError(t, err)
+	}
+	// the failures of the finished jobs are returned by the next flush only
+	client.jobsWg.Wait()
+	err = client.Flush()
+	test.AssertIsError(t, err, errJob)
+	test.AssertTrue(t, strings.Contains(err.Error(), "1 other failed load jobs"))
+	test.AssertNoError(t, client.Flush())
+}
+
+func TestBatchClientLoaderOptions(t *testing.T) {
+	options := LoadOptions{
+		CreateDisposition: bigquery.CreateNever,
+		TimePartitioning:  &bigquery.TimePartitioning{Type: bigquery.HourPartitioningType},
+		RangePartitioning: &bigquery.RangePartitioning{Field: "id"},
+		Clustering:        &bigquery.Clustering{Fields: []string{"name"}},
+		SchemaUpdateOptions: []string{
+			"ALLOW_FIELD_ADDITION",
+		},
+		DestinationEncryptionConfig: &bigquery.EncryptionConfig{KMSKeyName: "key"},
+		Labels:                      map[string]string{"team": "data"},
+		MaxBadRecords:               5,
+		JobIDPrefix:                 "bqwriter_",
+	}
+	client, err := newTestClient(t, &TestClientConfig{
+		SourceFormat:     bigquery.JSON,
+		WriteDisposition: bigquery.WriteAppend,
+		LoadOptions:      options,
+	})
+	test.AssertNoErrorFatal(t, err)
+	var loaders []*bigquery.Loader
+	client.run = func(_ context.Context, loader *bigquery.Loader) (loadJob, error) {
+		loaders = append(loaders, loader)
+		return &testLoadJob{id: "job"}, nil
+	}
+
+	_, err = client.Put(strings.NewReader("{}"))
+	test.AssertNoError(t, err)
+	test.AssertNoError(t, client.Flush())
+	test.AssertEqual(t, 1, len(loaders))
+	loader := loaders[0]
+	test.AssertEqual(t, bigquery.WriteAppend, loader.WriteDisposition)
+	test.AssertEqual(t, options.CreateDisposition, loader.CreateDisposition)
+	test.AssertEqual(t, options.TimePartitioning, loader.TimePartitioning)
+	test.AssertEqual(t, options.RangePartitioning, loader.RangePartitioning)
+	test.AssertEqual(t, options.Clustering, loader.Clustering)
+	test.AssertEqual(t, options.SchemaUpdateOptions, loader.SchemaUpdateOptions)
+	test.AssertEqual(t, options.DestinationEncryptionConfig, loader.DestinationEncryptionConfig)
+	test.AssertEqual(t, options.Labels, loader.Labels)
+	test.AssertEqual(t, "bqwriter_", loader.JobID)
+	test.AssertTrue(t, loader.AddJobIDSuffix)
+	readerSource, ok := loader.Src.(*bigquery.ReaderSource)
+	test.AssertTrue(t, ok)
+	test.AssertEqual(t, int64(5), readerSource.MaxBadRecords)
+}
+
+// testKeyedReader is a reader with a load key
+type testKeyedReader struct {
+	io.Reader
+	key string
+}
+
+func (r testKeyedReader) LoadKey() string {
+	return r.key
+}
+
+func TestBatchClientIdempotentJobIDs(t *testing.T) {
+	for _, prefix := range []string{"", "my_prefix-"} {
+		client, err := newTestClient(t, &TestClientConfig{
+			SourceFormat: bigquery.JSON,
+			NewRowWriter: newTestLineWriter,
+			BatchSize:    2,
+			LoadOptions: LoadOptions{
+				IdempotentLoads: true,
+				JobIDPrefix:     prefix,
+			},
+		})
+		test.AssertNoErrorFatal(t, err)
+		var jobIDs []string
+		client.run = func(_ context.Context, loader *bigquery.Loader) (loadJob, error) {
+			test.AssertFalse(t, loader.AddJobIDSuffix)
+			jobIDs = append(jobIDs, loader.JobID)
+			return &testLoadJob{id: loader.JobID}, nil
+		}
+
+		for _, data := range []interface{}{
+			strings.NewReader("a"),
+			strings.NewReader("a"),
+			strings.NewReader("b"),
+			testKeyedReader{Reader: strings.NewReader("a"), key: "a"},
+			testKeyedReader{Reader: strings.NewReader("b"), key: "a"},
+			// rows are loaded as a file as well
+			"a", "b",
+		} {
+			_, err := client.Put(data)
+			test.AssertNoError(t, err)
+		}
+		test.AssertNoError(t, client.Flush())
+
+		test.AssertEqual(t, 6, len(jobIDs))
+		expectedPrefix := prefix
+		if expectedPrefix == "" {
+			expectedPrefix = constant.DefaultJobIDPrefix
+		}
+		for _, jobID := range jobIDs {
+			test.AssertTrue(t, strings.HasPrefix(jobID, expectedPrefix))
+			test.AssertEqual(t, len(expectedPrefix)+64, len(jobID))
+		}
+		// the same content or key results in the same job ID
+		test.AssertEqual(t, jobIDs[0], jobIDs[1])
+		test.AssertEqual(t, jobIDs[3], jobIDs[4])
+		// while different content, or a key rather than content, does not
+		test.AssertTrue(t, jobIDs[0] != jobIDs[2])
+		test.AssertTrue(t, jobIDs[0] != jobIDs[3])
+		test.AssertTrue(t, jobIDs[5] != jobIDs[0])
+
+		// the job ID is also table specific
+		otherClient, err := newClient(
+			new(bigquery.Client), "test", "other",
+			false, bigquery.JSON, bigquery.WriteAppend,
+			nil, LoadOptions{IdempotentLoads: true, JobIDPrefix: prefix},
+			nil, 1, nil, test.Logger{},
+		)
+		test.AssertNoErrorFatal(t, err)
+		test.AssertTrue(t, otherClient.idempotentJobID(jobIDKindKey, []byte("a")) != jobIDs[3])
+	}
+}
+
+func TestBatchClientIdempotentLoadRetries(t *testing.T) {
+	client, err := newTestClient(t, &TestClientConfig{
+		SourceFormat: bigquery.JSON,
+		LoadOptions: LoadOptions{
+			IdempotentLoads: true,
+		},
+	})
+	test.AssertNoErrorFatal(t, err)
+	client.initialRetryDelay = time.Millisecond
+
+	// the first attempt fails transiently, while the job was accepted,
+	// resulting in the second attempt being rejected as the job exists already
+	var (
+		sources []bigquery.LoadSource
+		jobIDs  []string
+	)
+	client.run = func(_ context.Context, loader *bigquery.Loader) (loadJob, error) {
+		jobIDs = append(jobIDs, loader.JobID)
+		sources = append(sources, loader.Src)
+		switch len(jobIDs) {
+		case 1:
+			return nil, &googleapi.Error{Code: http.StatusServiceUnavailable}
+		default:
+			return nil, &googleapi.Error{Code: http.StatusConflict}
+		}
+	}
+	// the existing job fails transiently while awaiting it, prior to succeeding
+	existingJob := &testFlakyLoadJob{id: "existing", failures: 2}
+	var lookups []string
+	client.lookupJob = func(_ context.Context, id string) (loadJob, error) {
+		lookups = append(lookups, id)
+		return existingJob, nil
+	}
+
+	_, err = client.Put(strings.NewReader("data"))
+	test.AssertNoError(t, err)
+	test.AssertNoError(t, flushAndWait(client))
+	test.AssertEqual(t, 2, len(jobIDs))
+	test.AssertEqual(t, jobIDs[0], jobIDs[1])
+	// each attempt uploads the (buffered) file using a new source
+	test.AssertTrue(t, sources[0] != sources[1])
+	test.AssertEqual(t, []string{jobIDs[0]}, lookups)
+	test.AssertEqual(t, 3, existingJob.waits)
+
+	// a non-transient failure is not retried
+	jobIDs = nil
+	client.run = func(_ context.Context, loader *bigquery.Loader) (loadJob, error) {
+		jobIDs = append(jobIDs, loader.JobID)
+		return nil, &googleapi.Error{Code: http.StatusBadRequest}
+	}
+	_, err = client.Put(strings.NewReader("other data"))
+	test.AssertError(t, err)
+	test.AssertEqual(t, 1, len(jobIDs))
+}
+
+func TestBatchClientRandomJobIDsNotRetried(t *testing.T) {
+	client, err := newTestClient(t, &TestClientConfig{SourceFormat: bigquery.JSON})
+	test.AssertNoErrorFatal(t, err)
+	client.initialRetryDelay = time.Millisecond
+	var runs int
+	client.run = func(_ context.Context, loader *bigquery.Loader) (loadJob, error) {
+		runs++
+		return nil, &googleapi.Error{Code: http.StatusServiceUnavailable}
+	}
+	_, err = client.Put(strings.NewReader("data"))
+	test.AssertError(t, err)
+	test.AssertEqual(t, 1, runs)
+}
+
+// testFlakyLoadJob is a loadJob failing transiently to retrieve its status for the defined amount of failures,
+// prior to returning a successful status.
+type testFlakyLoadJob struct {
+	id       string
+	failures int
+	waits    int
+}
+
+func (job *testFlakyLoadJob) ID() string {
+	return job.id
+}
+
+func (job *testFlakyLoadJob) Wait(context.Context) (*bigquery.JobStatus, error) {
+	job.waits++
+	if job.waits <= job.failures {
+		return nil, &googleapi.Error{Code: http.StatusInternalServerError}
+	}
+	return &bigquery.JobStatus{State: bigquery.Done}, nil
+}
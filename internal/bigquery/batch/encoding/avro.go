@@ -0,0 +1,335 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/value"
+)
+
+const (
+	// avroBlockSize is the (uncompressed) size in bytes at which
+	// the rows written so far are flushed as a single block.
+	avroBlockSize = 1 << 20
+	// avroSyncSize is the size of the sync marker written after each block.
+	avroSyncSize = 16
+)
+
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+// AvroWriter is a RowWriter which writes the rows as an Avro Object Container File,
+// using the Avro schema derived from the BigQuery schema of the rows (see AvroSchema).
+//
+// A row can be a map[string]bigquery.Value, map[string]interface{}, bigquery.ValueSaver
+// or a struct (pointer), of which the fields are named the same way as done by the bigquery.StructSaver.
+// The values are converted to the Avro type of their field, accepting the same Go values as the SchemaEncoder
+// of the storage client, e.g. time.Time, civil types, *big.Rat, bigquery.NullX types or their string representation.
+//
+// The rows are written in blocks of about 1 MiB, compressed using the codec of the writer.
+type AvroWriter struct {
+	w           io.Writer
+	schema      bigquery.Schema
+	compression bigquery.Compression
+	sync        [avroSyncSize]byte
+
+	block      bytes.Buffer
+	blockCount int64
+	row        bytes.Buffer
+	compressed bytes.Buffer
+	flateW     *flate.Writer
+}
+
+// interface compile-time compliance check
+var _ RowWriter = (*AvroWriter)(nil)
+
+// NewAvroWriter creates a new AvroWriter, writing the header of the Avro Object Container File
+// to the given writer immediately. Supported compressions are bigquery.None, bigquery.Deflate and bigquery.Snappy.
+func NewAvroWriter(w io.Writer, schema *AvroSchema, compression bigquery.Compression) (*AvroWriter, error) {
+	if schema == nil {
+		return nil, fmt.Errorf("new avro writer: %w: nil schema", ErrInvalidData)
+	}
+	var codec string
+	switch compression {
+	case bigquery.None, "":
+		compression = bigquery.None
+		codec = "null"
+	case bigquery.Deflate:
+		codec = "deflate"
+	case bigquery.Snappy:
+		codec = "snappy"
+	default:
+		return nil, fmt.Errorf("new avro writer: %w: unsupported compression %q", ErrInvalidData, compression)
+	}
+	aw := &AvroWriter{
+		w:           w,
+		schema:      schema.schema,
+		compression: compression,
+	}
+	if _, err := rand.Read(aw.sync[:]); err != nil {
+		return nil, fmt.Errorf("new avro writer: generate sync marker: %w", err)
+	}
+
+	var header bytes.Buffer
+	header.Write(avroMagic)
+	// file metadata, a map with a single block of two entries
+	writeLong(&header, 2)
+	writeString(&header, "avro.schema")
+	writeBytes(&header, schema.json)
+	writeString(&header, "avro.codec")
+	writeString(&header, codec)
+	writeLong(&header, 0)
+	header.Write(aw.sync[:])
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, fmt.Errorf("new avro writer: write header: %w", err)
+	}
+	return aw, nil
+}
+
+// NewAvroWriterFunc returns a NewRowWriterFunc creating an AvroWriter
+// for the given BigQuery schema and compression.
+func NewAvroWriterFunc(schema bigquery.Schema, compression bigquery.Compression) (NewRowWriterFunc, error) {
+	avroSchema, err := NewAvroSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	// validate the compression once, rather than for each file
+	if _, err := NewAvroWriter(ioutil.Discard, avroSchema, compression); err != nil {
+		return nil, err
+	}
+	return func(w io.Writer) (RowWriter, error) {
+		return NewAvroWriter(w, avroSchema, compression)
+	}, nil
+}
+
+// WriteRow implements RowWriter::WriteRow
+func (aw *AvroWriter) WriteRow(row interface{}) error {
+	aw.row.Reset()
+	if err := writeRecord(&aw.row, aw.schema, row); err != nil {
+		return fmt.Errorf("avro writer: write row: %w", err)
+	}
+	aw.block.Write(aw.row.Bytes())
+	aw.blockCount++
+	if aw.block.Len() >= avroBlockSize {
+		return aw.flushBlock()
+	}
+	return nil
+}
+
+// Close implements RowWriter::Close
+func (aw *AvroWriter) Close() error {
+	return aw.flushBlock()
+}
+
+// flushBlock writes all rows written since the previous block as a single block.
+func (aw *AvroWriter) flushBlock() error {
+	if aw.blockCount == 0 {
+		return nil
+	}
+	data := aw.block.Bytes()
+	switch aw.compression {
+	case bigquery.Deflate:
+		aw.compressed.Reset()
+		if aw.flateW == nil {
+			var err error
+			if aw.flateW, err = flate.NewWriter(&aw.compressed, flate.DefaultCompression); err != nil {
+				return fmt.Errorf("avro writer: create deflate writer: %w", err)
+			}
+		} else {
+			aw.flateW.Reset(&aw.compressed)
+		}
+		if _, err := aw.flateW.Write(data); err != nil {
+			return fmt.Errorf("avro writer: deflate block: %w", err)
+		}
+		if err := aw.flateW.Close(); err != nil {
+			return fmt.Errorf("avro writer: deflate block: %w", err)
+		}
+		data = aw.compressed.Bytes()
+	case bigquery.Snappy:
+		encoded := snappyEncode(data)
+		// the snappy codec of Avro appends the big-endian CRC32 checksum of the uncompressed data
+		var checksum [4]byte
+		binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(data))
+		data = append(encoded, checksum[:]...)
+	}
+
+	var header bytes.Buffer
+	writeLong(&header, aw.blockCount)
+	writeLong(&header, int64(len(data)))
+	for _, b := range [][]byte{header.Bytes(), data, aw.sync[:]} {
+		if _, err := aw.w.Write(b); err != nil {
+			return fmt.Errorf("avro writer: write block: %w", err)
+		}
+	}
+	aw.block.Reset()
+	aw.blockCount = 0
+	return nil
+}
+
+// writeRecord writes the (nested) row as an Avro record of the given schema.
+func writeRecord(buf *bytes.Buffer, schema bigquery.Schema, row interface{}) error {
+	values, err := rowValues(schema, row)
+	if err != nil {
+		return err
+	}
+	for _, field := range schema {
+		v, ok := fieldValue(values, field.Name)
+		if err := writeField(buf, field, v, ok); err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeField writes the value of a field, where defined is false in case the field has no value.
+func writeField(buf *bytes.Buffer, field *bigquery.FieldSchema, v interface{}, defined bool) error {
+	if field.Repeated {
+		if !defined {
+			// an empty array
+			writeLong(buf, 0)
+			return nil
+		}
+		items, err := repeatedValues(v)
+		if err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			writeLong(buf, int64(len(items)))
+			for i, item := range items {
+				if err := writeValue(buf, field, item); err != nil {
+					return fmt.Errorf("index %d: %w", i, err)
+				}
+			}
+		}
+		writeLong(buf, 0)
+		return nil
+	}
+	if field.Required {
+		if !defined {
+			return fmt.Errorf("%w: missing value for required field", ErrInvalidData)
+		}
+		return writeValue(buf, field, v)
+	}
+	// union of null (index 0) and the field type (index 1)
+	if !defined {
+		writeLong(buf, 0)
+		return nil
+	}
+	writeLong(buf, 1)
+	return writeValue(buf, field, v)
+}
+
+// writeValue writes a single (non-null) value of the given field.
+func writeValue(buf *bytes.Buffer, field *bigquery.FieldSchema, v interface{}) error {
+	switch field.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		b, ok, err := stringValue(v)
+		if err != nil {
+			return err
+		}
+		if ok {
+			writeBytes(buf, b)
+			return nil
+		}
+	case bigquery.BytesFieldType:
+		if b, ok := bytesValue(v); ok {
+			writeBytes(buf, b)
+			return nil
+		}
+	case bigquery.IntegerFieldType:
+		if i, ok := integerValue(v); ok {
+			writeLong(buf, i)
+			return nil
+		}
+	case bigquery.FloatFieldType:
+		if f, ok := value.ToFloat64(v); ok {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+			buf.Write(b[:])
+			return nil
+		}
+	case bigquery.BooleanFieldType:
+		if b, ok := value.ToBool(v); ok {
+			if b {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+			return nil
+		}
+	case bigquery.TimestampFieldType:
+		if t, ok := value.ToTimestamp(v); ok {
+			writeLong(buf, timestampMicros(t))
+			return nil
+		}
+	case bigquery.DateFieldType:
+		if d, ok := value.ToDate(v); ok {
+			writeLong(buf, int64(dateDays(d)))
+			return nil
+		}
+	case bigquery.TimeFieldType:
+		if t, ok := value.ToTime(v); ok {
+			writeLong(buf, timeMicros(t))
+			return nil
+		}
+	case bigquery.DateTimeFieldType:
+		if dt, ok := value.ToDateTime(v); ok {
+			writeString(buf, formatDateTime(dt))
+			return nil
+		}
+	case bigquery.NumericFieldType:
+		if r, ok := value.ToRat(v); ok {
+			writeBytes(buf, encodeDecimal(r, numericScale))
+			return nil
+		}
+	case bigquery.BigNumericFieldType:
+		if r, ok := value.ToRat(v); ok {
+			writeBytes(buf, encodeDecimal(r, bigNumericScale))
+			return nil
+		}
+	case bigquery.RecordFieldType:
+		return writeRecord(buf, field.Schema, v)
+	}
+	return fmt.Errorf("%w: unexpected value of type %T for %s field", ErrInvalidData, v, field.Type)
+}
+
+// writeLong writes the zigzag varint encoding of an Avro int or long.
+func writeLong(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+// writeBytes writes the length-prefixed bytes.
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeLong(buf, int64(len(b)))
+	buf.Write(b)
+}
+
+// writeString writes the length-prefixed string.
+func writeString(buf *bytes.Buffer, s string) {
+	writeLong(buf, int64(len(s)))
+	buf.WriteString(s)
+}
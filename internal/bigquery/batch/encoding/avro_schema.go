@@ -0,0 +1,144 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// AvroSchema is an Avro record schema derived from a BigQuery schema,
+// used to write Avro Object Container Files (see AvroWriter).
+//
+// BigQuery types are mapped to the Avro types as documented at
+// https://cloud.google.com/bigquery/docs/loading-data-cloud-storage-avro#avro_conversions,
+// using the logical types for TIMESTAMP (timestamp-micros), DATE (date), TIME (time-micros),
+// DATETIME (datetime) and NUMERIC and BIGNUMERIC (decimal) fields, which requires the load job
+// to be configured to use the Avro logical types. NULLABLE fields are a union of null and their type,
+// REPEATED fields an array of their type and RECORD fields a (nested) record.
+type AvroSchema struct {
+	schema bigquery.Schema
+	json   []byte
+}
+
+// NewAvroSchema derives an Avro schema from the given BigQuery schema.
+func NewAvroSchema(schema bigquery.Schema) (*AvroSchema, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("new avro schema: %w: empty BigQuery schema", ErrInvalidData)
+	}
+	record, err := avroRecord("root", schema)
+	if err != nil {
+		return nil, fmt.Errorf("new avro schema: %w", err)
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("new avro schema: marshal as Json: %w", err)
+	}
+	return &AvroSchema{
+		schema: schema,
+		json:   b,
+	}, nil
+}
+
+// String returns the Json definition of the Avro schema.
+func (s *AvroSchema) String() string {
+	return string(s.json)
+}
+
+type avroRecordType struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Fields []avroFieldType `json:"fields"`
+}
+
+type avroFieldType struct {
+	Name    string           `json:"name"`
+	Type    interface{}      `json:"type"`
+	Default *json.RawMessage `json:"default,omitempty"`
+}
+
+var avroNullDefault = json.RawMessage("null")
+
+// avroRecord returns the Avro record type for the given schema,
+// using the given name, which is used as the prefix for the names of its nested records,
+// as these have to be unique within an Avro schema.
+func avroRecord(name string, schema bigquery.Schema) (*avroRecordType, error) {
+	record := &avroRecordType{
+		Type:   "record",
+		Name:   name,
+		Fields: make([]avroFieldType, 0, len(schema)),
+	}
+	for _, field := range schema {
+		fieldType, err := avroType(name+"_"+field.Name, field)
+		if err != nil {
+			return nil, err
+		}
+		avroField := avroFieldType{
+			Name: field.Name,
+			Type: fieldType,
+		}
+		if !field.Required && !field.Repeated {
+			avroField.Type = []interface{}{"null", fieldType}
+			avroField.Default = &avroNullDefault
+		}
+		record.Fields = append(record.Fields, avroField)
+	}
+	return record, nil
+}
+
+// avroType returns the Avro type of the values of the given field.
+func avroType(name string, field *bigquery.FieldSchema) (interface{}, error) {
+	var valueType interface{}
+	switch field.Type {
+	case bigquery.StringFieldType:
+		valueType = "string"
+	case bigquery.GeographyFieldType:
+		valueType = map[string]string{"type": "string", "sqlType": "GEOGRAPHY"}
+	case bigquery.BytesFieldType:
+		valueType = "bytes"
+	case bigquery.IntegerFieldType:
+		valueType = "long"
+	case bigquery.FloatFieldType:
+		valueType = "double"
+	case bigquery.BooleanFieldType:
+		valueType = "boolean"
+	case bigquery.TimestampFieldType:
+		valueType = map[string]string{"type": "long", "logicalType": "timestamp-micros"}
+	case bigquery.DateFieldType:
+		valueType = map[string]string{"type": "int", "logicalType": "date"}
+	case bigquery.TimeFieldType:
+		valueType = map[string]string{"type": "long", "logicalType": "time-micros"}
+	case bigquery.DateTimeFieldType:
+		valueType = map[string]string{"type": "string", "logicalType": "datetime"}
+	case bigquery.NumericFieldType:
+		valueType = map[string]interface{}{"type": "bytes", "logicalType": "decimal", "precision": numericPrecision, "scale": numericScale}
+	case bigquery.BigNumericFieldType:
+		valueType = map[string]interface{}{"type": "bytes", "logicalType": "decimal", "precision": bigNumericPrecision, "scale": bigNumericScale}
+	case bigquery.RecordFieldType:
+		record, err := avroRecord(name, field.Schema)
+		if err != nil {
+			return nil, err
+		}
+		valueType = record
+	default:
+		return nil, fmt.Errorf("field %s: %w: unsupported BigQuery type %s", field.Name, ErrInvalidData, field.Type)
+	}
+	if field.Repeated {
+		return map[string]interface{}{"type": "array", "items": valueType}, nil
+	}
+	return valueType, nil
+}
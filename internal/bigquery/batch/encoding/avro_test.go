@@ -0,0 +1,321 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+// avroReader decodes the Avro binary encoding, as written by the AvroWriter.
+type avroReader struct {
+	t   *testing.T
+	buf *bytes.Reader
+}
+
+func (r *avroReader) long() int64 {
+	v, err := binary.ReadVarint(r.buf)
+	test.AssertNoErrorFatal(r.t, err)
+	return v
+}
+
+func (r *avroReader) bytes() []byte {
+	b := make([]byte, r.long())
+	_, err := r.buf.Read(b)
+	if len(b) > 0 {
+		test.AssertNoErrorFatal(r.t, err)
+	}
+	return b
+}
+
+func (r *avroReader) fixed(n int) []byte {
+	b := make([]byte, n)
+	_, err := r.buf.Read(b)
+	test.AssertNoErrorFatal(r.t, err)
+	return b
+}
+
+func (r *avroReader) record(schema bigquery.Schema) map[string]interface{} {
+	row := make(map[string]interface{}, len(schema))
+	for _, field := range schema {
+		switch {
+		case field.Repeated:
+			var values []interface{}
+			for n := r.long(); n != 0; n = r.long() {
+				for i := int64(0); i < n; i++ {
+					values = append(values, r.value(field))
+				}
+			}
+			row[field.Name] = values
+		case field.Required:
+			row[field.Name] = r.value(field)
+		default:
+			if r.long() == 0 {
+				row[field.Name] = nil
+			} else {
+				row[field.Name] = r.value(field)
+			}
+		}
+	}
+	return row
+}
+
+func (r *avroReader) value(field *bigquery.FieldSchema) interface{} {
+	switch field.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType, bigquery.DateTimeFieldType:
+		return string(r.bytes())
+	case bigquery.BytesFieldType:
+		return r.bytes()
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		b := r.bytes()
+		x := new(big.Int).SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+		}
+		return x.String()
+	case bigquery.FloatFieldType:
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.fixed(8)))
+	case bigquery.BooleanFieldType:
+		return r.fixed(1)[0] == 1
+	case bigquery.RecordFieldType:
+		return r.record(field.Schema)
+	default:
+		return r.long()
+	}
+}
+
+// readAvroFile decodes all rows of the Avro Object Container File, validating its header and blocks.
+func readAvroFile(t *testing.T, b []byte, schema bigquery.Schema) (string, []map[string]interface{}) {
+	t.Helper()
+	test.AssertTrue(t, bytes.HasPrefix(b, avroMagic))
+	r := &avroReader{t: t, buf: bytes.NewReader(b[len(avroMagic):])}
+	metadata := map[string]string{}
+	for n := r.long(); n != 0; n = r.long() {
+		for i := int64(0); i < n; i++ {
+			key := string(r.bytes())
+			metadata[key] = string(r.bytes())
+		}
+	}
+	sync := r.fixed(avroSyncSize)
+	var rows []map[string]interface{}
+	for r.buf.Len() > 0 {
+		count := r.long()
+		data := r.bytes()
+		switch metadata["avro.codec"] {
+		case "deflate":
+			var err error
+			data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+			test.AssertNoErrorFatal(t, err)
+		case "snappy":
+			checksum := binary.BigEndian.Uint32(data[len(data)-4:])
+			var err error
+			data, err = snappyDecode(data[:len(data)-4])
+			test.AssertNoErrorFatal(t, err)
+			test.AssertEqual(t, crc32.ChecksumIEEE(data), checksum)
+		}
+		test.AssertEqual(t, sync, r.fixed(avroSyncSize))
+		blockReader := &avroReader{t: t, buf: bytes.NewReader(data)}
+		for i := int64(0); i < count; i++ {
+			rows = append(rows, blockReader.record(schema))
+		}
+		test.AssertEqual(t, 0, blockReader.buf.Len())
+	}
+	var avroSchema map[string]interface{}
+	test.AssertNoErrorFatal(t, json.Unmarshal([]byte(metadata["avro.schema"]), &avroSchema))
+	return metadata["avro.codec"], rows
+}
+
+var testAvroSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "count", Type: bigquery.IntegerFieldType},
+	{Name: "ratio", Type: bigquery.FloatFieldType},
+	{Name: "enabled", Type: bigquery.BooleanFieldType},
+	{Name: "data", Type: bigquery.BytesFieldType},
+	{Name: "created_at", Type: bigquery.TimestampFieldType},
+	{Name: "day", Type: bigquery.DateFieldType},
+	{Name: "clock", Type: bigquery.TimeFieldType},
+	{Name: "moment", Type: bigquery.DateTimeFieldType},
+	{Name: "price", Type: bigquery.NumericFieldType},
+	{Name: "big_price", Type: bigquery.BigNumericFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "street", Type: bigquery.StringFieldType},
+		{Name: "number", Type: bigquery.IntegerFieldType, Required: true},
+	}},
+}
+
+type testAvroAddress struct {
+	Street string
+	Number int
+}
+
+type testAvroRow struct {
+	Name      string
+	Count     bigquery.NullInt64
+	Ratio     float64
+	Enabled   bool
+	Data      []byte
+	CreatedAt time.Time `bigquery:"created_at"`
+	Day       civil.Date
+	Clock     civil.Time
+	Moment    civil.DateTime
+	Price     *big.Rat
+	BigPrice  *big.Rat `bigquery:"big_price"`
+	Tags      []string
+	Address   *testAvroAddress
+	Ignored   string
+}
+
+func TestNewAvroSchema(t *testing.T) {
+	schema, err := NewAvroSchema(bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "created_at", Type: bigquery.TimestampFieldType},
+		{Name: "price", Type: bigquery.NumericFieldType},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "day", Type: bigquery.DateFieldType, Required: true},
+		}},
+	})
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, `{"type":"record","name":"root","fields":[`+
+		`{"name":"name","type":"string"},`+
+		`{"name":"created_at","type":["null",{"logicalType":"timestamp-micros","type":"long"}],"default":null},`+
+		`{"name":"price","type":["null",{"logicalType":"decimal","precision":38,"scale":9,"type":"bytes"}],"default":null},`+
+		`{"name":"tags","type":{"items":"string","type":"array"}},`+
+		`{"name":"address","type":["null",{"type":"record","name":"root_address","fields":[{"name":"day","type":{"logicalType":"date","type":"int"}}]}],"default":null}]}`,
+		schema.String())
+}
+
+func TestNewAvroSchemaErrors(t *testing.T) {
+	_, err := NewAvroSchema(nil)
+	test.AssertIsError(t, err, ErrInvalidData)
+	_, err = NewAvroSchema(bigquery.Schema{{Name: "foo", Type: bigquery.FieldType("INTERVAL")}})
+	test.AssertIsError(t, err, ErrInvalidData)
+}
+
+func TestAvroWriterRows(t *testing.T) {
+	createdAt := time.Date(2021, 10, 18, 15, 4, 5, 123456789, time.UTC)
+	for _, compression := range []bigquery.Compression{bigquery.None, bigquery.Deflate, bigquery.Snappy} {
+		newRowWriter, err := NewAvroWriterFunc(testAvroSchema, compression)
+		test.AssertNoErrorFatal(t, err)
+		var buf bytes.Buffer
+		writer, err := newRowWriter(&buf)
+		test.AssertNoErrorFatal(t, err)
+
+		test.AssertNoError(t, writer.WriteRow(&testAvroRow{
+			Name:      "foo",
+			Count:     bigquery.NullInt64{Int64: 42, Valid: true},
+			Ratio:     0.5,
+			Enabled:   true,
+			Data:      []byte("bar"),
+			CreatedAt: createdAt,
+			Day:       civil.Date{Year: 1970, Month: time.January, Day: 11},
+			Clock:     civil.Time{Hour: 1, Minute: 2, Second: 3, Nanosecond: 4000},
+			Moment:    civil.DateTime{Date: civil.Date{Year: 2021, Month: time.October, Day: 18}, Time: civil.Time{Hour: 15, Minute: 4, Second: 5, Nanosecond: 123456789}},
+			Price:     big.NewRat(-1, 4),
+			BigPrice:  big.NewRat(1, 1),
+			Tags:      []string{"a", "b"},
+			Address:   &testAvroAddress{Street: "main", Number: 1},
+			Ignored:   "ignored",
+		}))
+		// a required field is missing, nothing is written
+		test.AssertIsError(t, writer.WriteRow(map[string]interface{}{"count": 1}), ErrInvalidData)
+		// an invalid value, nothing is written
+		test.AssertIsError(t, writer.WriteRow(map[string]interface{}{"name": "foo", "count": "nope"}), ErrInvalidData)
+		test.AssertNoError(t, writer.WriteRow(map[string]bigquery.Value{
+			"NAME":    "bar",
+			"unknown": true,
+			"address": map[string]interface{}{"number": 2},
+		}))
+		test.AssertNoError(t, writer.Close())
+
+		codec, rows := readAvroFile(t, buf.Bytes(), testAvroSchema)
+		test.AssertEqual(t, map[bigquery.Compression]string{bigquery.None: "null", bigquery.Deflate: "deflate", bigquery.Snappy: "snappy"}[compression], codec)
+		test.AssertEqual(t, []map[string]interface{}{
+			{
+				"name":       "foo",
+				"count":      int64(42),
+				"ratio":      0.5,
+				"enabled":    true,
+				"data":       []byte("bar"),
+				"created_at": createdAt.UnixNano() / 1e3,
+				"day":        int64(10),
+				"clock":      int64(3723000004),
+				"moment":     "2021-10-18T15:04:05.123456",
+				"price":      "-250000000",
+				"big_price":  new(big.Int).Exp(big.NewInt(10), big.NewInt(38), nil).String(),
+				"tags":       []interface{}{"a", "b"},
+				"address":    map[string]interface{}{"street": "main", "number": int64(1)},
+			},
+			{
+				"name":       "bar",
+				"count":      nil,
+				"ratio":      nil,
+				"enabled":    nil,
+				"data":       nil,
+				"created_at": nil,
+				"day":        nil,
+				"clock":      nil,
+				"moment":     nil,
+				"price":      nil,
+				"big_price":  nil,
+				"tags":       []interface{}(nil),
+				"address":    map[string]interface{}{"street": nil, "number": int64(2)},
+			},
+		}, rows)
+	}
+}
+
+func TestAvroWriterBlocks(t *testing.T) {
+	schema := bigquery.Schema{{Name: "data", Type: bigquery.StringFieldType, Required: true}}
+	avroSchema, err := NewAvroSchema(schema)
+	test.AssertNoErrorFatal(t, err)
+	var buf bytes.Buffer
+	writer, err := NewAvroWriter(&buf, avroSchema, bigquery.Snappy)
+	test.AssertNoErrorFatal(t, err)
+	headerSize := buf.Len()
+
+	// closing a file without rows only writes the header
+	test.AssertNoError(t, writer.Close())
+	test.AssertEqual(t, headerSize, buf.Len())
+
+	row := map[string]interface{}{"data": string(bytes.Repeat([]byte("x"), 1024))}
+	for i := 0; i < 1500; i++ {
+		test.AssertNoError(t, writer.WriteRow(row))
+	}
+	// a full block is flushed while writing
+	test.AssertTrue(t, buf.Len() > headerSize)
+	test.AssertNoError(t, writer.Close())
+	_, rows := readAvroFile(t, buf.Bytes(), schema)
+	test.AssertEqual(t, 1500, len(rows))
+}
+
+func TestNewAvroWriterInvalidCompression(t *testing.T) {
+	_, err := NewAvroWriterFunc(testAvroSchema, bigquery.Gzip)
+	test.AssertIsError(t, err, ErrInvalidData)
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/value"
+)
+
+// CSVWriter is a RowWriter which writes the rows as a CSV file,
+// with the values written in the order of the columns of the BigQuery schema.
+//
+// The file is written according to the given bigquery.CSVOptions, as to be loaded using the same options:
+// the FieldDelimiter is used as the separator of the values, the Quote is used to quote values when required
+// (unless ForceZeroQuote is set, in which case values requiring quotes are rejected), the column names are
+// written as the SkipLeadingRows header row(s) and the file is encoded using the given Encoding.
+// Values containing a newline are rejected unless AllowQuotedNewlines is set.
+//
+// A row can be any value supported by the AvroWriter, but as CSV files have no support for nested or repeated values,
+// the schema cannot contain RECORD or REPEATED fields. Null values are written as empty fields, which is the default
+// null marker used by BigQuery, while empty strings are written as a quoted empty value.
+// BYTES values are written as their base64 encoding, and temporal values using the canonical
+// BigQuery formats with microsecond precision.
+type CSVWriter struct {
+	w         io.Writer
+	schema    bigquery.Schema
+	delimiter string
+	quote     string
+	newlines  bool
+	latin1    bool
+
+	header bool
+	skip   int64
+	row    bytes.Buffer
+	out    []byte
+}
+
+// interface compile-time compliance check
+var _ RowWriter = (*CSVWriter)(nil)
+
+// NewCSVWriter creates a new CSVWriter, writing the rows of the given BigQuery schema
+// as a CSV file using the given options.
+func NewCSVWriter(w io.Writer, schema bigquery.Schema, options bigquery.CSVOptions) (*CSVWriter, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("new csv writer: %w: empty BigQuery schema", ErrInvalidData)
+	}
+	for _, field := range schema {
+		if field.Repeated || field.Type == bigquery.RecordFieldType {
+			return nil, fmt.Errorf("new csv writer: field %s: %w: nested or repeated values are not supported for CSV", field.Name, ErrInvalidData)
+		}
+	}
+	cw := &CSVWriter{
+		w:         w,
+		schema:    schema,
+		delimiter: options.FieldDelimiter,
+		quote:     options.Quote,
+		newlines:  options.AllowQuotedNewlines,
+		skip:      options.SkipLeadingRows,
+	}
+	switch cw.delimiter {
+	case "":
+		cw.delimiter = ","
+	case `\t`, "tab":
+		// escape sequence (and alias) supported by BigQuery
+		cw.delimiter = "\t"
+	}
+	if cw.quote == "" {
+		cw.quote = `"`
+	}
+	if options.ForceZeroQuote {
+		cw.quote = ""
+	} else if utf8.RuneCountInString(cw.quote) != 1 {
+		return nil, fmt.Errorf("new csv writer: %w: quote %q has to be a single character", ErrInvalidData, cw.quote)
+	}
+	switch options.Encoding {
+	case "", bigquery.UTF_8:
+	case bigquery.ISO_8859_1:
+		cw.latin1 = true
+	default:
+		return nil, fmt.Errorf("new csv writer: %w: unsupported encoding %q", ErrInvalidData, options.Encoding)
+	}
+	if strings.ContainsAny(cw.delimiter, "\r\n") || (cw.quote != "" && strings.Contains(cw.delimiter, cw.quote)) {
+		return nil, fmt.Errorf("new csv writer: %w: invalid delimiter %q", ErrInvalidData, cw.delimiter)
+	}
+	return cw, nil
+}
+
+// NewCSVWriterFunc returns a NewRowWriterFunc creating a CSVWriter
+// for the given BigQuery schema and options.
+func NewCSVWriterFunc(schema bigquery.Schema, options bigquery.CSVOptions) (NewRowWriterFunc, error) {
+	// validate the schema and options once, rather than for each file
+	if _, err := NewCSVWriter(ioutil.Discard, schema, options); err != nil {
+		return nil, err
+	}
+	return func(w io.Writer) (RowWriter, error) {
+		return NewCSVWriter(w, schema, options)
+	}, nil
+}
+
+// WriteRow implements RowWriter::WriteRow
+func (cw *CSVWriter) WriteRow(row interface{}) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	values, err := rowValues(cw.schema, row)
+	if err != nil {
+		return fmt.Errorf("csv writer: write row: %w", err)
+	}
+	cw.row.Reset()
+	for i, field := range cw.schema {
+		if i > 0 {
+			cw.row.WriteString(cw.delimiter)
+		}
+		v, ok := fieldValue(values, field.Name)
+		if !ok {
+			if field.Required {
+				return fmt.Errorf("csv writer: write row: field %s: %w: missing value for required field", field.Name, ErrInvalidData)
+			}
+			continue // null
+		}
+		s, err := csvValue(field, v)
+		if err != nil {
+			return fmt.Errorf("csv writer: write row: field %s: %w", field.Name, err)
+		}
+		if err := cw.writeField(s); err != nil {
+			return fmt.Errorf("csv writer: write row: field %s: %w", field.Name, err)
+		}
+	}
+	cw.row.WriteByte('\n')
+	return cw.write(cw.row.Bytes())
+}
+
+// writeHeader writes the column names as the header row(s), prior to the first row.
+func (cw *CSVWriter) writeHeader() error {
+	if cw.header {
+		return nil
+	}
+	cw.row.Reset()
+	for i := int64(0); i < cw.skip; i++ {
+		for j, field := range cw.schema {
+			if j > 0 {
+				cw.row.WriteString(cw.delimiter)
+			}
+			if err := cw.writeField(field.Name); err != nil {
+				return fmt.Errorf("csv writer: write header: %w", err)
+			}
+		}
+		cw.row.WriteByte('\n')
+	}
+	if err := cw.write(cw.row.Bytes()); err != nil {
+		return fmt.Errorf("csv writer: write header: %w", err)
+	}
+	cw.header = true
+	return nil
+}
+
+// writeField writes a single (non-null) value, quoting it if required.
+func (cw *CSVWriter) writeField(s string) error {
+	needsQuotes := s == "" || strings.Contains(s, cw.delimiter) || strings.ContainsAny(s, "\r\n") ||
+		(cw.quote != "" && strings.Contains(s, cw.quote))
+	if strings.ContainsAny(s, "\r\n") && !cw.newlines {
+		return fmt.Errorf("%w: newline in value, which requires AllowQuotedNewlines", ErrInvalidData)
+	}
+	if !needsQuotes {
+		cw.row.WriteString(s)
+		return nil
+	}
+	if cw.quote == "" {
+		if s == "" {
+			// without quotes an empty string can only be written as null
+			return nil
+		}
+		return fmt.Errorf("%w: value requires quotes, which are disabled", ErrInvalidData)
+	}
+	cw.row.WriteString(cw.quote)
+	cw.row.WriteString(strings.ReplaceAll(s, cw.quote, cw.quote+cw.quote))
+	cw.row.WriteString(cw.quote)
+	return nil
+}
+
+// write the (UTF-8) bytes, encoded using the encoding of the writer.
+func (cw *CSVWriter) write(b []byte) error {
+	if cw.latin1 {
+		cw.out = cw.out[:0]
+		for len(b) > 0 {
+			r, size := utf8.DecodeRune(b)
+			if r > 0xff {
+				return fmt.Errorf("csv writer: %w: character %q cannot be encoded as ISO-8859-1", ErrInvalidData, r)
+			}
+			cw.out = append(cw.out, byte(r))
+			b = b[size:]
+		}
+		b = cw.out
+	}
+	if _, err := cw.w.Write(b); err != nil {
+		return fmt.Errorf("csv writer: write: %w", err)
+	}
+	return nil
+}
+
+// Close implements RowWriter::Close
+func (cw *CSVWriter) Close() error {
+	// ensure a file without rows still has its header
+	return cw.writeHeader()
+}
+
+// csvValue returns the string representation of a single (non-null) value of the given field.
+func csvValue(field *bigquery.FieldSchema, v interface{}) (string, error) {
+	switch field.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		b, ok, err := stringValue(v)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return string(b), nil
+		}
+	case bigquery.BytesFieldType:
+		if b, ok := bytesValue(v); ok {
+			return base64.StdEncoding.EncodeToString(b), nil
+		}
+	case bigquery.IntegerFieldType:
+		if i, ok := integerValue(v); ok {
+			return strconv.FormatInt(i, 10), nil
+		}
+	case bigquery.FloatFieldType:
+		if f, ok := value.ToFloat64(v); ok {
+			return strconv.FormatFloat(f, 'g', -1, 64), nil
+		}
+	case bigquery.BooleanFieldType:
+		if b, ok := value.ToBool(v); ok {
+			return strconv.FormatBool(b), nil
+		}
+	case bigquery.TimestampFieldType:
+		if t, ok := value.ToTimestamp(v); ok {
+			return t.UTC().Format("2006-01-02 15:04:05.999999") + " UTC", nil
+		}
+	case bigquery.DateFieldType:
+		if d, ok := value.ToDate(v); ok {
+			return d.String(), nil
+		}
+	case bigquery.TimeFieldType:
+		if t, ok := value.ToTime(v); ok {
+			return formatTime(t), nil
+		}
+	case bigquery.DateTimeFieldType:
+		if dt, ok := value.ToDateTime(v); ok {
+			return formatDateTime(dt), nil
+		}
+	case bigquery.NumericFieldType:
+		if r, ok := value.ToRat(v); ok {
+			return formatDecimal(r, numericScale), nil
+		}
+	case bigquery.BigNumericFieldType:
+		if r, ok := value.ToRat(v); ok {
+			return formatDecimal(r, bigNumericScale), nil
+		}
+	}
+	return "", fmt.Errorf("%w: unexpected value of type %T for %s field", ErrInvalidData, v, field.Type)
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+var testCSVSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "count", Type: bigquery.IntegerFieldType},
+	{Name: "ratio", Type: bigquery.FloatFieldType},
+	{Name: "enabled", Type: bigquery.BooleanFieldType},
+	{Name: "data", Type: bigquery.BytesFieldType},
+	{Name: "created_at", Type: bigquery.TimestampFieldType},
+	{Name: "day", Type: bigquery.DateFieldType},
+	{Name: "clock", Type: bigquery.TimeFieldType},
+	{Name: "moment", Type: bigquery.DateTimeFieldType},
+	{Name: "price", Type: bigquery.NumericFieldType},
+}
+
+type testCSVRow struct {
+	Price     *big.Rat
+	Name      string
+	Count     int
+	Ratio     float64
+	Enabled   bool
+	Data      []byte
+	CreatedAt time.Time `bigquery:"created_at"`
+	Day       civil.Date
+	Clock     civil.Time
+	Moment    civil.DateTime
+	Ignored   string
+}
+
+func TestCSVWriterRows(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewCSVWriter(&buf, testCSVSchema, bigquery.CSVOptions{SkipLeadingRows: 1})
+	test.AssertNoErrorFatal(t, err)
+
+	test.AssertNoError(t, writer.WriteRow(&testCSVRow{
+		Price:     big.NewRat(-5, 4),
+		Name:      `foo, "bar"`,
+		Count:     42,
+		Ratio:     0.5,
+		Enabled:   true,
+		Data:      []byte("bar"),
+		CreatedAt: time.Date(2021, 10, 18, 17, 4, 5, 123456789, time.FixedZone("CEST", 7200)),
+		Day:       civil.Date{Year: 2021, Month: time.October, Day: 18},
+		Clock:     civil.Time{Hour: 1, Minute: 2, Second: 3},
+		Moment:    civil.DateTime{Date: civil.Date{Year: 2021, Month: time.October, Day: 18}, Time: civil.Time{Hour: 15, Minute: 4, Second: 5, Nanosecond: 1000}},
+		Ignored:   "ignored",
+	}))
+	// a required field is missing, nothing is written
+	test.AssertIsError(t, writer.WriteRow(map[string]interface{}{"count": 1}), ErrInvalidData)
+	// newlines require quoted newlines to be allowed
+	test.AssertIsError(t, writer.WriteRow(map[string]interface{}{"name": "foo\nbar"}), ErrInvalidData)
+	test.AssertNoError(t, writer.WriteRow(map[string]bigquery.Value{"NAME": "", "unknown": true}))
+	test.AssertNoError(t, writer.Close())
+
+	test.AssertEqual(t, "name,count,ratio,enabled,data,created_at,day,clock,moment,price\n"+
+		`"foo, ""bar""",42,0.5,true,YmFy,2021-10-18 15:04:05.123456 UTC,2021-10-18,01:02:03,2021-10-18T15:04:05.000001,-1.25`+"\n"+
+		`"",,,,,,,,,`+"\n", buf.String())
+}
+
+func TestCSVWriterOptions(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "a", Type: bigquery.StringFieldType},
+		{Name: "b", Type: bigquery.StringFieldType},
+	}
+	testCases := []struct {
+		Options  bigquery.CSVOptions
+		Row      map[string]interface{}
+		Expected string
+		Err      bool
+	}{
+		{bigquery.CSVOptions{FieldDelimiter: `\t`}, map[string]interface{}{"a": "x,y", "b": "z"}, "x,y\tz\n", false},
+		{bigquery.CSVOptions{FieldDelimiter: "|", Quote: "'"}, map[string]interface{}{"a": "x|'y'", "b": "z"}, "'x|''y'''|z\n", false},
+		{bigquery.CSVOptions{AllowQuotedNewlines: true}, map[string]interface{}{"a": "x\ny"}, "\"x\ny\",\n", false},
+		{bigquery.CSVOptions{ForceZeroQuote: true}, map[string]interface{}{"a": `"x"`, "b": ""}, "\"x\",\n", false},
+		{bigquery.CSVOptions{ForceZeroQuote: true}, map[string]interface{}{"a": "x,y"}, "", true},
+		{bigquery.CSVOptions{Encoding: bigquery.ISO_8859_1}, map[string]interface{}{"a": "café"}, "caf\xe9,\n", false},
+		{bigquery.CSVOptions{Encoding: bigquery.ISO_8859_1}, map[string]interface{}{"a": "€"}, "", true},
+		{bigquery.CSVOptions{SkipLeadingRows: 2}, map[string]interface{}{"b": "x"}, "a,b\na,b\n,x\n", false},
+	}
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		writer, err := NewCSVWriter(&buf, schema, testCase.Options)
+		test.AssertNoErrorFatal(t, err)
+		err = writer.WriteRow(testCase.Row)
+		if testCase.Err {
+			test.AssertIsError(t, err, ErrInvalidData)
+			continue
+		}
+		test.AssertNoError(t, err)
+		test.AssertNoError(t, writer.Close())
+		test.AssertEqual(t, testCase.Expected, buf.String())
+	}
+}
+
+func TestNewCSVWriterErrors(t *testing.T) {
+	testCases := []struct {
+		Schema  bigquery.Schema
+		Options bigquery.CSVOptions
+	}{
+		{nil, bigquery.CSVOptions{}},
+		{bigquery.Schema{{Name: "a", Type: bigquery.StringFieldType, Repeated: true}}, bigquery.CSVOptions{}},
+		{bigquery.Schema{{Name: "a", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{{Name: "b", Type: bigquery.StringFieldType}}}}, bigquery.CSVOptions{}},
+		{testCSVSchema, bigquery.CSVOptions{Quote: "''"}},
+		{testCSVSchema, bigquery.CSVOptions{Encoding: "UTF-16"}},
+		{testCSVSchema, bigquery.CSVOptions{FieldDelimiter: "\n"}},
+	}
+	for _, testCase := range testCases {
+		_, err := NewCSVWriterFunc(testCase.Schema, testCase.Options)
+		test.AssertIsError(t, err, ErrInvalidData)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encoding provides the row writers used by the batch client,
+// encoding rows into a file of the source format of its load jobs.
+package encoding
+
+import (
+	"errors"
+	"io"
+)
+
+// RowWriter is the interface required by the BigQuery batch client in order
+// to encode rows into a (load) file of a specific source format.
+type RowWriter interface {
+	// WriteRow encodes a single row. ErrInvalidData is to be returned in case the row
+	// could not be encoded, in which case none of it is written, such that the writer
+	// can still be used for subsequent rows. Any other error can be returned for all other possible error cases.
+	WriteRow(row interface{}) error
+
+	// Close finalizes the file, flushing all rows written so far to the underlying writer.
+	// It does not close the underlying writer itself.
+	Close() error
+}
+
+// NewRowWriterFunc creates a new RowWriter, writing a new file to the given writer.
+type NewRowWriterFunc func(w io.Writer) (RowWriter, error)
+
+// ErrInvalidData is an error that can be returned by a RowWriter
+// in case the given row was invalid within the context of that writer.
+var ErrInvalidData = errors.New("invalid data")
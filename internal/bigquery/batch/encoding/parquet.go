@@ -0,0 +1,781 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/value"
+)
+
+const (
+	// parquetRowGroupSize is the (uncompressed) size in bytes at which
+	// the rows written so far are flushed as a single row group.
+	parquetRowGroupSize = 8 << 20
+	// parquetCreatedBy is the application which wrote the Parquet file, as stored in its metadata.
+	parquetCreatedBy = "github.com/OTA-Insight/bqwriter"
+)
+
+var parquetMagic = []byte("PAR1")
+
+// Parquet (physical) types
+const (
+	parquetBoolean   int32 = 0
+	parquetInt32     int32 = 1
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Parquet field repetition types
+const (
+	parquetRequired int32 = 0
+	parquetOptional int32 = 1
+	parquetRepeated int32 = 2
+)
+
+// Parquet converted types
+const (
+	parquetConvertedUTF8            int32 = 0
+	parquetConvertedDecimal         int32 = 5
+	parquetConvertedDate            int32 = 6
+	parquetConvertedTimestampMicros int32 = 10
+)
+
+// Parquet encodings
+const (
+	parquetEncodingPlain int32 = 0
+	parquetEncodingRLE   int32 = 3
+)
+
+// Parquet compression codecs
+const (
+	parquetUncompressed int32 = 0
+	parquetSnappy       int32 = 1
+	parquetGzip         int32 = 2
+)
+
+// ParquetWriter is a RowWriter which writes the rows as a Parquet file,
+// using a Parquet schema derived from the BigQuery schema of the rows.
+//
+// BigQuery types are mapped to the Parquet types as documented at
+// https://cloud.google.com/bigquery/docs/loading-data-cloud-storage-parquet#type_conversions:
+// TIMESTAMP fields are written as INT64 timestamps (in microseconds, adjusted to UTC), DATETIME fields
+// as INT64 timestamps not adjusted to UTC, DATE fields as INT32 dates, TIME fields as INT64 times (in microseconds)
+// and NUMERIC and BIGNUMERIC fields as decimals. RECORD fields are written as groups and REPEATED fields
+// as repeated Parquet fields, such that no list inference is required.
+//
+// A row can be any value supported by the AvroWriter. The rows are buffered in memory as columns,
+// flushed as a row group of about 8 MiB, of which each column chunk is written as a single (compressed) data page.
+type ParquetWriter struct {
+	w      *countingWriter
+	codec  int32
+	schema bigquery.Schema
+	fields []*parquetField
+
+	elements  []parquetSchemaElement
+	columns   []*parquetColumn
+	marks     []parquetColumnMark
+	rowCount  int64
+	rowGroups []parquetRowGroup
+	totalRows int64
+
+	page bytes.Buffer
+}
+
+// interface compile-time compliance check
+var _ RowWriter = (*ParquetWriter)(nil)
+
+// NewParquetWriter creates a new ParquetWriter, writing the rows of the given BigQuery schema
+// as a Parquet file to the given writer. Supported compressions are bigquery.None, bigquery.Snappy and bigquery.Gzip.
+func NewParquetWriter(w io.Writer, schema bigquery.Schema, compression bigquery.Compression) (*ParquetWriter, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("new parquet writer: %w: empty BigQuery schema", ErrInvalidData)
+	}
+	pw := &ParquetWriter{
+		w:      &countingWriter{w: w},
+		schema: schema,
+	}
+	switch compression {
+	case bigquery.None, "":
+		pw.codec = parquetUncompressed
+	case bigquery.Snappy:
+		pw.codec = parquetSnappy
+	case bigquery.Gzip:
+		pw.codec = parquetGzip
+	default:
+		return nil, fmt.Errorf("new parquet writer: %w: unsupported compression %q", ErrInvalidData, compression)
+	}
+	pw.elements = append(pw.elements, parquetSchemaElement{name: "root", numChildren: int32(len(schema)), root: true})
+	var err error
+	if pw.fields, err = pw.newFields(schema, nil, 0, 0); err != nil {
+		return nil, fmt.Errorf("new parquet writer: %w", err)
+	}
+	pw.marks = make([]parquetColumnMark, len(pw.columns))
+
+	if _, err := pw.w.Write(parquetMagic); err != nil {
+		return nil, fmt.Errorf("new parquet writer: write header: %w", err)
+	}
+	return pw, nil
+}
+
+// NewParquetWriterFunc returns a NewRowWriterFunc creating a ParquetWriter
+// for the given BigQuery schema and compression.
+func NewParquetWriterFunc(schema bigquery.Schema, compression bigquery.Compression) (NewRowWriterFunc, error) {
+	// validate the schema and compression once, rather than for each file
+	if _, err := NewParquetWriter(ioutil.Discard, schema, compression); err != nil {
+		return nil, err
+	}
+	return func(w io.Writer) (RowWriter, error) {
+		return NewParquetWriter(w, schema, compression)
+	}, nil
+}
+
+// parquetField is a (nested) field of the Parquet schema,
+// used to shred the (nested) row values into the leaf columns.
+type parquetField struct {
+	field    *bigquery.FieldSchema
+	children []*parquetField // only defined for RECORD fields
+	column   *parquetColumn  // only defined for leaf fields
+	// all leaf columns of the field
+	columns []*parquetColumn
+	// the repetition level of the (repeated) field and
+	// the definition level in case the field is defined
+	repLevel, defLevel int
+}
+
+// newFields creates the fields (as well as their schema elements and leaf columns)
+// for the given schema, nested within a field at the given path and levels.
+func (pw *ParquetWriter) newFields(schema bigquery.Schema, path []string, repLevel, defLevel int) ([]*parquetField, error) {
+	fields := make([]*parquetField, 0, len(schema))
+	for _, field := range schema {
+		pf := &parquetField{
+			field:    field,
+			repLevel: repLevel,
+			defLevel: defLevel,
+		}
+		element := parquetSchemaElement{
+			name:       field.Name,
+			repetition: parquetRequired,
+		}
+		switch {
+		case field.Repeated:
+			element.repetition = parquetRepeated
+			pf.repLevel++
+			pf.defLevel++
+		case !field.Required:
+			element.repetition = parquetOptional
+			pf.defLevel++
+		}
+		fieldPath := append(append([]string(nil), path...), field.Name)
+
+		if field.Type == bigquery.RecordFieldType {
+			if len(field.Schema) == 0 {
+				return nil, fmt.Errorf("field %s: %w: RECORD without fields", field.Name, ErrInvalidData)
+			}
+			element.numChildren = int32(len(field.Schema))
+			pw.elements = append(pw.elements, element)
+			children, err := pw.newFields(field.Schema, fieldPath, pf.repLevel, pf.defLevel)
+			if err != nil {
+				return nil, err
+			}
+			pf.children = children
+			for _, child := range children {
+				pf.columns = append(pf.columns, child.columns...)
+			}
+			fields = append(fields, pf)
+			continue
+		}
+
+		if err := setParquetType(&element, field); err != nil {
+			return nil, err
+		}
+		pw.elements = append(pw.elements, element)
+		pf.column = &parquetColumn{
+			path:         fieldPath,
+			fieldType:    field.Type,
+			physicalType: element.physicalType,
+			maxRepLevel:  pf.repLevel,
+			maxDefLevel:  pf.defLevel,
+		}
+		pf.columns = []*parquetColumn{pf.column}
+		pw.columns = append(pw.columns, pf.column)
+		fields = append(fields, pf)
+	}
+	return fields, nil
+}
+
+// parquetSchemaElement is an element of the (flattened) Parquet schema.
+type parquetSchemaElement struct {
+	name         string
+	root         bool
+	repetition   int32
+	numChildren  int32 // only defined for groups
+	physicalType int32 // only defined for leaves
+	// optional annotations of leaves, the zero value of each meaning undefined
+	convertedType    *int32
+	scale, precision int32
+	writeLogicalType func(tw *thriftWriter)
+}
+
+// setParquetType sets the physical type and annotations of the (leaf) element for the given field.
+func setParquetType(element *parquetSchemaElement, field *bigquery.FieldSchema) error {
+	converted := func(t int32) *int32 { return &t }
+	switch field.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		element.physicalType = parquetByteArray
+		element.convertedType = converted(parquetConvertedUTF8)
+		element.writeLogicalType = func(tw *thriftWriter) { tw.structField(1, nil) }
+	case bigquery.BytesFieldType:
+		element.physicalType = parquetByteArray
+	case bigquery.IntegerFieldType:
+		element.physicalType = parquetInt64
+	case bigquery.FloatFieldType:
+		element.physicalType = parquetDouble
+	case bigquery.BooleanFieldType:
+		element.physicalType = parquetBoolean
+	case bigquery.TimestampFieldType:
+		element.physicalType = parquetInt64
+		element.convertedType = converted(parquetConvertedTimestampMicros)
+		element.writeLogicalType = writeParquetTimeType(8, true)
+	case bigquery.DateTimeFieldType:
+		element.physicalType = parquetInt64
+		element.writeLogicalType = writeParquetTimeType(8, false)
+	case bigquery.TimeFieldType:
+		element.physicalType = parquetInt64
+		element.writeLogicalType = writeParquetTimeType(7, false)
+	case bigquery.DateFieldType:
+		element.physicalType = parquetInt32
+		element.convertedType = converted(parquetConvertedDate)
+		element.writeLogicalType = func(tw *thriftWriter) { tw.structField(6, nil) }
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		element.physicalType = parquetByteArray
+		element.convertedType = converted(parquetConvertedDecimal)
+		element.scale, element.precision = numericScale, numericPrecision
+		if field.Type == bigquery.BigNumericFieldType {
+			element.scale, element.precision = bigNumericScale, bigNumericPrecision
+		}
+		scale, precision := element.scale, element.precision
+		element.writeLogicalType = func(tw *thriftWriter) {
+			tw.structField(5, func() {
+				tw.i32Field(1, scale)
+				tw.i32Field(2, precision)
+			})
+		}
+	default:
+		return fmt.Errorf("field %s: %w: unsupported BigQuery type %s", field.Name, ErrInvalidData, field.Type)
+	}
+	return nil
+}
+
+// writeParquetTimeType returns a function writing the TIME (7) or TIMESTAMP (8)
+// logical type with the given UTC adjustment and a microseconds unit.
+func writeParquetTimeType(id int16, adjustedToUTC bool) func(tw *thriftWriter) {
+	return func(tw *thriftWriter) {
+		tw.structField(id, func() {
+			tw.boolField(1, adjustedToUTC)
+			tw.structField(2, func() {
+				tw.structField(2, nil) // MICROS
+			})
+		})
+	}
+}
+
+// WriteRow implements RowWriter::WriteRow
+func (pw *ParquetWriter) WriteRow(row interface{}) error {
+	for i, column := range pw.columns {
+		pw.marks[i] = column.mark()
+	}
+	values, err := rowValues(pw.schema, row)
+	if err == nil {
+		err = pw.writeFields(pw.fields, values, 0)
+	}
+	if err != nil {
+		// undo the (partially) written row
+		for i, column := range pw.columns {
+			column.reset(pw.marks[i])
+		}
+		return fmt.Errorf("parquet writer: write row: %w", err)
+	}
+	pw.rowCount++
+	if pw.bufferedSize() >= parquetRowGroupSize {
+		return pw.flushRowGroup()
+	}
+	return nil
+}
+
+// writeFields shreds the values of a (nested) row into the leaf columns of the given fields.
+func (pw *ParquetWriter) writeFields(fields []*parquetField, values map[string]bigquery.Value, repLevel int) error {
+	for _, field := range fields {
+		v, ok := fieldValue(values, field.field.Name)
+		if err := pw.writeField(field, v, ok, repLevel); err != nil {
+			return fmt.Errorf("field %s: %w", field.field.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeField writes the value of a field, where defined is false in case the field has no value,
+// with the given repetition level used for the first value written to each of its columns.
+func (pw *ParquetWriter) writeField(field *parquetField, v interface{}, defined bool, repLevel int) error {
+	if field.field.Repeated {
+		var items []interface{}
+		if defined {
+			var err error
+			if items, err = repeatedValues(v); err != nil {
+				return err
+			}
+		}
+		if len(items) == 0 {
+			writeParquetNulls(field, repLevel, field.defLevel-1)
+			return nil
+		}
+		for i, item := range items {
+			if i > 0 {
+				repLevel = field.repLevel
+			}
+			if err := pw.writeDefinedField(field, item, repLevel); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+	if !defined {
+		if field.field.Required {
+			return fmt.Errorf("%w: missing value for required field", ErrInvalidData)
+		}
+		writeParquetNulls(field, repLevel, field.defLevel-1)
+		return nil
+	}
+	return pw.writeDefinedField(field, v, repLevel)
+}
+
+// writeDefinedField writes a single (non-null) value of the given field.
+func (pw *ParquetWriter) writeDefinedField(field *parquetField, v interface{}, repLevel int) error {
+	if field.children == nil {
+		return field.column.writeValue(v, repLevel, field.defLevel)
+	}
+	values, err := rowValues(field.field.Schema, v)
+	if err != nil {
+		return err
+	}
+	return pw.writeFields(field.children, values, repLevel)
+}
+
+// writeParquetNulls writes a null value, defined up to the given definition level, to all leaf columns of the field.
+func writeParquetNulls(field *parquetField, repLevel, defLevel int) {
+	for _, column := range field.columns {
+		column.writeLevels(repLevel, defLevel)
+	}
+}
+
+// bufferedSize returns the (uncompressed) size of the rows buffered so far.
+func (pw *ParquetWriter) bufferedSize() int {
+	var size int
+	for _, column := range pw.columns {
+		size += column.size()
+	}
+	return size
+}
+
+// Close implements RowWriter::Close
+func (pw *ParquetWriter) Close() error {
+	if err := pw.flushRowGroup(); err != nil {
+		return err
+	}
+	tw := new(thriftWriter)
+	pw.writeFileMetaData(tw)
+	var footer [4]byte
+	binary.LittleEndian.PutUint32(footer[:], uint32(tw.buf.Len()))
+	for _, b := range [][]byte{tw.buf.Bytes(), footer[:], parquetMagic} {
+		if _, err := pw.w.Write(b); err != nil {
+			return fmt.Errorf("parquet writer: write footer: %w", err)
+		}
+	}
+	return nil
+}
+
+// parquetRowGroup is the metadata of a row group written to the file.
+type parquetRowGroup struct {
+	numRows       int64
+	totalByteSize int64
+	chunks        []parquetColumnChunk
+}
+
+// parquetColumnChunk is the metadata of a column chunk written to the file.
+type parquetColumnChunk struct {
+	column           *parquetColumn
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// flushRowGroup writes all rows written since the previous row group as a single row group.
+func (pw *ParquetWriter) flushRowGroup() error {
+	if pw.rowCount == 0 {
+		return nil
+	}
+	rowGroup := parquetRowGroup{
+		numRows: pw.rowCount,
+		chunks:  make([]parquetColumnChunk, 0, len(pw.columns)),
+	}
+	for _, column := range pw.columns {
+		chunk, err := pw.writeColumnChunk(column)
+		if err != nil {
+			return fmt.Errorf("parquet writer: write column %v: %w", column.path, err)
+		}
+		rowGroup.totalByteSize += chunk.uncompressedSize
+		rowGroup.chunks = append(rowGroup.chunks, chunk)
+		column.reset(parquetColumnMark{})
+	}
+	pw.rowGroups = append(pw.rowGroups, rowGroup)
+	pw.totalRows += pw.rowCount
+	pw.rowCount = 0
+	return nil
+}
+
+// writeColumnChunk writes the buffered values of the column as a column chunk of a single data page.
+func (pw *ParquetWriter) writeColumnChunk(column *parquetColumn) (parquetColumnChunk, error) {
+	pw.page.Reset()
+	column.encode(&pw.page)
+	uncompressed := pw.page.Bytes()
+	compressed, err := pw.compress(uncompressed)
+	if err != nil {
+		return parquetColumnChunk{}, err
+	}
+
+	tw := new(thriftWriter)
+	tw.structBegin()
+	tw.i32Field(1, 0) // DATA_PAGE
+	tw.i32Field(2, int32(len(uncompressed)))
+	tw.i32Field(3, int32(len(compressed)))
+	tw.structField(5, func() {
+		tw.i32Field(1, int32(column.numValues()))
+		tw.i32Field(2, parquetEncodingPlain)
+		tw.i32Field(3, parquetEncodingRLE)
+		tw.i32Field(4, parquetEncodingRLE)
+	})
+	tw.structEnd()
+
+	chunk := parquetColumnChunk{
+		column:           column,
+		offset:           pw.w.n,
+		numValues:        int64(column.numValues()),
+		uncompressedSize: int64(tw.buf.Len() + len(uncompressed)),
+		compressedSize:   int64(tw.buf.Len() + len(compressed)),
+	}
+	for _, b := range [][]byte{tw.buf.Bytes(), compressed} {
+		if _, err := pw.w.Write(b); err != nil {
+			return parquetColumnChunk{}, err
+		}
+	}
+	return chunk, nil
+}
+
+// compress the (page) data using the codec of the writer.
+func (pw *ParquetWriter) compress(data []byte) ([]byte, error) {
+	switch pw.codec {
+	case parquetSnappy:
+		return snappyEncode(data), nil
+	case parquetGzip:
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(data); err != nil {
+			return nil, fmt.Errorf("gzip page: %w", err)
+		}
+		if err := gw.Close(); err != nil {
+			return nil, fmt.Errorf("gzip page: %w", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return data, nil
+	}
+}
+
+// writeFileMetaData writes the FileMetaData of the Parquet file, describing its schema and row groups.
+func (pw *ParquetWriter) writeFileMetaData(tw *thriftWriter) {
+	tw.structBegin()
+	tw.i32Field(1, 1) // version
+	tw.listField(2, thriftStruct, len(pw.elements))
+	for _, element := range pw.elements {
+		element := element
+		tw.structElem(func() {
+			if element.numChildren == 0 {
+				tw.i32Field(1, element.physicalType)
+			}
+			if !element.root {
+				tw.i32Field(3, element.repetition)
+			}
+			tw.stringField(4, element.name)
+			if element.numChildren > 0 {
+				tw.i32Field(5, element.numChildren)
+			}
+			if element.convertedType != nil {
+				tw.i32Field(6, *element.convertedType)
+			}
+			if element.precision > 0 {
+				tw.i32Field(7, element.scale)
+				tw.i32Field(8, element.precision)
+			}
+			if element.writeLogicalType != nil {
+				tw.structField(10, func() { element.writeLogicalType(tw) })
+			}
+		})
+	}
+	tw.i64Field(3, pw.totalRows)
+	tw.listField(4, thriftStruct, len(pw.rowGroups))
+	for _, rowGroup := range pw.rowGroups {
+		rowGroup := rowGroup
+		tw.structElem(func() {
+			tw.listField(1, thriftStruct, len(rowGroup.chunks))
+			for _, chunk := range rowGroup.chunks {
+				chunk := chunk
+				tw.structElem(func() {
+					tw.i64Field(2, chunk.offset)
+					tw.structField(3, func() {
+						tw.i32Field(1, chunk.column.physicalType)
+						tw.listField(2, thriftI32, 2)
+						tw.i32Elem(parquetEncodingPlain)
+						tw.i32Elem(parquetEncodingRLE)
+						tw.listField(3, thriftBinary, len(chunk.column.path))
+						for _, name := range chunk.column.path {
+							tw.stringElem(name)
+						}
+						tw.i32Field(4, pw.codec)
+						tw.i64Field(5, chunk.numValues)
+						tw.i64Field(6, chunk.uncompressedSize)
+						tw.i64Field(7, chunk.compressedSize)
+						tw.i64Field(9, chunk.offset)
+					})
+				})
+			}
+			tw.i64Field(2, rowGroup.totalByteSize)
+			tw.i64Field(3, rowGroup.numRows)
+		})
+	}
+	tw.stringField(6, parquetCreatedBy)
+	tw.structEnd()
+}
+
+// parquetColumn buffers the (PLAIN encoded) values and levels of a leaf column.
+type parquetColumn struct {
+	path         []string
+	fieldType    bigquery.FieldType
+	physicalType int32
+	maxRepLevel  int
+	maxDefLevel  int
+
+	repLevels []uint8
+	defLevels []uint8
+	values    bytes.Buffer
+	bools     []bool
+}
+
+// parquetColumnMark is the (buffered) length of a column,
+// used to undo the values of a row which failed to be written.
+type parquetColumnMark struct {
+	levels, values, bools int
+}
+
+func (c *parquetColumn) mark() parquetColumnMark {
+	return parquetColumnMark{
+		levels: len(c.defLevels),
+		values: c.values.Len(),
+		bools:  len(c.bools),
+	}
+}
+
+// reset the column to the given mark, dropping all values written since.
+func (c *parquetColumn) reset(mark parquetColumnMark) {
+	c.repLevels = c.repLevels[:mark.levels]
+	c.defLevels = c.defLevels[:mark.levels]
+	c.values.Truncate(mark.values)
+	c.bools = c.bools[:mark.bools]
+}
+
+// numValues returns the amount of values (including nulls) written to the column.
+func (c *parquetColumn) numValues() int {
+	return len(c.defLevels)
+}
+
+// size returns the approximate (encoded) size of the buffered values.
+func (c *parquetColumn) size() int {
+	return c.values.Len() + len(c.bools)/8 + len(c.defLevels)/4
+}
+
+func (c *parquetColumn) writeLevels(repLevel, defLevel int) {
+	c.repLevels = append(c.repLevels, uint8(repLevel))
+	c.defLevels = append(c.defLevels, uint8(defLevel))
+}
+
+// writeValue writes a single (non-null) value to the column.
+func (c *parquetColumn) writeValue(v interface{}, repLevel, defLevel int) error {
+	var b [8]byte
+	switch c.fieldType {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		s, ok, err := stringValue(v)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c.writeByteArray(s)
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.BytesFieldType:
+		if s, ok := bytesValue(v); ok {
+			c.writeByteArray(s)
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.IntegerFieldType:
+		if i, ok := integerValue(v); ok {
+			binary.LittleEndian.PutUint64(b[:], uint64(i))
+			c.values.Write(b[:8])
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.FloatFieldType:
+		if f, ok := value.ToFloat64(v); ok {
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+			c.values.Write(b[:8])
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.BooleanFieldType:
+		if bv, ok := value.ToBool(v); ok {
+			c.bools = append(c.bools, bv)
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.TimestampFieldType:
+		if t, ok := value.ToTimestamp(v); ok {
+			binary.LittleEndian.PutUint64(b[:], uint64(timestampMicros(t)))
+			c.values.Write(b[:8])
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.DateTimeFieldType:
+		if dt, ok := value.ToDateTime(v); ok {
+			binary.LittleEndian.PutUint64(b[:], uint64(timestampMicros(dt.In(time.UTC))))
+			c.values.Write(b[:8])
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.TimeFieldType:
+		if t, ok := value.ToTime(v); ok {
+			binary.LittleEndian.PutUint64(b[:], uint64(timeMicros(t)))
+			c.values.Write(b[:8])
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.DateFieldType:
+		if d, ok := value.ToDate(v); ok {
+			binary.LittleEndian.PutUint32(b[:], uint32(dateDays(d)))
+			c.values.Write(b[:4])
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		if r, ok := value.ToRat(v); ok {
+			scale := numericScale
+			if c.fieldType == bigquery.BigNumericFieldType {
+				scale = bigNumericScale
+			}
+			c.writeByteArray(encodeDecimal(r, scale))
+			c.writeLevels(repLevel, defLevel)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unexpected value of type %T for %s field", ErrInvalidData, v, c.fieldType)
+}
+
+func (c *parquetColumn) writeByteArray(s []byte) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(len(s)))
+	c.values.Write(b[:])
+	c.values.Write(s)
+}
+
+// encode the buffered levels and values of the column as the (uncompressed) data of a data page.
+func (c *parquetColumn) encode(buf *bytes.Buffer) {
+	if c.maxRepLevel > 0 {
+		writeParquetLevels(buf, c.repLevels, c.maxRepLevel)
+	}
+	if c.maxDefLevel > 0 {
+		writeParquetLevels(buf, c.defLevels, c.maxDefLevel)
+	}
+	if c.physicalType != parquetBoolean {
+		buf.Write(c.values.Bytes())
+		return
+	}
+	// booleans are bit-packed, least significant bit first
+	packed := make([]byte, (len(c.bools)+7)/8)
+	for i, b := range c.bools {
+		if b {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	buf.Write(packed)
+}
+
+// writeParquetLevels writes the levels using the RLE/bit-packing hybrid encoding, prefixed with its length,
+// only using RLE runs, which is efficient given the levels of a column are usually (mostly) equal.
+func writeParquetLevels(buf *bytes.Buffer, levels []uint8, maxLevel int) {
+	byteWidth := (bits.Len(uint(maxLevel)) + 7) / 8
+	var runs bytes.Buffer
+	var header [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		n := binary.PutUvarint(header[:], uint64(j-i)<<1)
+		runs.Write(header[:n])
+		runs.WriteByte(levels[i])
+		for k := 1; k < byteWidth; k++ {
+			runs.WriteByte(0)
+		}
+		i = j
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(runs.Len()))
+	buf.Write(length[:])
+	buf.Write(runs.Bytes())
+}
+
+// countingWriter counts the bytes written to the underlying writer,
+// required to know the offsets of the column chunks.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
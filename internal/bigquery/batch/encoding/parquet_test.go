@@ -0,0 +1,394 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+// thriftStructValue is a decoded Thrift struct, its values by field id.
+type thriftStructValue map[int16]interface{}
+
+// thriftReader decodes the Thrift compact protocol (subset) written by the thriftWriter.
+type thriftReader struct {
+	t   *testing.T
+	buf *bytes.Reader
+}
+
+func (r *thriftReader) varint() int64 {
+	v, err := binary.ReadVarint(r.buf)
+	test.AssertNoErrorFatal(r.t, err)
+	return v
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, err := binary.ReadUvarint(r.buf)
+	test.AssertNoErrorFatal(r.t, err)
+	return v
+}
+
+func (r *thriftReader) readStruct() thriftStructValue {
+	s := thriftStructValue{}
+	var lastID int16
+	for {
+		header, err := r.buf.ReadByte()
+		test.AssertNoErrorFatal(r.t, err)
+		if header == 0 {
+			return s
+		}
+		fieldType := header & 0x0f
+		id := lastID + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		lastID = id
+		s[id] = r.readValue(fieldType)
+	}
+}
+
+func (r *thriftReader) readValue(fieldType byte) interface{} {
+	switch fieldType {
+	case thriftBooleanTrue:
+		return true
+	case thriftBooleanFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		b := make([]byte, r.uvarint())
+		_, _ = r.buf.Read(b)
+		return string(b)
+	case thriftList:
+		header, err := r.buf.ReadByte()
+		test.AssertNoErrorFatal(r.t, err)
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.readValue(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	default:
+		r.t.Fatalf("unexpected thrift type %d", fieldType)
+		return nil
+	}
+}
+
+// parquetTestColumn is a decoded column of all row groups.
+type parquetTestColumn struct {
+	repLevels []int
+	defLevels []int
+	values    []interface{}
+}
+
+// readParquetFile decodes the metadata and columns (by path) of the Parquet file.
+func readParquetFile(t *testing.T, b []byte) (thriftStructValue, map[string]*parquetTestColumn) {
+	t.Helper()
+	test.AssertTrue(t, bytes.HasPrefix(b, parquetMagic))
+	test.AssertTrue(t, bytes.HasSuffix(b, parquetMagic))
+	footerSize := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	metadata := (&thriftReader{t: t, buf: bytes.NewReader(b[len(b)-8-footerSize : len(b)-8])}).readStruct()
+
+	// resolve the max levels of each column using the schema
+	type leaf struct {
+		physicalType   int64
+		maxRep, maxDef int
+	}
+	leaves := map[string]leaf{}
+	elements := metadata[2].([]interface{})
+	var walk func(i int, prefix string, maxRep, maxDef int) int
+	walk = func(i int, prefix string, maxRep, maxDef int) int {
+		element := elements[i].(thriftStructValue)
+		name := prefix + element[4].(string)
+		switch element[3] {
+		case int64(parquetOptional):
+			maxDef++
+		case int64(parquetRepeated):
+			maxRep++
+			maxDef++
+		}
+		i++
+		if numChildren, ok := element[5].(int64); ok {
+			for c := int64(0); c < numChildren; c++ {
+				i = walk(i, name+".", maxRep, maxDef)
+			}
+			return i
+		}
+		leaves[name] = leaf{physicalType: element[1].(int64), maxRep: maxRep, maxDef: maxDef}
+		return i
+	}
+	root := elements[0].(thriftStructValue)
+	for i, c := 1, int64(0); c < root[5].(int64); c++ {
+		i = walk(i, "", 0, 0)
+	}
+
+	columns := map[string]*parquetTestColumn{}
+	for _, rg := range metadata[4].([]interface{}) {
+		for _, cc := range rg.(thriftStructValue)[1].([]interface{}) {
+			meta := cc.(thriftStructValue)[3].(thriftStructValue)
+			var path string
+			for i, name := range meta[3].([]interface{}) {
+				if i > 0 {
+					path += "."
+				}
+				path += name.(string)
+			}
+			l := leaves[path]
+			column := columns[path]
+			if column == nil {
+				column = new(parquetTestColumn)
+				columns[path] = column
+			}
+
+			r := &thriftReader{t: t, buf: bytes.NewReader(b[meta[9].(int64):])}
+			header := r.readStruct()
+			data := make([]byte, header[3].(int64))
+			_, _ = r.buf.Read(data)
+			switch int32(meta[4].(int64)) {
+			case parquetSnappy:
+				var err error
+				data, err = snappyDecode(data)
+				test.AssertNoErrorFatal(t, err)
+			case parquetGzip:
+				gr, err := gzip.NewReader(bytes.NewReader(data))
+				test.AssertNoErrorFatal(t, err)
+				data, err = ioutil.ReadAll(gr)
+				test.AssertNoErrorFatal(t, err)
+			}
+			test.AssertEqual(t, int(header[2].(int64)), len(data))
+
+			numValues := int(header[5].(thriftStructValue)[1].(int64))
+			readLevels := func(maxLevel int) []int {
+				levels := make([]int, 0, numValues)
+				if maxLevel == 0 {
+					for len(levels) < numValues {
+						levels = append(levels, 0)
+					}
+					return levels
+				}
+				size := int(binary.LittleEndian.Uint32(data))
+				runs := bytes.NewReader(data[4 : 4+size])
+				data = data[4+size:]
+				for runs.Len() > 0 {
+					runHeader, err := binary.ReadUvarint(runs)
+					test.AssertNoErrorFatal(t, err)
+					level, _ := runs.ReadByte()
+					for i := uint64(0); i < runHeader>>1; i++ {
+						levels = append(levels, int(level))
+					}
+				}
+				return levels
+			}
+			repLevels := readLevels(l.maxRep)
+			defLevels := readLevels(l.maxDef)
+			column.repLevels = append(column.repLevels, repLevels...)
+			column.defLevels = append(column.defLevels, defLevels...)
+
+			var boolIndex int
+			for _, def := range defLevels {
+				if def < l.maxDef {
+					column.values = append(column.values, nil)
+					continue
+				}
+				switch int32(l.physicalType) {
+				case parquetBoolean:
+					column.values = append(column.values, data[boolIndex/8]&(1<<(boolIndex%8)) != 0)
+					boolIndex++
+				case parquetInt32:
+					column.values = append(column.values, int64(int32(binary.LittleEndian.Uint32(data))))
+					data = data[4:]
+				case parquetInt64:
+					column.values = append(column.values, int64(binary.LittleEndian.Uint64(data)))
+					data = data[8:]
+				case parquetDouble:
+					column.values = append(column.values, math.Float64frombits(binary.LittleEndian.Uint64(data)))
+					data = data[8:]
+				case parquetByteArray:
+					n := binary.LittleEndian.Uint32(data)
+					column.values = append(column.values, string(data[4:4+n]))
+					data = data[4+n:]
+				}
+			}
+		}
+	}
+	return metadata, columns
+}
+
+var testParquetSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "count", Type: bigquery.IntegerFieldType},
+	{Name: "ratio", Type: bigquery.FloatFieldType},
+	{Name: "enabled", Type: bigquery.BooleanFieldType},
+	{Name: "created_at", Type: bigquery.TimestampFieldType},
+	{Name: "moment", Type: bigquery.DateTimeFieldType},
+	{Name: "day", Type: bigquery.DateFieldType},
+	{Name: "price", Type: bigquery.NumericFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "items", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "sku", Type: bigquery.StringFieldType, Required: true},
+		{Name: "codes", Type: bigquery.IntegerFieldType, Repeated: true},
+	}},
+}
+
+type testParquetItem struct {
+	SKU   string `bigquery:"sku"`
+	Codes []int
+}
+
+type testParquetRow struct {
+	Name      string
+	Count     *int64
+	Ratio     float64
+	Enabled   bool
+	CreatedAt time.Time `bigquery:"created_at"`
+	Moment    civil.DateTime
+	Day       civil.Date
+	Price     *big.Rat
+	Tags      []string
+	Items     []testParquetItem
+	Ignored   string
+}
+
+func TestParquetWriterRows(t *testing.T) {
+	createdAt := time.Date(2021, 10, 18, 15, 4, 5, 123456789, time.UTC)
+	count := int64(42)
+	for _, compression := range []bigquery.Compression{bigquery.None, bigquery.Snappy, bigquery.Gzip} {
+		newRowWriter, err := NewParquetWriterFunc(testParquetSchema, compression)
+		test.AssertNoErrorFatal(t, err)
+		var buf bytes.Buffer
+		writer, err := newRowWriter(&buf)
+		test.AssertNoErrorFatal(t, err)
+
+		test.AssertNoError(t, writer.WriteRow(&testParquetRow{
+			Name:      "foo",
+			Count:     &count,
+			Ratio:     0.5,
+			Enabled:   true,
+			CreatedAt: createdAt,
+			Moment:    civil.DateTime{Date: civil.Date{Year: 1970, Month: time.January, Day: 2}, Time: civil.Time{Second: 1}},
+			Day:       civil.Date{Year: 1970, Month: time.January, Day: 11},
+			Price:     big.NewRat(1, 4),
+			Tags:      []string{"a", "b"},
+			Items: []testParquetItem{
+				{SKU: "x", Codes: []int{1, 2}},
+				{SKU: "y"},
+			},
+			Ignored: "ignored",
+		}))
+		// a required nested field is missing, nothing is written
+		test.AssertIsError(t, writer.WriteRow(map[string]interface{}{
+			"name":  "bar",
+			"items": []interface{}{map[string]interface{}{"codes": []int{1}}},
+		}), ErrInvalidData)
+		test.AssertNoError(t, writer.WriteRow(map[string]bigquery.Value{
+			"NAME":    "bar",
+			"unknown": true,
+		}))
+		test.AssertNoError(t, writer.Close())
+
+		metadata, columns := readParquetFile(t, buf.Bytes())
+		test.AssertEqual(t, int64(2), metadata[3])
+		test.AssertEqual(t, parquetCreatedBy, metadata[6])
+
+		assertColumn := func(path string, repLevels, defLevels []int, values []interface{}) {
+			t.Helper()
+			column := columns[path]
+			test.AssertNotNil(t, column)
+			test.AssertEqual(t, repLevels, column.repLevels)
+			test.AssertEqual(t, defLevels, column.defLevels)
+			test.AssertEqual(t, values, column.values)
+		}
+		assertColumn("name", []int{0, 0}, []int{0, 0}, []interface{}{"foo", "bar"})
+		assertColumn("count", []int{0, 0}, []int{1, 0}, []interface{}{int64(42), nil})
+		assertColumn("ratio", []int{0, 0}, []int{1, 0}, []interface{}{0.5, nil})
+		assertColumn("enabled", []int{0, 0}, []int{1, 0}, []interface{}{true, nil})
+		assertColumn("created_at", []int{0, 0}, []int{1, 0}, []interface{}{createdAt.UnixNano() / 1e3, nil})
+		assertColumn("moment", []int{0, 0}, []int{1, 0}, []interface{}{int64(86401e6), nil})
+		assertColumn("day", []int{0, 0}, []int{1, 0}, []interface{}{int64(10), nil})
+		assertColumn("price", []int{0, 0}, []int{1, 0}, []interface{}{string([]byte{0x0e, 0xe6, 0xb2, 0x80}), nil})
+		assertColumn("tags", []int{0, 1, 0}, []int{1, 1, 0}, []interface{}{"a", "b", nil})
+		assertColumn("items.sku", []int{0, 1, 0}, []int{1, 1, 0}, []interface{}{"x", "y", nil})
+		assertColumn("items.codes", []int{0, 2, 1, 0}, []int{2, 2, 1, 0}, []interface{}{int64(1), int64(2), nil, nil})
+	}
+}
+
+func TestParquetWriterSchema(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewParquetWriter(&buf, bigquery.Schema{
+		{Name: "created_at", Type: bigquery.TimestampFieldType, Required: true},
+		{Name: "moment", Type: bigquery.DateTimeFieldType},
+		{Name: "big_price", Type: bigquery.BigNumericFieldType},
+		{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "street", Type: bigquery.StringFieldType},
+		}},
+	}, bigquery.None)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertNoError(t, writer.Close())
+	metadata, _ := readParquetFile(t, buf.Bytes())
+	test.AssertEqual(t, int64(0), metadata[3])
+	test.AssertEqual(t, []interface{}{
+		thriftStructValue{4: "root", 5: int64(4)},
+		thriftStructValue{1: int64(parquetInt64), 3: int64(parquetRequired), 4: "created_at", 6: int64(parquetConvertedTimestampMicros),
+			10: thriftStructValue{8: thriftStructValue{1: true, 2: thriftStructValue{2: thriftStructValue{}}}}},
+		thriftStructValue{1: int64(parquetInt64), 3: int64(parquetOptional), 4: "moment",
+			10: thriftStructValue{8: thriftStructValue{1: false, 2: thriftStructValue{2: thriftStructValue{}}}}},
+		thriftStructValue{1: int64(parquetByteArray), 3: int64(parquetOptional), 4: "big_price", 6: int64(parquetConvertedDecimal), 7: int64(38), 8: int64(76),
+			10: thriftStructValue{5: thriftStructValue{1: int64(38), 2: int64(76)}}},
+		thriftStructValue{3: int64(parquetOptional), 4: "address", 5: int64(1)},
+		thriftStructValue{1: int64(parquetByteArray), 3: int64(parquetOptional), 4: "street", 6: int64(parquetConvertedUTF8),
+			10: thriftStructValue{1: thriftStructValue{}}},
+	}, metadata[2])
+}
+
+func TestParquetWriterRowGroups(t *testing.T) {
+	schema := bigquery.Schema{{Name: "data", Type: bigquery.StringFieldType, Required: true}}
+	var buf bytes.Buffer
+	writer, err := NewParquetWriter(&buf, schema, bigquery.Snappy)
+	test.AssertNoErrorFatal(t, err)
+	row := map[string]interface{}{"data": string(bytes.Repeat([]byte("x"), 1024))}
+	for i := 0; i < 10000; i++ {
+		test.AssertNoError(t, writer.WriteRow(row))
+	}
+	test.AssertNoError(t, writer.Close())
+	metadata, columns := readParquetFile(t, buf.Bytes())
+	test.AssertEqual(t, int64(10000), metadata[3])
+	test.AssertEqual(t, 2, len(metadata[4].([]interface{})))
+	test.AssertEqual(t, 10000, len(columns["data"].values))
+}
+
+func TestNewParquetWriterErrors(t *testing.T) {
+	_, err := NewParquetWriterFunc(testParquetSchema, bigquery.Deflate)
+	test.AssertIsError(t, err, ErrInvalidData)
+	_, err = NewParquetWriterFunc(nil, bigquery.Snappy)
+	test.AssertIsError(t, err, ErrInvalidData)
+	_, err = NewParquetWriterFunc(bigquery.Schema{{Name: "foo", Type: bigquery.FieldType("INTERVAL")}}, bigquery.Snappy)
+	test.AssertIsError(t, err, ErrInvalidData)
+}
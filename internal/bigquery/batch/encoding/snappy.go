@@ -0,0 +1,109 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// snappyMinMatch is the minimum length of a match encoded as a copy.
+	snappyMinMatch = 4
+	// snappyMaxOffset is the maximum offset of a copy with a 2-byte offset.
+	snappyMaxOffset = 1 << 16
+	// snappyHashBits defines the size of the hash table used to find matches.
+	snappyHashBits = 14
+)
+
+// snappyEncode returns the snappy block encoding of src,
+// as defined in https://github.com/google/snappy/blob/main/format_description.txt.
+//
+// Only a minimal (greedy) compressor is implemented, using literals and copies with a 2-byte offset,
+// which is sufficient given the encoding is only used for the (optional) block compression of Avro files
+// and the (optional) page compression of Parquet files.
+func snappyEncode(src []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(src)))
+	dst := make([]byte, 0, n+len(src)+len(src)/6+16)
+	dst = append(dst, lenBuf[:n]...)
+
+	var table [1 << snappyHashBits]int32
+	for i := range table {
+		table[i] = -1
+	}
+	hash := func(u uint32) uint32 {
+		return (u * 0x1e35a7bd) >> (32 - snappyHashBits)
+	}
+
+	literalStart := 0
+	for i := 0; i+snappyMinMatch <= len(src); {
+		u := binary.LittleEndian.Uint32(src[i:])
+		h := hash(u)
+		candidate := int(table[h])
+		table[h] = int32(i)
+		if candidate < 0 || i-candidate >= snappyMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != u {
+			i++
+			continue
+		}
+		// extend the match as far as possible
+		length := snappyMinMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = appendSnappyLiteral(dst, src[literalStart:i])
+		dst = appendSnappyCopy(dst, i-candidate, length)
+		i += length
+		literalStart = i
+	}
+	return appendSnappyLiteral(dst, src[literalStart:])
+}
+
+// appendSnappyLiteral appends the literal element(s) for the given bytes.
+func appendSnappyLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	n := uint64(len(literal) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+// appendSnappyCopy appends the copy element(s), with a 2-byte offset, for the given match.
+func appendSnappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > 64 {
+			n = 64
+			// keep the remainder at least as long as a minimal match
+			if length-n < snappyMinMatch {
+				n = length - snappyMinMatch
+			}
+		}
+		dst = append(dst, byte(n-1)<<2|0x02, byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+var errInvalidSnappy = errors.New("invalid snappy block")
+
+// snappyDecode decodes a snappy block (supporting only the elements produced by snappyEncode).
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errInvalidSnappy
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 0x03 {
+		case 0x00:
+			n := int(tag >> 2)
+			src = src[1:]
+			if n >= 60 {
+				size := n - 59
+				n = 0
+				for i := 0; i < size; i++ {
+					n |= int(src[i]) << (8 * i)
+				}
+				src = src[size:]
+			}
+			n++
+			if n > len(src) {
+				return nil, errInvalidSnappy
+			}
+			dst = append(dst, src[:n]...)
+			src = src[n:]
+		case 0x02:
+			n := int(tag>>2) + 1
+			offset := int(src[1]) | int(src[2])<<8
+			src = src[3:]
+			if offset == 0 || offset > len(dst) {
+				return nil, errInvalidSnappy
+			}
+			for i := 0; i < n; i++ {
+				dst = append(dst, dst[len(dst)-offset])
+			}
+		default:
+			return nil, errInvalidSnappy
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, errInvalidSnappy
+	}
+	return dst, nil
+}
+
+func TestSnappyEncodeRoundTrip(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(42)).Read(random)
+	testCases := map[string][]byte{
+		"empty":        {},
+		"short":        []byte("foo"),
+		"repeated":     bytes.Repeat([]byte("a"), 1000),
+		"text":         bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog, "), 500),
+		"random":       random,
+		"long-literal": append(random[:300:300], bytes.Repeat([]byte("xyz"), 300)...),
+	}
+	for name, input := range testCases {
+		encoded := snappyEncode(input)
+		decoded, err := snappyDecode(encoded)
+		test.AssertNoError(t, err)
+		test.AssertTrue(t, bytes.Equal(input, decoded))
+		if name == "repeated" || name == "text" {
+			test.AssertTrue(t, len(encoded) < len(input)/10)
+		}
+	}
+}
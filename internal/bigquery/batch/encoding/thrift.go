@@ -0,0 +1,145 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// thrift compact protocol field types
+const (
+	thriftBooleanTrue  = 1
+	thriftBooleanFalse = 2
+	thriftI32          = 5
+	thriftI64          = 6
+	thriftBinary       = 8
+	thriftList         = 9
+	thriftStruct       = 12
+)
+
+// thriftWriter writes structs using the Thrift compact protocol,
+// as used for the (page and file) metadata of Parquet files, see
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md.
+//
+// Only the subset of the protocol required to write the Parquet metadata is implemented.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// the id of the last field written, per (nested) struct
+	lastFieldIDs []int16
+}
+
+// structBegin starts a new (nested) struct.
+func (tw *thriftWriter) structBegin() {
+	tw.lastFieldIDs = append(tw.lastFieldIDs, 0)
+}
+
+// structEnd ends the current (nested) struct.
+func (tw *thriftWriter) structEnd() {
+	tw.buf.WriteByte(0) // stop field
+	tw.lastFieldIDs = tw.lastFieldIDs[:len(tw.lastFieldIDs)-1]
+}
+
+// fieldBegin writes the header of a field of the current struct.
+func (tw *thriftWriter) fieldBegin(id int16, fieldType byte) {
+	last := &tw.lastFieldIDs[len(tw.lastFieldIDs)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		tw.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		tw.buf.WriteByte(fieldType)
+		tw.varint(int64(id))
+	}
+	*last = id
+}
+
+// i32Field writes an i32 field.
+func (tw *thriftWriter) i32Field(id int16, v int32) {
+	tw.fieldBegin(id, thriftI32)
+	tw.varint(int64(v))
+}
+
+// i64Field writes an i64 field.
+func (tw *thriftWriter) i64Field(id int16, v int64) {
+	tw.fieldBegin(id, thriftI64)
+	tw.varint(v)
+}
+
+// boolField writes a bool field.
+func (tw *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		tw.fieldBegin(id, thriftBooleanTrue)
+	} else {
+		tw.fieldBegin(id, thriftBooleanFalse)
+	}
+}
+
+// stringField writes a string (binary) field.
+func (tw *thriftWriter) stringField(id int16, s string) {
+	tw.fieldBegin(id, thriftBinary)
+	tw.uvarint(uint64(len(s)))
+	tw.buf.WriteString(s)
+}
+
+// structField writes a struct field, of which the fields are written by the given function.
+func (tw *thriftWriter) structField(id int16, writeFields func()) {
+	tw.fieldBegin(id, thriftStruct)
+	tw.structBegin()
+	if writeFields != nil {
+		writeFields()
+	}
+	tw.structEnd()
+}
+
+// listField writes the header of a list field of the given size,
+// after which its elements have to be written.
+func (tw *thriftWriter) listField(id int16, elemType byte, size int) {
+	tw.fieldBegin(id, thriftList)
+	if size < 15 {
+		tw.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		tw.buf.WriteByte(0xf0 | elemType)
+		tw.uvarint(uint64(size))
+	}
+}
+
+// i32Elem writes an i32 list element.
+func (tw *thriftWriter) i32Elem(v int32) {
+	tw.varint(int64(v))
+}
+
+// stringElem writes a string (binary) list element.
+func (tw *thriftWriter) stringElem(s string) {
+	tw.uvarint(uint64(len(s)))
+	tw.buf.WriteString(s)
+}
+
+// structElem writes a struct list element, of which the fields are written by the given function.
+func (tw *thriftWriter) structElem(writeFields func()) {
+	tw.structBegin()
+	writeFields()
+	tw.structEnd()
+}
+
+func (tw *thriftWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	tw.buf.Write(b[:n])
+}
+
+func (tw *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	tw.buf.Write(b[:n])
+}
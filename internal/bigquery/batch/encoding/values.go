@@ -0,0 +1,198 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/value"
+)
+
+const (
+	// numericPrecision is the precision of the decimal used for a NUMERIC value.
+	numericPrecision = 38
+	// numericScale is the amount of decimal digits of a NUMERIC value.
+	numericScale = 9
+	// bigNumericPrecision is the precision of the decimal used for a BIGNUMERIC value.
+	bigNumericPrecision = 76
+	// bigNumericScale is the amount of decimal digits of a BIGNUMERIC value.
+	bigNumericScale = 38
+)
+
+// rowValues returns the values of the (nested) row,
+// which can be any row value supported by value.ToRow.
+func rowValues(schema bigquery.Schema, row interface{}) (map[string]bigquery.Value, error) {
+	values, ok, err := value.ToRow(schema, row)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported row type %T", ErrInvalidData, row)
+	}
+	return values, nil
+}
+
+// fieldValue returns the (dereferenced) value of the row for the given field, preferring an exact match
+// over a case-insensitive one, the same way BigQuery matches its column names.
+// False is returned in case the field has no (non-null) value.
+func fieldValue(values map[string]bigquery.Value, name string) (interface{}, bool) {
+	if v, ok := values[name]; ok {
+		return value.Deref(v)
+	}
+	for key, v := range values {
+		if value.EqualFoldASCII(key, name) {
+			return value.Deref(v)
+		}
+	}
+	return nil, false
+}
+
+// repeatedValues returns the (dereferenced) items of the value of a repeated field,
+// which has to be a slice or array without null items.
+func repeatedValues(v interface{}) ([]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%w: unexpected value of type %T for repeated field", ErrInvalidData, v)
+	}
+	items := make([]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		item, ok := value.Deref(rv.Index(i).Interface())
+		if !ok {
+			return nil, fmt.Errorf("%w: null value at index %d of repeated field", ErrInvalidData, i)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// stringValue returns the bytes of a STRING (or GEOGRAPHY) value, accepting a string
+// or a proto message (e.g. a google.protobuf.Struct), which is written as its Json encoded string.
+func stringValue(v interface{}) ([]byte, bool, error) {
+	switch typedValue := v.(type) {
+	case string:
+		return []byte(typedValue), true, nil
+	case proto.Message:
+		b, err := protojson.Marshal(typedValue)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: marshal %T as Json: %v", ErrInvalidData, v, err)
+		}
+		return b, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// bytesValue returns the bytes of a BYTES value, accepting a byte slice or a string.
+func bytesValue(v interface{}) ([]byte, bool) {
+	switch typedValue := v.(type) {
+	case []byte:
+		return typedValue, true
+	case string:
+		return []byte(typedValue), true
+	default:
+		return nil, false
+	}
+}
+
+// integerValue returns the value of an INTEGER value, accepting the values supported by value.ToInt64,
+// as well as a time.Duration, which is written as its amount of microseconds.
+func integerValue(v interface{}) (int64, bool) {
+	if d, ok := v.(time.Duration); ok {
+		return d.Microseconds(), true
+	}
+	return value.ToInt64(v)
+}
+
+// timestampMicros returns the amount of microseconds since the Unix epoch of the given time.
+func timestampMicros(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+// dateDays returns the amount of days since the Unix epoch of the given date.
+func dateDays(d civil.Date) int32 {
+	return int32(d.DaysSince(civil.Date{Year: 1970, Month: time.January, Day: 1}))
+}
+
+// timeMicros returns the amount of microseconds since midnight of the given time.
+func timeMicros(t civil.Time) int64 {
+	return (int64(t.Hour)*3600+int64(t.Minute)*60+int64(t.Second))*1e6 + int64(t.Nanosecond/1e3)
+}
+
+// formatDateTime formats the datetime as expected by BigQuery,
+// truncated to microsecond precision, e.g. 2021-10-18T15:04:05.123456
+func formatDateTime(dt civil.DateTime) string {
+	return dt.Date.String() + "T" + formatTime(dt.Time)
+}
+
+// formatTime formats the time as expected by BigQuery,
+// truncated to microsecond precision, e.g. 15:04:05.123456
+func formatTime(t civil.Time) string {
+	s := fmt.Sprintf("%02d:%02d:%02d", t.Hour, t.Minute, t.Second)
+	if micros := t.Nanosecond / 1e3; micros != 0 {
+		s += fmt.Sprintf(".%06d", micros)
+	}
+	return s
+}
+
+// formatDecimal formats the rational number as a decimal string,
+// rounded to the given scale (half away from zero), without trailing zeros.
+func formatDecimal(r *big.Rat, scale int) string {
+	s := r.FloatString(scale)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// encodeDecimal encodes the rational number, rounded to the given scale (half away from zero),
+// as the big-endian two's complement of its scaled integer value, as expected for an Avro decimal.
+func encodeDecimal(r *big.Rat, scale int) []byte {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	num, denom := scaled.Num(), scaled.Denom()
+	quo, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	// round half away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(denom) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return twosComplementBE(quo)
+}
+
+// twosComplementBE returns the minimal big-endian two's complement representation of x.
+func twosComplementBE(x *big.Int) []byte {
+	n := x.BitLen()/8 + 1
+	b := make([]byte, n)
+	if x.Sign() >= 0 {
+		x.FillBytes(b)
+		return b
+	}
+	new(big.Int).Add(x, new(big.Int).Lsh(big.NewInt(1), uint(8*n))).FillBytes(b)
+	return b
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+)
+
+// JobLimiter bounds the amount of load jobs which are in flight at once,
+// and can be shared by multiple clients (e.g. all workers of a streamer).
+type JobLimiter struct {
+	slots chan struct{}
+}
+
+// NewJobLimiter creates a new JobLimiter, allowing up to n load jobs to be in flight at once.
+// A value of n smaller than 1 is treated as 1.
+func NewJobLimiter(n int) *JobLimiter {
+	if n < 1 {
+		n = 1
+	}
+	return &JobLimiter{
+		slots: make(chan struct{}, n),
+	}
+}
+
+// acquire a slot for a new load job, blocking until one is available or the context is done.
+func (l *JobLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release a slot previously acquired for a load job which is no longer in flight.
+func (l *JobLimiter) release() {
+	<-l.slots
+}
+
+// loadJob is the interface of a submitted (*bigquery.Job) load job,
+// defined as an interface to allow it to be swapped out in tests.
+type loadJob interface {
+	// ID of the job
+	ID() string
+	// Wait blocks until the job is done, returning its final status,
+	// or an error in case its status could not be retrieved.
+	Wait(ctx context.Context) (*bigquery.JobStatus, error)
+}
+
+// jobFailures collects the failures of the load jobs of a client
+// which finished since the last time it was flushed.
+type jobFailures struct {
+	first error
+	count int
+}
+
+// add a failed job its error
+func (f *jobFailures) add(err error) {
+	if f.first == nil {
+		f.first = err
+	}
+	f.count++
+}
+
+// err returns the error of the first failed job, if any
+func (f *jobFailures) err() error {
+	switch f.count {
+	case 0:
+		return nil
+	case 1:
+		return f.first
+	default:
+		return fmt.Errorf("%w (and %d other failed load jobs)", f.first, f.count-1)
+	}
+}
+
+// The kinds of keys from which the ID of a load job can be derived.
+const (
+	jobIDKindKey     = "key"
+	jobIDKindContent = "content"
+)
+
+// retryableLoadError returns true in case the given error is a transient failure
+// to submit or await a load job, which can be retried.
+func retryableLoadError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		for _, item := range apiErr.Errors {
+			if item.Reason == "backendError" || item.Reason == "rateLimitExceeded" {
+				return true
+			}
+		}
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// jobExistsError returns true in case the given error is returned
+// because a job with the same ID was submitted already.
+func jobExistsError(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict
+}
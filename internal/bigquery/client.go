@@ -14,6 +14,11 @@
 
 package bigquery
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Client is the interface we expect a BQ client to implement.
 // The only reason for this abstraction is so we can easily unit test this class,
 // without actual BQ interaction.
@@ -28,9 +33,45 @@ type Client interface {
 	// has flushed as part of its Put process.
 	Put(data interface{}) (bool, error)
 
+	// PutBatch puts multiple rows of data at once, the same way as Put would for each row,
+	// but allowing the client to do so more efficiently (e.g. encoding them as a single write).
+	//
+	// Rows which are rejected by the client are returned as RowErrors,
+	// while the other rows of the batch are still written.
+	PutBatch(rows []interface{}) (bool, error)
+
 	// Flush any data already Put but not yet written to BigQuery.
 	Flush() error
 
 	// Close the BQ Client
 	Close() error
 }
+
+// RowError is the error of a single row rejected as part of a batch of rows.
+type RowError struct {
+	// Index of the row within its batch
+	Index int
+	// Err is the reason the row was rejected
+	Err error
+}
+
+// RowErrors is the error returned for the rows rejected as part of a batch of rows,
+// ordered by the index of the rows.
+type RowErrors []RowError
+
+// maxRowErrorsInMessage defines the maximum amount of row errors included in the message of RowErrors.
+const maxRowErrorsInMessage = 3
+
+// Error implements error::Error
+func (errs RowErrors) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d row(s) rejected", len(errs))
+	for i, err := range errs {
+		if i == maxRowErrorsInMessage {
+			fmt.Fprintf(&sb, "; and %d more", len(errs)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "; row #%d: %v", err.Index, err.Err)
+	}
+	return sb.String()
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insertall
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// AdaptiveBatchOptions configures the adaptive batch sizing of a Client,
+// adjusting its batch size between MinBatchSize and MaxBatchSize
+// such that its rows are flushed in about TargetFlushLatency.
+//
+// The options are expected to be sanitized by the caller, no defaults are applied.
+type AdaptiveBatchOptions struct {
+	// MinBatchSize is the lower bound of the batch size, required to be positive.
+	MinBatchSize int
+	// MaxBatchSize is the upper bound of the batch size, required to be at least MinBatchSize.
+	MaxBatchSize int
+	// TargetFlushLatency is the latency aimed at for a single flush, required to be positive.
+	TargetFlushLatency time.Duration
+	// MaxBatchBytes is the upper bound of the estimated payload size of a batch, required to be positive.
+	MaxBatchBytes int
+	// BatchSizeHook is optionally called with the new batch size each time it is adapted.
+	BatchSizeHook func(batchSize int)
+}
+
+// validate returns an error in case the options are not sanitized.
+func (opts AdaptiveBatchOptions) validate() error {
+	switch {
+	case opts.MinBatchSize <= 0:
+		return fmt.Errorf("validate min batch size: %w: %d", internal.ErrInvalidParam, opts.MinBatchSize)
+	case opts.MaxBatchSize < opts.MinBatchSize:
+		return fmt.Errorf("validate max batch size: %w: %d is lower than the min batch size", internal.ErrInvalidParam, opts.MaxBatchSize)
+	case opts.TargetFlushLatency <= 0:
+		return fmt.Errorf("validate target flush latency: %w: %v", internal.ErrInvalidParam, opts.TargetFlushLatency)
+	case opts.MaxBatchBytes <= 0:
+		return fmt.Errorf("validate max batch bytes: %w: %d", internal.ErrInvalidParam, opts.MaxBatchBytes)
+	}
+	return nil
+}
+
+const (
+	// adaptiveSmoothing is the weight given to the latest flush
+	// when updating the moving averages of the batch sizer.
+	adaptiveSmoothing = 0.5
+	// adaptiveMaxGrowth is the maximum factor by which the batch size
+	// can grow as the result of a single flush.
+	adaptiveMaxGrowth = 2
+)
+
+// batchSizer computes the batch size of a Client,
+// based on the latency, payload size and errors of its recent flushes.
+//
+// Assuming the latency of a flush grows linearly with the amount of rows flushed,
+// each successful flush moves the batch size towards the amount of rows
+// which could have been flushed within the target latency. Failed flushes
+// shrink the batch size instead, the more so the higher the recent error rate.
+type batchSizer struct {
+	minSize  int
+	maxSize  int
+	target   time.Duration
+	maxBytes int
+
+	size      float64
+	rowBytes  float64
+	errorRate float64
+}
+
+// newBatchSizer creates a new batchSizer starting off with the given batch size,
+// clamped within the bounds of the given (validated) options.
+func newBatchSizer(opts AdaptiveBatchOptions, initialSize int) *batchSizer {
+	s := &batchSizer{
+		minSize:  opts.MinBatchSize,
+		maxSize:  opts.MaxBatchSize,
+		target:   opts.TargetFlushLatency,
+		maxBytes: opts.MaxBatchBytes,
+	}
+	s.size = float64(initialSize)
+	s.clamp()
+	return s
+}
+
+// batchSize returns the current batch size.
+func (s *batchSizer) batchSize() int {
+	return int(s.size + 0.5)
+}
+
+// observe updates the batch size using the outcome of a flush of the given amount of rows,
+// with rowBytes the estimated size of a single row (0 if unknown), returning the new batch size.
+func (s *batchSizer) observe(rows, rowBytes int, latency time.Duration, err error) int {
+	if rowBytes > 0 {
+		if s.rowBytes == 0 {
+			s.rowBytes = float64(rowBytes)
+		} else {
+			s.rowBytes += (float64(rowBytes) - s.rowBytes) * adaptiveSmoothing
+		}
+	}
+	if err != nil {
+		s.errorRate += (1 - s.errorRate) * adaptiveSmoothing
+		s.size *= 1 - s.errorRate/2
+	} else {
+		s.errorRate -= s.errorRate * adaptiveSmoothing
+		if rows > 0 && latency > 0 {
+			candidate := float64(rows) * float64(s.target) / float64(latency)
+			if maxCandidate := s.size * adaptiveMaxGrowth; candidate > maxCandidate {
+				candidate = maxCandidate
+			}
+			s.size += (candidate - s.size) * adaptiveSmoothing
+		}
+	}
+	s.clamp()
+	return s.batchSize()
+}
+
+// clamp ensures the batch size is within the configured bounds,
+// with the max bound lowered such that a batch stays within the max payload size.
+// The min bound takes precedence over the max payload size.
+func (s *batchSizer) clamp() {
+	upper := float64(s.maxSize)
+	if s.rowBytes > 0 {
+		if n := float64(s.maxBytes) / s.rowBytes; n < upper {
+			upper = n
+		}
+	}
+	if s.size > upper {
+		s.size = upper
+	}
+	if s.size < float64(s.minSize) {
+		s.size = float64(s.minSize)
+	}
+}
+
+// maxRowSizeSamples defines the max amount of rows of a batch encoded in order to estimate
+// the average payload size of its rows, bounding the cost of encoding these rows once more.
+const maxRowSizeSamples = 16
+
+// estimateAverageRowSize estimates the average payload size of the given rows,
+// based on at most maxRowSizeSamples rows spread evenly over the rows,
+// returning 0 in case none of the sampled rows can be encoded.
+func estimateAverageRowSize(rows []interface{}) int {
+	step := 1
+	if len(rows) > maxRowSizeSamples {
+		step = len(rows) / maxRowSizeSamples
+	}
+	total, known := 0, 0
+	for i, sampled := 0, 0; i < len(rows) && sampled < maxRowSizeSamples; i, sampled = i+step, sampled+1 {
+		if size := estimateRowSize(rows[i]); size > 0 {
+			total += size
+			known++
+		}
+	}
+	if known == 0 {
+		return 0
+	}
+	return total / known
+}
+
+// estimateRowSize estimates the payload size of a row by encoding it as JSON,
+// returning 0 in case the row cannot be encoded.
+func estimateRowSize(row interface{}) int {
+	if saver, ok := row.(bigquery.ValueSaver); ok {
+		values, _, err := saver.Save()
+		if err != nil {
+			return 0
+		}
+		row = values
+	}
+	b, err := json.Marshal(row)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insertall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+func TestAdaptiveBatchOptionsValidate(t *testing.T) {
+	valid := AdaptiveBatchOptions{
+		MinBatchSize:       10,
+		MaxBatchSize:       100,
+		TargetFlushLatency: time.Second,
+		MaxBatchBytes:      4000,
+	}
+	test.AssertNoError(t, valid.validate())
+
+	testCases := []func(opts *AdaptiveBatchOptions){
+		func(opts *AdaptiveBatchOptions) { opts.MinBatchSize = 0 },
+		func(opts *AdaptiveBatchOptions) { opts.MaxBatchSize = 9 },
+		func(opts *AdaptiveBatchOptions) { opts.TargetFlushLatency = 0 },
+		func(opts *AdaptiveBatchOptions) { opts.MaxBatchBytes = -1 },
+	}
+	for testCaseIndex, modify := range testCases {
+		opts := valid
+		modify(&opts)
+		test.AssertIsError(t, opts.validate(), internal.ErrInvalidParam, testCaseIndex)
+	}
+}
+
+func TestBatchSizerInitialSizeClamped(t *testing.T) {
+	sizer := newBatchSizer(AdaptiveBatchOptions{
+		MinBatchSize:       20,
+		MaxBatchSize:       40,
+		TargetFlushLatency: time.Second,
+		MaxBatchBytes:      4000,
+	}, 50)
+	test.AssertEqual(t, 40, sizer.batchSize())
+}
+
+func TestBatchSizerGrowsWhenFasterThanTarget(t *testing.T) {
+	sizer := newBatchSizer(AdaptiveBatchOptions{
+		MinBatchSize:       10,
+		MaxBatchSize:       100,
+		TargetFlushLatency: time.Second,
+	}, 20)
+	// growth is limited to a factor of 2 per flush, and smoothed
+	test.AssertEqual(t, 30, sizer.observe(20, 0, 100*time.Millisecond, nil))
+	test.AssertEqual(t, 45, sizer.observe(30, 0, 100*time.Millisecond, nil))
+	// the batch size never grows beyond the max batch size
+	for i := 0; i < 10; i++ {
+		sizer.observe(sizer.batchSize(), 0, 100*time.Millisecond, nil)
+	}
+	test.AssertEqual(t, 100, sizer.batchSize())
+	// partial batches flushed within the target latency can cause the batch size to grow as well
+	test.AssertEqual(t, 100, sizer.observe(60, 0, 500*time.Millisecond, nil))
+}
+
+func TestBatchSizerShrinksWhenSlowerThanTarget(t *testing.T) {
+	sizer := newBatchSizer(AdaptiveBatchOptions{
+		MinBatchSize:       10,
+		MaxBatchSize:       100,
+		TargetFlushLatency: time.Second,
+	}, 100)
+	// 25 rows could have been flushed within the target latency
+	test.AssertEqual(t, 63, sizer.observe(100, 0, 4*time.Second, nil))
+	// the batch size never shrinks below the min batch size
+	for i := 0; i < 10; i++ {
+		sizer.observe(sizer.batchSize(), 0, time.Minute, nil)
+	}
+	test.AssertEqual(t, 10, sizer.batchSize())
+}
+
+func TestBatchSizerShrinksOnErrors(t *testing.T) {
+	sizer := newBatchSizer(AdaptiveBatchOptions{
+		MinBatchSize:       10,
+		MaxBatchSize:       100,
+		TargetFlushLatency: time.Second,
+	}, 100)
+	// the batch size shrinks the more, the more recent flushes failed
+	test.AssertEqual(t, 75, sizer.observe(100, 0, time.Second, test.ErrStatic))
+	test.AssertEqual(t, 47, sizer.observe(75, 0, time.Second, test.ErrStatic))
+	// the error rate recovers with each successful flush
+	test.AssertEqual(t, 47, sizer.observe(47, 0, time.Second, nil))
+	test.AssertTrue(t, sizer.errorRate < 0.5)
+}
+
+func TestBatchSizerMaxBatchBytes(t *testing.T) {
+	sizer := newBatchSizer(AdaptiveBatchOptions{
+		MinBatchSize:       10,
+		MaxBatchSize:       100,
+		TargetFlushLatency: time.Second,
+		MaxBatchBytes:      4000,
+	}, 100)
+	// the estimated payload of a batch stays within the max batch bytes
+	test.AssertEqual(t, 40, sizer.observe(100, 100, time.Second, nil))
+	// the min batch size takes precedence over the max batch bytes
+	test.AssertEqual(t, 10, sizer.observe(40, 10000, time.Second, nil))
+}
+
+func TestEstimateRowSize(t *testing.T) {
+	test.AssertEqual(t, 7, estimateRowSize("hello"))
+	test.AssertEqual(t, 13, estimateRowSize(map[string]int{"answer": 42}))
+	// rows which cannot be encoded have an unknown size
+	test.AssertEqual(t, 0, estimateRowSize(make(chan int)))
+}
+
+func TestEstimateAverageRowSize(t *testing.T) {
+	test.AssertEqual(t, 0, estimateAverageRowSize(nil))
+	// rows of an unknown size are not taken into account
+	test.AssertEqual(t, 0, estimateAverageRowSize([]interface{}{make(chan int)}))
+	test.AssertEqual(t, 9, estimateAverageRowSize([]interface{}{"a", make(chan int), "hello world!!"}))
+
+	// only a bounded sample of the rows, spread evenly, is encoded
+	rows := make([]interface{}, 0, 4*maxRowSizeSamples)
+	for len(rows) < cap(rows) {
+		rows = append(rows, "a", "hello world!!")
+	}
+	test.AssertEqual(t, 3, estimateAverageRowSize(rows))
+	test.AssertEqual(t, 9, estimateAverageRowSize(rows[:maxRowSizeSamples]))
+}
@@ -16,12 +16,17 @@ package insertall
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/OTA-Insight/bqwriter/constant"
 	"github.com/OTA-Insight/bqwriter/internal"
+	bqbase "github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/table"
 	"github.com/OTA-Insight/bqwriter/log"
 )
 
@@ -38,6 +43,27 @@ type Client struct {
 	batchSize int
 
 	maxRetryDeadlineOffset time.Duration
+
+	// evolver is used to add unknown fields as columns to the table schema,
+	// nil in case schema evolution is disabled
+	evolver schemaEvolver
+
+	// sizer is used to adapt the batch size after each flush,
+	// nil in case adaptive batch sizing is disabled
+	sizer         *batchSizer
+	batchSizeHook func(batchSize int)
+
+	// throttler is waited on prior to writing the batched rows,
+	// nil in case the client is not throttled
+	throttler bqbase.Throttler
+}
+
+// schemaEvolver defines the API we expect in order to evolve the table schema,
+// allowing it to be stubbed for testing purposes as well.
+type schemaEvolver interface {
+	// AddColumns adds the fields of the given schema which are not yet defined
+	// in the table schema as NULLABLE columns, returning the (updated) table schema.
+	AddColumns(ctx context.Context, columns bigquery.Schema) (bigquery.Schema, error)
 }
 
 // bqClient defines the API we expect from the BQ InsertAll client,
@@ -53,7 +79,8 @@ type bqClient interface {
 
 // stdBQClient impements bqClient using the official Golang Gcloud BigQuery API client.
 type stdBQClient struct {
-	client *bigquery.Client
+	client      *bigquery.Client
+	closeClient func() error
 
 	dataSetID string
 	tableID   string
@@ -75,25 +102,27 @@ func (bqc *stdBQClient) Put(ctx context.Context, data interface{}) error {
 
 // Close implements bqClient::Close
 func (bqc *stdBQClient) Close() error {
-	if err := bqc.client.Close(); err != nil {
+	if err := bqc.closeClient(); err != nil {
 		return fmt.Errorf("close BQ google-API insertAll client: %w", err)
 	}
 	return nil
 }
 
 // newStdBQClient creates a new Client,
-// a production-ready implementation of bqClient.
-func newStdBQClient(projectID, dataSetID, tableID string, skipInvalidRows, ignoreUnknownValues bool) (*stdBQClient, error) {
+// a production-ready implementation of bqClient,
+// using the BigQuery client of the given pool if defined.
+func newStdBQClient(pool bqbase.ClientPool, projectID, dataSetID, tableID string, skipInvalidRows, ignoreUnknownValues bool) (*stdBQClient, error) {
 	// NOTE: we are using the background Context,
 	// as to ensure that we can always write to the client,
 	// even when the actual parent context is already done.
 	// This is a requirement given the streamer will batch its rows.
-	client, err := bigquery.NewClient(context.Background(), projectID)
+	client, closeClient, err := bqbase.NewBigQueryClient(context.Background(), pool, projectID)
 	if err != nil {
 		return nil, fmt.Errorf("create BQ Insert All Client: %w", err)
 	}
 	return &stdBQClient{
-		client: client,
+		client:      client,
+		closeClient: closeClient,
 
 		dataSetID: dataSetID,
 		tableID:   tableID,
@@ -104,7 +133,17 @@ func newStdBQClient(projectID, dataSetID, tableID string, skipInvalidRows, ignor
 }
 
 // NewClient creates a new Client.
-func NewClient(projectID, dataSetID, tableID string, skipInvalidRows, ignoreUnknownValues bool, batchSize int, maxRetryDeadlineOffset time.Duration, logger log.Logger) (*Client, error) {
+//
+// In case evolveSchema is true, unknown values are never ignored. Instead the fields of
+// rows rejected because of unknown values are added as NULLABLE columns to the table schema,
+// after which these rows are retried.
+//
+// In case adaptive options are defined, the given batchSize is only used as the initial batch size,
+// with the batch size adapted after each flush within the bounds of these options.
+//
+// In case a pool is defined, the BigQuery client of the pool is used (and released once closed),
+// rather than creating a new BigQuery client.
+func NewClient(projectID, dataSetID, tableID string, skipInvalidRows, ignoreUnknownValues, evolveSchema bool, batchSize int, maxRetryDeadlineOffset time.Duration, adaptive *AdaptiveBatchOptions, pool bqbase.ClientPool, logger log.Logger) (*Client, error) {
 	if projectID == "" {
 		return nil, fmt.Errorf("bq insertAll client creation: validate projectID: %w: missing", internal.ErrInvalidParam)
 	}
@@ -114,11 +153,30 @@ func NewClient(projectID, dataSetID, tableID string, skipInvalidRows, ignoreUnkn
 	if tableID == "" {
 		return nil, fmt.Errorf("bq insertAll client creation: validate tableID: %w: missing", internal.ErrInvalidParam)
 	}
-	client, err := newStdBQClient(projectID, dataSetID, tableID, skipInvalidRows, ignoreUnknownValues)
+	if evolveSchema {
+		// unknown values have to be reported by BQ, in order to be able to add them
+		ignoreUnknownValues = false
+	}
+	client, err := newStdBQClient(pool, projectID, dataSetID, tableID, skipInvalidRows, ignoreUnknownValues)
 	if err != nil {
 		return nil, err
 	}
-	return newClient(client, batchSize, maxRetryDeadlineOffset, logger)
+	thickClient, err := newClient(client, batchSize, maxRetryDeadlineOffset, logger)
+	if err != nil {
+		return nil, err
+	}
+	if adaptive != nil {
+		if err := thickClient.enableAdaptiveBatchSize(*adaptive); err != nil {
+			return nil, fmt.Errorf("bq insertAll client creation: adaptive batch size: %w", err)
+		}
+	}
+	if evolveSchema {
+		thickClient.evolver, err = table.NewManager(client.client, dataSetID, tableID, logger)
+		if err != nil {
+			return nil, fmt.Errorf("bq insertAll client creation: create table manager: %w", err)
+		}
+	}
+	return thickClient, nil
 }
 
 func newClient(client bqClient, batchSize int, maxRetryDeadlineOffset time.Duration, logger log.Logger) (*Client, error) {
@@ -157,11 +215,38 @@ func (bqc *Client) Put(data interface{}) (bool, error) {
 	return true, bqc.Flush()
 }
 
+// PutBatch implements bqClient::PutBatch
+//
+// The rows are appended directly to the batched rows, flushing each time the batch is full,
+// such that no more than batchSize rows are written at once.
+func (bqc *Client) PutBatch(rows []interface{}) (flushed bool, err error) {
+	for len(rows) > 0 {
+		n := bqc.batchSize - len(bqc.rows)
+		if n > len(rows) {
+			n = len(rows)
+		}
+		bqc.rows = append(bqc.rows, rows[:n]...)
+		rows = rows[n:]
+		if len(bqc.rows) < bqc.batchSize {
+			break // batch not yet full, nothing more to do
+		}
+		// the flushed rows are dropped on failure,
+		// so we continue with the remaining rows, returning the first error
+		flushed = true
+		if flushErr := bqc.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	return flushed, err
+}
+
 // Flush implements bqClient::Flush
 func (bqc *Client) Flush() (err error) {
 	if len(bqc.rows) == 0 {
 		return nil // nothing to do :)
 	}
+	bqc.throttle()
+	start := time.Now()
 	// ensure at the end we clear out our written rows,
 	// we could return the unwritten rows with the errors,
 	// and that is what an early prototype of this library did,
@@ -171,7 +256,14 @@ func (bqc *Client) Flush() (err error) {
 	// haven on worker goroutines.
 	defer func() {
 		if err != nil {
-			bqc.logger.Errorf("BQ InsertAll Client: Flush: dropping %d row(s) due to error: %v", len(bqc.rows), err)
+			log.Log(
+				bqc.logger, log.LevelError,
+				fmt.Sprintf("BQ InsertAll Client: Flush: dropping %d row(s) due to error: %v", len(bqc.rows), err),
+				log.F(log.FieldRowCount, len(bqc.rows)),
+			)
+		}
+		if bqc.sizer != nil {
+			bqc.adaptBatchSize(time.Since(start), err)
 		}
 		// empty rows, written or not,
 		// such that we can start inserting new rows
@@ -185,11 +277,152 @@ func (bqc *Client) Flush() (err error) {
 	ctx, cancelFunc := context.WithTimeout(context.Background(), bqc.maxRetryDeadlineOffset)
 	defer cancelFunc()
 	if err := bqc.client.Put(ctx, bqc.rows); err != nil {
-		return fmt.Errorf("thick insertAll BQ client: put batched rows (count=%d): %w", len(bqc.rows), err)
+		if bqc.evolver != nil {
+			err = bqc.evolveSchemaAndRetry(ctx, bqc.rows, err)
+		}
+		if err != nil {
+			return fmt.Errorf("thick insertAll BQ client: put batched rows (count=%d): %w", len(bqc.rows), err)
+		}
 	}
 	return nil
 }
 
+// SetThrottler implements bigquery.Throttled::SetThrottler
+func (bqc *Client) SetThrottler(throttler bqbase.Throttler) {
+	bqc.throttler = throttler
+}
+
+// throttle waits until the batched rows can be written, in case the client is throttled,
+// estimating their size only in case the throttler limits the bytes written.
+func (bqc *Client) throttle() {
+	if bqc.throttler == nil {
+		return
+	}
+	size := 0
+	if bqc.throttler.LimitsBytes() {
+		size = estimateAverageRowSize(bqc.rows) * len(bqc.rows)
+	}
+	bqc.throttler.Throttle(len(bqc.rows), size)
+}
+
+// enableAdaptiveBatchSize enables adaptive batch sizing,
+// using the current batch size as the initial batch size.
+func (bqc *Client) enableAdaptiveBatchSize(opts AdaptiveBatchOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	bqc.sizer = newBatchSizer(opts, bqc.batchSize)
+	bqc.batchSizeHook = opts.BatchSizeHook
+	bqc.batchSize = bqc.sizer.batchSize()
+	return nil
+}
+
+// BatchSize returns the current batch size of the client,
+// which is adapted after each flush in case adaptive batch sizing is enabled.
+func (bqc *Client) BatchSize() int {
+	return bqc.batchSize
+}
+
+// adaptBatchSize adapts the batch size using the outcome of the flush of the current rows.
+func (bqc *Client) adaptBatchSize(latency time.Duration, err error) {
+	size := bqc.sizer.observe(len(bqc.rows), estimateAverageRowSize(bqc.rows), latency, err)
+	if size == bqc.batchSize {
+		return
+	}
+	log.Log(
+		bqc.logger, log.LevelDebug,
+		fmt.Sprintf("BQ InsertAll Client: adapted batch size from %d to %d row(s) after a flush of %v", bqc.batchSize, size, latency),
+		log.F(log.FieldBatchSize, size),
+		log.F(log.FieldRowCount, len(bqc.rows)),
+	)
+	bqc.batchSize = size
+	if bqc.batchSizeHook != nil {
+		bqc.batchSizeHook(size)
+	}
+}
+
+// evolveSchemaAndRetry adds the unknown fields of the rejected rows as columns to the table schema,
+// and retries putting these rows afterwards. The original put error is returned as-is in case
+// the rows were rejected (also) for any other reason than unknown fields.
+func (bqc *Client) evolveSchemaAndRetry(ctx context.Context, rows []interface{}, putErr error) error {
+	unknownRows, rejectedRows, ok := rowsRejectedForUnknownFields(rows, putErr)
+	if !ok {
+		return putErr
+	}
+	var columns bigquery.Schema
+	for _, row := range unknownRows {
+		rowSchema, err := schema.InferFromRow(row)
+		if err != nil {
+			return fmt.Errorf("evolve schema: %v: %w", putErr, err)
+		}
+		columns, _ = schema.Merge(columns, rowSchema)
+	}
+	if _, err := bqc.evolver.AddColumns(ctx, columns); err != nil {
+		return fmt.Errorf("evolve schema: %v: %w", putErr, err)
+	}
+	log.Log(
+		bqc.logger, log.LevelInfo,
+		"BQ InsertAll Client: evolved table schema: retry rows rejected for unknown fields",
+		log.F(log.FieldRowCount, len(rejectedRows)),
+	)
+	// new columns can take a little while before they are known by the insertAll API,
+	// hence we retry for as long as the rows are rejected for unknown fields only
+	retryer := bqbase.NewRetryer(
+		ctx,
+		constant.DefaultMaxRetries,
+		constant.DefaultInitialRetryDelay,
+		bqc.maxRetryDeadlineOffset,
+		constant.DefaultRetryDelayMultiplier,
+		func(err error) bool {
+			_, _, ok := rowsRejectedForUnknownFields(rejectedRows, err)
+			return ok
+		},
+	)
+	return retryer.RetryOp(func(ctx context.Context) error {
+		return bqc.client.Put(ctx, rejectedRows)
+	})
+}
+
+// rowsRejectedForUnknownFields returns the rows rejected because of unknown fields,
+// as well as all rows that were rejected as part of the same put operation. False is returned
+// in case the error isn't a put error or in case any row was rejected for another reason.
+//
+// When invalid rows aren't skipped, all other rows are rejected as well ("stopped"),
+// which is why these are returned separately, as these have to be retried as well.
+func rowsRejectedForUnknownFields(rows []interface{}, err error) (unknownRows []interface{}, rejectedRows []interface{}, ok bool) {
+	var putErr bigquery.PutMultiError
+	if !errors.As(err, &putErr) || len(putErr) == 0 {
+		return nil, nil, false
+	}
+	for _, rowErr := range putErr {
+		if rowErr.RowIndex < 0 || rowErr.RowIndex >= len(rows) {
+			return nil, nil, false
+		}
+		unknown := false
+		for _, err := range rowErr.Errors {
+			var bqErr *bigquery.Error
+			if !errors.As(err, &bqErr) {
+				return nil, nil, false
+			}
+			switch {
+			case bqErr.Reason == "invalid" && strings.Contains(bqErr.Message, "no such field"):
+				unknown = true
+			case bqErr.Reason == "stopped":
+			default:
+				return nil, nil, false
+			}
+		}
+		if unknown {
+			unknownRows = append(unknownRows, rows[rowErr.RowIndex])
+		}
+		rejectedRows = append(rejectedRows, rows[rowErr.RowIndex])
+	}
+	if len(unknownRows) == 0 {
+		return nil, nil, false
+	}
+	return unknownRows, rejectedRows, true
+}
+
 // Close implements bqClient::Close
 func (bqc *Client) Close() error {
 	// no need to flush first,
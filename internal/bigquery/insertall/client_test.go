@@ -26,12 +26,16 @@ import (
 	"github.com/OTA-Insight/bqwriter/internal"
 	"github.com/OTA-Insight/bqwriter/internal/test"
 	"github.com/OTA-Insight/bqwriter/log"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
 )
 
 // stubClient is an in-memory stub client for the bqInsertAllClient interface,
 // allowing us to see what data is written into it
 type stubClient struct {
 	rows            []interface{}
+	putSizes        []int
 	nextErrors      []error
 	sleepPriorToPut time.Duration
 }
@@ -51,6 +55,7 @@ func (sbqc *stubClient) Put(ctx context.Context, data interface{}) error {
 	}
 	if rows, ok := data.([]interface{}); ok {
 		sbqc.rows = append(sbqc.rows, rows...)
+		sbqc.putSizes = append(sbqc.putSizes, len(rows))
 	} else {
 		sbqc.rows = append(sbqc.rows, data)
 	}
@@ -146,6 +151,65 @@ func TestBQInsertAllThickClientBatchExhaustBatch(t *testing.T) {
 	stubClient.AssertStringSlice(t, []string{"hello", "world", "!"})
 }
 
+func TestBQInsertAllThickClientThrottledOnFlush(t *testing.T) {
+	stubClient, client := newTestClient(t, &TestClientConfig{
+		BatchSize: 2,
+	})
+	defer stubClient.Close()
+	throttler := &test.Throttler{LimitBytes: true}
+	client.SetThrottler(throttler)
+
+	// batched rows are only throttled once written
+	_, err := client.Put("a")
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, 0, len(throttler.Calls))
+	_, err = client.Put("b")
+	test.AssertNoError(t, err)
+	_, err = client.Put("hello")
+	test.AssertNoError(t, err)
+	test.AssertNoError(t, client.Flush())
+	test.AssertEqual(t, []test.Throttle{{Rows: 2, Bytes: 6}, {Rows: 1, Bytes: 7}}, throttler.Calls)
+
+	// the size of the rows is not estimated unless it is limited
+	throttler.LimitBytes = false
+	_, err = client.Put("c")
+	test.AssertNoError(t, err)
+	test.AssertNoError(t, client.Flush())
+	test.AssertEqual(t, test.Throttle{Rows: 1}, throttler.Calls[2])
+}
+
+func TestBQInsertAllThickClientPutBatch(t *testing.T) {
+	stubClient, client := newTestClient(t, &TestClientConfig{
+		BatchSize: 2,
+	})
+	defer stubClient.Close()
+
+	flushed, err := client.Put("a")
+	test.AssertNoError(t, err)
+	test.AssertFalse(t, flushed)
+
+	// the rows are appended to the batched rows, written at most batch size rows at once
+	flushed, err = client.PutBatch([]interface{}{"b", "c", "d", "e"})
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, flushed)
+	stubClient.AssertStringSlice(t, []string{"a", "b", "c", "d"})
+	test.AssertEqual(t, []int{2, 2}, stubClient.putSizes)
+
+	flushed, err = client.PutBatch(nil)
+	test.AssertNoError(t, err)
+	test.AssertFalse(t, flushed)
+
+	test.AssertNoError(t, client.Flush())
+	stubClient.AssertStringSlice(t, []string{"a", "b", "c", "d", "e"})
+
+	// a failed flush drops its rows only, with the remaining rows still being written
+	stubClient.AddNextError(test.ErrStatic)
+	flushed, err = client.PutBatch([]interface{}{"f", "g", "h", "i"})
+	test.AssertIsError(t, err, test.ErrStatic)
+	test.AssertTrue(t, flushed)
+	stubClient.AssertStringSlice(t, []string{"a", "b", "c", "d", "e", "h", "i"})
+}
+
 func TestBQInsertAllThickClientFlushMaxDeadlineExhausted(t *testing.T) {
 	stubClient, client := newTestClient(t, &TestClientConfig{
 		BatchSize:              1,
@@ -190,7 +254,8 @@ func TestNewStdBQInsertAllThickClientInputErrors(t *testing.T) {
 	for _, testCase := range testCases {
 		client, err := NewClient(
 			testCase.ProjectID, testCase.DataSetID, testCase.TableID,
-			false, false, 0, 0,
+			false, false, false, 0, 0, nil,
+			nil,
 			test.Logger{},
 		)
 		test.AssertError(t, err)
@@ -199,6 +264,44 @@ func TestNewStdBQInsertAllThickClientInputErrors(t *testing.T) {
 	}
 }
 
+// stubPool is a client pool returning a single BigQuery client,
+// allowing us to see how many references to it are acquired and released
+type stubPool struct {
+	client *bigquery.Client
+	refs   int
+}
+
+// BigQueryClient implements bigquery.ClientPool::BigQueryClient
+func (sp *stubPool) BigQueryClient() (*bigquery.Client, error) {
+	sp.refs++
+	return sp.client, nil
+}
+
+// WriterClient implements bigquery.ClientPool::WriterClient
+func (sp *stubPool) WriterClient() (*managedwriter.Client, error) {
+	return nil, fmt.Errorf("writer client: %w", internal.ErrInvalidParam)
+}
+
+// Release implements bigquery.ClientPool::Release
+func (sp *stubPool) Release() error {
+	sp.refs--
+	return nil
+}
+
+func TestNewBQInsertAllClientWithPool(t *testing.T) {
+	pool := &stubPool{client: new(bigquery.Client)}
+	clientA, err := NewClient("a", "b", "c", false, false, false, 0, 0, nil, pool, test.Logger{})
+	test.AssertNoErrorFatal(t, err)
+	clientB, err := NewClient("a", "b", "d", false, false, false, 0, 0, nil, pool, test.Logger{})
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, 2, pool.refs)
+
+	// closing the clients releases the pool, rather than closing the shared client
+	test.AssertNoError(t, clientA.Close())
+	test.AssertNoError(t, clientB.Close())
+	test.AssertEqual(t, 0, pool.refs)
+}
+
 func TestNewBQInsertAllThickClientErrors(t *testing.T) {
 	testCases := []struct {
 		Client bqClient
@@ -217,3 +320,123 @@ func TestNewBQInsertAllThickClientErrors(t *testing.T) {
 		test.AssertNil(t, client)
 	}
 }
+
+// stubEvolver is an in-memory stub for the schemaEvolver interface,
+// allowing us to see what columns are added using it
+type stubEvolver struct {
+	columns bigquery.Schema
+	err     error
+}
+
+// AddColumns implements schemaEvolver::AddColumns
+func (se *stubEvolver) AddColumns(ctx context.Context, columns bigquery.Schema) (bigquery.Schema, error) {
+	if se.err != nil {
+		return nil, se.err
+	}
+	se.columns = append(se.columns, columns...)
+	return se.columns, nil
+}
+
+func unknownFieldPutErr(rowCount int, unknownRowIndices ...int) error {
+	unknown := make(map[int]bool, len(unknownRowIndices))
+	for _, idx := range unknownRowIndices {
+		unknown[idx] = true
+	}
+	var putErr bigquery.PutMultiError
+	for idx := 0; idx < rowCount; idx++ {
+		rowErr := &bigquery.Error{Reason: "stopped"}
+		if unknown[idx] {
+			rowErr = &bigquery.Error{Reason: "invalid", Location: "age", Message: "no such field: age."}
+		}
+		putErr = append(putErr, bigquery.RowInsertionError{RowIndex: idx, Errors: bigquery.MultiError{rowErr}})
+	}
+	return putErr
+}
+
+func TestBQInsertAllThickClientEvolveSchema(t *testing.T) {
+	stubClient, client := newTestClient(t, &TestClientConfig{BatchSize: 2})
+	evolver := new(stubEvolver)
+	client.evolver = evolver
+	stubClient.AddNextError(unknownFieldPutErr(2, 1))
+
+	rows := []interface{}{
+		map[string]interface{}{"name": "foo"},
+		map[string]interface{}{"name": "bar", "age": 42},
+	}
+	_, err := client.Put(rows[0])
+	test.AssertNoError(t, err)
+	flushed, err := client.Put(rows[1])
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, flushed)
+
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "age", Type: bigquery.IntegerFieldType},
+		{Name: "name", Type: bigquery.StringFieldType},
+	}, evolver.columns)
+	test.AssertEqual(t, rows, stubClient.rows)
+}
+
+func TestBQInsertAllThickClientEvolveSchemaErrors(t *testing.T) {
+	testCases := []struct {
+		PutErr     error
+		EvolverErr error
+	}{
+		// not an unknown field error
+		{test.ErrStatic, nil},
+		// rejected for another reason as well
+		{bigquery.PutMultiError{
+			{RowIndex: 0, Errors: bigquery.MultiError{&bigquery.Error{Reason: "invalid", Message: "no such field: age."}}},
+			{RowIndex: 1, Errors: bigquery.MultiError{&bigquery.Error{Reason: "invalid", Message: "cannot convert value"}}},
+		}, nil},
+		// evolver fails
+		{unknownFieldPutErr(2, 0), test.ErrStatic},
+	}
+	for _, testCase := range testCases {
+		stubClient, client := newTestClient(t, &TestClientConfig{BatchSize: 2})
+		client.evolver = &stubEvolver{err: testCase.EvolverErr}
+		stubClient.AddNextError(testCase.PutErr)
+		_, err := client.Put(map[string]interface{}{"age": 42})
+		test.AssertNoError(t, err)
+		_, err = client.Put(map[string]interface{}{"age": 42})
+		test.AssertError(t, err)
+		test.AssertEqual(t, 0, len(stubClient.rows))
+	}
+}
+
+func TestBQInsertAllThickClientAdaptiveBatchSize(t *testing.T) {
+	stubClient, client := newTestClient(t, &TestClientConfig{
+		BatchSize: 4,
+	})
+	defer stubClient.Close()
+	var hookSizes []int
+	test.AssertNoErrorFatal(t, client.enableAdaptiveBatchSize(AdaptiveBatchOptions{
+		MinBatchSize:       2,
+		MaxBatchSize:       8,
+		TargetFlushLatency: time.Hour,
+		MaxBatchBytes:      1000,
+		BatchSizeHook: func(batchSize int) {
+			hookSizes = append(hookSizes, batchSize)
+		},
+	}))
+	test.AssertEqual(t, 4, client.BatchSize())
+
+	// a failed flush shrinks the batch size
+	stubClient.AddNextError(test.ErrStatic)
+	flushed, err := client.PutBatch([]interface{}{"a", "b", "c", "d"})
+	test.AssertIsError(t, err, test.ErrStatic)
+	test.AssertTrue(t, flushed)
+	test.AssertEqual(t, 3, client.BatchSize())
+
+	// fast flushes grow the batch size, up to the max batch size
+	flushed, err = client.PutBatch([]interface{}{"e", "f", "g"})
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, flushed)
+	test.AssertEqual(t, 5, client.BatchSize())
+	flushed, err = client.PutBatch([]interface{}{"h", "i", "j", "k", "l"})
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, flushed)
+	test.AssertEqual(t, 7, client.BatchSize())
+	test.AssertEqual(t, []int{3, 5}, stubClient.putSizes)
+	test.AssertEqual(t, []int{3, 5, 7}, hookSizes)
+	stubClient.AssertStringSlice(t, []string{"e", "f", "g", "h", "i", "j", "k", "l"})
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+)
+
+// ClientPool provides the Google API clients used by the internal clients, allowing them
+// to be shared by multiple (internal) clients rather than each client creating its own.
+//
+// Each client returned by the pool acquires a reference to the pool, which has to be released
+// once the client is no longer used, rather than closing the client itself.
+type ClientPool interface {
+	// BigQueryClient returns the shared BigQuery client, acquiring a reference to the pool.
+	BigQueryClient() (*bq.Client, error)
+	// WriterClient returns the shared Storage Write API client, acquiring a reference to the pool.
+	WriterClient() (*managedwriter.Client, error)
+	// Release releases a reference to the pool, acquired by one of its clients.
+	Release() error
+}
+
+// NewBigQueryClient returns the BigQuery client of the given pool, if defined,
+// or creates a new BigQuery client otherwise. The returned function is to be used to close the client,
+// which releases it in case it was acquired from the pool.
+func NewBigQueryClient(ctx context.Context, pool ClientPool, projectID string) (*bq.Client, func() error, error) {
+	if pool != nil {
+		client, err := pool.BigQueryClient()
+		if err != nil {
+			return nil, nil, fmt.Errorf("acquire BQ client from pool: %w", err)
+		}
+		return client, pool.Release, nil
+	}
+	client, err := bq.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, client.Close, nil
+}
+
+// NewWriterClient returns the Storage Write API client of the given pool, if defined,
+// or creates a new Storage Write API client otherwise. The returned function is to be used to close the client,
+// which releases it in case it was acquired from the pool.
+func NewWriterClient(ctx context.Context, pool ClientPool, projectID string) (*managedwriter.Client, func() error, error) {
+	if pool != nil {
+		client, err := pool.WriterClient()
+		if err != nil {
+			return nil, nil, fmt.Errorf("acquire BQ Storage writer client from pool: %w", err)
+		}
+		return client, pool.Release, nil
+	}
+	client, err := managedwriter.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, client.Close, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"errors"
+	"net/http"
+
+	bq "cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QuotaNotifier can be implemented by a Client which receives (some of) its errors asynchronously,
+// such as the results of appends, in order to notify the streamer of the quota errors among them,
+// as these cannot be returned by Put or Flush.
+type QuotaNotifier interface {
+	// NotifyQuotaExceeded registers the function called with each quota error received asynchronously.
+	NotifyQuotaExceeded(f func(err error))
+}
+
+// IsQuotaError returns true in case the given error reports that a quota or rate limit of BigQuery was exceeded,
+// either as a ResourceExhausted gRPC status (Storage Write API) or as an API error (insertAll and load jobs).
+func IsQuotaError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) && grpcErr.GRPCStatus().Code() == codes.ResourceExhausted {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == http.StatusTooManyRequests {
+			return true
+		}
+		for _, item := range apiErr.Errors {
+			if isQuotaReason(item.Reason) {
+				return true
+			}
+		}
+	}
+	var bqErr *bq.Error
+	return errors.As(err, &bqErr) && isQuotaReason(bqErr.Reason)
+}
+
+// isQuotaReason returns true for the reasons used by BigQuery for quota errors,
+// see https://cloud.google.com/bigquery/docs/error-messages.
+func isQuotaReason(reason string) bool {
+	return reason == "quotaExceeded" || reason == "rateLimitExceeded"
+}
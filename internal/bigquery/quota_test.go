@@ -0,0 +1,49 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsQuotaError(t *testing.T) {
+	testCases := []struct {
+		Err      error
+		Expected bool
+	}{
+		{nil, false},
+		{test.ErrStatic, false},
+		{status.Error(codes.ResourceExhausted, "quota"), true},
+		{fmt.Errorf("append rows: %w", status.Error(codes.ResourceExhausted, "quota")), true},
+		{status.Error(codes.Unavailable, "unavailable"), false},
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{fmt.Errorf("put rows: %w", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}), true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessDenied"}}}, false},
+		{fmt.Errorf("load job: %w", &bq.Error{Reason: "quotaExceeded"}), true},
+		{&bq.Error{Reason: "invalid"}, false},
+	}
+	for i, testCase := range testCases {
+		test.AssertEqual(t, testCase.Expected, IsQuotaError(testCase.Err), "test case #%d: %v", i, testCase.Err)
+	}
+}
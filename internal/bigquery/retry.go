@@ -16,7 +16,6 @@ package bigquery
 
 import (
 	"context"
-	"errors"
 	"time"
 
 	gax "github.com/googleapis/gax-go/v2"
@@ -92,7 +91,7 @@ func (r *Retryer) Retry(err error) (pause time.Duration, shouldRetry bool) {
 		// no error returned, no need to retry
 		return 0, false
 	}
-	if errors.Is(r.deadlineCtx.Err(), context.Canceled) {
+	if r.deadlineCtx.Err() != nil {
 		// if parent ctx is done or the deadline has been reached,
 		// no retry is possible any longer either
 		return 0, false
@@ -114,6 +114,21 @@ func TestBQRetryerNoRetryBecauseOfCanceledContext(t *testing.T) {
 	test.AssertEqual(t, time.Duration(0), pause)
 }
 
+func TestBQRetryerNoRetryBecauseOfDeadline(t *testing.T) {
+	retryer := NewRetryer(
+		context.Background(),
+		constant.DefaultMaxRetries,
+		constant.DefaultInitialRetryDelay,
+		time.Millisecond,
+		constant.DefaultRetryDelayMultiplier,
+		nil, // no error filter
+	)
+	time.Sleep(5 * time.Millisecond)
+	pause, shouldRetry := retryer.Retry(fmt.Errorf("retry: %w", test.ErrStatic))
+	test.AssertFalse(t, shouldRetry)
+	test.AssertEqual(t, time.Duration(0), pause)
+}
+
 func TestBQRetryerNoRetryBecauseOfMaxRetries(t *testing.T) {
 	retryer := NewRetryer(
 		context.Background(),
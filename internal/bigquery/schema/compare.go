@@ -0,0 +1,194 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// MismatchKind defines the kind of incompatibility found between two schemas.
+type MismatchKind string
+
+const (
+	// MismatchMissingRequired indicates that a REQUIRED column of the table
+	// is not defined by the configured schema, making it impossible to write any row.
+	MismatchMissingRequired MismatchKind = "missing required column"
+	// MismatchUnknownField indicates that a field of the configured schema
+	// is not defined as a column of the table.
+	MismatchUnknownField MismatchKind = "unknown column"
+	// MismatchType indicates that a field of the configured schema
+	// has a type which cannot be written to the type of its table column.
+	MismatchType MismatchKind = "type incompatibility"
+	// MismatchMode indicates that the mode (NULLABLE, REQUIRED or REPEATED)
+	// of a field of the configured schema conflicts with the mode of its table column.
+	MismatchMode MismatchKind = "mode conflict"
+)
+
+// Mismatch is a single incompatibility found between a field of the configured schema
+// and its column in the table schema.
+type Mismatch struct {
+	// Field is the (dot-separated) path of the field.
+	Field string
+	// Kind defines what kind of incompatibility it is.
+	Kind MismatchKind
+	// Configured describes the field as defined in the configured schema,
+	// empty in case the field isn't defined in the configured schema.
+	Configured string
+	// Table describes the field as defined in the table schema,
+	// empty in case the field isn't defined in the table schema.
+	Table string
+}
+
+// String implements fmt.Stringer::String
+func (m Mismatch) String() string {
+	switch {
+	case m.Configured == "":
+		return fmt.Sprintf("%s: %s (table: %s)", m.Field, m.Kind, m.Table)
+	case m.Table == "":
+		return fmt.Sprintf("%s: %s (configured: %s)", m.Field, m.Kind, m.Configured)
+	default:
+		return fmt.Sprintf("%s: %s (configured: %s, table: %s)", m.Field, m.Kind, m.Configured, m.Table)
+	}
+}
+
+// CompareOptions can be used to configure how two schemas are compared.
+type CompareOptions struct {
+	// AllowUnknownFields skips the fields of the configured schema
+	// which are not defined in the table schema, e.g. because these are added
+	// by the client when evolving the table schema.
+	AllowUnknownFields bool
+
+	// StorageWireTypes allows the types of the configured schema to be compatible
+	// with all BigQuery types they can be converted to by the Storage Write API,
+	// e.g. an INTEGER to a TIMESTAMP column, as is the case for a schema
+	// derived from a protobuf descriptor.
+	StorageWireTypes bool
+}
+
+// Compare compares the configured schema, used to write rows, against the schema of the table,
+// returning all incompatibilities found, nested fields included. Fields are matched case-insensitive,
+// the same way BigQuery matches its column names. Nil is returned in case the schemas are compatible.
+func Compare(configured, table bigquery.Schema, opts CompareOptions) []Mismatch {
+	return compare(configured, table, opts, "")
+}
+
+func compare(configured, table bigquery.Schema, opts CompareOptions, prefix string) []Mismatch {
+	var mismatches []Mismatch
+	for _, configuredField := range configured {
+		path := prefix + configuredField.Name
+		idx := indexOfField(table, configuredField.Name)
+		if idx == -1 {
+			if !opts.AllowUnknownFields {
+				mismatches = append(mismatches, Mismatch{
+					Field:      path,
+					Kind:       MismatchUnknownField,
+					Configured: describeField(configuredField),
+				})
+			}
+			continue
+		}
+		tableField := table[idx]
+		if !typesCompatible(configuredField.Type, tableField.Type, opts.StorageWireTypes) {
+			mismatches = append(mismatches, Mismatch{
+				Field:      path,
+				Kind:       MismatchType,
+				Configured: describeField(configuredField),
+				Table:      describeField(tableField),
+			})
+			continue
+		}
+		if !modesCompatible(configuredField, tableField) {
+			mismatches = append(mismatches, Mismatch{
+				Field:      path,
+				Kind:       MismatchMode,
+				Configured: describeField(configuredField),
+				Table:      describeField(tableField),
+			})
+		}
+		if configuredField.Type == bigquery.RecordFieldType && tableField.Type == bigquery.RecordFieldType {
+			mismatches = append(mismatches, compare(configuredField.Schema, tableField.Schema, opts, path+".")...)
+		}
+	}
+	for _, tableField := range table {
+		if tableField.Required && indexOfField(configured, tableField.Name) == -1 {
+			mismatches = append(mismatches, Mismatch{
+				Field: prefix + tableField.Name,
+				Kind:  MismatchMissingRequired,
+				Table: describeField(tableField),
+			})
+		}
+	}
+	return mismatches
+}
+
+// modesCompatible returns false in case a field is repeated in one schema but not in the other,
+// or in case a NULLABLE field is written to a REQUIRED column.
+func modesCompatible(configured, table *bigquery.FieldSchema) bool {
+	if configured.Repeated != table.Repeated {
+		return false
+	}
+	return configured.Required || !table.Required
+}
+
+// storageWireTypeConversions defines for each type of a protobuf derived schema
+// to what other BigQuery types it can be converted by the Storage Write API, as documented at
+// https://cloud.google.com/bigquery/docs/write-api#data_type_conversions.
+var storageWireTypeConversions = map[bigquery.FieldType][]bigquery.FieldType{
+	bigquery.IntegerFieldType: {
+		bigquery.FloatFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType,
+		bigquery.BooleanFieldType, bigquery.DateFieldType, bigquery.TimeFieldType,
+		bigquery.DateTimeFieldType, bigquery.TimestampFieldType,
+	},
+	bigquery.FloatFieldType: {
+		bigquery.NumericFieldType, bigquery.BigNumericFieldType,
+	},
+	bigquery.StringFieldType: {
+		bigquery.NumericFieldType, bigquery.BigNumericFieldType, bigquery.DateFieldType,
+		bigquery.TimeFieldType, bigquery.DateTimeFieldType, bigquery.TimestampFieldType,
+		bigquery.GeographyFieldType,
+	},
+	bigquery.BytesFieldType: {
+		bigquery.NumericFieldType, bigquery.BigNumericFieldType,
+	},
+}
+
+func typesCompatible(configured, table bigquery.FieldType, storageWireTypes bool) bool {
+	if configured == table {
+		return true
+	}
+	if !storageWireTypes {
+		return false
+	}
+	for _, t := range storageWireTypeConversions[configured] {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// describeField describes the type and mode of a field, e.g. "REPEATED INTEGER".
+func describeField(field *bigquery.FieldSchema) string {
+	switch {
+	case field.Repeated:
+		return "REPEATED " + string(field.Type)
+	case field.Required:
+		return "REQUIRED " + string(field.Type)
+	default:
+		return "NULLABLE " + string(field.Type)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+var testCompareTableSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "created", Type: bigquery.TimestampFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "street", Type: bigquery.StringFieldType},
+		{Name: "number", Type: bigquery.IntegerFieldType, Required: true},
+	}},
+}
+
+func TestCompareCompatible(t *testing.T) {
+	test.AssertNil(t, Compare(testCompareTableSchema, testCompareTableSchema, CompareOptions{}))
+	test.AssertNil(t, Compare(bigquery.Schema{
+		{Name: "NAME", Type: bigquery.StringFieldType, Required: true},
+		{Name: "unknown", Type: bigquery.StringFieldType},
+	}, testCompareTableSchema, CompareOptions{AllowUnknownFields: true}))
+	test.AssertNil(t, Compare(bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "created", Type: bigquery.IntegerFieldType},
+	}, testCompareTableSchema, CompareOptions{StorageWireTypes: true}))
+}
+
+func TestCompareMismatches(t *testing.T) {
+	mismatches := Compare(bigquery.Schema{
+		{Name: "created", Type: bigquery.IntegerFieldType},
+		{Name: "tags", Type: bigquery.StringFieldType},
+		{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "number", Type: bigquery.IntegerFieldType},
+		}},
+		{Name: "unknown", Type: bigquery.BooleanFieldType},
+	}, testCompareTableSchema, CompareOptions{})
+	test.AssertEqual(t, []Mismatch{
+		{Field: "created", Kind: MismatchType, Configured: "NULLABLE INTEGER", Table: "NULLABLE TIMESTAMP"},
+		{Field: "tags", Kind: MismatchMode, Configured: "NULLABLE STRING", Table: "REPEATED STRING"},
+		{Field: "address.number", Kind: MismatchMode, Configured: "NULLABLE INTEGER", Table: "REQUIRED INTEGER"},
+		{Field: "unknown", Kind: MismatchUnknownField, Configured: "NULLABLE BOOLEAN"},
+		{Field: "name", Kind: MismatchMissingRequired, Table: "REQUIRED STRING"},
+	}, mismatches)
+	test.AssertEqual(t, "created: type incompatibility (configured: NULLABLE INTEGER, table: NULLABLE TIMESTAMP)", mismatches[0].String())
+	test.AssertEqual(t, "unknown: unknown column (configured: NULLABLE BOOLEAN)", mismatches[3].String())
+	test.AssertEqual(t, "name: missing required column (table: REQUIRED STRING)", mismatches[4].String())
+}
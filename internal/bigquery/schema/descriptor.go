@@ -0,0 +1,129 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schema provides utilities to derive, compare and merge BigQuery schemas,
+// as used by the different Streamer clients.
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FromMessageDescriptor derives a BigQuery schema from the given protobuf message descriptor,
+// using the type mappings as documented for the BigQuery Storage Write API at
+// https://cloud.google.com/bigquery/docs/write-api#data_type_conversions.
+//
+// Proto scalar types are mapped to their BigQuery equivalent, with int32 and int64
+// (as well as their variants) mapped to INTEGER and enums to INTEGER as well. Nested messages
+// are mapped to RECORD fields, except for the well-known google.protobuf.Timestamp message
+// which is mapped to TIMESTAMP, google.protobuf.Duration which is mapped to INTEGER (microseconds),
+// google.protobuf.Struct, Value and ListValue which are mapped to STRING (Json)
+// and the wrapper messages which are mapped to their (NULLABLE) scalar type.
+//
+// Required fields are mapped to REQUIRED fields, repeated fields to REPEATED fields
+// and all other fields are mapped to NULLABLE fields.
+func FromMessageDescriptor(md protoreflect.MessageDescriptor) (bigquery.Schema, error) {
+	return fromMessageDescriptor(md, map[protoreflect.FullName]bool{})
+}
+
+func fromMessageDescriptor(md protoreflect.MessageDescriptor, visited map[protoreflect.FullName]bool) (bigquery.Schema, error) {
+	if md == nil {
+		return nil, fmt.Errorf("schema from message descriptor: %w: nil descriptor", internal.ErrInvalidParam)
+	}
+	if visited[md.FullName()] {
+		return nil, fmt.Errorf("schema from message descriptor: %s: %w", md.FullName(), ErrRecursiveType)
+	}
+	visited[md.FullName()] = true
+	defer delete(visited, md.FullName())
+
+	fields := md.Fields()
+	schema := make(bigquery.Schema, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		fieldSchema := &bigquery.FieldSchema{
+			Name:     string(fd.Name()),
+			Repeated: fd.Cardinality() == protoreflect.Repeated,
+			Required: fd.Cardinality() == protoreflect.Required,
+		}
+		if fd.IsMap() {
+			return nil, fmt.Errorf("schema from message descriptor: field %s: %w: map fields", fd.FullName(), ErrUnsupportedType)
+		}
+		switch fd.Kind() {
+		case protoreflect.BoolKind:
+			fieldSchema.Type = bigquery.BooleanFieldType
+		case protoreflect.EnumKind,
+			protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+			protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+			protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+			protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			fieldSchema.Type = bigquery.IntegerFieldType
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			fieldSchema.Type = bigquery.FloatFieldType
+		case protoreflect.StringKind:
+			fieldSchema.Type = bigquery.StringFieldType
+		case protoreflect.BytesKind:
+			fieldSchema.Type = bigquery.BytesFieldType
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			if fieldType, ok := wellKnownFieldType(fd.Message()); ok {
+				fieldSchema.Type = fieldType
+				break
+			}
+			nested, err := fromMessageDescriptor(fd.Message(), visited)
+			if err != nil {
+				return nil, err
+			}
+			fieldSchema.Type = bigquery.RecordFieldType
+			fieldSchema.Schema = nested
+		default:
+			return nil, fmt.Errorf("schema from message descriptor: field %s: %w: kind %s", fd.FullName(), ErrUnsupportedType, fd.Kind())
+		}
+		schema = append(schema, fieldSchema)
+	}
+	return schema, nil
+}
+
+// wellKnownFieldTypes defines the BigQuery field types
+// used for the supported well-known protobuf message types.
+var wellKnownFieldTypes = map[protoreflect.FullName]bigquery.FieldType{
+	"google.protobuf.Timestamp":   bigquery.TimestampFieldType,
+	"google.protobuf.Duration":    bigquery.IntegerFieldType,
+	"google.protobuf.Struct":      bigquery.StringFieldType,
+	"google.protobuf.Value":       bigquery.StringFieldType,
+	"google.protobuf.ListValue":   bigquery.StringFieldType,
+	"google.protobuf.DoubleValue": bigquery.FloatFieldType,
+	"google.protobuf.FloatValue":  bigquery.FloatFieldType,
+	"google.protobuf.Int64Value":  bigquery.IntegerFieldType,
+	"google.protobuf.UInt64Value": bigquery.IntegerFieldType,
+	"google.protobuf.Int32Value":  bigquery.IntegerFieldType,
+	"google.protobuf.UInt32Value": bigquery.IntegerFieldType,
+	"google.protobuf.BoolValue":   bigquery.BooleanFieldType,
+	"google.protobuf.StringValue": bigquery.StringFieldType,
+	"google.protobuf.BytesValue":  bigquery.BytesFieldType,
+}
+
+// wellKnownFieldType returns the BigQuery field type for the given message descriptor,
+// in case it is one of the supported well-known types, either as-is or as a nested type
+// normalized by adapt.NormalizeDescriptor (e.g. google_protobuf_Timestamp).
+func wellKnownFieldType(md protoreflect.MessageDescriptor) (bigquery.FieldType, bool) {
+	if fieldType, ok := wellKnownFieldTypes[md.FullName()]; ok {
+		return fieldType, true
+	}
+	fieldType, ok := wellKnownFieldTypes[protoreflect.FullName(strings.ReplaceAll(string(md.Name()), "_", "."))]
+	return fieldType, ok
+}
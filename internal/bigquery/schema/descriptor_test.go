@@ -0,0 +1,96 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding/testdata"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestFromMessageDescriptorProto2(t *testing.T) {
+	md := (&testdata.SimpleMessageProto2{}).ProtoReflect().Descriptor()
+	schema, err := FromMessageDescriptor(md)
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "value", Type: bigquery.IntegerFieldType},
+	}, schema)
+}
+
+func TestFromMessageDescriptorProto3WellKnownWrapper(t *testing.T) {
+	md := (&testdata.SimpleMessageProto3{}).ProtoReflect().Descriptor()
+	schema, err := FromMessageDescriptor(md)
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "value", Type: bigquery.IntegerFieldType},
+	}, schema)
+}
+
+func TestFromMessageDescriptorWellKnownTypes(t *testing.T) {
+	schema, err := FromMessageDescriptor(testdata.WellKnownMessageDescriptor())
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "created_at", Type: bigquery.TimestampFieldType},
+		{Name: "elapsed", Type: bigquery.IntegerFieldType},
+		{Name: "count", Type: bigquery.IntegerFieldType},
+		{Name: "total", Type: bigquery.IntegerFieldType},
+		{Name: "label", Type: bigquery.StringFieldType},
+		{Name: "attributes", Type: bigquery.StringFieldType},
+		{Name: "events", Type: bigquery.TimestampFieldType, Repeated: true},
+		{Name: "nested", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "enabled", Type: bigquery.BooleanFieldType},
+			{Name: "ratio", Type: bigquery.FloatFieldType},
+		}},
+	}, schema)
+}
+
+func TestFromMessageDescriptorAdaptNormalizedWellKnownType(t *testing.T) {
+	dp, err := adapt.NormalizeDescriptor((&testdata.SimpleMessageProto3{}).ProtoReflect().Descriptor())
+	test.AssertNoErrorFatal(t, err)
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("normalized.proto"),
+		Syntax:      proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{dp},
+	}, nil)
+	test.AssertNoErrorFatal(t, err)
+	schema, err := FromMessageDescriptor(fd.Messages().Get(0))
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "value", Type: bigquery.IntegerFieldType},
+	}, schema)
+}
+
+func TestFromMessageDescriptorMapUnsupported(t *testing.T) {
+	md := (&structpb.Struct{}).ProtoReflect().Descriptor()
+	_, err := FromMessageDescriptor(md)
+	test.AssertError(t, err)
+	test.AssertIsError(t, err, ErrUnsupportedType)
+}
+
+func TestFromMessageDescriptorNil(t *testing.T) {
+	_, err := FromMessageDescriptor(nil)
+	test.AssertError(t, err)
+}
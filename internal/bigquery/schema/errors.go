@@ -0,0 +1,26 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import "errors"
+
+var (
+	// ErrUnsupportedType is returned in case a type cannot be mapped to a BigQuery field type.
+	ErrUnsupportedType = errors.New("unsupported type")
+
+	// ErrRecursiveType is returned in case a (message) type references itself,
+	// something which cannot be expressed as a BigQuery schema.
+	ErrRecursiveType = errors.New("recursive type not supported")
+)
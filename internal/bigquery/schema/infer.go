@@ -0,0 +1,192 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+)
+
+// InferFromRow infers a BigQuery schema from a single row of data,
+// for the purpose of detecting the fields it defines which might not yet
+// be defined in the table schema. All inferred fields are NULLABLE (or REPEATED).
+//
+// The following values are supported:
+//   - bigquery.ValueSaver, map[string]bigquery.Value and map[string]interface{},
+//     for which the schema is inferred from the (Go) types of its values;
+//   - []byte, expected to be a Json encoded message, and json.Marshaler,
+//     for which the schema is inferred from the decoded Json values;
+//   - a struct or pointer to a struct, for which the schema is inferred
+//     from its type using FromStructType;
+//
+// Values of which the type cannot be inferred (e.g. nil values or empty lists) are skipped.
+func InferFromRow(row interface{}) (bigquery.Schema, error) {
+	switch typedRow := row.(type) {
+	case bigquery.ValueSaver:
+		values, _, err := typedRow.Save()
+		if err != nil {
+			return nil, fmt.Errorf("infer schema from ValueSaver: save row: %w", err)
+		}
+		return inferFromValues(values)
+	case map[string]bigquery.Value:
+		return inferFromValues(typedRow)
+	case map[string]interface{}:
+		return inferFromInterfaceMap(typedRow)
+	case []byte:
+		return inferFromJSON(typedRow)
+	case json.Marshaler:
+		b, err := typedRow.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("infer schema from json.Marshaler: marshal row: %w", err)
+		}
+		return inferFromJSON(b)
+	}
+	rowType := reflect.TypeOf(row)
+	if rowType != nil && rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	if rowType == nil || rowType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("infer schema from row: %w: %T", ErrUnsupportedType, row)
+	}
+	inferred, err := FromStructType(rowType)
+	if err != nil {
+		return nil, fmt.Errorf("infer schema from struct row (%T): %w", row, err)
+	}
+	return Relax(inferred), nil
+}
+
+func inferFromJSON(b []byte) (bigquery.Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("infer schema from json row: decode: %w", err)
+	}
+	return inferFromInterfaceMap(values)
+}
+
+func inferFromValues(values map[string]bigquery.Value) (bigquery.Schema, error) {
+	m := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		m[key] = value
+	}
+	return inferFromInterfaceMap(m)
+}
+
+func inferFromInterfaceMap(values map[string]interface{}) (bigquery.Schema, error) {
+	// sort the keys, such that the inferred schema is deterministic
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	schema := make(bigquery.Schema, 0, len(keys))
+	for _, key := range keys {
+		field, err := inferField(key, values[key])
+		if err != nil {
+			return nil, err
+		}
+		if field != nil {
+			schema = append(schema, field)
+		}
+	}
+	return schema, nil
+}
+
+// inferField infers the schema of a single field,
+// returning nil in case it cannot be inferred (e.g. for a nil value).
+func inferField(name string, value interface{}) (*bigquery.FieldSchema, error) {
+	field := &bigquery.FieldSchema{Name: name}
+	switch typedValue := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		field.Type = bigquery.StringFieldType
+	case bool:
+		field.Type = bigquery.BooleanFieldType
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		field.Type = bigquery.IntegerFieldType
+	case float32, float64:
+		field.Type = bigquery.FloatFieldType
+	case json.Number:
+		if _, err := typedValue.Int64(); err == nil {
+			field.Type = bigquery.IntegerFieldType
+		} else {
+			field.Type = bigquery.FloatFieldType
+		}
+	case []byte:
+		field.Type = bigquery.BytesFieldType
+	case time.Time:
+		field.Type = bigquery.TimestampFieldType
+	case civil.Date:
+		field.Type = bigquery.DateFieldType
+	case civil.Time:
+		field.Type = bigquery.TimeFieldType
+	case civil.DateTime:
+		field.Type = bigquery.DateTimeFieldType
+	case *big.Rat:
+		field.Type = bigquery.NumericFieldType
+	case map[string]interface{}:
+		nested, err := inferFromInterfaceMap(typedValue)
+		if err != nil {
+			return nil, err
+		}
+		if len(nested) == 0 {
+			return nil, nil
+		}
+		field.Type = bigquery.RecordFieldType
+		field.Schema = nested
+	case map[string]bigquery.Value:
+		nested, err := inferFromValues(typedValue)
+		if err != nil {
+			return nil, err
+		}
+		if len(nested) == 0 {
+			return nil, nil
+		}
+		field.Type = bigquery.RecordFieldType
+		field.Schema = nested
+	default:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, fmt.Errorf("infer schema of field %q: %w: %T", name, ErrUnsupportedType, value)
+		}
+		// infer the type from the first element of which the type can be inferred
+		for i := 0; i < rv.Len(); i++ {
+			elem, err := inferField(name, rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			if elem == nil {
+				continue
+			}
+			if elem.Repeated {
+				return nil, fmt.Errorf("infer schema of field %q: %w: nested lists", name, ErrUnsupportedType)
+			}
+			elem.Repeated = true
+			return elem, nil
+		}
+		return nil, nil
+	}
+	return field, nil
+}
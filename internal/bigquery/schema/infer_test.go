@@ -0,0 +1,109 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+type testInferRow struct {
+	Name string
+	Age  int64
+}
+
+type testInferValueSaver map[string]bigquery.Value
+
+func (vs testInferValueSaver) Save() (map[string]bigquery.Value, string, error) {
+	return vs, "", nil
+}
+
+func TestInferFromRowStruct(t *testing.T) {
+	expected := bigquery.Schema{
+		{Name: "Name", Type: bigquery.StringFieldType},
+		{Name: "Age", Type: bigquery.IntegerFieldType},
+	}
+	for _, row := range []interface{}{testInferRow{}, &testInferRow{}} {
+		schema, err := InferFromRow(row)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, expected, schema)
+	}
+}
+
+func TestInferFromRowMaps(t *testing.T) {
+	expected := bigquery.Schema{
+		{Name: "amount", Type: bigquery.NumericFieldType},
+		{Name: "created", Type: bigquery.TimestampFieldType},
+		{Name: "day", Type: bigquery.DateFieldType},
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "nested", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "ok", Type: bigquery.BooleanFieldType},
+		}},
+		{Name: "scores", Type: bigquery.FloatFieldType, Repeated: true},
+	}
+	values := map[string]interface{}{
+		"amount":  big.NewRat(1, 2),
+		"created": time.Now(),
+		"day":     civil.DateOf(time.Now()),
+		"name":    "foo",
+		"nested":  map[string]interface{}{"ok": true},
+		"scores":  []float64{1, 2},
+		"unknown": nil,
+		"empty":   []string{},
+	}
+	bqValues := make(map[string]bigquery.Value, len(values))
+	for key, value := range values {
+		bqValues[key] = value
+	}
+	for _, row := range []interface{}{values, bqValues, testInferValueSaver(bqValues)} {
+		schema, err := InferFromRow(row)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, expected, schema)
+	}
+}
+
+func TestInferFromRowJSON(t *testing.T) {
+	schema, err := InferFromRow([]byte(`{"name": "foo", "age": 42, "score": 0.5, "tags": ["a"], "nested": {"ok": true}, "null": null}`))
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "age", Type: bigquery.IntegerFieldType},
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "nested", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "ok", Type: bigquery.BooleanFieldType},
+		}},
+		{Name: "score", Type: bigquery.FloatFieldType},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	}, schema)
+}
+
+func TestInferFromRowErrors(t *testing.T) {
+	testCases := []interface{}{
+		nil,
+		"foo",
+		42,
+		[]byte("not json"),
+		map[string]interface{}{"ch": make(chan int)},
+		map[string]interface{}{"matrix": [][]int{{1}}},
+	}
+	for _, row := range testCases {
+		_, err := InferFromRow(row)
+		test.AssertError(t, err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// Merge returns the current schema, extended with all fields of the other schema
+// which are not yet defined in the current schema. Fields of nested records
+// defined in both schemas are merged recursively.
+//
+// Added fields are always NULLABLE (or REPEATED), nested fields included,
+// as BigQuery doesn't allow REQUIRED columns to be added to an existing table.
+// The type and mode of existing fields are never changed, fields are matched case-insensitive,
+// the same way BigQuery matches its column names.
+//
+// The returned boolean indicates whether or not any field was added, the current schema is returned as-is if not.
+func Merge(current, other bigquery.Schema) (bigquery.Schema, bool) {
+	var merged bigquery.Schema
+	changed := false
+	for _, otherField := range other {
+		idx := indexOfField(current, otherField.Name)
+		if idx == -1 {
+			if !changed {
+				merged = copySchema(current)
+				changed = true
+			}
+			merged = append(merged, relaxedField(otherField))
+			continue
+		}
+		currentField := current[idx]
+		if currentField.Type != bigquery.RecordFieldType || otherField.Type != bigquery.RecordFieldType {
+			continue
+		}
+		nested, nestedChanged := Merge(currentField.Schema, otherField.Schema)
+		if !nestedChanged {
+			continue
+		}
+		if !changed {
+			merged = copySchema(current)
+			changed = true
+		}
+		fieldCopy := *currentField
+		fieldCopy.Schema = nested
+		merged[idx] = &fieldCopy
+	}
+	if !changed {
+		return current, false
+	}
+	return merged, true
+}
+
+// Additions returns the fields (nested fields included) of the other schema
+// which are not yet defined in the current schema, each identified by its (dot-separated) path.
+func Additions(current, other bigquery.Schema) []string {
+	return additions(current, other, "")
+}
+
+func additions(current, other bigquery.Schema, prefix string) []string {
+	var paths []string
+	for _, otherField := range other {
+		idx := indexOfField(current, otherField.Name)
+		if idx == -1 {
+			paths = append(paths, prefix+otherField.Name)
+			continue
+		}
+		if current[idx].Type == bigquery.RecordFieldType && otherField.Type == bigquery.RecordFieldType {
+			paths = append(paths, additions(current[idx].Schema, otherField.Schema, prefix+otherField.Name+".")...)
+		}
+	}
+	return paths
+}
+
+// Relax returns a copy of the given schema with all its REQUIRED fields,
+// nested fields included, turned into NULLABLE fields.
+func Relax(schema bigquery.Schema) bigquery.Schema {
+	if schema == nil {
+		return nil
+	}
+	relaxed := make(bigquery.Schema, 0, len(schema))
+	for _, field := range schema {
+		relaxed = append(relaxed, relaxedField(field))
+	}
+	return relaxed
+}
+
+func relaxedField(field *bigquery.FieldSchema) *bigquery.FieldSchema {
+	fieldCopy := *field
+	fieldCopy.Required = false
+	fieldCopy.Schema = Relax(field.Schema)
+	return &fieldCopy
+}
+
+func indexOfField(schema bigquery.Schema, name string) int {
+	for idx, field := range schema {
+		if strings.EqualFold(field.Name, name) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func copySchema(schema bigquery.Schema) bigquery.Schema {
+	schemaCopy := make(bigquery.Schema, len(schema))
+	copy(schemaCopy, schema)
+	return schemaCopy
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+func TestMerge(t *testing.T) {
+	current := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "street", Type: bigquery.StringFieldType},
+		}},
+	}
+	other := bigquery.Schema{
+		{Name: "NAME", Type: bigquery.IntegerFieldType},
+		{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "street", Type: bigquery.StringFieldType},
+			{Name: "city", Type: bigquery.StringFieldType, Required: true},
+		}},
+		{Name: "age", Type: bigquery.IntegerFieldType, Required: true},
+	}
+	merged, changed := Merge(current, other)
+	test.AssertTrue(t, changed)
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "street", Type: bigquery.StringFieldType},
+			{Name: "city", Type: bigquery.StringFieldType},
+		}},
+		{Name: "age", Type: bigquery.IntegerFieldType},
+	}, merged)
+	// the current schema is never modified
+	test.AssertEqual(t, 1, len(current[1].Schema))
+	test.AssertEqual(t, []string{"address.city", "age"}, Additions(current, other))
+}
+
+func TestMergeNop(t *testing.T) {
+	current := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	}
+	merged, changed := Merge(current, bigquery.Schema{
+		{Name: "Name", Type: bigquery.StringFieldType},
+	})
+	test.AssertFalse(t, changed)
+	test.AssertEqual(t, current, merged)
+	test.AssertEqual(t, 0, len(Additions(current, merged)))
+}
+
+func TestRelax(t *testing.T) {
+	test.AssertNil(t, Relax(nil))
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "street", Type: bigquery.StringFieldType},
+		}},
+	}, Relax(bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "address", Type: bigquery.RecordFieldType, Required: true, Schema: bigquery.Schema{
+			{Name: "street", Type: bigquery.StringFieldType, Required: true},
+		}},
+	}))
+}
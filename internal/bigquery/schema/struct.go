@@ -0,0 +1,273 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal"
+)
+
+// structTagKey is the key of the struct tag used to define the name
+// and options of a field, the same one as used by the BigQuery Go client.
+const structTagKey = "bigquery"
+
+// nullableTagOption marks a (non-pointer) field as NULLABLE.
+const nullableTagOption = "nullable"
+
+var (
+	typeOfByteSlice = reflect.TypeOf([]byte(nil))
+	typeOfTime      = reflect.TypeOf(time.Time{})
+	typeOfRat       = reflect.TypeOf((*big.Rat)(nil))
+
+	// knownFieldTypes maps the types which are not mapped
+	// by their kind to their BigQuery type, including the nullable (bigquery.NullX) types,
+	// with the latter always being mapped as NULLABLE fields.
+	knownFieldTypes = map[reflect.Type]struct {
+		fieldType bigquery.FieldType
+		nullable  bool
+	}{
+		typeOfByteSlice:                          {bigquery.BytesFieldType, false},
+		typeOfTime:                               {bigquery.TimestampFieldType, false},
+		typeOfRat:                                {bigquery.NumericFieldType, true},
+		reflect.TypeOf(civil.Date{}):             {bigquery.DateFieldType, false},
+		reflect.TypeOf(civil.Time{}):             {bigquery.TimeFieldType, false},
+		reflect.TypeOf(civil.DateTime{}):         {bigquery.DateTimeFieldType, false},
+		reflect.TypeOf(bigquery.NullInt64{}):     {bigquery.IntegerFieldType, true},
+		reflect.TypeOf(bigquery.NullFloat64{}):   {bigquery.FloatFieldType, true},
+		reflect.TypeOf(bigquery.NullBool{}):      {bigquery.BooleanFieldType, true},
+		reflect.TypeOf(bigquery.NullString{}):    {bigquery.StringFieldType, true},
+		reflect.TypeOf(bigquery.NullGeography{}): {bigquery.GeographyFieldType, true},
+		reflect.TypeOf(bigquery.NullTimestamp{}): {bigquery.TimestampFieldType, true},
+		reflect.TypeOf(bigquery.NullDate{}):      {bigquery.DateFieldType, true},
+		reflect.TypeOf(bigquery.NullTime{}):      {bigquery.TimeFieldType, true},
+		reflect.TypeOf(bigquery.NullDateTime{}):  {bigquery.DateTimeFieldType, true},
+	}
+)
+
+// FromStructType derives a BigQuery schema from the given struct (pointer) type,
+// in a similar way as bigquery.InferSchema, but with pointer fields supported as NULLABLE fields.
+//
+// Fields are named after their Go field name, unless a name is defined using the `bigquery:"name"` tag.
+// Fields tagged with `bigquery:"-"` and unexported fields are skipped, and the fields of
+// embedded (anonymous) structs are promoted, unless given a name using a tag.
+//
+// Fields are REQUIRED, unless they are a pointer, a bigquery.NullX type, or tagged with the
+// `bigquery:",nullable"` option, in which case they are NULLABLE. Slices and arrays (except for []byte) are
+// REPEATED fields. Nested structs are mapped to RECORD fields, time.Time to TIMESTAMP, civil.Date, civil.Time
+// and civil.DateTime to DATE, TIME and DATETIME and *big.Rat to NUMERIC.
+func FromStructType(rt reflect.Type) (bigquery.Schema, error) {
+	if rt == nil {
+		return nil, fmt.Errorf("schema from struct type: %w: nil type", internal.ErrInvalidParam)
+	}
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("schema from struct type: %w: %v is not a struct", ErrUnsupportedType, rt)
+	}
+	return fromStructType(rt, map[reflect.Type]bool{})
+}
+
+func fromStructType(rt reflect.Type, visited map[reflect.Type]bool) (bigquery.Schema, error) {
+	if visited[rt] {
+		return nil, fmt.Errorf("schema from struct type: %v: %w", rt, ErrRecursiveType)
+	}
+	visited[rt] = true
+	defer delete(visited, rt)
+
+	fields, err := StructFields(rt)
+	if err != nil {
+		return nil, err
+	}
+	schema := make(bigquery.Schema, 0, len(fields))
+	for _, sf := range fields {
+		field, err := fromFieldType(sf.Name, sf.Type, visited)
+		if err != nil {
+			return nil, err
+		}
+		if sf.Nullable && !field.Repeated {
+			field.Required = false
+		}
+		schema = append(schema, field)
+	}
+	return schema, nil
+}
+
+// StructField is an exported field of a struct type,
+// as it maps to a BigQuery field.
+type StructField struct {
+	// Name is the name of the BigQuery field,
+	// the name defined using the `bigquery:"name"` tag or else the Go field name.
+	Name string
+	// Index is the index sequence of the field, as used by reflect.Value.FieldByIndex,
+	// containing more than one index in case it is a field promoted from an embedded struct.
+	Index []int
+	// Type is the Go type of the field.
+	Type reflect.Type
+	// Nullable is true in case the field is tagged with the `bigquery:",nullable"` option.
+	Nullable bool
+}
+
+// StructFields returns the exported fields of the given struct type, in the order they are defined,
+// skipping fields tagged with `bigquery:"-"` and promoting the fields of embedded (anonymous) structs,
+// unless given a name using a tag. Promoted fields are shadowed by the fields of the outer struct.
+func StructFields(rt reflect.Type) ([]StructField, error) {
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("struct fields: %w: %v is not a struct", ErrUnsupportedType, rt)
+	}
+	return structFields(rt, nil, map[reflect.Type]bool{})
+}
+
+func structFields(rt reflect.Type, index []int, visited map[reflect.Type]bool) ([]StructField, error) {
+	if visited[rt] {
+		return nil, fmt.Errorf("struct fields: %v: %w", rt, ErrRecursiveType)
+	}
+	visited[rt] = true
+	defer delete(visited, rt)
+
+	var fields, promoted []StructField
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		name, options, skip := parseStructTag(sf)
+		if skip {
+			continue
+		}
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+		if sf.Anonymous && name == "" {
+			embeddedType := sf.Type
+			if embeddedType.Kind() == reflect.Ptr {
+				embeddedType = embeddedType.Elem()
+			}
+			if embeddedType.Kind() == reflect.Struct {
+				embedded, err := structFields(embeddedType, fieldIndex, visited)
+				if err != nil {
+					return nil, err
+				}
+				promoted = append(promoted, embedded...)
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, StructField{
+			Name:     name,
+			Index:    fieldIndex,
+			Type:     sf.Type,
+			Nullable: options[nullableTagOption],
+		})
+	}
+	for _, field := range promoted {
+		if indexOfStructField(fields, field.Name) == -1 {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+func indexOfStructField(fields []StructField, name string) int {
+	for idx, field := range fields {
+		if strings.EqualFold(field.Name, name) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// parseStructTag parses the bigquery struct tag of the given field,
+// returning its (optional) name and options, or true in case the field is to be skipped.
+func parseStructTag(sf reflect.StructField) (name string, options map[string]bool, skip bool) {
+	tag, ok := sf.Tag.Lookup(structTagKey)
+	if !ok {
+		return "", nil, false
+	}
+	if tag == "-" {
+		return "", nil, true
+	}
+	parts := strings.Split(tag, ",")
+	options = make(map[string]bool, len(parts)-1)
+	for _, option := range parts[1:] {
+		options[strings.TrimSpace(option)] = true
+	}
+	return parts[0], options, false
+}
+
+func fromFieldType(name string, rt reflect.Type, visited map[reflect.Type]bool) (*bigquery.FieldSchema, error) {
+	if known, ok := knownFieldTypes[rt]; ok {
+		return &bigquery.FieldSchema{Name: name, Type: known.fieldType, Required: !known.nullable}, nil
+	}
+	switch rt.Kind() {
+	case reflect.Ptr:
+		elemType := rt.Elem()
+		if elemType.Kind() == reflect.Ptr || elemType.Kind() == reflect.Slice || elemType.Kind() == reflect.Array {
+			return nil, fmt.Errorf("schema of field %q: %w: %v", name, ErrUnsupportedType, rt)
+		}
+		field, err := fromFieldType(name, elemType, visited)
+		if err != nil {
+			return nil, err
+		}
+		field.Required = false
+		return field, nil
+	case reflect.Slice, reflect.Array:
+		elemType := rt.Elem()
+		if elemType.Kind() == reflect.Ptr && elemType != typeOfRat {
+			return nil, fmt.Errorf("schema of field %q: %w: %v: repeated nullable values", name, ErrUnsupportedType, rt)
+		}
+		field, err := fromFieldType(name, elemType, visited)
+		if err != nil {
+			return nil, err
+		}
+		if field.Repeated {
+			return nil, fmt.Errorf("schema of field %q: %w: %v: nested lists", name, ErrUnsupportedType, rt)
+		}
+		if known, ok := knownFieldTypes[elemType]; ok && known.nullable && elemType != typeOfRat {
+			return nil, fmt.Errorf("schema of field %q: %w: %v: repeated nullable values", name, ErrUnsupportedType, rt)
+		}
+		field.Required = false
+		field.Repeated = true
+		return field, nil
+	case reflect.Struct:
+		nested, err := fromStructType(rt, visited)
+		if err != nil {
+			return nil, fmt.Errorf("schema of field %q: %w", name, err)
+		}
+		return &bigquery.FieldSchema{Name: name, Type: bigquery.RecordFieldType, Required: true, Schema: nested}, nil
+	case reflect.String:
+		return &bigquery.FieldSchema{Name: name, Type: bigquery.StringFieldType, Required: true}, nil
+	case reflect.Bool:
+		return &bigquery.FieldSchema{Name: name, Type: bigquery.BooleanFieldType, Required: true}, nil
+	case reflect.Float32, reflect.Float64:
+		return &bigquery.FieldSchema{Name: name, Type: bigquery.FloatFieldType, Required: true}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &bigquery.FieldSchema{Name: name, Type: bigquery.IntegerFieldType, Required: true}, nil
+	default:
+		// uint64 (and uint) values can overflow an INTEGER,
+		// which is why these are not supported, same as the BigQuery Go client
+		return nil, fmt.Errorf("schema of field %q: %w: %v", name, ErrUnsupportedType, rt)
+	}
+}
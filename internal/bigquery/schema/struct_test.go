@@ -0,0 +1,116 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+type testStructBase struct {
+	ID      int64
+	Created time.Time
+}
+
+type testStructAddress struct {
+	Street string
+	Number *int32
+}
+
+type testStructRow struct {
+	testStructBase
+	Name     string  `bigquery:"name"`
+	Nickname *string `bigquery:"nickname"`
+	Score    float64 `bigquery:",nullable"`
+	Tags     []string
+	Data     []byte
+	Amount   *big.Rat
+	Day      civil.Date
+	At       *civil.Time
+	When     civil.DateTime
+	Count    bigquery.NullInt64
+	Address  testStructAddress
+	Previous *testStructAddress
+	History  []testStructAddress
+	Ignored  string `bigquery:"-"`
+	internal string
+}
+
+func TestFromStructType(t *testing.T) {
+	expected := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "nickname", Type: bigquery.StringFieldType},
+		{Name: "Score", Type: bigquery.FloatFieldType},
+		{Name: "Tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "Data", Type: bigquery.BytesFieldType, Required: true},
+		{Name: "Amount", Type: bigquery.NumericFieldType},
+		{Name: "Day", Type: bigquery.DateFieldType, Required: true},
+		{Name: "At", Type: bigquery.TimeFieldType},
+		{Name: "When", Type: bigquery.DateTimeFieldType, Required: true},
+		{Name: "Count", Type: bigquery.IntegerFieldType},
+		{Name: "Address", Type: bigquery.RecordFieldType, Required: true, Schema: bigquery.Schema{
+			{Name: "Street", Type: bigquery.StringFieldType, Required: true},
+			{Name: "Number", Type: bigquery.IntegerFieldType},
+		}},
+		{Name: "Previous", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "Street", Type: bigquery.StringFieldType, Required: true},
+			{Name: "Number", Type: bigquery.IntegerFieldType},
+		}},
+		{Name: "History", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "Street", Type: bigquery.StringFieldType, Required: true},
+			{Name: "Number", Type: bigquery.IntegerFieldType},
+		}},
+		{Name: "ID", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "Created", Type: bigquery.TimestampFieldType, Required: true},
+	}
+	for _, rt := range []reflect.Type{reflect.TypeOf(testStructRow{}), reflect.TypeOf(&testStructRow{})} {
+		schema, err := FromStructType(rt)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, expected, schema)
+	}
+}
+
+type testStructRecursive struct {
+	Next *testStructRecursive
+}
+
+func TestFromStructTypeErrors(t *testing.T) {
+	_, err := FromStructType(nil)
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+
+	testCases := []struct {
+		Type          reflect.Type
+		ExpectedError error
+	}{
+		{reflect.TypeOf(42), ErrUnsupportedType},
+		{reflect.TypeOf(struct{ Value uint64 }{}), ErrUnsupportedType},
+		{reflect.TypeOf(struct{ Values [][]int }{}), ErrUnsupportedType},
+		{reflect.TypeOf(struct{ Values []*int }{}), ErrUnsupportedType},
+		{reflect.TypeOf(struct{ Values []bigquery.NullString }{}), ErrUnsupportedType},
+		{reflect.TypeOf(struct{ Value map[string]int }{}), ErrUnsupportedType},
+		{reflect.TypeOf(testStructRecursive{}), ErrRecursiveType},
+	}
+	for _, testCase := range testCases {
+		_, err := FromStructType(testCase.Type)
+		test.AssertIsError(t, err, testCase.ExpectedError)
+	}
+}
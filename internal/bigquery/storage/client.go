@@ -19,12 +19,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"sync"
+	"sync/atomic"
 
 	"github.com/OTA-Insight/bqwriter/internal"
+	bqbase "github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
 	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/table"
 	"github.com/OTA-Insight/bqwriter/log"
 
+	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/bigquery/storage/managedwriter"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -43,22 +49,65 @@ import (
 // the workers will also batch its received rows rather than writing them one by one,
 // this can be disabled by setting the batchSize value to the value of 1.
 type Client struct {
-	client *managedwriter.Client
-	stream *managedwriter.ManagedStream
+	client      *managedwriter.Client
+	closeClient func() error
+	stream      *managedwriter.ManagedStream
+
+	// streams replaced by a stream with an evolved schema,
+	// kept open until the client is closed as to not cancel their pending appends
+	retiredStreams []*managedwriter.ManagedStream
+	// the stream and result of the last append,
+	// used to preserve the order of the rows when the stream is replaced
+	lastStream *managedwriter.ManagedStream
+	lastResult *managedwriter.AppendResult
+
+	writerOpts []managedwriter.WriterOption
 
 	encoder encoding.Encoder
 
+	// evolver is used to add unknown fields as columns to the table schema,
+	// nil in case schema evolution is disabled
+	evolver schemaEvolver
+	// evolverClient is the BigQuery client used by the evolver,
+	// nil in case schema evolution is disabled
+	evolverClient      *bigquery.Client
+	closeEvolverClient func() error
+	// checkedRowTypes contains the struct types which have been checked already
+	// for fields unknown to the schema of the (schema) encoder
+	checkedRowTypes map[reflect.Type]struct{}
+
 	ctx context.Context
 
 	wg             sync.WaitGroup
 	appendResultCh chan *managedwriter.AppendResult
 
+	// quotaExceeded holds the (optional) func(error) called for quota errors of append results
+	quotaExceeded atomic.Value
+
+	// throttler is waited on prior to each append, nil in case the client is not throttled
+	throttler bqbase.Throttler
+
 	logger log.Logger
 }
 
+// schemaEvolver defines the API we expect in order to evolve the table schema.
+type schemaEvolver interface {
+	// AddColumns adds the fields of the given schema which are not yet defined
+	// in the table schema as NULLABLE columns, returning the (updated) table schema.
+	AddColumns(ctx context.Context, columns bigquery.Schema) (bigquery.Schema, error)
+}
+
 // NewClient creates a new BQ Storage Client.
 // See the documentation of Client for more information how to use it.
-func NewClient(projectID, dataSetID, tableID string, encoder encoding.Encoder, dp *descriptorpb.DescriptorProto, logger log.Logger) (*Client, error) {
+//
+// Schema evolution can only be enabled in case the encoder is a SchemaBasedEncoder. When enabled, the fields
+// of rows which cannot be encoded due to fields unknown to the encoder's schema are added as NULLABLE
+// columns to the table schema, after which the encoder and stream are rebuilt using the updated schema
+// and the rows are encoded once again.
+//
+// In case a pool is defined, the clients of the pool are used (and released once closed),
+// rather than creating new clients.
+func NewClient(projectID, dataSetID, tableID string, encoder encoding.Encoder, dp *descriptorpb.DescriptorProto, evolveSchema bool, pool bqbase.ClientPool, logger log.Logger) (*Client, error) {
 	if projectID == "" {
 		return nil, fmt.Errorf("bq storage client creation: validate projectID: %w: missing", internal.ErrInvalidParam)
 	}
@@ -74,6 +123,9 @@ func NewClient(projectID, dataSetID, tableID string, encoder encoding.Encoder, d
 	if dp == nil {
 		return nil, fmt.Errorf("bq storage client creation: validate dp (DescriptorProto): %w: missing", internal.ErrInvalidParam)
 	}
+	if _, ok := encoder.(encoding.SchemaBasedEncoder); evolveSchema && !ok {
+		return nil, fmt.Errorf("bq storage client creation: validate encoder: %w: schema evolution requires a schema-based encoder", internal.ErrInvalidParam)
+	}
 
 	// NOTE: we are using the background Context,
 	// as to ensure that we can always write to the client,
@@ -81,7 +133,7 @@ func NewClient(projectID, dataSetID, tableID string, encoder encoding.Encoder, d
 	// This is a requirement given the streamer will batch its rows.
 	ctx := context.Background()
 
-	writer, err := managedwriter.NewClient(ctx, projectID)
+	writer, closeWriter, err := bqbase.NewWriterClient(ctx, pool, projectID)
 	if err != nil {
 		return nil, fmt.Errorf("BQ Storage Client creation: create managed writer: %w", err)
 	}
@@ -93,12 +145,9 @@ func NewClient(projectID, dataSetID, tableID string, encoder encoding.Encoder, d
 		managedwriter.WithDataOrigin("OTA-Insight/bqwriter"),
 		managedwriter.WithType(managedwriter.DefaultStream),
 	}
-	if dp != nil {
-		writerOpts = append(writerOpts, managedwriter.WithSchemaDescriptor(dp))
-	}
-	stream, err := writer.NewManagedStream(ctx, writerOpts...)
+	stream, err := writer.NewManagedStream(ctx, append(writerOpts, managedwriter.WithSchemaDescriptor(dp))...)
 	if err != nil {
-		if err := writer.Close(); err != nil {
+		if err := closeWriter(); err != nil {
 			logger.Errorf("failed to close BQ Storage client that failed to create stream: %v", err)
 		}
 		return nil, fmt.Errorf("BQ Storage Client creation: create managed writer: %w", err)
@@ -107,13 +156,29 @@ func NewClient(projectID, dataSetID, tableID string, encoder encoding.Encoder, d
 	// create storage client
 	client := &Client{
 		client:         writer,
+		closeClient:    closeWriter,
 		stream:         stream,
+		writerOpts:     writerOpts,
 		encoder:        encoder,
 		ctx:            ctx,
 		appendResultCh: make(chan *managedwriter.AppendResult, 1),
 		logger:         logger,
 	}
 
+	if evolveSchema {
+		client.evolverClient, client.closeEvolverClient, err = bqbase.NewBigQueryClient(ctx, pool, projectID)
+		if err != nil {
+			client.closeStreamAndWriter()
+			return nil, fmt.Errorf("BQ Storage Client creation: create BQ client for schema evolution: %w", err)
+		}
+		client.evolver, err = table.NewManager(client.evolverClient, dataSetID, tableID, logger)
+		if err != nil {
+			client.closeStreamAndWriter()
+			return nil, fmt.Errorf("BQ Storage Client creation: create table manager: %w", err)
+		}
+		client.checkedRowTypes = make(map[reflect.Type]struct{})
+	}
+
 	// spawn a worker goroutine,
 	// in order to check the append results in the background
 	client.wg.Add(1)
@@ -128,23 +193,201 @@ func NewClient(projectID, dataSetID, tableID string, encoder encoding.Encoder, d
 
 // Put implements bigquery.Client::Put
 func (bqc *Client) Put(data interface{}) (bool, error) {
-	binaryData, err := bqc.encoder.EncodeRows(data)
+	binaryData, err := bqc.encodeRows(data)
 	if err != nil {
 		return false, fmt.Errorf("BQ Storage Client: Put Data: encode data: %w", err)
 	}
 
+	if err := bqc.appendRows(bqc.stream, binaryData); err != nil {
+		return false, fmt.Errorf("BQ Storage Client: %w", err)
+	}
+	// we flush every time we write data,
+	// as the default stream commits immediately
+	return true, nil
+}
+
+// maxAppendRowsBytes defines the maximum size of the encoded rows appended at once by PutBatch,
+// staying well within the request size limit (10MB) of the Storage Write API.
+const maxAppendRowsBytes = 8 * 1024 * 1024
+
+// PutBatch implements bigquery.Client::PutBatch
+//
+// All rows are encoded first, after which they are appended as a single append,
+// or multiple appends in case the encoded rows exceed maxAppendRowsBytes.
+// Rows which could not be encoded are rejected, without affecting the other rows.
+func (bqc *Client) PutBatch(rows []interface{}) (bool, error) {
+	var (
+		rowErrs     bqbase.RowErrors
+		appendErr   error
+		appended    bool
+		pending     [][]byte
+		pendingSize int
+		stream      = bqc.stream
+	)
+	appendPending := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := bqc.appendRows(stream, pending); err != nil {
+			if appendErr == nil {
+				appendErr = err
+			}
+		} else {
+			appended = true
+		}
+		pending, pendingSize = nil, 0
+	}
+	for i, row := range rows {
+		binaryData, err := bqc.encodeRows(row)
+		if err != nil {
+			rowErrs = append(rowErrs, bqbase.RowError{Index: i, Err: err})
+			continue
+		}
+		if bqc.stream != stream {
+			// the schema evolved while encoding this row, so the rows encoded prior to it
+			// are appended to the (now retired) stream using the schema they were encoded for
+			appendPending()
+			stream = bqc.stream
+		}
+		for _, b := range binaryData {
+			if pendingSize+len(b) > maxAppendRowsBytes {
+				appendPending()
+			}
+			pending = append(pending, b)
+			pendingSize += len(b)
+		}
+	}
+	appendPending()
+
+	switch {
+	case appendErr != nil && len(rowErrs) > 0:
+		return appended, fmt.Errorf("BQ Storage Client: PutBatch: %w (encode rows: %v)", appendErr, rowErrs)
+	case appendErr != nil:
+		return appended, fmt.Errorf("BQ Storage Client: PutBatch: %w", appendErr)
+	case len(rowErrs) > 0:
+		return appended, fmt.Errorf("BQ Storage Client: PutBatch: encode rows: %w", rowErrs)
+	default:
+		return appended, nil
+	}
+}
+
+// appendRows appends the encoded rows to the given stream,
+// with the append result being checked asynchronously.
+func (bqc *Client) appendRows(stream *managedwriter.ManagedStream, binaryData [][]byte) error {
 	// NOTE: we do not define an offset here,
 	// as it would only be useful in case we want to do
 	// diagnostics with them. Once we would support CommittedStream than
 	// we do want to use the offset for tracking purposes.
-	result, err := bqc.stream.AppendRows(bqc.ctx, binaryData, managedwriter.NoStreamOffset)
+	//
+	// Appends (including their retries) are ordered within a single stream, but not across streams,
+	// hence the last append to a retired stream is awaited prior to appending to its replacement.
+	if bqc.lastResult != nil && bqc.lastStream != stream {
+		<-bqc.lastResult.Ready()
+	}
+	if bqc.throttler != nil {
+		size := 0
+		for _, b := range binaryData {
+			size += len(b)
+		}
+		bqc.throttler.Throttle(len(binaryData), size)
+	}
+	result, err := stream.AppendRows(bqc.ctx, binaryData, managedwriter.NoStreamOffset)
 	if err != nil && !errors.Is(err, io.EOF) {
-		return false, fmt.Errorf("BQ Storage Client: Stream: AppendRows: %w", err)
+		return fmt.Errorf("Stream: AppendRows: %w", err)
 	}
+	bqc.lastStream, bqc.lastResult = stream, result
 	bqc.appendResultCh <- result
-	// we flush every time we write data,
-	// as the default stream commits immediately
-	return true, nil
+	return nil
+}
+
+// encodeRows encodes the data using the client's encoder,
+// evolving the table schema first if required and enabled.
+func (bqc *Client) encodeRows(data interface{}) ([][]byte, error) {
+	if bqc.evolver == nil {
+		return bqc.encoder.EncodeRows(data)
+	}
+	// struct fields unknown to the schema are ignored by the schema encoder,
+	// hence we check each struct type once against the schema prior to encoding
+	if err := bqc.checkRowType(data); err != nil {
+		return nil, err
+	}
+	rows, err := bqc.encoder.EncodeRows(data)
+	if err == nil || !errors.Is(err, encoding.ErrUnknownField) {
+		return rows, err
+	}
+	columns, inferErr := schema.InferFromRow(data)
+	if inferErr != nil {
+		return nil, fmt.Errorf("evolve schema: %v: %w", err, inferErr)
+	}
+	if err := bqc.evolveSchema(columns); err != nil {
+		return nil, err
+	}
+	return bqc.encoder.EncodeRows(data)
+}
+
+// checkRowType checks, once per type, if the given data is a struct
+// which defines fields unknown to the encoder's schema, evolving the schema if so.
+func (bqc *Client) checkRowType(data interface{}) error {
+	rowType := reflect.TypeOf(data)
+	if rowType != nil && rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	if rowType == nil || rowType.Kind() != reflect.Struct {
+		return nil
+	}
+	if _, ok := bqc.checkedRowTypes[rowType]; ok {
+		return nil
+	}
+	columns, err := schema.InferFromRow(data)
+	if err != nil {
+		// not a row type we can check,
+		// the encoder will report it if it is not supported
+		bqc.checkedRowTypes[rowType] = struct{}{}
+		return nil //nolint: nilerr
+	}
+	if len(schema.Additions(bqc.schemaEncoder().Schema(), columns)) > 0 {
+		if err := bqc.evolveSchema(columns); err != nil {
+			return err
+		}
+	}
+	bqc.checkedRowTypes[rowType] = struct{}{}
+	return nil
+}
+
+// evolveSchema adds the given columns to the table schema, if not yet defined,
+// and replaces the encoder and stream with ones using the updated schema.
+func (bqc *Client) evolveSchema(columns bigquery.Schema) error {
+	if len(schema.Additions(bqc.schemaEncoder().Schema(), columns)) == 0 {
+		// nothing new to add, the unknown field could not be inferred
+		return fmt.Errorf("evolve schema: %w: no new columns could be inferred", encoding.ErrUnknownField)
+	}
+	updatedSchema, err := bqc.evolver.AddColumns(bqc.ctx, columns)
+	if err != nil {
+		return fmt.Errorf("evolve schema: %w", err)
+	}
+	encoder, err := bqc.schemaEncoder().WithSchema(updatedSchema)
+	if err != nil {
+		return fmt.Errorf("evolve schema: create encoder: %w", err)
+	}
+	dp, err := encoder.NormalizedDescriptorProto()
+	if err != nil {
+		return fmt.Errorf("evolve schema: %w", err)
+	}
+	stream, err := bqc.client.NewManagedStream(bqc.ctx, append(bqc.writerOpts, managedwriter.WithSchemaDescriptor(dp))...)
+	if err != nil {
+		return fmt.Errorf("evolve schema: create managed stream: %w", err)
+	}
+	bqc.retiredStreams = append(bqc.retiredStreams, bqc.stream)
+	bqc.stream = stream
+	bqc.encoder = encoder
+	log.Log(bqc.logger, log.LevelInfo, "BQ Storage Client: evolved table schema: replaced encoder and stream")
+	return nil
+}
+
+// schemaEncoder returns the encoder as a SchemaBasedEncoder,
+// which is guaranteed to be the case when schema evolution is enabled.
+func (bqc *Client) schemaEncoder() encoding.SchemaBasedEncoder {
+	return bqc.encoder.(encoding.SchemaBasedEncoder) //nolint: forcetypeassert
 }
 
 func (bqc *Client) checkAppendResultsAsync() {
@@ -187,6 +430,7 @@ func (bqc *Client) checkAppendResultsAsync() {
 							bqc.logger.Debugf("ready append resulted in error: %v", err)
 						} else {
 							bqc.logger.Errorf("ready append resulted in error: %v", err)
+							bqc.notifyQuotaError(err)
 						}
 					}
 				default:
@@ -200,6 +444,23 @@ func (bqc *Client) checkAppendResultsAsync() {
 	}
 }
 
+// SetThrottler implements bigquery.Throttled::SetThrottler
+func (bqc *Client) SetThrottler(throttler bqbase.Throttler) {
+	bqc.throttler = throttler
+}
+
+// NotifyQuotaExceeded implements bigquery.QuotaNotifier::NotifyQuotaExceeded
+func (bqc *Client) NotifyQuotaExceeded(f func(err error)) {
+	bqc.quotaExceeded.Store(f)
+}
+
+// notifyQuotaError notifies the registered function, if any, in case the given error is a quota error.
+func (bqc *Client) notifyQuotaError(err error) {
+	if f, ok := bqc.quotaExceeded.Load().(func(error)); ok && bqbase.IsQuotaError(err) {
+		f(err)
+	}
+}
+
 func isCanceledGRPCError(err error) bool {
 	st, ok := status.FromError(err)
 	if !ok {
@@ -222,13 +483,35 @@ func (bqc *Client) Close() error {
 			bqc.logger.Errorf("close BQ storage client: close internal append result ch: %v", panicErr)
 		}
 	}()
-	if err := bqc.stream.Close(); err != nil && !errors.Is(err, io.EOF) {
-		bqc.logger.Errorf("close BQ storage client: close stream: %v", err)
+	for _, stream := range append(bqc.retiredStreams, bqc.stream) {
+		if err := stream.Close(); err != nil && !errors.Is(err, io.EOF) {
+			bqc.logger.Errorf("close BQ storage client: close stream: %v", err)
+		}
+	}
+	if bqc.evolverClient != nil {
+		if err := bqc.closeEvolverClient(); err != nil {
+			bqc.logger.Errorf("close BQ storage client: close BQ client used for schema evolution: %v", err)
+		}
 	}
-	if err := bqc.client.Close(); err != nil {
+	if err := bqc.closeClient(); err != nil {
 		return fmt.Errorf("close BQ storage client: close internal storage writer client: %w", err)
 	}
 	close(bqc.appendResultCh)
 	bqc.wg.Wait()
 	return nil
 }
+
+// closeStreamAndWriter closes the stream and writer of a client that failed to be created.
+func (bqc *Client) closeStreamAndWriter() {
+	if err := bqc.stream.Close(); err != nil && !errors.Is(err, io.EOF) {
+		bqc.logger.Errorf("failed to close stream of BQ Storage client that failed to be created: %v", err)
+	}
+	if bqc.evolverClient != nil {
+		if err := bqc.closeEvolverClient(); err != nil {
+			bqc.logger.Errorf("failed to close BQ client of BQ Storage client that failed to be created: %v", err)
+		}
+	}
+	if err := bqc.closeClient(); err != nil {
+		bqc.logger.Errorf("failed to close BQ Storage client that failed to be created: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// register the well-known types which can be referenced by a descriptor
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// NewMessageDescriptor creates a message descriptor for the given (normalized) descriptor proto,
+// such as the one given as the ProtobufDescriptor of a StorageClientConfig. Nested types are expected
+// to be defined as part of the descriptor itself (see adapt.NormalizeDescriptor), with the exception
+// of the well-known types (google.protobuf.*) which are resolved using the global protobuf registry.
+//
+// Fields defined as a proto3 optional field are defined as regular optional fields instead (see toProto2Descriptor).
+func NewMessageDescriptor(dp *descriptorpb.DescriptorProto) (protoreflect.MessageDescriptor, error) {
+	if dp == nil {
+		return nil, fmt.Errorf("new message descriptor: %w: nil descriptor proto", ErrInvalidData)
+	}
+	return newMessageDescriptor(toProto2Descriptor(dp), "proto2")
+}
+
+func newMessageDescriptor(dp *descriptorpb.DescriptorProto, syntax string) (protoreflect.MessageDescriptor, error) {
+	if dp == nil {
+		return nil, fmt.Errorf("new message descriptor: %w: nil descriptor proto", ErrInvalidData)
+	}
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String(fmt.Sprintf("bqwriter/%s.proto", dp.GetName())),
+		Syntax:      proto.String(syntax),
+		Dependency:  wellKnownDependencies(dp),
+		MessageType: []*descriptorpb.DescriptorProto{dp},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("new message descriptor: protodesc.NewFile: %w", err)
+	}
+	return fd.Messages().Get(0), nil
+}
+
+// wellKnownDependencies returns the (sorted) file paths of all the
+// well-known types referenced by the given descriptor proto, nested types included.
+func wellKnownDependencies(dp *descriptorpb.DescriptorProto) []string {
+	paths := map[string]struct{}{}
+	var walk func(dp *descriptorpb.DescriptorProto)
+	walk = func(dp *descriptorpb.DescriptorProto) {
+		for _, field := range dp.GetField() {
+			typeName := field.GetTypeName()
+			if !strings.HasPrefix(typeName, ".google.protobuf.") {
+				continue
+			}
+			desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(typeName[1:]))
+			if err != nil {
+				// not resolvable, protodesc will report an error for it
+				continue
+			}
+			paths[desc.ParentFile().Path()] = struct{}{}
+		}
+		for _, nested := range dp.GetNestedType() {
+			walk(nested)
+		}
+	}
+	walk(dp)
+	deps := make([]string, 0, len(paths))
+	for path := range paths {
+		deps = append(deps, path)
+	}
+	sort.Strings(deps)
+	return deps
+}
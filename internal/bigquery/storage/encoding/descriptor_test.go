@@ -0,0 +1,53 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding/testdata"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestNewMessageDescriptorWithWellKnownType(t *testing.T) {
+	dp := protodesc.ToDescriptorProto((&testdata.SimpleMessageProto3{}).ProtoReflect().Descriptor())
+	md, err := NewMessageDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, 2, md.Fields().Len())
+	valueField := md.Fields().ByName("value")
+	test.AssertNotNil(t, valueField)
+	test.AssertEqual(t, protoreflect.FullName("google.protobuf.Int64Value"), valueField.Message().FullName())
+}
+
+func TestNewMessageDescriptorNormalized(t *testing.T) {
+	dp, err := adapt.NormalizeDescriptor((&testdata.SimpleMessageProto3{}).ProtoReflect().Descriptor())
+	test.AssertNoErrorFatal(t, err)
+	md, err := NewMessageDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, 2, md.Fields().Len())
+	valueField := md.Fields().ByName("value")
+	test.AssertNotNil(t, valueField)
+	// well-known types are normalized as nested types as well
+	test.AssertEqual(t, protoreflect.Name("google_protobuf_Int64Value"), valueField.Message().Name())
+}
+
+func TestNewMessageDescriptorNil(t *testing.T) {
+	md, err := NewMessageDescriptor(nil)
+	test.AssertError(t, err)
+	test.AssertNil(t, md)
+}
@@ -14,7 +14,13 @@
 
 package encoding
 
-import "errors"
+import (
+	"errors"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
 
 // Encoder is the interface required by the BigQuery storage client
 // in order to encode the data into the protobuf expected format.
@@ -26,8 +32,61 @@ type Encoder interface {
 	EncodeRows(data interface{}) (rows [][]byte, err error)
 }
 
+// SchemaBasedEncoder is an Encoder which encodes the data based on a BigQuery schema,
+// and which can be recreated for another (e.g. evolved) schema.
+type SchemaBasedEncoder interface {
+	Encoder
+
+	// Schema returns the BigQuery schema used by this encoder.
+	Schema() bigquery.Schema
+
+	// NormalizedDescriptorProto returns the normalized descriptor of the messages encoded by this encoder,
+	// as is to be used by the storage client for the managed stream (see adapt.NormalizeDescriptor).
+	NormalizedDescriptorProto() (*descriptorpb.DescriptorProto, error)
+
+	// WithSchema creates a new encoder of the same kind, using the given schema instead.
+	WithSchema(schema bigquery.Schema) (SchemaBasedEncoder, error)
+}
+
 var (
 	// ErrInvalidData is an error that can be returned by an Encoder
 	// in case the given input data was invalid within the context of that encoder.
 	ErrInvalidData = errors.New("invalid data")
+
+	// ErrUnknownField is an error that can be returned by an Encoder
+	// in case the given input data defines a field unknown to the encoder's schema.
+	ErrUnknownField = errors.New("unknown field")
 )
+
+// unknownFieldError wraps a decode error caused by an unknown field,
+// such that it can be identified as an ErrUnknownField while still
+// giving access to the original decode error.
+type unknownFieldError struct {
+	err error
+}
+
+// Error implements error::Error
+func (e unknownFieldError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the original decode error.
+func (e unknownFieldError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true in case the target is ErrUnknownField.
+func (e unknownFieldError) Is(target error) bool {
+	return target == ErrUnknownField
+}
+
+// wrapDecodeError wraps the given decode error as an unknownFieldError,
+// in case it was caused by an unknown field, returning it as-is otherwise.
+func wrapDecodeError(err error) error {
+	// both the protojson as well as the prototext decoder
+	// report unknown fields using this exact phrase
+	if err != nil && strings.Contains(err.Error(), "unknown field") {
+		return unknownFieldError{err: err}
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The Storage Write API decodes the rows using proto2 semantics, which differ from proto3 in a couple of ways:
+//   - singular scalar fields of a proto3 message have no presence (unless defined as optional),
+//     and are not written when they have their zero value, which would be decoded as NULL rather than that zero value;
+//   - optional proto3 fields are defined as part of a synthetic oneof, which is not part of a normalized descriptor;
+//   - proto3 enums are open, allowing values not defined by the enum, while proto2 enums are closed.
+//
+// A descriptor derived from a proto3 message is therefore converted to an equivalent proto2 descriptor (see toProto2Descriptor),
+// and proto3 messages are normalized (see normalizeMessage) such that they are decoded the same way as they would using proto3 semantics.
+
+// toProto2Descriptor returns a copy of the given (normalized) descriptor proto, with all fields defined
+// as part of a synthetic (proto3 optional) or undeclared oneof converted to regular optional fields,
+// returning the given descriptor as-is in case none of its fields required such a conversion.
+func toProto2Descriptor(dp *descriptorpb.DescriptorProto) *descriptorpb.DescriptorProto {
+	if !hasProto3Fields(dp) {
+		return dp
+	}
+	dp = proto.Clone(dp).(*descriptorpb.DescriptorProto) //nolint: forcetypeassert
+	var convert func(dp *descriptorpb.DescriptorProto)
+	convert = func(dp *descriptorpb.DescriptorProto) {
+		for _, fdp := range dp.GetField() {
+			if isProto3Field(dp, fdp) {
+				fdp.Proto3Optional = nil
+				fdp.OneofIndex = nil
+			}
+		}
+		// synthetic oneofs are defined after all regular oneofs, and can thus be dropped
+		oneofs := dp.GetOneofDecl()[:0]
+		for idx, oneof := range dp.GetOneofDecl() {
+			if isOneofReferenced(dp, int32(idx)) {
+				oneofs = append(oneofs, oneof)
+			}
+		}
+		dp.OneofDecl = oneofs
+		for _, nested := range dp.GetNestedType() {
+			convert(nested)
+		}
+	}
+	convert(dp)
+	return dp
+}
+
+func hasProto3Fields(dp *descriptorpb.DescriptorProto) bool {
+	for _, fdp := range dp.GetField() {
+		if isProto3Field(dp, fdp) {
+			return true
+		}
+	}
+	for _, nested := range dp.GetNestedType() {
+		if hasProto3Fields(nested) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProto3Field returns true in case the field is a proto3 optional field
+// or references a oneof which is not declared by the descriptor (e.g. as a result of normalizing it).
+func isProto3Field(dp *descriptorpb.DescriptorProto, fdp *descriptorpb.FieldDescriptorProto) bool {
+	if fdp.GetProto3Optional() {
+		return true
+	}
+	return fdp.OneofIndex != nil && int(fdp.GetOneofIndex()) >= len(dp.GetOneofDecl())
+}
+
+func isOneofReferenced(dp *descriptorpb.DescriptorProto, idx int32) bool {
+	for _, fdp := range dp.GetField() {
+		if fdp.OneofIndex != nil && fdp.GetOneofIndex() == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpenEnum returns true in case the enum is defined in a proto3 file,
+// and thus allows values which are not defined by the enum itself.
+func isOpenEnum(ed protoreflect.EnumDescriptor) bool {
+	return ed.ParentFile() != nil && ed.ParentFile().Syntax() == protoreflect.Proto3
+}
+
+// setImplicitZeroValues explicitly sets the zero value of all singular fields of the src message
+// which have no presence (proto3) and are not populated, on the dst message.
+func setImplicitZeroValues(src, dst protoreflect.Message) error {
+	if src.Descriptor().Syntax() != protoreflect.Proto3 {
+		return nil
+	}
+	fields, dstFields := src.Descriptor().Fields(), dst.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.HasPresence() || fd.IsList() || fd.IsMap() || src.Has(fd) {
+			continue
+		}
+		dstFd := dstFields.ByNumber(fd.Number())
+		if dstFd == nil {
+			// not defined in the normalized descriptor, and nothing to be written either
+			continue
+		}
+		v, err := normalizeEnumOrScalarValue(fd, dstFd, src.Get(fd))
+		if err != nil {
+			return fmt.Errorf("field %s: %w", fd.FullName(), err)
+		}
+		dst.Set(dstFd, v)
+	}
+	return nil
+}
+
+func normalizeEnumOrScalarValue(fd, dstFd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, error) {
+	if fd.Kind() == protoreflect.EnumKind {
+		return normalizeEnumValue(fd, dstFd, v)
+	}
+	return v, nil
+}
+
+// normalizeEnumValue normalizes the value of an enum field, returning it as an integer value
+// in case the normalized field is an integer (e.g. as normalized for an open enum by NormalizeDescriptor).
+// An ErrInvalidData error is returned in case the value is not defined by the (closed) enum of the normalized field.
+func normalizeEnumValue(fd, dstFd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, error) {
+	number := v.Enum()
+	switch dstFd.Kind() {
+	case protoreflect.EnumKind:
+		if dstFd.Enum().Values().ByNumber(number) == nil {
+			return protoreflect.Value{}, fmt.Errorf(
+				"%w: value %d of enum %s is not defined by the (closed) enum %s of the descriptor",
+				ErrInvalidData, number, fd.Enum().FullName(), dstFd.Enum().FullName())
+		}
+		return v, nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(number)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(int64(number)), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf(
+			"%w: enum %s cannot be normalized as a %s value", ErrInvalidData, fd.Enum().FullName(), dstFd.Kind())
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding/testdata"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func newProto3Message(status protoreflect.EnumNumber) *dynamicpb.Message {
+	md := testdata.Proto3MessageDescriptor()
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("limit"), protoreflect.ValueOfInt64(0))
+	msg.Set(md.Fields().ByName("status"), protoreflect.ValueOfEnum(status))
+	msg.Mutable(md.Fields().ByName("tags")).List().Append(protoreflect.ValueOfString("a"))
+	return msg
+}
+
+func TestNormalizeDescriptorProto3(t *testing.T) {
+	dp, err := NormalizeDescriptor(testdata.Proto3MessageDescriptor())
+	test.AssertNoErrorFatal(t, err)
+	limit := dp.GetField()[2]
+	test.AssertEqual(t, "limit", limit.GetName())
+	test.AssertFalse(t, limit.GetProto3Optional())
+	test.AssertNil(t, limit.OneofIndex)
+	// open enums are normalized as an int32
+	status := dp.GetField()[3]
+	test.AssertEqual(t, descriptorpb.FieldDescriptorProto_TYPE_INT32, status.GetType())
+	test.AssertEqual(t, "", status.GetTypeName())
+	test.AssertEqual(t, 1, len(dp.GetNestedType()))
+
+	_, err = NewMessageDescriptor(dp)
+	test.AssertNoError(t, err)
+}
+
+func TestToProto2DescriptorAdaptNormalized(t *testing.T) {
+	dp, err := adapt.NormalizeDescriptor(testdata.Proto3MessageDescriptor())
+	test.AssertNoErrorFatal(t, err)
+	// the synthetic oneof is referenced, but not declared
+	test.AssertNotNil(t, dp.GetField()[2].OneofIndex)
+	test.AssertEqual(t, 0, len(dp.GetOneofDecl()))
+
+	converted := toProto2Descriptor(dp)
+	test.AssertNil(t, converted.GetField()[2].OneofIndex)
+	test.AssertFalse(t, converted.GetField()[2].GetProto3Optional())
+	// the given descriptor is left untouched
+	test.AssertNotNil(t, dp.GetField()[2].OneofIndex)
+
+	// descriptors without proto3 fields are returned as-is
+	dp, err = adapt.NormalizeDescriptor((&testdata.SimpleMessageProto2{}).ProtoReflect().Descriptor())
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, dp, toProto2Descriptor(dp))
+}
+
+func TestProtobufEncoderProto3ImplicitPresence(t *testing.T) {
+	dp, err := NormalizeDescriptor(testdata.Proto3MessageDescriptor())
+	test.AssertNoErrorFatal(t, err)
+	encoder, _, err := NewProtobufEncoderForDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+
+	rows, err := encoder.EncodeRows(newProto3Message(0))
+	test.AssertNoErrorFatal(t, err)
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	fields := encoder.md.Fields()
+
+	// fields without presence are written with their zero value
+	for _, name := range []protoreflect.Name{"name", "count", "limit", "status", "active"} {
+		test.AssertTrue(t, msg.Has(fields.ByName(name)), name)
+	}
+	test.AssertEqual(t, "", msg.Get(fields.ByName("name")).String())
+	test.AssertEqual(t, int64(0), msg.Get(fields.ByName("count")).Int())
+	test.AssertEqual(t, 1, msg.Get(fields.ByName("tags")).List().Len())
+	// messages have presence, and are thus not written when not set
+	test.AssertFalse(t, msg.Has(fields.ByName("nested")))
+
+	// values of an open enum are written as-is
+	rows, err = encoder.EncodeRows(newProto3Message(42))
+	test.AssertNoErrorFatal(t, err)
+	msg = unmarshalRow(t, encoder.md, rows[0])
+	test.AssertEqual(t, int64(42), msg.Get(fields.ByName("status")).Int())
+}
+
+func TestProtobufEncoderProto3AdaptNormalized(t *testing.T) {
+	dp, err := adapt.NormalizeDescriptor(testdata.Proto3MessageDescriptor())
+	test.AssertNoErrorFatal(t, err)
+	encoder, streamDP, err := NewProtobufEncoderForDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertNil(t, streamDP.GetField()[2].OneofIndex)
+
+	rows, err := encoder.EncodeRows(newProto3Message(1))
+	test.AssertNoErrorFatal(t, err)
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	fields := encoder.md.Fields()
+	test.AssertTrue(t, msg.Has(fields.ByName("name")))
+	test.AssertEqual(t, protoreflect.EnumNumber(1), msg.Get(fields.ByName("status")).Enum())
+
+	// the enum of the descriptor is closed, so undefined values are rejected
+	_, err = encoder.EncodeRows(newProto3Message(42))
+	test.AssertIsError(t, err, ErrInvalidData)
+}
+
+func TestProtobufEncoderProto3Generated(t *testing.T) {
+	dp, err := adapt.NormalizeDescriptor((&testdata.SimpleMessageProto3{}).ProtoReflect().Descriptor())
+	test.AssertNoErrorFatal(t, err)
+	encoder, _, err := NewProtobufEncoderForDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+
+	rows, err := encoder.EncodeRows(&testdata.SimpleMessageProto3{})
+	test.AssertNoErrorFatal(t, err)
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	test.AssertTrue(t, msg.Has(encoder.md.Fields().ByName("name")))
+	test.AssertFalse(t, msg.Has(encoder.md.Fields().ByName("value")))
+}
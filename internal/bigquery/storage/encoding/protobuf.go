@@ -19,6 +19,9 @@ import (
 
 	"github.com/OTA-Insight/bqwriter/internal"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 // ProtobufEncoder is the preferred encoder shipped with the bqwriter package.
@@ -28,7 +31,16 @@ import (
 // In case using a proto message is not an option for you,
 // you can use the SchemaEncoder instead, but do not that you
 // will be mostlikely pay a performance penalty for doing so.
-type ProtobufEncoder struct{}
+//
+// When created for a descriptor (see NewProtobufEncoderForDescriptor), messages defining fields
+// of a well-known type (e.g. google.protobuf.Timestamp) are normalized prior to encoding them,
+// converting the values of those fields to the scalar (wire) type expected by the Storage Write API.
+// Messages without such fields are encoded as-is.
+type ProtobufEncoder struct {
+	// md is the normalized message descriptor, nil in case messages are encoded as-is
+	md         protoreflect.MessageDescriptor
+	normalized normalizationCache
+}
 
 // interface compile-time compliance check
 var (
@@ -42,6 +54,20 @@ func NewProtobufEncoder() *ProtobufEncoder {
 	return &ProtobufEncoder{}
 }
 
+// NewProtobufEncoderForDescriptor creates a new ProtobufEncoder for messages of the given (normalized)
+// descriptor proto, e.g. the ProtobufDescriptor of a StorageClientConfig, normalizing the fields
+// of a well-known type in messages. It returns, next to the encoder, the descriptor proto to be used
+// by the storage client, which is a copy of the given descriptor with all fields referencing a well-known type
+// normalized to their scalar (wire) type, or the given descriptor as-is if it did not reference any.
+func NewProtobufEncoderForDescriptor(dp *descriptorpb.DescriptorProto) (*ProtobufEncoder, *descriptorpb.DescriptorProto, error) {
+	dp = toProto2Descriptor(normalizeWellKnownTypes(dp))
+	md, err := NewMessageDescriptor(dp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("NewProtobufEncoderForDescriptor: %w", err)
+	}
+	return &ProtobufEncoder{md: md}, dp, nil
+}
+
 // EncodeRows implements Encoder::EncodeRows
 //
 // Data passed in as input and to be encoded is expected to be a single row of data only.
@@ -52,6 +78,13 @@ func (pbe *ProtobufEncoder) EncodeRows(data interface{}) ([][]byte, error) {
 			"ProtoBufEncoder: EncodeRows: data is expected to be a proto.Message"+
 				", %T is not supported: %w", data, internal.ErrInvalidParam)
 	}
+	if pbe.md != nil && pbe.normalized.requiresNormalization(msg.ProtoReflect().Descriptor()) {
+		normalized := dynamicpb.NewMessage(pbe.md)
+		if err := normalizeMessage(msg.ProtoReflect(), normalized); err != nil {
+			return nil, fmt.Errorf("ProtobufEncoder: EncodeRows: failed to normalize message: %w", err)
+		}
+		msg = normalized
+	}
 	b, err := proto.Marshal(msg)
 	if err != nil {
 		return nil, fmt.Errorf("ProtobufEncoder: EncodeRows: failed to proto marshal message: %w", err)
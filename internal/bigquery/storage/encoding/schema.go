@@ -36,20 +36,30 @@ import (
 // this Encoder requires a lot of recflection as well as some possibly some extra trial-and-error.
 //
 // The following values can be encoded:
-// - []byte, expected to be a Json encoded message from which it will proto-encode
-//   using a json-driven decoder (see the official protobuf protojson package);
-// - JsonMarshaler, which will be Json-encoded to []byte
-//   and follow the same path as previous option from here;
-// - string, expected to be a Text (human-friendly) encoded message from which it will
-//   proto-encode using a text-driven decoder (see the official protobuf prototext package);
-// - Stringer, which will be stringified to string
-//   and follow the same path as previous option here;
+//   - bigquery.ValueSaver, map[string]bigquery.Value and map[string]interface{}, of which the values
+//     are set directly on the (dynamic) proto message, walking its descriptor, converting Go values
+//     (e.g. time.Time, civil types, *big.Rat, bigquery.NullX types or their string representation)
+//     to the wire type expected for the BigQuery type of their field;
+//   - []byte, expected to be a Json encoded message from which it will proto-encode
+//     using a json-driven decoder (see the official protobuf protojson package);
+//   - JsonMarshaler, which will be Json-encoded to []byte
+//     and follow the same path as previous option from here;
+//   - string, expected to be a Text (human-friendly) encoded message from which it will
+//     proto-encode using a text-driven decoder (see the official protobuf prototext package);
+//   - Stringer, which will be stringified to string
+//     and follow the same path as previous option here;
 //
-// Any value of a type different than the ones listed above will be attempted to be encoded
-// using the bigquery.StructSaver in order to be able to via that long road to Json-Encode
-// it to a []byte value and use a Json-driven decoder in the end just as when you would
-// have passed in a []byte value yourself. It's a long road and even much more so inefficient,
-// you have been warned.
+// Any value of a type different than the ones listed above is expected to be a struct (pointer),
+// of which the field values are set directly on the (dynamic) proto message the same way as for
+// a map of values, with the fields named the same way as done by the bigquery.StructSaver
+// (see schema.StructFields), skipping fields which are not defined in the schema.
+// This requires a lot of reflection and is thus inefficient, you should use the StructEncoder
+// in case all rows are of the same struct type.
+//
+// Values of the well-known protobuf types are normalized as well, such that e.g. a *timestamppb.Timestamp
+// can be used for a TIMESTAMP field, a wrapper type for its scalar field and a *structpb.Struct for a STRING field,
+// which will be written as its Json encoded string. A time.Duration (or *durationpb.Duration) is written
+// as its amount of microseconds for an INTEGER field.
 type SchemaEncoder struct {
 	schema bigquery.Schema
 
@@ -59,7 +69,8 @@ type SchemaEncoder struct {
 
 // interface compile-time compliance check
 var (
-	_ Encoder = (*SchemaEncoder)(nil)
+	_ Encoder            = (*SchemaEncoder)(nil)
+	_ SchemaBasedEncoder = (*SchemaEncoder)(nil)
 )
 
 // NewSchemaEncoder creates a new SchemaEncoder. Can fail in case the given
@@ -78,6 +89,25 @@ func NewSchemaEncoder(schema bigquery.Schema) (*SchemaEncoder, error) {
 	}, nil
 }
 
+// Schema implements SchemaBasedEncoder::Schema
+func (se *SchemaEncoder) Schema() bigquery.Schema {
+	return se.schema
+}
+
+// NormalizedDescriptorProto implements SchemaBasedEncoder::NormalizedDescriptorProto
+func (se *SchemaEncoder) NormalizedDescriptorProto() (*descriptorpb.DescriptorProto, error) {
+	dp, err := adapt.NormalizeDescriptor(se.md)
+	if err != nil {
+		return nil, fmt.Errorf("SchemaEncoder: NormalizedDescriptorProto: adapt.NormalizeDescriptor: %w", err)
+	}
+	return dp, nil
+}
+
+// WithSchema implements SchemaBasedEncoder::WithSchema
+func (se *SchemaEncoder) WithSchema(schema bigquery.Schema) (SchemaBasedEncoder, error) {
+	return NewSchemaEncoder(schema)
+}
+
 // EncodeRows implements Encoder::EncodeRows
 //
 // Data passed in as input and to be encoded is expected to be a single row of data only.
@@ -85,10 +115,16 @@ func (se *SchemaEncoder) EncodeRows(data interface{}) ([][]byte, error) {
 	message := dynamicpb.NewMessage(se.md)
 
 	switch typedData := data.(type) {
+	// row values, set directly on the dynamic message
+	case bigquery.ValueSaver, map[string]bigquery.Value, map[string]interface{}:
+		if err := setRowValues(message, se.schema, typedData); err != nil {
+			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to set values as row: %w", err)
+		}
+
 	// json Proto
 	case []byte:
 		if err := protojson.Unmarshal(typedData, message); err != nil {
-			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal json message as row: %w", err)
+			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal json message as row: %w", wrapDecodeError(err))
 		}
 	case json.Marshaler:
 		bytes, err := typedData.MarshalJSON()
@@ -96,35 +132,24 @@ func (se *SchemaEncoder) EncodeRows(data interface{}) ([][]byte, error) {
 			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Marshal json.Marshaler as bytes: %w", err)
 		}
 		if err := protojson.Unmarshal(bytes, message); err != nil {
-			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal json (marshalled) message as row: %w", err)
+			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal json (marshalled) message as row: %w", wrapDecodeError(err))
 		}
 
 	// text Proto
 	case string:
 		if err := prototext.Unmarshal([]byte(typedData), message); err != nil {
-			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal text message as row: %w", err)
+			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal text message as row: %w", wrapDecodeError(err))
 		}
 	case interface{ String() string }:
 		if err := prototext.Unmarshal([]byte(typedData.String()), message); err != nil {
-			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal Stringer as text message as row: %w", err)
+			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal Stringer as text message as row: %w", wrapDecodeError(err))
 		}
 
-	// use it using the struct saver as a last resort,
-	// very inefficient, so best to give json bytes instead
+	// set the values of the struct fields directly on the dynamic message,
+	// still inefficient due to the reflection, so best to use a StructEncoder instead
 	default:
-		bqMap, _, err := (&bigquery.StructSaver{
-			Schema: se.schema,
-			Struct: data,
-		}).Save()
-		if err != nil {
-			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: unknown data type (%T) not useable as StructSaver: %w", data, err)
-		}
-		bytes, err := json.Marshal(bqMap)
-		if err != nil {
-			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: StructSaved data type (%T) failed to marshal as Json: %w", data, err)
-		}
-		if err := protojson.Unmarshal(bytes, message); err != nil {
-			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to Unmarshal struct-saved-to-json message as row: %w", err)
+		if err := setRowValues(message, se.schema, data); err != nil {
+			return nil, fmt.Errorf("SchemaEncoder: EncodeRows: failed to set values of data type (%T) as row: %w", data, err)
 		}
 	}
 
@@ -17,6 +17,7 @@ package encoding
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -392,3 +393,51 @@ func TestSchemaEncoder(t *testing.T) {
 		}
 	}
 }
+
+func TestSchemaEncoderUnknownField(t *testing.T) {
+	encoder, err := NewSchemaEncoder(SimpleNameOnlyMessageSchema)
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, SimpleNameOnlyMessageSchema, encoder.Schema())
+
+	testCases := []interface{}{
+		[]byte(`{"name": "foo", "value": 42}`),
+		`name: "foo" value: 42`,
+	}
+	for _, testCase := range testCases {
+		_, err := encoder.EncodeRows(testCase)
+		test.AssertError(t, err)
+		test.AssertIsError(t, err, ErrUnknownField)
+	}
+
+	_, err = encoder.EncodeRows([]byte(`{"name": 42}`))
+	test.AssertError(t, err)
+	test.AssertFalse(t, errors.Is(err, ErrUnknownField))
+}
+
+func TestSchemaEncoderNormalizedDescriptorProto(t *testing.T) {
+	encoder, err := NewSchemaEncoder(SimpleMessageSchema)
+	test.AssertNoError(t, err)
+	dp, err := encoder.NormalizedDescriptorProto()
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, 2, len(dp.GetField()))
+	test.AssertEqual(t, "name", dp.GetField()[0].GetName())
+}
+
+type simpleMessagePointerStruct struct {
+	Name  string `bigquery:"name"`
+	Value *int64 `bigquery:"value"`
+}
+
+func TestSchemaEncoderPointerFields(t *testing.T) {
+	encoder, err := NewSchemaEncoder(SimpleMessageSchema)
+	test.AssertNoError(t, err)
+	value := int64(42)
+	for _, row := range []interface{}{
+		simpleMessagePointerStruct{Name: "foo", Value: &value},
+		&simpleMessagePointerStruct{Name: "foo"},
+	} {
+		rows, err := encoder.EncodeRows(row)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, 1, len(rows))
+	}
+}
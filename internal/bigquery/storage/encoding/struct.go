@@ -0,0 +1,577 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/value"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	// ErrIncompatibleStructField is an error returned in case a field of a struct type
+	// cannot be encoded as the BigQuery type of its schema field.
+	ErrIncompatibleStructField = errors.New("incompatible struct field")
+
+	// ErrMissingRequiredValue is an error returned in case no value is defined
+	// for a REQUIRED field, e.g. because it is a nil pointer.
+	ErrMissingRequiredValue = errors.New("missing value for required field")
+)
+
+// StructEncoder is an encoder that encodes values of a single Go struct type
+// directly as protobuf wire bytes, based on a dynamically defined BigQuery schema.
+//
+// A field plan, mapping each schema field to its struct field and wire encoding,
+// is compiled once when creating the encoder, such that no reflection-driven marshalling
+// (StructSaver, Json and dynamic proto messages) is required in order to encode a row,
+// as is the case for the SchemaEncoder.
+//
+// Struct fields are matched to schema fields by the name defined using their `bigquery:"name"` tag,
+// or else their Go field name, case-insensitive. Struct fields which are not defined in the schema are ignored.
+// Values of any other type than the struct type (or a pointer to it) are encoded using a SchemaEncoder.
+type StructEncoder struct {
+	rowType reflect.Type
+	plan    *structPlan
+
+	fallback *SchemaEncoder
+}
+
+// interface compile-time compliance check
+var (
+	_ Encoder            = (*StructEncoder)(nil)
+	_ SchemaBasedEncoder = (*StructEncoder)(nil)
+)
+
+// NewStructEncoder creates a new StructEncoder for the given struct (pointer) type.
+// Can fail in case the given bigquery.Schema cannot be converted to a Protobuf Descriptor,
+// a REQUIRED schema field has no matching struct field, or in case a struct field cannot be encoded
+// as the BigQuery type of its schema field.
+func NewStructEncoder(rowType reflect.Type, schema bigquery.Schema) (*StructEncoder, error) {
+	if rowType == nil {
+		return nil, fmt.Errorf("NewStructEncoder: validate row type: %w: missing", internal.ErrInvalidParam)
+	}
+	if rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	fallback, err := NewSchemaEncoder(schema)
+	if err != nil {
+		return nil, fmt.Errorf("NewStructEncoder: %w", err)
+	}
+	plan, err := compileStructPlan(rowType, schema, fallback.md)
+	if err != nil {
+		return nil, fmt.Errorf("NewStructEncoder: compile %v: %w", rowType, err)
+	}
+	return &StructEncoder{
+		rowType:  rowType,
+		plan:     plan,
+		fallback: fallback,
+	}, nil
+}
+
+// Schema implements SchemaBasedEncoder::Schema
+func (se *StructEncoder) Schema() bigquery.Schema {
+	return se.fallback.Schema()
+}
+
+// NormalizedDescriptorProto implements SchemaBasedEncoder::NormalizedDescriptorProto
+func (se *StructEncoder) NormalizedDescriptorProto() (*descriptorpb.DescriptorProto, error) {
+	return se.fallback.NormalizedDescriptorProto()
+}
+
+// WithSchema implements SchemaBasedEncoder::WithSchema
+func (se *StructEncoder) WithSchema(schema bigquery.Schema) (SchemaBasedEncoder, error) {
+	return NewStructEncoder(se.rowType, schema)
+}
+
+// EncodeRows implements Encoder::EncodeRows
+//
+// Data passed in as input and to be encoded is expected to be a single row of data only.
+func (se *StructEncoder) EncodeRows(data interface{}) ([][]byte, error) {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() {
+		return nil, fmt.Errorf("StructEncoder: EncodeRows: nil data: %w", internal.ErrInvalidParam)
+	}
+	if v.Kind() == reflect.Ptr && v.Type().Elem() == se.rowType {
+		if v.IsNil() {
+			return nil, fmt.Errorf("StructEncoder: EncodeRows: nil %T: %w", data, ErrInvalidData)
+		}
+		v = v.Elem()
+	}
+	if v.Type() != se.rowType {
+		return se.fallback.EncodeRows(data)
+	}
+	b, err := se.plan.appendStruct(nil, v)
+	if err != nil {
+		return nil, fmt.Errorf("StructEncoder: EncodeRows: %w", err)
+	}
+	return [][]byte{b}, nil
+}
+
+// structPlan is the compiled plan used to encode a struct as a protobuf message.
+type structPlan struct {
+	fields []fieldPlan
+}
+
+// fieldPlan is the compiled plan used to encode a single struct field as a protobuf field.
+type fieldPlan struct {
+	name     string
+	index    []int
+	required bool
+	repeated bool
+	encode   valueEncoder
+}
+
+// valueEncoder appends a single (non-repeated) value, tag included, to the given buffer,
+// returning false in case no value is defined (e.g. a nil pointer), in which case nothing is appended.
+type valueEncoder func(b []byte, v reflect.Value) ([]byte, bool, error)
+
+func compileStructPlan(rt reflect.Type, bqSchema bigquery.Schema, md protoreflect.MessageDescriptor) (*structPlan, error) {
+	structFields, err := schema.StructFields(rt)
+	if err != nil {
+		return nil, err
+	}
+	plan := new(structPlan)
+	for _, field := range bqSchema {
+		fd := md.Fields().ByName(protoreflect.Name(field.Name))
+		if fd == nil {
+			return nil, fmt.Errorf("schema field %q: %w: no matching proto field", field.Name, internal.ErrInvalidParam)
+		}
+		sf, ok := findStructField(structFields, field.Name)
+		if !ok {
+			if field.Required {
+				return nil, fmt.Errorf("required schema field %q: %w: no matching struct field", field.Name, ErrIncompatibleStructField)
+			}
+			continue
+		}
+		fieldType := sf.Type
+		if field.Repeated {
+			if fieldType.Kind() != reflect.Slice && fieldType.Kind() != reflect.Array {
+				return nil, fmt.Errorf("repeated schema field %q: %w: %v is not a slice or array", field.Name, ErrIncompatibleStructField, sf.Type)
+			}
+			fieldType = fieldType.Elem()
+		}
+		encode, err := compileValueEncoder(field, fd, fieldType)
+		if err != nil {
+			return nil, err
+		}
+		plan.fields = append(plan.fields, fieldPlan{
+			name:     field.Name,
+			index:    sf.Index,
+			required: field.Required,
+			repeated: field.Repeated,
+			encode:   encode,
+		})
+	}
+	return plan, nil
+}
+
+func findStructField(fields []schema.StructField, name string) (schema.StructField, bool) {
+	// an exact match takes priority over a case-insensitive one
+	for _, field := range fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if value.EqualFoldASCII(field.Name, name) {
+			return field, true
+		}
+	}
+	return schema.StructField{}, false
+}
+
+// appendStruct appends the fields of the given struct value,
+// encoded as a protobuf message, to the given buffer.
+func (p *structPlan) appendStruct(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := range p.fields {
+		field := &p.fields[i]
+		fv, ok := fieldByIndex(v, field.index)
+		if !ok {
+			// field promoted from a nil embedded struct pointer
+			if field.required {
+				return nil, fmt.Errorf("field %q: %w", field.name, ErrMissingRequiredValue)
+			}
+			continue
+		}
+		if field.repeated {
+			for j := 0; j < fv.Len(); j++ {
+				var set bool
+				b, set, err = field.encode(b, fv.Index(j))
+				if err != nil {
+					return nil, fmt.Errorf("field %q: %w", field.name, err)
+				}
+				if !set {
+					return nil, fmt.Errorf("field %q: element #%d: %w", field.name, j, ErrMissingRequiredValue)
+				}
+			}
+			continue
+		}
+		var set bool
+		b, set, err = field.encode(b, fv)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", field.name, err)
+		}
+		if !set && field.required {
+			return nil, fmt.Errorf("field %q: %w", field.name, ErrMissingRequiredValue)
+		}
+	}
+	return b, nil
+}
+
+// fieldByIndex returns the nested field of the given struct value,
+// returning false in case it is promoted from a nil embedded struct pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	if len(index) == 1 {
+		return v.Field(index[0]), true
+	}
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfDate          = reflect.TypeOf(civil.Date{})
+	typeOfCivilTime     = reflect.TypeOf(civil.Time{})
+	typeOfDateTime      = reflect.TypeOf(civil.DateTime{})
+	typeOfRat           = reflect.TypeOf((*big.Rat)(nil))
+	typeOfNullInt64     = reflect.TypeOf(bigquery.NullInt64{})
+	typeOfNullFloat64   = reflect.TypeOf(bigquery.NullFloat64{})
+	typeOfNullBool      = reflect.TypeOf(bigquery.NullBool{})
+	typeOfNullString    = reflect.TypeOf(bigquery.NullString{})
+	typeOfNullGeo       = reflect.TypeOf(bigquery.NullGeography{})
+	typeOfNullTimestamp = reflect.TypeOf(bigquery.NullTimestamp{})
+	typeOfNullDate      = reflect.TypeOf(bigquery.NullDate{})
+	typeOfNullCivilTime = reflect.TypeOf(bigquery.NullTime{})
+	typeOfNullDateTime  = reflect.TypeOf(bigquery.NullDateTime{})
+)
+
+// compileValueEncoder compiles the encoder for a single value of the given Go type,
+// as the (proto wire) type of the given schema field.
+func compileValueEncoder(field *bigquery.FieldSchema, fd protoreflect.FieldDescriptor, rt reflect.Type) (valueEncoder, error) {
+	// pointers are dereferenced, with nil pointers resulting in no value
+	if rt.Kind() == reflect.Ptr && rt != typeOfRat {
+		elemEncoder, err := compileValueEncoder(field, fd, rt.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+			if v.IsNil() {
+				return b, false, nil
+			}
+			return elemEncoder(b, v.Elem())
+		}, nil
+	}
+
+	num := fd.Number()
+	incompatible := func() (valueEncoder, error) {
+		return nil, fmt.Errorf("schema field %q: %w: %v cannot be encoded as %s", field.Name, ErrIncompatibleStructField, rt, field.Type)
+	}
+
+	switch field.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		switch {
+		case rt.Kind() == reflect.String:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				return appendString(b, num, v.String()), true, nil
+			}, nil
+		case rt == typeOfNullString:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				ns := v.Interface().(bigquery.NullString) //nolint: forcetypeassert
+				if !ns.Valid {
+					return b, false, nil
+				}
+				return appendString(b, num, ns.StringVal), true, nil
+			}, nil
+		case rt == typeOfNullGeo:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				ng := v.Interface().(bigquery.NullGeography) //nolint: forcetypeassert
+				if !ng.Valid {
+					return b, false, nil
+				}
+				return appendString(b, num, ng.GeographyVal), true, nil
+			}, nil
+		}
+	case bigquery.BytesFieldType:
+		if rt.Kind() == reflect.Slice && rt.Elem().Kind() == reflect.Uint8 {
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				if v.IsNil() {
+					return b, false, nil
+				}
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				return protowire.AppendBytes(b, v.Bytes()), true, nil
+			}, nil
+		}
+	case bigquery.IntegerFieldType:
+		switch rt.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				return appendVarint(b, num, uint64(v.Int())), true, nil
+			}, nil
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				return appendVarint(b, num, v.Uint()), true, nil
+			}, nil
+		}
+		if rt == typeOfNullInt64 {
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				ni := v.Interface().(bigquery.NullInt64) //nolint: forcetypeassert
+				if !ni.Valid {
+					return b, false, nil
+				}
+				return appendVarint(b, num, uint64(ni.Int64)), true, nil
+			}, nil
+		}
+	case bigquery.FloatFieldType:
+		switch {
+		case rt.Kind() == reflect.Float32 || rt.Kind() == reflect.Float64:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				return appendDouble(b, num, v.Float()), true, nil
+			}, nil
+		case rt == typeOfNullFloat64:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				nf := v.Interface().(bigquery.NullFloat64) //nolint: forcetypeassert
+				if !nf.Valid {
+					return b, false, nil
+				}
+				return appendDouble(b, num, nf.Float64), true, nil
+			}, nil
+		}
+	case bigquery.BooleanFieldType:
+		switch {
+		case rt.Kind() == reflect.Bool:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				return appendVarint(b, num, protowire.EncodeBool(v.Bool())), true, nil
+			}, nil
+		case rt == typeOfNullBool:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				nb := v.Interface().(bigquery.NullBool) //nolint: forcetypeassert
+				if !nb.Valid {
+					return b, false, nil
+				}
+				return appendVarint(b, num, protowire.EncodeBool(nb.Bool)), true, nil
+			}, nil
+		}
+	case bigquery.TimestampFieldType:
+		switch rt {
+		case typeOfTime:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				t := v.Interface().(time.Time) //nolint: forcetypeassert
+				return appendVarint(b, num, uint64(timestampMicros(t))), true, nil
+			}, nil
+		case typeOfNullTimestamp:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				nt := v.Interface().(bigquery.NullTimestamp) //nolint: forcetypeassert
+				if !nt.Valid {
+					return b, false, nil
+				}
+				return appendVarint(b, num, uint64(timestampMicros(nt.Timestamp))), true, nil
+			}, nil
+		}
+	case bigquery.DateFieldType:
+		switch rt {
+		case typeOfDate:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				d := v.Interface().(civil.Date) //nolint: forcetypeassert
+				return appendVarint(b, num, uint64(int64(dateDays(d)))), true, nil
+			}, nil
+		case typeOfNullDate:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				nd := v.Interface().(bigquery.NullDate) //nolint: forcetypeassert
+				if !nd.Valid {
+					return b, false, nil
+				}
+				return appendVarint(b, num, uint64(int64(dateDays(nd.Date)))), true, nil
+			}, nil
+		}
+	case bigquery.TimeFieldType:
+		switch rt {
+		case typeOfCivilTime:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				t := v.Interface().(civil.Time) //nolint: forcetypeassert
+				return appendVarint(b, num, uint64(packedTimeMicros(t))), true, nil
+			}, nil
+		case typeOfNullCivilTime:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				nt := v.Interface().(bigquery.NullTime) //nolint: forcetypeassert
+				if !nt.Valid {
+					return b, false, nil
+				}
+				return appendVarint(b, num, uint64(packedTimeMicros(nt.Time))), true, nil
+			}, nil
+		}
+	case bigquery.DateTimeFieldType:
+		switch rt {
+		case typeOfDateTime:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				dt := v.Interface().(civil.DateTime) //nolint: forcetypeassert
+				return appendVarint(b, num, uint64(packedDateTimeMicros(dt))), true, nil
+			}, nil
+		case typeOfNullDateTime:
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				ndt := v.Interface().(bigquery.NullDateTime) //nolint: forcetypeassert
+				if !ndt.Valid {
+					return b, false, nil
+				}
+				return appendVarint(b, num, uint64(packedDateTimeMicros(ndt.DateTime))), true, nil
+			}, nil
+		}
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		if rt == typeOfRat {
+			scale := numericScale
+			if field.Type == bigquery.BigNumericFieldType {
+				scale = bigNumericScale
+			}
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				r := v.Interface().(*big.Rat) //nolint: forcetypeassert
+				if r == nil {
+					return b, false, nil
+				}
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				return protowire.AppendBytes(b, encodeDecimal(r, scale)), true, nil
+			}, nil
+		}
+	case bigquery.RecordFieldType:
+		if rt.Kind() == reflect.Struct {
+			nested, err := compileStructPlan(rt, field.Schema, fd.Message())
+			if err != nil {
+				return nil, fmt.Errorf("schema field %q: %w", field.Name, err)
+			}
+			return func(b []byte, v reflect.Value) ([]byte, bool, error) {
+				msg, err := nested.appendStruct(nil, v)
+				if err != nil {
+					return nil, false, err
+				}
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				return protowire.AppendBytes(b, msg), true, nil
+			}, nil
+		}
+	}
+	return incompatible()
+}
+
+func appendVarint(b []byte, num protowire.Number, x uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, x)
+}
+
+func appendDouble(b []byte, num protowire.Number, f float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(f))
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// timestampMicros returns the time as the amount of microseconds since the unix epoch,
+// the wire format expected for a TIMESTAMP field by the Storage Write API.
+func timestampMicros(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+var unixEpochDate = civil.Date{Year: 1970, Month: time.January, Day: 1}
+
+// dateDays returns the date as the amount of days since the unix epoch,
+// the wire format expected for a DATE field by the Storage Write API.
+func dateDays(d civil.Date) int32 {
+	return int32(d.DaysSince(unixEpochDate))
+}
+
+// packedTimeMicros returns the time packed as a 64-bit integer,
+// the wire format expected for a TIME field by the Storage Write API:
+//
+//	(hour << 12 | minute << 6 | second) << 20 | microseconds
+func packedTimeMicros(t civil.Time) int64 {
+	seconds := int64(t.Hour)<<12 | int64(t.Minute)<<6 | int64(t.Second)
+	return seconds<<20 | int64(t.Nanosecond/1e3)
+}
+
+// packedDateTimeMicros returns the datetime packed as a 64-bit integer,
+// the wire format expected for a DATETIME field by the Storage Write API:
+//
+//	(year << 26 | month << 22 | day << 17 | hour << 12 | minute << 6 | second) << 20 | microseconds
+func packedDateTimeMicros(dt civil.DateTime) int64 {
+	seconds := int64(dt.Date.Year)<<26 | int64(dt.Date.Month)<<22 | int64(dt.Date.Day)<<17 |
+		int64(dt.Time.Hour)<<12 | int64(dt.Time.Minute)<<6 | int64(dt.Time.Second)
+	return seconds<<20 | int64(dt.Time.Nanosecond/1e3)
+}
+
+const (
+	// numericScale is the amount of decimal digits of a NUMERIC value.
+	numericScale = 9
+	// bigNumericScale is the amount of decimal digits of a BIGNUMERIC value.
+	bigNumericScale = 38
+)
+
+// encodeDecimal encodes the rational number, rounded to the given scale (half away from zero),
+// as the little-endian two's complement of its scaled integer value,
+// the wire format expected for a NUMERIC or BIGNUMERIC field by the Storage Write API.
+func encodeDecimal(r *big.Rat, scale int) []byte {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	num, denom := scaled.Num(), scaled.Denom()
+	quo, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	// round half away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(denom) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return twosComplementLE(quo)
+}
+
+// twosComplementLE returns the minimal little-endian two's complement representation of x.
+func twosComplementLE(x *big.Int) []byte {
+	n := x.BitLen()/8 + 1
+	var be []byte
+	if x.Sign() >= 0 {
+		be = x.Bytes()
+	} else {
+		be = new(big.Int).Add(x, new(big.Int).Lsh(big.NewInt(1), uint(8*n))).Bytes()
+	}
+	le := make([]byte, n)
+	for i := 0; i < len(be); i++ {
+		le[i] = be[len(be)-1-i]
+	}
+	if x.Sign() < 0 {
+		for i := len(be); i < n; i++ {
+			le[i] = 0xff
+		}
+	}
+	return le
+}
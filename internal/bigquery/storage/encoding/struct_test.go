@@ -0,0 +1,279 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type structEncoderAddress struct {
+	Street string `bigquery:"street"`
+	Number *int32 `bigquery:"number"`
+}
+
+type structEncoderBase struct {
+	ID int64 `bigquery:"id"`
+}
+
+type structEncoderRow struct {
+	structEncoderBase
+	Name     string                 `bigquery:"name"`
+	Nickname *string                `bigquery:"nickname"`
+	Score    float64                `bigquery:"score"`
+	Active   bool                   `bigquery:"active"`
+	Tags     []string               `bigquery:"tags"`
+	Data     []byte                 `bigquery:"data"`
+	Count    bigquery.NullInt64     `bigquery:"count"`
+	Address  structEncoderAddress   `bigquery:"address"`
+	History  []structEncoderAddress `bigquery:"history"`
+	Ignored  string                 `bigquery:"-"`
+}
+
+var structEncoderRowSchema = bigquery.Schema{
+	{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "nickname", Type: bigquery.StringFieldType},
+	{Name: "score", Type: bigquery.FloatFieldType},
+	{Name: "active", Type: bigquery.BooleanFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "data", Type: bigquery.BytesFieldType},
+	{Name: "count", Type: bigquery.IntegerFieldType},
+	{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "street", Type: bigquery.StringFieldType},
+		{Name: "number", Type: bigquery.IntegerFieldType},
+	}},
+	{Name: "history", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "street", Type: bigquery.StringFieldType},
+		{Name: "number", Type: bigquery.IntegerFieldType},
+	}},
+	{Name: "unmapped", Type: bigquery.StringFieldType},
+}
+
+func newStructEncoderRow() *structEncoderRow {
+	nickname := "bar"
+	number := int32(42)
+	return &structEncoderRow{
+		structEncoderBase: structEncoderBase{ID: -7},
+		Name:              "foo",
+		Nickname:          &nickname,
+		Score:             0.5,
+		Active:            true,
+		Tags:              []string{"a", "b"},
+		Data:              []byte{1, 2, 3},
+		Count:             bigquery.NullInt64{Int64: 3, Valid: true},
+		Address:           structEncoderAddress{Street: "main", Number: &number},
+		History:           []structEncoderAddress{{Street: "old"}, {Street: "older", Number: &number}},
+		Ignored:           "ignored",
+	}
+}
+
+func unmarshalRow(t *testing.T, md protoreflect.MessageDescriptor, b []byte) *dynamicpb.Message {
+	t.Helper()
+	msg := dynamicpb.NewMessage(md)
+	test.AssertNoErrorFatal(t, proto.Unmarshal(b, msg))
+	return msg
+}
+
+func TestStructEncoderMatchesSchemaEncoder(t *testing.T) {
+	structEncoder, err := NewStructEncoder(reflect.TypeOf(structEncoderRow{}), structEncoderRowSchema)
+	test.AssertNoErrorFatal(t, err)
+	schemaEncoder, err := NewSchemaEncoder(structEncoderRowSchema)
+	test.AssertNoErrorFatal(t, err)
+
+	rows := []*structEncoderRow{
+		newStructEncoderRow(),
+		{Name: "minimal"},
+	}
+	for _, row := range rows {
+		for _, data := range []interface{}{row, *row} {
+			structRows, err := structEncoder.EncodeRows(data)
+			test.AssertNoError(t, err)
+			test.AssertEqual(t, 1, len(structRows))
+			schemaRows, err := schemaEncoder.EncodeRows(data)
+			test.AssertNoError(t, err)
+
+			structMsg := unmarshalRow(t, schemaEncoder.md, structRows[0])
+			schemaMsg := unmarshalRow(t, schemaEncoder.md, schemaRows[0])
+			if !proto.Equal(structMsg, schemaMsg) {
+				t.Errorf("struct encoded message %v differs from schema encoded message %v", structMsg, schemaMsg)
+			}
+		}
+	}
+}
+
+type structEncoderTemporalRow struct {
+	Timestamp time.Time            `bigquery:"ts"`
+	Date      civil.Date           `bigquery:"date"`
+	Time      civil.Time           `bigquery:"time"`
+	DateTime  civil.DateTime       `bigquery:"datetime"`
+	Numeric   *big.Rat             `bigquery:"numeric"`
+	Big       *big.Rat             `bigquery:"bignumeric"`
+	NullDate  bigquery.NullDate    `bigquery:"null_date"`
+	NullTS    *time.Time           `bigquery:"null_ts"`
+	NullTime  bigquery.NullTime    `bigquery:"null_time"`
+	NullFloat bigquery.NullFloat64 `bigquery:"null_float"`
+}
+
+func TestStructEncoderTemporalAndNumericValues(t *testing.T) {
+	rowSchema := bigquery.Schema{
+		{Name: "ts", Type: bigquery.TimestampFieldType},
+		{Name: "date", Type: bigquery.DateFieldType},
+		{Name: "time", Type: bigquery.TimeFieldType},
+		{Name: "datetime", Type: bigquery.DateTimeFieldType},
+		{Name: "numeric", Type: bigquery.NumericFieldType},
+		{Name: "bignumeric", Type: bigquery.BigNumericFieldType},
+		{Name: "null_date", Type: bigquery.DateFieldType},
+		{Name: "null_ts", Type: bigquery.TimestampFieldType},
+		{Name: "null_time", Type: bigquery.TimeFieldType},
+		{Name: "null_float", Type: bigquery.FloatFieldType},
+	}
+	encoder, err := NewStructEncoder(reflect.TypeOf(structEncoderTemporalRow{}), rowSchema)
+	test.AssertNoErrorFatal(t, err)
+
+	rows, err := encoder.EncodeRows(&structEncoderTemporalRow{
+		Timestamp: time.Date(2021, time.November, 12, 10, 30, 15, 123456789, time.UTC),
+		Date:      civil.Date{Year: 1969, Month: time.December, Day: 31},
+		Time:      civil.Time{Hour: 12, Minute: 34, Second: 56, Nanosecond: 789000},
+		DateTime: civil.DateTime{
+			Date: civil.Date{Year: 2021, Month: time.November, Day: 12},
+			Time: civil.Time{Hour: 1, Minute: 2, Second: 3, Nanosecond: 4000},
+		},
+		Numeric:   big.NewRat(-1, 2),
+		Big:       big.NewRat(1, 1),
+		NullDate:  bigquery.NullDate{Date: civil.Date{Year: 1970, Month: time.January, Day: 2}, Valid: true},
+		NullFloat: bigquery.NullFloat64{Float64: 1.5, Valid: true},
+	})
+	test.AssertNoError(t, err)
+	msg := unmarshalRow(t, encoder.fallback.md, rows[0])
+	fields := encoder.fallback.md.Fields()
+
+	test.AssertEqual(t, int64(1636713015123456), msg.Get(fields.ByName("ts")).Int())
+	test.AssertEqual(t, int64(-1), msg.Get(fields.ByName("date")).Int())
+	test.AssertEqual(t, int64((12<<12|34<<6|56)<<20|789), msg.Get(fields.ByName("time")).Int())
+	test.AssertEqual(t, int64((2021<<26|11<<22|12<<17|1<<12|2<<6|3)<<20|4), msg.Get(fields.ByName("datetime")).Int())
+	// -0.5 * 1e9 = -500000000 = 0xE2329B00 (two's complement)
+	test.AssertEqual(t, []byte{0x00, 0x9b, 0x32, 0xe2}, msg.Get(fields.ByName("numeric")).Bytes())
+	test.AssertEqual(t, twosComplementLE(new(big.Int).Exp(big.NewInt(10), big.NewInt(38), nil)), msg.Get(fields.ByName("bignumeric")).Bytes())
+	test.AssertEqual(t, int64(1), msg.Get(fields.ByName("null_date")).Int())
+	test.AssertFalse(t, msg.Has(fields.ByName("null_ts")))
+	test.AssertFalse(t, msg.Has(fields.ByName("null_time")))
+	test.AssertEqual(t, 1.5, msg.Get(fields.ByName("null_float")).Float())
+}
+
+func TestTwosComplementLE(t *testing.T) {
+	testCases := []struct {
+		Value    int64
+		Expected []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-1, []byte{0xff}},
+		{-129, []byte{0x7f, 0xff}},
+	}
+	for _, testCase := range testCases {
+		test.AssertEqual(t, testCase.Expected, twosComplementLE(big.NewInt(testCase.Value)))
+	}
+}
+
+func TestStructEncoderFallback(t *testing.T) {
+	encoder, err := NewStructEncoder(reflect.TypeOf(structEncoderRow{}), structEncoderRowSchema)
+	test.AssertNoErrorFatal(t, err)
+	rows, err := encoder.EncodeRows([]byte(`{"id": 1, "name": "json"}`))
+	test.AssertNoError(t, err)
+	msg := unmarshalRow(t, encoder.fallback.md, rows[0])
+	test.AssertEqual(t, "json", msg.Get(encoder.fallback.md.Fields().ByName("name")).String())
+}
+
+func TestStructEncoderErrors(t *testing.T) {
+	_, err := NewStructEncoder(nil, structEncoderRowSchema)
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+
+	type incompatibleRow struct {
+		Name int `bigquery:"name"`
+	}
+	_, err = NewStructEncoder(reflect.TypeOf(incompatibleRow{}), SimpleNameOnlyMessageSchema)
+	test.AssertIsError(t, err, ErrIncompatibleStructField)
+
+	type missingRequiredRow struct {
+		Value int `bigquery:"value"`
+	}
+	_, err = NewStructEncoder(reflect.TypeOf(missingRequiredRow{}), SimpleMessageSchema)
+	test.AssertIsError(t, err, ErrIncompatibleStructField)
+
+	type nilRequiredRow struct {
+		Name *string `bigquery:"name"`
+	}
+	encoder, err := NewStructEncoder(reflect.TypeOf(nilRequiredRow{}), SimpleMessageSchema)
+	test.AssertNoErrorFatal(t, err)
+	_, err = encoder.EncodeRows(nilRequiredRow{})
+	test.AssertIsError(t, err, ErrMissingRequiredValue)
+	_, err = encoder.EncodeRows((*nilRequiredRow)(nil))
+	test.AssertIsError(t, err, ErrInvalidData)
+	_, err = encoder.EncodeRows(nil)
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+}
+
+func TestStructEncoderWithSchema(t *testing.T) {
+	encoder, err := NewStructEncoder(reflect.TypeOf(structEncoderRow{}), SimpleNameOnlyMessageSchema)
+	test.AssertNoErrorFatal(t, err)
+	evolved, err := encoder.WithSchema(structEncoderRowSchema)
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, structEncoderRowSchema, evolved.Schema())
+	_, ok := evolved.(*StructEncoder)
+	test.AssertTrue(t, ok)
+}
+
+func BenchmarkStructEncoder(b *testing.B) {
+	encoder, err := NewStructEncoder(reflect.TypeOf(structEncoderRow{}), structEncoderRowSchema)
+	if err != nil {
+		b.Fatal(err)
+	}
+	row := newStructEncoderRow()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := encoder.EncodeRows(row); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSchemaEncoderStruct(b *testing.B) {
+	encoder, err := NewSchemaEncoder(structEncoderRowSchema)
+	if err != nil {
+		b.Fatal(err)
+	}
+	row := newStructEncoderRow()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := encoder.EncodeRows(row); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testdata
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Proto3MessageDescriptor returns the descriptor of a (dynamic) proto3 message,
+// defining fields with implicit and explicit presence as well as an (open) enum,
+// equivalent to the following proto3 definition:
+//
+//	message Proto3Message {
+//	    string name = 1;
+//	    int64 count = 2;
+//	    optional int64 limit = 3;
+//	    Status status = 4;
+//	    repeated string tags = 5;
+//	    bool active = 6;
+//	    Nested nested = 7;
+//
+//	    enum Status {
+//	        STATUS_UNSPECIFIED = 0;
+//	        STATUS_ACTIVE = 1;
+//	    }
+//
+//	    message Nested {
+//	        string label = 1;
+//	    }
+//	}
+//
+// No code is generated for it, as messages of it can be created using dynamicpb instead.
+func Proto3MessageDescriptor() protoreflect.MessageDescriptor {
+	return proto3MessageDescriptor
+}
+
+var proto3MessageDescriptor = func() protoreflect.MessageDescriptor {
+	field := func(name string, number int32, fieldType descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   fieldType.Enum(),
+		}
+	}
+	limit := field("limit", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64)
+	limit.Proto3Optional = proto.Bool(true)
+	limit.OneofIndex = proto.Int32(0)
+	status := field("status", 4, descriptorpb.FieldDescriptorProto_TYPE_ENUM)
+	status.TypeName = proto.String(".testdata.Proto3Message.Status")
+	tags := field("tags", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	tags.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	nested := field("nested", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	nested.TypeName = proto.String(".testdata.Proto3Message.Nested")
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("bqwriter/testdata/proto3_message.proto"),
+		Package: proto.String("testdata"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Proto3Message"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+				limit,
+				status,
+				tags,
+				field("active", 6, descriptorpb.FieldDescriptorProto_TYPE_BOOL),
+				nested,
+			},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_limit")}},
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name: proto.String("Status"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("STATUS_UNSPECIFIED"), Number: proto.Int32(0)},
+					{Name: proto.String("STATUS_ACTIVE"), Number: proto.Int32(1)},
+				},
+			}},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name:  proto.String("Nested"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("label", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING)},
+			}},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	return fd.Messages().Get(0)
+}()
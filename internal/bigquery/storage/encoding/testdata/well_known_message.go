@@ -0,0 +1,110 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testdata
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// register the well-known types referenced by the message
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// WellKnownMessageDescriptor returns the descriptor of a (dynamic) message
+// defining fields of the well-known types, equivalent to the following proto3 definition:
+//
+//	message WellKnownMessage {
+//	    string name = 1;
+//	    google.protobuf.Timestamp created_at = 2;
+//	    google.protobuf.Duration elapsed = 3;
+//	    google.protobuf.Int64Value count = 4;
+//	    google.protobuf.UInt64Value total = 5;
+//	    google.protobuf.StringValue label = 6;
+//	    google.protobuf.Struct attributes = 7;
+//	    repeated google.protobuf.Timestamp events = 8;
+//	    Nested nested = 9;
+//
+//	    message Nested {
+//	        google.protobuf.BoolValue enabled = 1;
+//	        google.protobuf.DoubleValue ratio = 2;
+//	    }
+//	}
+//
+// No code is generated for it, as messages of it can be created using dynamicpb instead.
+func WellKnownMessageDescriptor() protoreflect.MessageDescriptor {
+	return wellKnownMessageDescriptor
+}
+
+var wellKnownMessageDescriptor = func() protoreflect.MessageDescriptor {
+	field := func(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			TypeName: proto.String(typeName),
+		}
+	}
+	events := field("events", 8, ".google.protobuf.Timestamp")
+	events.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("bqwriter/testdata/well_known_message.proto"),
+		Package: proto.String("testdata"),
+		Syntax:  proto.String("proto3"),
+		Dependency: []string{
+			"google/protobuf/duration.proto",
+			"google/protobuf/struct.proto",
+			"google/protobuf/timestamp.proto",
+			"google/protobuf/wrappers.proto",
+		},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("WellKnownMessage"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{
+					Name:   proto.String("name"),
+					Number: proto.Int32(1),
+					Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				},
+				field("created_at", 2, ".google.protobuf.Timestamp"),
+				field("elapsed", 3, ".google.protobuf.Duration"),
+				field("count", 4, ".google.protobuf.Int64Value"),
+				field("total", 5, ".google.protobuf.UInt64Value"),
+				field("label", 6, ".google.protobuf.StringValue"),
+				field("attributes", 7, ".google.protobuf.Struct"),
+				events,
+				field("nested", 9, ".testdata.WellKnownMessage.Nested"),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Nested"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("enabled", 1, ".google.protobuf.BoolValue"),
+					field("ratio", 2, ".google.protobuf.DoubleValue"),
+				},
+			}},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	return fd.Messages().Get(0)
+}()
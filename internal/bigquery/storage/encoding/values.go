@@ -0,0 +1,176 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/value"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// setMessageValues sets the given (row) values on the message, walking its descriptor
+// together with the BigQuery schema it was derived from. Values are matched to fields by name,
+// case-insensitive, and nil (or invalid bigquery.NullX) values are skipped.
+//
+// An ErrUnknownField error is returned in case a value is given for a field not defined in the schema.
+func setMessageValues(msg protoreflect.Message, schema bigquery.Schema, values map[string]bigquery.Value) error {
+	fields := msg.Descriptor().Fields()
+	for key, v := range values {
+		field := value.FieldByName(schema, key)
+		if field == nil {
+			return unknownFieldError{err: fmt.Errorf("%w: %q", ErrUnknownField, key)}
+		}
+		fd := fields.ByName(protoreflect.Name(field.Name))
+		if fd == nil {
+			return unknownFieldError{err: fmt.Errorf("%w: %q: no matching proto field", ErrUnknownField, key)}
+		}
+		if err := setFieldValue(msg, fd, field, v); err != nil {
+			return fmt.Errorf("field %q: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// setRowValues sets the values of the given row (see value.ToRow) on the message,
+// see setMessageValues for more information.
+func setRowValues(msg protoreflect.Message, schema bigquery.Schema, row interface{}) error {
+	values, ok, err := value.ToRow(schema, row)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %T is not a supported row value", ErrInvalidData, row)
+	}
+	return setMessageValues(msg, schema, values)
+}
+
+func setFieldValue(msg protoreflect.Message, fd protoreflect.FieldDescriptor, field *bigquery.FieldSchema, v interface{}) error {
+	v, ok := value.Deref(v)
+	if !ok {
+		return nil // no value to set
+	}
+	if !field.Repeated {
+		pv, err := protoValue(func() protoreflect.Message { return msg.NewField(fd).Message() }, field, v)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, pv)
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Errorf("%w: repeated field expects a slice or array, got %T", ErrInvalidData, v)
+	}
+	list := msg.Mutable(fd).List()
+	for i := 0; i < rv.Len(); i++ {
+		elem, ok := value.Deref(rv.Index(i).Interface())
+		if !ok {
+			return fmt.Errorf("element #%d: %w: repeated fields cannot contain null values", i, ErrInvalidData)
+		}
+		pv, err := protoValue(func() protoreflect.Message { return list.NewElement().Message() }, field, elem)
+		if err != nil {
+			return fmt.Errorf("element #%d: %w", i, err)
+		}
+		list.Append(pv)
+	}
+	return nil
+}
+
+// protoValue converts a single (non-repeated and non-nil) value to the proto value of the given field,
+// based on its BigQuery type. The newMessage function is used to create a nested message for a RECORD field.
+func protoValue(newMessage func() protoreflect.Message, field *bigquery.FieldSchema, v interface{}) (protoreflect.Value, error) {
+	switch field.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		switch typedValue := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(typedValue), nil
+		case *structpb.Struct, *structpb.Value, *structpb.ListValue:
+			// written as its Json encoded string
+			return wellKnownValue(typedValue.(proto.Message).ProtoReflect()) //nolint: forcetypeassert
+		}
+	case bigquery.BytesFieldType:
+		if b, ok := v.([]byte); ok {
+			return protoreflect.ValueOfBytes(b), nil
+		}
+	case bigquery.IntegerFieldType:
+		if d, ok := v.(time.Duration); ok {
+			// written as its amount of microseconds, the same as a google.protobuf.Duration
+			return protoreflect.ValueOfInt64(d.Microseconds()), nil
+		}
+		if i, ok := value.ToInt64(v); ok {
+			return protoreflect.ValueOfInt64(i), nil
+		}
+	case bigquery.FloatFieldType:
+		if f, ok := value.ToFloat64(v); ok {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	case bigquery.BooleanFieldType:
+		if b, ok := value.ToBool(v); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case bigquery.TimestampFieldType:
+		if t, ok := value.ToTimestamp(v); ok {
+			return protoreflect.ValueOfInt64(timestampMicros(t)), nil
+		}
+		// raw value, expected to be in microseconds since the unix epoch
+		if i, ok := value.ToInt64(v); ok {
+			return protoreflect.ValueOfInt64(i), nil
+		}
+	case bigquery.DateFieldType:
+		if d, ok := value.ToDate(v); ok {
+			return protoreflect.ValueOfInt32(dateDays(d)), nil
+		}
+		// raw value, expected to be in days since the unix epoch
+		if i, ok := value.ToInt64(v); ok {
+			return protoreflect.ValueOfInt32(int32(i)), nil
+		}
+	case bigquery.TimeFieldType:
+		if t, ok := value.ToTime(v); ok {
+			return protoreflect.ValueOfInt64(packedTimeMicros(t)), nil
+		}
+	case bigquery.DateTimeFieldType:
+		if dt, ok := value.ToDateTime(v); ok {
+			return protoreflect.ValueOfInt64(packedDateTimeMicros(dt)), nil
+		}
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		scale := numericScale
+		if field.Type == bigquery.BigNumericFieldType {
+			scale = bigNumericScale
+		}
+		if r, ok := value.ToRat(v); ok {
+			return protoreflect.ValueOfBytes(encodeDecimal(r, scale)), nil
+		}
+	case bigquery.RecordFieldType:
+		values, ok, err := value.ToRow(field.Schema, v)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%w: %v", ErrInvalidData, err)
+		}
+		if !ok {
+			break
+		}
+		nested := newMessage()
+		if err := setMessageValues(nested, field.Schema, values); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(nested), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("%w: %T cannot be encoded as %s", ErrInvalidData, v, field.Type)
+}
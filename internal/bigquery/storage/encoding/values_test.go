@@ -0,0 +1,160 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var valuesSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "value", Type: bigquery.IntegerFieldType},
+	{Name: "score", Type: bigquery.FloatFieldType},
+	{Name: "active", Type: bigquery.BooleanFieldType},
+	{Name: "ts", Type: bigquery.TimestampFieldType},
+	{Name: "date", Type: bigquery.DateFieldType},
+	{Name: "time", Type: bigquery.TimeFieldType},
+	{Name: "datetime", Type: bigquery.DateTimeFieldType},
+	{Name: "numeric", Type: bigquery.NumericFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "street", Type: bigquery.StringFieldType},
+	}},
+	{Name: "history", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "street", Type: bigquery.StringFieldType},
+	}},
+}
+
+type testValueSaver map[string]bigquery.Value
+
+func (vs testValueSaver) Save() (map[string]bigquery.Value, string, error) {
+	return vs, "", nil
+}
+
+func assertValuesMessage(t *testing.T, encoder *SchemaEncoder, rows [][]byte) {
+	t.Helper()
+	test.AssertEqual(t, 1, len(rows))
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	fields := encoder.md.Fields()
+	get := func(name string) protoreflect.Value {
+		return msg.Get(fields.ByName(protoreflect.Name(name)))
+	}
+	test.AssertEqual(t, "foo", get("name").String())
+	test.AssertEqual(t, int64(42), get("value").Int())
+	test.AssertEqual(t, 0.5, get("score").Float())
+	test.AssertTrue(t, get("active").Bool())
+	test.AssertEqual(t, int64(1636713015123456), get("ts").Int())
+	test.AssertEqual(t, int64(18943), get("date").Int())
+	test.AssertEqual(t, packedTimeMicros(civil.Time{Hour: 1, Minute: 2, Second: 3}), get("time").Int())
+	test.AssertEqual(t, packedDateTimeMicros(civil.DateTime{
+		Date: civil.Date{Year: 2021, Month: time.November, Day: 12},
+		Time: civil.Time{Hour: 1, Minute: 2, Second: 3},
+	}), get("datetime").Int())
+	test.AssertEqual(t, encodeDecimal(big.NewRat(3, 2), numericScale), get("numeric").Bytes())
+	test.AssertEqual(t, 2, get("tags").List().Len())
+	test.AssertEqual(t, "b", get("tags").List().Get(1).String())
+	test.AssertEqual(t, "main", get("address").Message().Get(fields.ByName("address").Message().Fields().ByName("street")).String())
+	test.AssertEqual(t, 2, get("history").List().Len())
+}
+
+func TestSchemaEncoderValues(t *testing.T) {
+	encoder, err := NewSchemaEncoder(valuesSchema)
+	test.AssertNoErrorFatal(t, err)
+
+	name := "foo"
+	typedValues := map[string]bigquery.Value{
+		"name":     &name,
+		"Value":    int32(42),
+		"score":    bigquery.NullFloat64{Float64: 0.5, Valid: true},
+		"active":   true,
+		"ts":       time.Date(2021, time.November, 12, 10, 30, 15, 123456789, time.UTC),
+		"date":     civil.Date{Year: 2021, Month: time.November, Day: 12},
+		"time":     civil.Time{Hour: 1, Minute: 2, Second: 3},
+		"datetime": civil.DateTime{Date: civil.Date{Year: 2021, Month: time.November, Day: 12}, Time: civil.Time{Hour: 1, Minute: 2, Second: 3}},
+		"numeric":  big.NewRat(3, 2),
+		"tags":     []string{"a", "b"},
+		"address":  map[string]interface{}{"street": "main"},
+		"history":  []map[string]bigquery.Value{{"street": "old"}, {"street": "older"}},
+		"unset":    nil,
+	}
+	delete(typedValues, "unset")
+	// string representations, as commonly used for insertAll rows
+	stringValues := map[string]interface{}{
+		"name":     "foo",
+		"value":    json.Number("42"),
+		"score":    "0.5",
+		"active":   "true",
+		"ts":       "2021-11-12T10:30:15.123456789Z",
+		"date":     "2021-11-12",
+		"time":     "01:02:03",
+		"datetime": "2021-11-12T01:02:03",
+		"numeric":  "1.5",
+		"tags":     []interface{}{"a", "b"},
+		"address":  testValueSaver{"street": "main"},
+		"history":  []interface{}{map[string]interface{}{"street": "old"}, testValueSaver{"street": "older"}},
+		"nickname": bigquery.NullString{},
+	}
+	delete(stringValues, "nickname")
+
+	for _, data := range []interface{}{typedValues, testValueSaver(typedValues), stringValues} {
+		rows, err := encoder.EncodeRows(data)
+		test.AssertNoError(t, err)
+		assertValuesMessage(t, encoder, rows)
+	}
+}
+
+func TestSchemaEncoderValuesNullSkipped(t *testing.T) {
+	encoder, err := NewSchemaEncoder(SimpleMessageSchema)
+	test.AssertNoErrorFatal(t, err)
+	rows, err := encoder.EncodeRows(map[string]interface{}{
+		"name":  "foo",
+		"value": bigquery.NullInt64{},
+	})
+	test.AssertNoError(t, err)
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	test.AssertFalse(t, msg.Has(encoder.md.Fields().ByName("value")))
+}
+
+func TestSchemaEncoderValuesErrors(t *testing.T) {
+	encoder, err := NewSchemaEncoder(valuesSchema)
+	test.AssertNoErrorFatal(t, err)
+
+	_, err = encoder.EncodeRows(map[string]interface{}{"unknown": 42})
+	test.AssertIsError(t, err, ErrUnknownField)
+	_, err = encoder.EncodeRows(map[string]interface{}{"address": map[string]interface{}{"unknown": 42}})
+	test.AssertIsError(t, err, ErrUnknownField)
+
+	testCases := []map[string]bigquery.Value{
+		{"value": "not a number"},
+		{"value": 0.5},
+		{"name": 42},
+		{"tags": "a"},
+		{"tags": []interface{}{"a", nil}},
+		{"address": "main"},
+		{"date": "2021-13-45"},
+	}
+	for _, testCase := range testCases {
+		_, err := encoder.EncodeRows(testCase)
+		test.AssertIsError(t, err, ErrInvalidData)
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// wellKnownTypes defines the scalar (wire) type to which each of the supported
+// well-known protobuf message types is normalized, as the BigQuery Storage Write API
+// does not support (yet) these message types itself:
+//   - google.protobuf.Timestamp is written as an int64 with the microseconds since the unix epoch (TIMESTAMP);
+//   - google.protobuf.Duration is written as an int64 with its amount of microseconds (INTEGER);
+//   - the wrapper types (e.g. google.protobuf.Int64Value) are written as their (optional) scalar value,
+//     with the unsigned integers written as an int64, given BigQuery has no unsigned integer type;
+//   - google.protobuf.Struct, Value and ListValue are written as their Json encoded string (STRING).
+var wellKnownTypes = map[protoreflect.FullName]descriptorpb.FieldDescriptorProto_Type{
+	"google.protobuf.Timestamp":   descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"google.protobuf.Duration":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"google.protobuf.DoubleValue": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"google.protobuf.FloatValue":  descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"google.protobuf.Int64Value":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"google.protobuf.UInt64Value": descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"google.protobuf.Int32Value":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"google.protobuf.UInt32Value": descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"google.protobuf.BoolValue":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"google.protobuf.StringValue": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"google.protobuf.BytesValue":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	"google.protobuf.Struct":      descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"google.protobuf.Value":       descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"google.protobuf.ListValue":   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+}
+
+// wellKnownTypeName returns the full name of the well-known type referenced by the given type name,
+// which can either be a fully qualified reference (e.g. ".google.protobuf.Timestamp") or the name of
+// a nested type as normalized by adapt.NormalizeDescriptor (e.g. "google_protobuf_Timestamp").
+func wellKnownTypeName(typeName string) (protoreflect.FullName, bool) {
+	name := protoreflect.FullName(strings.TrimPrefix(typeName, "."))
+	if _, ok := wellKnownTypes[name]; ok {
+		return name, true
+	}
+	// nested types are normalized by replacing the dots of their full name with underscores
+	name = protoreflect.FullName(strings.ReplaceAll(string(name.Name()), "_", "."))
+	if _, ok := wellKnownTypes[name]; ok {
+		return name, true
+	}
+	return "", false
+}
+
+// NormalizeDescriptor builds a self-contained descriptor proto for the given message descriptor,
+// suitable for the BigQuery Storage Write API, the same way as adapt.NormalizeDescriptor does,
+// with the exception that the well-known types are normalized to their scalar (wire) type
+// rather than being nested as a message. Messages of the given descriptor can be encoded
+// using a ProtobufEncoder created for the returned descriptor (see NewProtobufEncoderForDescriptor).
+func NormalizeDescriptor(md protoreflect.MessageDescriptor) (*descriptorpb.DescriptorProto, error) {
+	if md == nil {
+		return nil, fmt.Errorf("normalize descriptor: %w: nil message descriptor", ErrInvalidData)
+	}
+	root := new(descriptorpb.DescriptorProto)
+	if err := normalizeDescriptor(md, root, root, map[protoreflect.FullName]bool{}, map[protoreflect.FullName]bool{}); err != nil {
+		return nil, fmt.Errorf("normalize descriptor: %w", err)
+	}
+	return root, nil
+}
+
+func normalizeDescriptor(md protoreflect.MessageDescriptor, dp, root *descriptorpb.DescriptorProto, visited, defined map[protoreflect.FullName]bool) error {
+	dp.Name = proto.String(normalizeTypeName(md.FullName()))
+	visited[md.FullName()] = true
+	defer delete(visited, md.FullName())
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		fdp := protodesc.ToFieldDescriptorProto(fd)
+		switch fd.Kind() {
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			msgName := fd.Message().FullName()
+			if wireType, ok := wellKnownTypes[msgName]; ok {
+				setWellKnownFieldType(fdp, wireType)
+				break
+			}
+			nestedName := normalizeTypeName(msgName)
+			fdp.TypeName = proto.String(nestedName)
+			if defined[msgName] {
+				break
+			}
+			if visited[msgName] {
+				return fmt.Errorf("field %s: %w: recursive message type %s", fd.FullName(), ErrInvalidData, msgName)
+			}
+			nested := new(descriptorpb.DescriptorProto)
+			if err := normalizeDescriptor(fd.Message(), nested, root, visited, defined); err != nil {
+				return err
+			}
+			root.NestedType = append(root.NestedType, nested)
+			defined[msgName] = true
+		case protoreflect.EnumKind:
+			if isOpenEnum(fd.Enum()) {
+				// open (proto3) enums are normalized as an int32,
+				// as to allow values which are not defined by the enum
+				fdp.Type = descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()
+				fdp.TypeName = nil
+				break
+			}
+			// enums are wrapped in an enclosing message, as to avoid conflicts between their values
+			enclosingName := normalizeTypeName(fd.Enum().FullName()) + "_E"
+			fdp.TypeName = proto.String(enclosingName + "." + string(fd.Enum().Name()))
+			if !defined[fd.Enum().FullName()] {
+				root.NestedType = append(root.NestedType, &descriptorpb.DescriptorProto{
+					Name:     proto.String(enclosingName),
+					EnumType: []*descriptorpb.EnumDescriptorProto{protodesc.ToEnumDescriptorProto(fd.Enum())},
+				})
+				defined[fd.Enum().FullName()] = true
+			}
+		}
+		// oneofs are not part of the normalized descriptor,
+		// their fields are defined as regular optional fields instead
+		fdp.OneofIndex = nil
+		fdp.Proto3Optional = nil
+		fdp.JsonName = nil
+		dp.Field = append(dp.Field, fdp)
+	}
+	return nil
+}
+
+func normalizeTypeName(name protoreflect.FullName) string {
+	return strings.ReplaceAll(string(name), ".", "_")
+}
+
+func setWellKnownFieldType(fdp *descriptorpb.FieldDescriptorProto, wireType descriptorpb.FieldDescriptorProto_Type) {
+	fdp.Type = wireType.Enum()
+	fdp.TypeName = nil
+	if fdp.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		// a message is always optional, and so is its normalized value
+		fdp.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	}
+}
+
+// normalizeWellKnownTypes returns a copy of the given (normalized) descriptor proto,
+// with all fields referencing a well-known type normalized to their scalar (wire) type
+// and the nested well-known types it defines removed. The given descriptor is returned as-is,
+// in case it does not reference any well-known type.
+func normalizeWellKnownTypes(dp *descriptorpb.DescriptorProto) *descriptorpb.DescriptorProto {
+	if !referencesWellKnownTypes(dp) {
+		return dp
+	}
+	dp = proto.Clone(dp).(*descriptorpb.DescriptorProto) //nolint: forcetypeassert
+	var normalize func(dp *descriptorpb.DescriptorProto)
+	normalize = func(dp *descriptorpb.DescriptorProto) {
+		for _, fdp := range dp.GetField() {
+			if name, ok := wellKnownTypeName(fdp.GetTypeName()); ok {
+				setWellKnownFieldType(fdp, wellKnownTypes[name])
+			}
+		}
+		nestedTypes := dp.NestedType[:0]
+		for _, nested := range dp.GetNestedType() {
+			if strings.HasPrefix(nested.GetName(), "google_protobuf_") {
+				// nested well-known types (and their own nested types)
+				// as normalized by adapt.NormalizeDescriptor
+				continue
+			}
+			normalize(nested)
+			nestedTypes = append(nestedTypes, nested)
+		}
+		dp.NestedType = nestedTypes
+	}
+	normalize(dp)
+	return dp
+}
+
+func referencesWellKnownTypes(dp *descriptorpb.DescriptorProto) bool {
+	for _, fdp := range dp.GetField() {
+		if _, ok := wellKnownTypeName(fdp.GetTypeName()); ok {
+			return true
+		}
+	}
+	for _, nested := range dp.GetNestedType() {
+		if referencesWellKnownTypes(nested) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizationCache caches for message descriptors whether or not their messages
+// require to be normalized, see requiresNormalization for more information.
+type normalizationCache struct {
+	m sync.Map // protoreflect.FullName -> bool
+}
+
+func (c *normalizationCache) requiresNormalization(md protoreflect.MessageDescriptor) bool {
+	if required, ok := c.m.Load(md.FullName()); ok {
+		return required.(bool) //nolint: forcetypeassert
+	}
+	required := requiresNormalization(md, map[protoreflect.FullName]bool{})
+	c.m.Store(md.FullName(), required)
+	return required
+}
+
+// requiresNormalization returns true in case the message descriptor, or any of its nested message types,
+// is a proto3 message or defines fields of a well-known type, in which case its messages cannot be encoded as-is.
+func requiresNormalization(md protoreflect.MessageDescriptor, visited map[protoreflect.FullName]bool) bool {
+	if visited[md.FullName()] {
+		return false
+	}
+	visited[md.FullName()] = true
+	if md.Syntax() == protoreflect.Proto3 {
+		return true
+	}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsMap() {
+			fd = fd.MapValue()
+		}
+		if fd.Message() == nil {
+			continue
+		}
+		if _, ok := wellKnownTypes[fd.Message().FullName()]; ok {
+			return true
+		}
+		if requiresNormalization(fd.Message(), visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeMessage sets the fields of the src message on the dst message, which is expected
+// to be of the normalized (proto2) descriptor of the src message, converting the values of well-known types
+// to their scalar (wire) type. Fields are matched by their field number.
+//
+// Singular fields of a proto3 message without presence are set explicitly, even if they have their zero value,
+// such that they are written as such rather than as NULL, given the Storage Write API uses proto2 semantics.
+func normalizeMessage(src, dst protoreflect.Message) error {
+	var err error
+	dstFields := dst.Descriptor().Fields()
+	if err = setImplicitZeroValues(src, dst); err != nil {
+		return err
+	}
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		dstFd := dstFields.ByNumber(fd.Number())
+		if dstFd == nil {
+			err = fmt.Errorf("field %s: %w: field #%d is not defined in the normalized descriptor", fd.FullName(), ErrInvalidData, fd.Number())
+			return false
+		}
+		switch {
+		case fd.IsList():
+			srcList, dstList := v.List(), dst.Mutable(dstFd).List()
+			for i := 0; i < srcList.Len(); i++ {
+				var ev protoreflect.Value
+				if ev, err = normalizeValue(fd, dstFd, srcList.Get(i), dstList.NewElement); err != nil {
+					err = fmt.Errorf("field %s: element #%d: %w", fd.FullName(), i, err)
+					return false
+				}
+				dstList.Append(ev)
+			}
+		case fd.IsMap():
+			dstMap := dst.Mutable(dstFd).Map()
+			v.Map().Range(func(key protoreflect.MapKey, mv protoreflect.Value) bool {
+				if mv, err = normalizeValue(fd.MapValue(), dstFd.MapValue(), mv, dstMap.NewValue); err != nil {
+					err = fmt.Errorf("field %s: key %v: %w", fd.FullName(), key, err)
+					return false
+				}
+				dstMap.Set(key, mv)
+				return true
+			})
+			if err != nil {
+				return false
+			}
+		default:
+			if v, err = normalizeValue(fd, dstFd, v, func() protoreflect.Value { return dst.NewField(dstFd) }); err != nil {
+				err = fmt.Errorf("field %s: %w", fd.FullName(), err)
+				return false
+			}
+			dst.Set(dstFd, v)
+		}
+		return true
+	})
+	return err
+}
+
+func normalizeValue(fd, dstFd protoreflect.FieldDescriptor, v protoreflect.Value, newMessage func() protoreflect.Value) (protoreflect.Value, error) {
+	if fd.Message() == nil {
+		return normalizeEnumOrScalarValue(fd, dstFd, v)
+	}
+	if dstFd.Message() == nil {
+		// message normalized to a scalar (wire) type
+		return wellKnownValue(v.Message())
+	}
+	nested := newMessage()
+	if err := normalizeMessage(v.Message(), nested.Message()); err != nil {
+		return protoreflect.Value{}, err
+	}
+	return nested, nil
+}
+
+// wellKnownValue converts the message of a well-known type to its scalar (wire) value,
+// see the documentation of wellKnownTypes for more information.
+func wellKnownValue(m protoreflect.Message) (protoreflect.Value, error) {
+	fields := m.Descriptor().Fields()
+	switch name := m.Descriptor().FullName(); name {
+	case "google.protobuf.Timestamp", "google.protobuf.Duration":
+		seconds := m.Get(fields.ByName("seconds")).Int()
+		nanos := m.Get(fields.ByName("nanos")).Int()
+		return protoreflect.ValueOfInt64(seconds*1e6 + nanos/1e3), nil
+	case "google.protobuf.UInt64Value":
+		u := m.Get(fields.ByName("value")).Uint()
+		if u > math.MaxInt64 {
+			return protoreflect.Value{}, fmt.Errorf("%w: %s %d overflows int64", ErrInvalidData, name, u)
+		}
+		return protoreflect.ValueOfInt64(int64(u)), nil
+	case "google.protobuf.UInt32Value":
+		return protoreflect.ValueOfInt64(int64(m.Get(fields.ByName("value")).Uint())), nil
+	case "google.protobuf.Struct", "google.protobuf.Value", "google.protobuf.ListValue":
+		b, err := protojson.Marshal(m.Interface())
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%w: marshal %s as Json: %v", ErrInvalidData, name, err)
+		}
+		return protoreflect.ValueOfString(string(b)), nil
+	default:
+		if _, ok := wellKnownTypes[name]; !ok {
+			return protoreflect.Value{}, fmt.Errorf("%w: message %s cannot be normalized as a scalar value", ErrInvalidData, name)
+		}
+		// wrapper types
+		return m.Get(fields.ByName("value")), nil
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding/testdata"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var wellKnownTestTime = time.Date(2021, time.November, 12, 10, 30, 15, 123456789, time.UTC)
+
+func newWellKnownMessage(t *testing.T) *dynamicpb.Message {
+	md := testdata.WellKnownMessageDescriptor()
+	fields := md.Fields()
+	msg := dynamicpb.NewMessage(md)
+	attributes, err := structpb.NewStruct(map[string]interface{}{"color": "red"})
+	test.AssertNoErrorFatal(t, err)
+	msg.Set(fields.ByName("name"), protoreflect.ValueOfString("foo"))
+	msg.Set(fields.ByName("created_at"), protoreflect.ValueOfMessage(timestamppb.New(wellKnownTestTime).ProtoReflect()))
+	msg.Set(fields.ByName("elapsed"), protoreflect.ValueOfMessage(durationpb.New(1500*time.Millisecond).ProtoReflect()))
+	msg.Set(fields.ByName("count"), protoreflect.ValueOfMessage(wrapperspb.Int64(42).ProtoReflect()))
+	msg.Set(fields.ByName("attributes"), protoreflect.ValueOfMessage(attributes.ProtoReflect()))
+	events := msg.Mutable(fields.ByName("events")).List()
+	events.Append(protoreflect.ValueOfMessage(timestamppb.New(time.Unix(1, 0)).ProtoReflect()))
+	events.Append(protoreflect.ValueOfMessage(timestamppb.New(time.Unix(2, 0)).ProtoReflect()))
+	nestedFd := fields.ByName("nested")
+	nested := msg.NewField(nestedFd).Message()
+	nested.Set(nestedFd.Message().Fields().ByName("enabled"), protoreflect.ValueOfMessage(wrapperspb.Bool(true).ProtoReflect()))
+	msg.Set(nestedFd, protoreflect.ValueOfMessage(nested))
+	return msg
+}
+
+func TestNormalizeDescriptorWellKnownTypes(t *testing.T) {
+	dp, err := NormalizeDescriptor(testdata.WellKnownMessageDescriptor())
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, "testdata_WellKnownMessage", dp.GetName())
+
+	expectedTypes := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"name":       descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"created_at": descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"elapsed":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"count":      descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"total":      descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"label":      descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"attributes": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"events":     descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"nested":     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+	}
+	test.AssertEqual(t, len(expectedTypes), len(dp.GetField()))
+	for _, field := range dp.GetField() {
+		test.AssertEqual(t, expectedTypes[field.GetName()], field.GetType(), field.GetName())
+	}
+	test.AssertEqual(t, descriptorpb.FieldDescriptorProto_LABEL_REPEATED, dp.GetField()[7].GetLabel())
+	test.AssertEqual(t, "testdata_WellKnownMessage_Nested", dp.GetField()[8].GetTypeName())
+
+	// only the nested message type is defined, no well-known types
+	test.AssertEqual(t, 1, len(dp.GetNestedType()))
+	test.AssertEqual(t, "testdata_WellKnownMessage_Nested", dp.GetNestedType()[0].GetName())
+	test.AssertEqual(t, descriptorpb.FieldDescriptorProto_TYPE_BOOL, dp.GetNestedType()[0].GetField()[0].GetType())
+	test.AssertEqual(t, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, dp.GetNestedType()[0].GetField()[1].GetType())
+
+	_, err = NewMessageDescriptor(dp)
+	test.AssertNoError(t, err)
+}
+
+func TestNormalizeDescriptorNil(t *testing.T) {
+	_, err := NormalizeDescriptor(nil)
+	test.AssertIsError(t, err, ErrInvalidData)
+}
+
+func TestProtobufEncoderWellKnownTypes(t *testing.T) {
+	dp, err := NormalizeDescriptor(testdata.WellKnownMessageDescriptor())
+	test.AssertNoErrorFatal(t, err)
+	encoder, streamDP, err := NewProtobufEncoderForDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+	// already normalized, so returned as-is
+	test.AssertEqual(t, dp, streamDP)
+
+	rows, err := encoder.EncodeRows(newWellKnownMessage(t))
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, 1, len(rows))
+
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	fields := encoder.md.Fields()
+	test.AssertEqual(t, "foo", msg.Get(fields.ByName("name")).String())
+	test.AssertEqual(t, int64(1636713015123456), msg.Get(fields.ByName("created_at")).Int())
+	test.AssertEqual(t, int64(1500000), msg.Get(fields.ByName("elapsed")).Int())
+	test.AssertEqual(t, int64(42), msg.Get(fields.ByName("count")).Int())
+	test.AssertFalse(t, msg.Has(fields.ByName("total")))
+	test.AssertFalse(t, msg.Has(fields.ByName("label")))
+	test.AssertEqual(t, `{"color":"red"}`, msg.Get(fields.ByName("attributes")).String())
+	events := msg.Get(fields.ByName("events")).List()
+	test.AssertEqual(t, 2, events.Len())
+	test.AssertEqual(t, int64(2e6), events.Get(1).Int())
+	nested := msg.Get(fields.ByName("nested")).Message()
+	test.AssertTrue(t, nested.Get(nested.Descriptor().Fields().ByName("enabled")).Bool())
+	test.AssertFalse(t, nested.Has(nested.Descriptor().Fields().ByName("ratio")))
+}
+
+func TestProtobufEncoderWellKnownTypesAdaptNormalized(t *testing.T) {
+	dp, err := adapt.NormalizeDescriptor((&testdata.SimpleMessageProto3{}).ProtoReflect().Descriptor())
+	test.AssertNoErrorFatal(t, err)
+	encoder, streamDP, err := NewProtobufEncoderForDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+
+	// the nested well-known type is replaced by its scalar type
+	test.AssertEqual(t, 0, len(streamDP.GetNestedType()))
+	test.AssertEqual(t, descriptorpb.FieldDescriptorProto_TYPE_INT64, streamDP.GetField()[1].GetType())
+	// the given descriptor is left untouched
+	test.AssertEqual(t, 1, len(dp.GetNestedType()))
+
+	rows, err := encoder.EncodeRows(&testdata.SimpleMessageProto3{
+		Name:  "foo",
+		Value: wrapperspb.Int64(42),
+	})
+	test.AssertNoErrorFatal(t, err)
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	test.AssertEqual(t, int64(42), msg.Get(encoder.md.Fields().ByName("value")).Int())
+}
+
+func TestProtobufEncoderWithoutWellKnownTypes(t *testing.T) {
+	dp, err := adapt.NormalizeDescriptor((&testdata.SimpleMessageProto2{}).ProtoReflect().Descriptor())
+	test.AssertNoErrorFatal(t, err)
+	encoder, streamDP, err := NewProtobufEncoderForDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, dp, streamDP)
+
+	name, value := "foo", int64(42)
+	input := &testdata.SimpleMessageProto2{Name: &name, Value: &value}
+	rows, err := encoder.EncodeRows(input)
+	test.AssertNoErrorFatal(t, err)
+	expected, err := proto.Marshal(input)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, expected, rows[0])
+}
+
+func TestProtobufEncoderWellKnownTypesOverflow(t *testing.T) {
+	dp, err := NormalizeDescriptor(testdata.WellKnownMessageDescriptor())
+	test.AssertNoErrorFatal(t, err)
+	encoder, _, err := NewProtobufEncoderForDescriptor(dp)
+	test.AssertNoErrorFatal(t, err)
+
+	msg := newWellKnownMessage(t)
+	msg.Set(msg.Descriptor().Fields().ByName("total"), protoreflect.ValueOfMessage(wrapperspb.UInt64(math.MaxUint64).ProtoReflect()))
+	_, err = encoder.EncodeRows(msg)
+	test.AssertIsError(t, err, ErrInvalidData)
+}
+
+func TestSchemaEncoderWellKnownValues(t *testing.T) {
+	encoder, err := NewSchemaEncoder(bigquery.Schema{
+		{Name: "ts", Type: bigquery.TimestampFieldType},
+		{Name: "elapsed", Type: bigquery.IntegerFieldType},
+		{Name: "timeout", Type: bigquery.IntegerFieldType},
+		{Name: "count", Type: bigquery.IntegerFieldType},
+		{Name: "label", Type: bigquery.StringFieldType},
+		{Name: "attributes", Type: bigquery.StringFieldType},
+	})
+	test.AssertNoErrorFatal(t, err)
+	attributes, err := structpb.NewStruct(map[string]interface{}{"color": "red"})
+	test.AssertNoErrorFatal(t, err)
+
+	rows, err := encoder.EncodeRows(map[string]interface{}{
+		"ts":         timestamppb.New(wellKnownTestTime),
+		"elapsed":    durationpb.New(time.Second),
+		"timeout":    2 * time.Millisecond,
+		"count":      wrapperspb.Int32(42),
+		"label":      (*wrapperspb.StringValue)(nil),
+		"attributes": attributes,
+	})
+	test.AssertNoErrorFatal(t, err)
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	fields := encoder.md.Fields()
+	test.AssertEqual(t, int64(1636713015123456), msg.Get(fields.ByName("ts")).Int())
+	test.AssertEqual(t, int64(1e6), msg.Get(fields.ByName("elapsed")).Int())
+	test.AssertEqual(t, int64(2000), msg.Get(fields.ByName("timeout")).Int())
+	test.AssertEqual(t, int64(42), msg.Get(fields.ByName("count")).Int())
+	test.AssertFalse(t, msg.Has(fields.ByName("label")))
+	test.AssertEqual(t, `{"color":"red"}`, msg.Get(fields.ByName("attributes")).String())
+}
+
+type temporalStruct struct {
+	Timestamp time.Time      `bigquery:"ts"`
+	Date      civil.Date     `bigquery:"date"`
+	Time      *civil.Time    `bigquery:"time"`
+	DateTime  civil.DateTime `bigquery:"datetime"`
+	Numeric   *big.Rat       `bigquery:"numeric"`
+	Extra     string         `bigquery:"extra"`
+}
+
+func TestSchemaEncoderStructTemporalValues(t *testing.T) {
+	encoder, err := NewSchemaEncoder(bigquery.Schema{
+		{Name: "ts", Type: bigquery.TimestampFieldType},
+		{Name: "date", Type: bigquery.DateFieldType},
+		{Name: "time", Type: bigquery.TimeFieldType},
+		{Name: "datetime", Type: bigquery.DateTimeFieldType},
+		{Name: "numeric", Type: bigquery.NumericFieldType},
+	})
+	test.AssertNoErrorFatal(t, err)
+
+	civilTime := civil.Time{Hour: 1, Minute: 2, Second: 3}
+	row := temporalStruct{
+		Timestamp: wellKnownTestTime,
+		Date:      civil.DateOf(wellKnownTestTime),
+		Time:      &civilTime,
+		DateTime:  civil.DateTimeOf(wellKnownTestTime),
+		Numeric:   big.NewRat(3, 2),
+		Extra:     "not defined in the schema",
+	}
+	rows, err := encoder.EncodeRows(&row)
+	test.AssertNoErrorFatal(t, err)
+	msg := unmarshalRow(t, encoder.md, rows[0])
+	fields := encoder.md.Fields()
+	test.AssertEqual(t, int64(1636713015123456), msg.Get(fields.ByName("ts")).Int())
+	test.AssertEqual(t, int64(18943), msg.Get(fields.ByName("date")).Int())
+	test.AssertEqual(t, packedTimeMicros(civilTime), msg.Get(fields.ByName("time")).Int())
+	test.AssertEqual(t, packedDateTimeMicros(civil.DateTimeOf(wellKnownTestTime)), msg.Get(fields.ByName("datetime")).Int())
+	test.AssertEqual(t, encodeDecimal(big.NewRat(3, 2), numericScale), msg.Get(fields.ByName("numeric")).Bytes())
+
+	// nil pointers are skipped
+	row.Time, row.Numeric = nil, nil
+	rows, err = encoder.EncodeRows(row)
+	test.AssertNoErrorFatal(t, err)
+	msg = unmarshalRow(t, encoder.md, rows[0])
+	test.AssertFalse(t, msg.Has(fields.ByName("time")))
+	test.AssertFalse(t, msg.Has(fields.ByName("numeric")))
+}
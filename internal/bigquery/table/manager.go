@@ -0,0 +1,245 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package table provides the metadata management of the BigQuery table
+// (and its dataset) a Streamer writes to, such as its creation.
+package table
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
+	"github.com/OTA-Insight/bqwriter/log"
+	"google.golang.org/api/googleapi"
+)
+
+// Manager manages the metadata of a single BigQuery table and its dataset.
+type Manager struct {
+	api    metadataAPI
+	logger log.Logger
+}
+
+// metadataAPI defines the API we expect from the BQ client in order to manage the metadata
+// of a dataset and table, allowing it to be stubbed for testing purposes as well.
+type metadataAPI interface {
+	// DatasetMetadata fetches the metadata of the dataset.
+	DatasetMetadata(ctx context.Context) (*bigquery.DatasetMetadata, error)
+	// CreateDataset creates the dataset using the given metadata.
+	CreateDataset(ctx context.Context, md *bigquery.DatasetMetadata) error
+	// TableMetadata fetches the metadata of the table.
+	TableMetadata(ctx context.Context) (*bigquery.TableMetadata, error)
+	// CreateTable creates the table using the given metadata.
+	CreateTable(ctx context.Context, md *bigquery.TableMetadata) error
+	// UpdateTable updates the table using the given metadata,
+	// only if the table's ETag still matches the given ETag.
+	UpdateTable(ctx context.Context, md bigquery.TableMetadataToUpdate, etag string) (*bigquery.TableMetadata, error)
+}
+
+// stdMetadataAPI implements metadataAPI using the official Golang Gcloud BigQuery API client.
+type stdMetadataAPI struct {
+	dataset *bigquery.Dataset
+	table   *bigquery.Table
+}
+
+// DatasetMetadata implements metadataAPI::DatasetMetadata
+func (api *stdMetadataAPI) DatasetMetadata(ctx context.Context) (*bigquery.DatasetMetadata, error) {
+	md, err := api.dataset.Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch BQ dataset metadata: %w", err)
+	}
+	return md, nil
+}
+
+// CreateDataset implements metadataAPI::CreateDataset
+func (api *stdMetadataAPI) CreateDataset(ctx context.Context, md *bigquery.DatasetMetadata) error {
+	if err := api.dataset.Create(ctx, md); err != nil {
+		return fmt.Errorf("create BQ dataset: %w", err)
+	}
+	return nil
+}
+
+// TableMetadata implements metadataAPI::TableMetadata
+func (api *stdMetadataAPI) TableMetadata(ctx context.Context) (*bigquery.TableMetadata, error) {
+	md, err := api.table.Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch BQ table metadata: %w", err)
+	}
+	return md, nil
+}
+
+// CreateTable implements metadataAPI::CreateTable
+func (api *stdMetadataAPI) CreateTable(ctx context.Context, md *bigquery.TableMetadata) error {
+	if err := api.table.Create(ctx, md); err != nil {
+		return fmt.Errorf("create BQ table: %w", err)
+	}
+	return nil
+}
+
+// UpdateTable implements metadataAPI::UpdateTable
+func (api *stdMetadataAPI) UpdateTable(ctx context.Context, md bigquery.TableMetadataToUpdate, etag string) (*bigquery.TableMetadata, error) {
+	updatedMD, err := api.table.Update(ctx, md, etag)
+	if err != nil {
+		return nil, fmt.Errorf("update BQ table: %w", err)
+	}
+	return updatedMD, nil
+}
+
+// NewManager creates a new Manager for the given table,
+// using the given BigQuery client to interact with the BigQuery API.
+func NewManager(client *bigquery.Client, dataSetID, tableID string, logger log.Logger) (*Manager, error) {
+	if client == nil {
+		return nil, fmt.Errorf("bq table manager creation: validate client: %w: missing", internal.ErrInvalidParam)
+	}
+	if dataSetID == "" {
+		return nil, fmt.Errorf("bq table manager creation: validate dataSetID: %w: missing", internal.ErrInvalidParam)
+	}
+	if tableID == "" {
+		return nil, fmt.Errorf("bq table manager creation: validate tableID: %w: missing", internal.ErrInvalidParam)
+	}
+	dataset := client.Dataset(dataSetID)
+	return newManager(
+		&stdMetadataAPI{
+			dataset: dataset,
+			table:   dataset.Table(tableID),
+		},
+		logger,
+	)
+}
+
+func newManager(api metadataAPI, logger log.Logger) (*Manager, error) {
+	if api == nil {
+		return nil, fmt.Errorf("bq table manager creation: validate api: %w: missing", internal.ErrInvalidParam)
+	}
+	if logger == nil {
+		return nil, fmt.Errorf("bq table manager creation: validate logger: %w: missing", internal.ErrInvalidParam)
+	}
+	return &Manager{
+		api:    api,
+		logger: logger,
+	}, nil
+}
+
+// EnsureExists ensures the dataset and table exist, creating them using the given metadata
+// in case they do not exist yet. The metadata of an existing dataset or table is left untouched.
+//
+// A dataset or table created by someone else in between checking and creating it
+// is treated as if it existed already, such that multiple streamers can be created concurrently.
+func (m *Manager) EnsureExists(ctx context.Context, datasetMD *bigquery.DatasetMetadata, tableMD *bigquery.TableMetadata) error {
+	if tableMD == nil {
+		return fmt.Errorf("bq table manager: ensure table exists: validate table metadata: %w: missing", internal.ErrInvalidParam)
+	}
+	if _, err := m.api.DatasetMetadata(ctx); err != nil {
+		if !IsNotFoundError(err) {
+			return fmt.Errorf("bq table manager: ensure dataset exists: %w", err)
+		}
+		if datasetMD == nil {
+			datasetMD = new(bigquery.DatasetMetadata)
+		}
+		if err := m.api.CreateDataset(ctx, datasetMD); err != nil {
+			if !IsAlreadyExistsError(err) {
+				return fmt.Errorf("bq table manager: ensure dataset exists: %w", err)
+			}
+			log.Log(m.logger, log.LevelInfo, "bq table manager: dataset already exists: created concurrently by someone else")
+		} else {
+			log.Log(m.logger, log.LevelInfo, "bq table manager: created dataset as it did not exist yet")
+		}
+	}
+	if _, err := m.api.TableMetadata(ctx); err != nil {
+		if !IsNotFoundError(err) {
+			return fmt.Errorf("bq table manager: ensure table exists: %w", err)
+		}
+		if err := m.api.CreateTable(ctx, tableMD); err != nil {
+			if !IsAlreadyExistsError(err) {
+				return fmt.Errorf("bq table manager: ensure table exists: %w", err)
+			}
+			log.Log(m.logger, log.LevelInfo, "bq table manager: table already exists: created concurrently by someone else")
+		} else {
+			log.Log(m.logger, log.LevelInfo, "bq table manager: created table as it did not exist yet")
+		}
+	}
+	return nil
+}
+
+// Schema returns the (live) schema of the table.
+func (m *Manager) Schema(ctx context.Context) (bigquery.Schema, error) {
+	md, err := m.api.TableMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("bq table manager: get table schema: %w", err)
+	}
+	return md.Schema, nil
+}
+
+// maxSchemaUpdateAttempts defines how many times AddColumns attempts to update the table schema,
+// as an update can fail due to the table being modified concurrently (e.g. by another worker).
+const maxSchemaUpdateAttempts = 5
+
+// AddColumns adds all fields of the given schema which are not yet defined in the table schema,
+// nested fields included, as NULLABLE (or REPEATED) columns to the table.
+// The table schema, updated or not, is returned.
+//
+// The table is only updated if its metadata wasn't modified in between
+// fetching and updating it, retrying a couple of times in case it was modified concurrently.
+func (m *Manager) AddColumns(ctx context.Context, columns bigquery.Schema) (bigquery.Schema, error) {
+	for attempt := 1; ; attempt++ {
+		md, err := m.api.TableMetadata(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("bq table manager: add columns: %w", err)
+		}
+		merged, changed := schema.Merge(md.Schema, columns)
+		if !changed {
+			return md.Schema, nil
+		}
+		updatedMD, err := m.api.UpdateTable(ctx, bigquery.TableMetadataToUpdate{Schema: merged}, md.ETag)
+		if err == nil {
+			log.Log(
+				m.logger, log.LevelInfo,
+				fmt.Sprintf("bq table manager: added columns to table schema: %s", strings.Join(schema.Additions(md.Schema, columns), ", ")),
+			)
+			return updatedMD.Schema, nil
+		}
+		if !IsPreconditionFailedError(err) || attempt >= maxSchemaUpdateAttempts {
+			return nil, fmt.Errorf("bq table manager: add columns (attempt #%d): %w", attempt, err)
+		}
+		log.Log(m.logger, log.LevelDebug, fmt.Sprintf("bq table manager: add columns (attempt #%d): table modified concurrently: %v", attempt, err))
+	}
+}
+
+// IsNotFoundError returns true in case the given error is a Google API error
+// indicating that the requested resource does not exist.
+func IsNotFoundError(err error) bool {
+	return isGoogleAPIErrorWithCode(err, http.StatusNotFound)
+}
+
+// IsAlreadyExistsError returns true in case the given error is a Google API error
+// indicating that the resource to be created exists already.
+func IsAlreadyExistsError(err error) bool {
+	return isGoogleAPIErrorWithCode(err, http.StatusConflict)
+}
+
+// IsPreconditionFailedError returns true in case the given error is a Google API error
+// indicating that the resource was modified since its metadata (ETag) was fetched.
+func IsPreconditionFailedError(err error) bool {
+	return isGoogleAPIErrorWithCode(err, http.StatusPreconditionFailed)
+}
+
+func isGoogleAPIErrorWithCode(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
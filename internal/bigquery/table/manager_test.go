@@ -0,0 +1,290 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+	"google.golang.org/api/googleapi"
+)
+
+// stubMetadataAPI is an in-memory stub for the metadataAPI interface,
+// allowing us to see what metadata is created using it.
+type stubMetadataAPI struct {
+	datasetMD *bigquery.DatasetMetadata
+	tableMD   *bigquery.TableMetadata
+
+	createDatasetErr error
+	createTableErr   error
+	metadataErr      error
+	updateTableErrs  []error
+
+	createDatasetCount int
+	createTableCount   int
+	updateTableCount   int
+}
+
+func notFoundErr() error {
+	return fmt.Errorf("stub: %w", &googleapi.Error{Code: http.StatusNotFound})
+}
+
+func conflictErr() error {
+	return fmt.Errorf("stub: %w", &googleapi.Error{Code: http.StatusConflict})
+}
+
+func (api *stubMetadataAPI) DatasetMetadata(ctx context.Context) (*bigquery.DatasetMetadata, error) {
+	if api.metadataErr != nil {
+		return nil, api.metadataErr
+	}
+	if api.datasetMD == nil {
+		return nil, notFoundErr()
+	}
+	return api.datasetMD, nil
+}
+
+func (api *stubMetadataAPI) CreateDataset(ctx context.Context, md *bigquery.DatasetMetadata) error {
+	api.createDatasetCount++
+	if api.createDatasetErr != nil {
+		return api.createDatasetErr
+	}
+	api.datasetMD = md
+	return nil
+}
+
+func (api *stubMetadataAPI) TableMetadata(ctx context.Context) (*bigquery.TableMetadata, error) {
+	if api.metadataErr != nil {
+		return nil, api.metadataErr
+	}
+	if api.tableMD == nil {
+		return nil, notFoundErr()
+	}
+	return api.tableMD, nil
+}
+
+func (api *stubMetadataAPI) CreateTable(ctx context.Context, md *bigquery.TableMetadata) error {
+	api.createTableCount++
+	if api.createTableErr != nil {
+		return api.createTableErr
+	}
+	api.tableMD = md
+	return nil
+}
+
+func (api *stubMetadataAPI) UpdateTable(ctx context.Context, md bigquery.TableMetadataToUpdate, etag string) (*bigquery.TableMetadata, error) {
+	api.updateTableCount++
+	if len(api.updateTableErrs) > 0 {
+		err := api.updateTableErrs[0]
+		api.updateTableErrs = api.updateTableErrs[1:]
+		return nil, err
+	}
+	if api.tableMD == nil {
+		return nil, notFoundErr()
+	}
+	if md.Schema != nil {
+		api.tableMD.Schema = md.Schema
+	}
+	return api.tableMD, nil
+}
+
+var testTableMD = &bigquery.TableMetadata{
+	Schema: bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	},
+}
+
+// messageLogger is a StructuredLogger which records the messages logged via Log.
+type messageLogger struct {
+	test.Logger
+	messages []string
+}
+
+// Log implements log.StructuredLogger::Log
+func (l *messageLogger) Log(_ log.Level, msg string, _ ...log.Field) {
+	l.messages = append(l.messages, msg)
+}
+
+func newTestManager(t *testing.T, api *stubMetadataAPI) *Manager {
+	manager, err := newManager(api, test.Logger{})
+	test.AssertNoErrorFatal(t, err)
+	return manager
+}
+
+func TestManagerEnsureExistsCreatesDatasetAndTable(t *testing.T) {
+	api := new(stubMetadataAPI)
+	logger := new(messageLogger)
+	manager, err := newManager(api, logger)
+	test.AssertNoErrorFatal(t, err)
+	datasetMD := &bigquery.DatasetMetadata{Location: "EU"}
+	test.AssertNoError(t, manager.EnsureExists(context.Background(), datasetMD, testTableMD))
+	test.AssertEqual(t, 1, api.createDatasetCount)
+	test.AssertEqual(t, 1, api.createTableCount)
+	test.AssertEqual(t, datasetMD, api.datasetMD)
+	test.AssertEqual(t, testTableMD, api.tableMD)
+	test.AssertEqual(t, []string{
+		"bq table manager: created dataset as it did not exist yet",
+		"bq table manager: created table as it did not exist yet",
+	}, logger.messages)
+}
+
+func TestManagerEnsureExistsNop(t *testing.T) {
+	api := &stubMetadataAPI{
+		datasetMD: new(bigquery.DatasetMetadata),
+		tableMD:   new(bigquery.TableMetadata),
+	}
+	manager := newTestManager(t, api)
+	test.AssertNoError(t, manager.EnsureExists(context.Background(), nil, testTableMD))
+	test.AssertEqual(t, 0, api.createDatasetCount)
+	test.AssertEqual(t, 0, api.createTableCount)
+}
+
+func TestManagerEnsureExistsAlreadyExistsConflict(t *testing.T) {
+	api := &stubMetadataAPI{
+		createDatasetErr: conflictErr(),
+		createTableErr:   conflictErr(),
+	}
+	logger := new(messageLogger)
+	manager, err := newManager(api, logger)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertNoError(t, manager.EnsureExists(context.Background(), nil, testTableMD))
+	test.AssertEqual(t, 1, api.createDatasetCount)
+	test.AssertEqual(t, 1, api.createTableCount)
+	// the dataset and table are not logged as created, as someone else created them
+	test.AssertEqual(t, []string{
+		"bq table manager: dataset already exists: created concurrently by someone else",
+		"bq table manager: table already exists: created concurrently by someone else",
+	}, logger.messages)
+}
+
+func TestManagerEnsureExistsErrors(t *testing.T) {
+	testCases := []*stubMetadataAPI{
+		{metadataErr: test.ErrStatic},
+		{createDatasetErr: test.ErrStatic},
+		{datasetMD: new(bigquery.DatasetMetadata), createTableErr: test.ErrStatic},
+	}
+	for _, api := range testCases {
+		manager := newTestManager(t, api)
+		err := manager.EnsureExists(context.Background(), nil, testTableMD)
+		test.AssertError(t, err)
+		test.AssertIsError(t, err, test.ErrStatic)
+	}
+}
+
+func TestManagerEnsureExistsNilTableMetadata(t *testing.T) {
+	manager := newTestManager(t, new(stubMetadataAPI))
+	err := manager.EnsureExists(context.Background(), nil, nil)
+	test.AssertError(t, err)
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+}
+
+func TestNewManagerInputErrors(t *testing.T) {
+	manager, err := NewManager(nil, "a", "b", test.Logger{})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+	test.AssertNil(t, manager)
+
+	manager, err = newManager(nil, test.Logger{})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+	test.AssertNil(t, manager)
+
+	manager, err = newManager(new(stubMetadataAPI), nil)
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+	test.AssertNil(t, manager)
+}
+
+func TestManagerAddColumns(t *testing.T) {
+	api := &stubMetadataAPI{
+		tableMD: &bigquery.TableMetadata{Schema: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		}},
+	}
+	manager := newTestManager(t, api)
+	updated, err := manager.AddColumns(context.Background(), bigquery.Schema{
+		{Name: "Name", Type: bigquery.StringFieldType},
+		{Name: "age", Type: bigquery.IntegerFieldType, Required: true},
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, 1, api.updateTableCount)
+	test.AssertEqual(t, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "age", Type: bigquery.IntegerFieldType},
+	}, updated)
+}
+
+func TestManagerAddColumnsNop(t *testing.T) {
+	api := &stubMetadataAPI{
+		tableMD: &bigquery.TableMetadata{Schema: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+		}},
+	}
+	manager := newTestManager(t, api)
+	updated, err := manager.AddColumns(context.Background(), bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, 0, api.updateTableCount)
+	test.AssertEqual(t, api.tableMD.Schema, updated)
+}
+
+func TestManagerAddColumnsRetryPreconditionFailed(t *testing.T) {
+	preconditionFailedErr := fmt.Errorf("stub: %w", &googleapi.Error{Code: http.StatusPreconditionFailed})
+	api := &stubMetadataAPI{
+		tableMD:         &bigquery.TableMetadata{},
+		updateTableErrs: []error{preconditionFailedErr, preconditionFailedErr},
+	}
+	manager := newTestManager(t, api)
+	updated, err := manager.AddColumns(context.Background(), bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, 3, api.updateTableCount)
+	test.AssertEqual(t, bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}, updated)
+}
+
+func TestManagerAddColumnsErrors(t *testing.T) {
+	preconditionFailedErr := fmt.Errorf("stub: %w", &googleapi.Error{Code: http.StatusPreconditionFailed})
+	testCases := []*stubMetadataAPI{
+		{metadataErr: test.ErrStatic},
+		{tableMD: &bigquery.TableMetadata{}, updateTableErrs: []error{test.ErrStatic}},
+		{tableMD: &bigquery.TableMetadata{}, updateTableErrs: []error{
+			preconditionFailedErr, preconditionFailedErr, preconditionFailedErr,
+			preconditionFailedErr, preconditionFailedErr,
+		}},
+	}
+	for _, api := range testCases {
+		manager := newTestManager(t, api)
+		_, err := manager.AddColumns(context.Background(), bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+		})
+		test.AssertError(t, err)
+	}
+}
+
+func TestManagerSchema(t *testing.T) {
+	manager := newTestManager(t, &stubMetadataAPI{tableMD: testTableMD})
+	schema, err := manager.Schema(context.Background())
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, testTableMD.Schema, schema)
+
+	manager = newTestManager(t, new(stubMetadataAPI))
+	_, err = manager.Schema(context.Background())
+	test.AssertError(t, err)
+	test.AssertTrue(t, IsNotFoundError(err))
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+// Throttler is used by a Client to wait prior to sending rows to BigQuery,
+// in order to respect the client-side rate limits of the streamer.
+type Throttler interface {
+	// Throttle waits until the given amount of rows, of the given (estimated) size in bytes, can be sent.
+	Throttle(rows, bytes int)
+	// LimitsBytes reports whether the size of the rows is limited,
+	// as a client only has to estimate the size of its rows if so.
+	LimitsBytes() bool
+}
+
+// Throttled is implemented by a Client in order to be throttled when it actually sends its rows,
+// rather than when they are put, as rows are buffered by the client until flushed or sent asynchronously.
+type Throttled interface {
+	// SetThrottler sets the Throttler waited on prior to each send of rows,
+	// called prior to the client being used.
+	SetThrottler(throttler Throttler)
+}
@@ -0,0 +1,379 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package value provides utilities to convert the Go values of a row,
+// as accepted by the different Streamer clients, to the values expected for their BigQuery field.
+package value
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// FieldByName returns the schema field with the given name, matched case-insensitive,
+// the same way BigQuery matches its column names, preferring an exact match.
+func FieldByName(bqSchema bigquery.Schema, name string) *bigquery.FieldSchema {
+	for _, field := range bqSchema {
+		if field.Name == name {
+			return field
+		}
+	}
+	for _, field := range bqSchema {
+		if EqualFoldASCII(field.Name, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// EqualFoldASCII reports whether the given (ASCII) names are equal, ignoring their case,
+// the same way BigQuery compares column names.
+func EqualFoldASCII(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+// Deref returns the underlying value of a (non-nil) pointer or valid bigquery.NullX value,
+// returning false in case no value is defined. Messages of a well-known protobuf type are
+// returned as their Go value, e.g. a time.Time for a *timestamppb.Timestamp.
+func Deref(v interface{}) (interface{}, bool) {
+	switch typedValue := v.(type) {
+	case nil:
+		return nil, false
+	case *big.Rat:
+		return typedValue, typedValue != nil
+	case []byte:
+		return typedValue, typedValue != nil
+	case bigquery.NullInt64:
+		return typedValue.Int64, typedValue.Valid
+	case bigquery.NullFloat64:
+		return typedValue.Float64, typedValue.Valid
+	case bigquery.NullBool:
+		return typedValue.Bool, typedValue.Valid
+	case bigquery.NullString:
+		return typedValue.StringVal, typedValue.Valid
+	case bigquery.NullGeography:
+		return typedValue.GeographyVal, typedValue.Valid
+	case bigquery.NullTimestamp:
+		return typedValue.Timestamp, typedValue.Valid
+	case bigquery.NullDate:
+		return typedValue.Date, typedValue.Valid
+	case bigquery.NullTime:
+		return typedValue.Time, typedValue.Valid
+	case bigquery.NullDateTime:
+		return typedValue.DateTime, typedValue.Valid
+	case proto.Message:
+		if !typedValue.ProtoReflect().IsValid() {
+			return nil, false // nil message
+		}
+		return wellKnownGoValue(typedValue), true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false
+		}
+		return Deref(rv.Elem().Interface())
+	}
+	return v, true
+}
+
+// wellKnownGoValue returns the Go value of a message of a well-known type, e.g. a time.Time for a Timestamp,
+// returning the message as-is for google.protobuf.Struct, Value and ListValue or any other message type.
+func wellKnownGoValue(msg proto.Message) interface{} {
+	switch typedMsg := msg.(type) {
+	case *timestamppb.Timestamp:
+		return typedMsg.AsTime()
+	case *durationpb.Duration:
+		return typedMsg.AsDuration()
+	case *wrapperspb.DoubleValue:
+		return typedMsg.Value
+	case *wrapperspb.FloatValue:
+		return typedMsg.Value
+	case *wrapperspb.Int64Value:
+		return typedMsg.Value
+	case *wrapperspb.UInt64Value:
+		return typedMsg.Value
+	case *wrapperspb.Int32Value:
+		return typedMsg.Value
+	case *wrapperspb.UInt32Value:
+		return typedMsg.Value
+	case *wrapperspb.BoolValue:
+		return typedMsg.Value
+	case *wrapperspb.StringValue:
+		return typedMsg.Value
+	case *wrapperspb.BytesValue:
+		return typedMsg.Value
+	default:
+		return msg
+	}
+}
+
+// ToRow converts a (nested) row value to its values, returning false in case it is not a supported row value.
+// Supported are a map[string]bigquery.Value, map[string]interface{}, bigquery.ValueSaver or a struct (pointer),
+// of which the fields not defined in the given schema are skipped.
+func ToRow(bqSchema bigquery.Schema, v interface{}) (map[string]bigquery.Value, bool, error) {
+	switch typedValue := v.(type) {
+	case map[string]bigquery.Value:
+		return typedValue, true, nil
+	case map[string]interface{}:
+		return FromInterfaceMap(typedValue), true, nil
+	case bigquery.ValueSaver:
+		values, _, err := typedValue.Save()
+		if err != nil {
+			return nil, false, fmt.Errorf("save ValueSaver: %w", err)
+		}
+		return values, true, nil
+	default:
+		return structValues(bqSchema, v)
+	}
+}
+
+// FromInterfaceMap converts the given map to a map of bigquery values.
+func FromInterfaceMap(m map[string]interface{}) map[string]bigquery.Value {
+	values := make(map[string]bigquery.Value, len(m))
+	for key, v := range m {
+		values[key] = v
+	}
+	return values
+}
+
+var (
+	typeOfTime      = reflect.TypeOf(time.Time{})
+	typeOfDate      = reflect.TypeOf(civil.Date{})
+	typeOfCivilTime = reflect.TypeOf(civil.Time{})
+	typeOfDateTime  = reflect.TypeOf(civil.DateTime{})
+)
+
+// structFieldsCache caches the (schema.StructFields) fields per struct type.
+var structFieldsCache sync.Map // reflect.Type -> []schema.StructField
+
+// structValues returns the values of the fields of the given struct (pointer) value,
+// returning false in case the value is not a struct which can be used as a (nested) row.
+// Fields which are not defined in the given schema are skipped, nil pointer fields are returned as nil.
+func structValues(bqSchema bigquery.Schema, v interface{}) (map[string]bigquery.Value, bool, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, false, nil
+	}
+	switch rv.Type() {
+	case typeOfTime, typeOfDate, typeOfCivilTime, typeOfDateTime:
+		// struct types which are values rather than rows
+		return nil, false, nil
+	}
+	var fields []schema.StructField
+	if cached, ok := structFieldsCache.Load(rv.Type()); ok {
+		fields = cached.([]schema.StructField) //nolint: forcetypeassert
+	} else {
+		var err error
+		if fields, err = schema.StructFields(rv.Type()); err != nil {
+			return nil, false, err
+		}
+		structFieldsCache.Store(rv.Type(), fields)
+	}
+	values := make(map[string]bigquery.Value, len(fields))
+	for _, field := range fields {
+		if FieldByName(bqSchema, field.Name) == nil {
+			continue
+		}
+		if fv, ok := fieldByIndex(rv, field.Index); ok {
+			values[field.Name] = fv.Interface()
+		} else {
+			values[field.Name] = nil
+		}
+	}
+	return values, true, nil
+}
+
+// fieldByIndex returns the (nested) field of the struct value with the given index,
+// returning false in case it is promoted from an embedded struct pointer which is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+// ToInt64 converts the value to an int64, accepting any integer type,
+// a whole float64 (e.g. as decoded from Json) as well as its string (or json.Number) representation.
+func ToInt64(v interface{}) (int64, bool) {
+	switch typedValue := v.(type) {
+	case json.Number:
+		i, err := typedValue.Int64()
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(typedValue, 10, 64)
+		return i, err == nil
+	case float64:
+		// e.g. a value decoded from Json, only accepted if it is a whole number
+		i := int64(typedValue)
+		return i, float64(i) == typedValue
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		return int64(u), u <= 1<<63-1
+	default:
+		return 0, false
+	}
+}
+
+// ToFloat64 converts the value to a float64, accepting any integer or float type,
+// as well as its string (or json.Number) representation.
+func ToFloat64(v interface{}) (float64, bool) {
+	switch typedValue := v.(type) {
+	case json.Number:
+		f, err := typedValue.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(typedValue, 64)
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	default:
+		return 0, false
+	}
+}
+
+// ToBool converts the value to a bool, accepting a bool or its string representation.
+func ToBool(v interface{}) (bool, bool) {
+	switch typedValue := v.(type) {
+	case bool:
+		return typedValue, true
+	case string:
+		b, err := strconv.ParseBool(typedValue)
+		return b, err == nil
+	default:
+		return false, false
+	}
+}
+
+// ToRat converts the value to a rational number, accepting a *big.Rat,
+// any integer or float type, as well as its string (or json.Number) representation.
+func ToRat(v interface{}) (*big.Rat, bool) {
+	switch typedValue := v.(type) {
+	case *big.Rat:
+		return typedValue, true
+	case string:
+		return new(big.Rat).SetString(typedValue)
+	case json.Number:
+		return new(big.Rat).SetString(typedValue.String())
+	case float32:
+		return new(big.Rat).SetFloat64(float64(typedValue)), true
+	case float64:
+		return new(big.Rat).SetFloat64(typedValue), true
+	}
+	if i, ok := ToInt64(v); ok {
+		return new(big.Rat).SetInt64(i), true
+	}
+	return nil, false
+}
+
+// ToTimestamp converts the value to a time.Time, accepting a time.Time or its RFC 3339 string representation.
+func ToTimestamp(v interface{}) (time.Time, bool) {
+	switch typedValue := v.(type) {
+	case time.Time:
+		return typedValue, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, typedValue)
+		return t, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
+// ToDate converts the value to a civil.Date, accepting a civil.Date or its string representation.
+func ToDate(v interface{}) (civil.Date, bool) {
+	switch typedValue := v.(type) {
+	case civil.Date:
+		return typedValue, true
+	case string:
+		d, err := civil.ParseDate(typedValue)
+		return d, err == nil
+	default:
+		return civil.Date{}, false
+	}
+}
+
+// ToTime converts the value to a civil.Time, accepting a civil.Time or its string representation.
+func ToTime(v interface{}) (civil.Time, bool) {
+	switch typedValue := v.(type) {
+	case civil.Time:
+		return typedValue, true
+	case string:
+		t, err := civil.ParseTime(typedValue)
+		return t, err == nil
+	default:
+		return civil.Time{}, false
+	}
+}
+
+// ToDateTime converts the value to a civil.DateTime, accepting a civil.DateTime or its string representation.
+func ToDateTime(v interface{}) (civil.DateTime, bool) {
+	switch typedValue := v.(type) {
+	case civil.DateTime:
+		return typedValue, true
+	case string:
+		dt, err := civil.ParseDateTime(typedValue)
+		return dt, err == nil
+	default:
+		return civil.DateTime{}, false
+	}
+}
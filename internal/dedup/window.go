@@ -0,0 +1,139 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dedup provides the window of recently seen row keys,
+// used by the streamer to suppress duplicate rows client-side.
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Window remembers the keys seen within a time window, bounded in the amount of keys it remembers.
+// Once full, the least recently seen keys are forgotten first, even if still within the time window.
+//
+// A key is remembered for the duration of the window since it was last seen,
+// such that a key which keeps being seen is not forgotten.
+//
+// A key which is not remembered is reserved first (see Reserve), and is only remembered
+// once committed (see Commit), while it can be released (see Release) in order to forget it.
+type Window struct {
+	mu sync.Mutex
+
+	duration time.Duration
+	maxKeys  int
+
+	keys map[string]*list.Element
+	// order contains the entries of the remembered keys, least recently seen first
+	order *list.List
+	// reserved contains the keys reserved but not yet committed or released
+	reserved map[string]struct{}
+
+	now func() time.Time
+}
+
+type entry struct {
+	key    string
+	seenAt time.Time
+}
+
+// NewWindow creates a new Window, remembering keys for the given duration,
+// with at most maxKeys keys remembered at once.
+func NewWindow(duration time.Duration, maxKeys int) *Window {
+	return &Window{
+		duration: duration,
+		maxKeys:  maxKeys,
+		keys:     make(map[string]*list.Element),
+		order:    list.New(),
+		reserved: make(map[string]struct{}),
+		now:      time.Now,
+	}
+}
+
+// Reserve reserves the key in case it is neither remembered nor reserved, reporting whether it did so.
+// A remembered key is marked as seen again instead.
+func (w *Window) Reserve(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	w.expire(now)
+	if elem, ok := w.keys[key]; ok {
+		w.touch(elem, now)
+		return false
+	}
+	if _, ok := w.reserved[key]; ok {
+		return false
+	}
+	w.reserved[key] = struct{}{}
+	return true
+}
+
+// Commit remembers the given reserved keys, as seen now.
+func (w *Window) Commit(keys ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	w.expire(now)
+	for _, key := range keys {
+		delete(w.reserved, key)
+		if elem, ok := w.keys[key]; ok {
+			w.touch(elem, now)
+			continue
+		}
+		if w.order.Len() >= w.maxKeys {
+			w.remove(w.order.Front())
+		}
+		w.keys[key] = w.order.PushBack(&entry{key: key, seenAt: now})
+	}
+}
+
+// Release releases the given reserved keys without remembering them.
+func (w *Window) Release(keys ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		delete(w.reserved, key)
+	}
+}
+
+// Len returns the amount of keys currently remembered, reserved keys excluded.
+func (w *Window) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.order.Len()
+}
+
+// expire forgets all keys last seen before the window, relative to the given time.
+func (w *Window) expire(now time.Time) {
+	for elem := w.order.Front(); elem != nil; elem = w.order.Front() {
+		if now.Sub(elem.Value.(*entry).seenAt) < w.duration {
+			return
+		}
+		w.remove(elem)
+	}
+}
+
+// touch marks the key of the given element as seen at the given time,
+// making it the most recently seen key.
+func (w *Window) touch(elem *list.Element, now time.Time) {
+	elem.Value.(*entry).seenAt = now
+	w.order.MoveToBack(elem)
+}
+
+func (w *Window) remove(elem *list.Element) {
+	w.order.Remove(elem)
+	delete(w.keys, elem.Value.(*entry).key)
+}
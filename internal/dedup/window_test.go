@@ -0,0 +1,88 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+func newTestWindow(duration time.Duration, maxKeys int) (*Window, *time.Time) {
+	now := time.Unix(0, 0)
+	w := NewWindow(duration, maxKeys)
+	w.now = func() time.Time { return now }
+	return w, &now
+}
+
+func TestWindowReserve(t *testing.T) {
+	w, _ := newTestWindow(time.Minute, 10)
+	test.AssertTrue(t, w.Reserve("a"))
+	test.AssertTrue(t, w.Reserve("b"))
+	// a reserved key cannot be reserved again
+	test.AssertFalse(t, w.Reserve("a"))
+	// reserved keys are not remembered until committed
+	test.AssertEqual(t, 0, w.Len())
+	w.Commit("a")
+	test.AssertEqual(t, 1, w.Len())
+	test.AssertFalse(t, w.Reserve("a"))
+	// released keys are forgotten
+	w.Release("b")
+	test.AssertTrue(t, w.Reserve("b"))
+	test.AssertEqual(t, 1, w.Len())
+}
+
+func TestWindowExpires(t *testing.T) {
+	w, now := newTestWindow(time.Minute, 10)
+	test.AssertTrue(t, w.Reserve("a"))
+	w.Commit("a")
+	*now = now.Add(30 * time.Second)
+	test.AssertTrue(t, w.Reserve("b"))
+	w.Commit("b")
+	*now = now.Add(30 * time.Second)
+	test.AssertTrue(t, w.Reserve("a"))
+	test.AssertFalse(t, w.Reserve("b"))
+	*now = now.Add(time.Minute)
+	test.AssertEqual(t, 1, w.Len())
+	test.AssertTrue(t, w.Reserve("b"))
+	test.AssertEqual(t, 0, w.Len())
+}
+
+func TestWindowSeenExtendsWindow(t *testing.T) {
+	w, now := newTestWindow(time.Minute, 10)
+	test.AssertTrue(t, w.Reserve("a"))
+	w.Commit("a")
+	*now = now.Add(45 * time.Second)
+	// seeing a key again extends its window
+	test.AssertFalse(t, w.Reserve("a"))
+	*now = now.Add(45 * time.Second)
+	test.AssertFalse(t, w.Reserve("a"))
+}
+
+func TestWindowMaxKeys(t *testing.T) {
+	w, _ := newTestWindow(time.Minute, 2)
+	test.AssertTrue(t, w.Reserve("a"))
+	test.AssertTrue(t, w.Reserve("b"))
+	w.Commit("a", "b")
+	// the least recently seen key is forgotten once full
+	test.AssertFalse(t, w.Reserve("a"))
+	test.AssertTrue(t, w.Reserve("c"))
+	w.Commit("c")
+	test.AssertEqual(t, 2, w.Len())
+	test.AssertTrue(t, w.Reserve("b"))
+	test.AssertFalse(t, w.Reserve("a"))
+	test.AssertFalse(t, w.Reserve("c"))
+}
@@ -24,12 +24,59 @@ var (
 	// are defined in a single StreamerConfig. As this is an ambigious situation we've chosen to fail on it instead.
 	ErrMutuallyExclusiveConfigs = errors.New("you cannot define both a storage Client and a batch client at once")
 
+	// ErrInvalidWorkerScaling is an error used in case a StreamerConfig enables autoscaling with a MaxWorkers
+	// lower than its MinWorkers, without a worker queue, which is what is sampled to scale the workers,
+	// or while sharding the rows, which requires a fixed amount of workers.
+	ErrInvalidWorkerScaling = errors.New("StreamerConfig invalid: autoscaling requires a shared worker queue and a MaxWorkers of at least MinWorkers")
+
+	// ErrPoolClosed is an error used in case a client is acquired from a Pool which is already closed.
+	ErrPoolClosed = errors.New("pool is closed")
+
 	// ErrAutoDetectSchemaNotSupported is an internal error used in case a batch client config was defined with a data format
 	// other than Json or CSV, yet no bigquery Schema defined. This makes auto-detection impossible according to our knowledge,
 	// and thus we fail early.
 	ErrAutoDetectSchemaNotSupported = errors.New("BQ batch client: autoDetect is only supported for JSON and CSV format")
 
-	// ErrProtobufOrSChemaRequired is an error used in case a Storage client config was created with no protobuf descriptor
-	// or bigquery schema defined, making it impossible for the internal storage client to know how to encode the data.
-	ErrProtobufOrSChemaRequired = errors.New("StorageClientConfig invalid: either a Protobuf descriptor or BigQuery schema is required")
+	// ErrProtobufOrSChemaRequired is an error used in case a Storage client config was created with no protobuf descriptor,
+	// bigquery schema or row type defined, making it impossible for the internal storage client to know how to encode the data.
+	ErrProtobufOrSChemaRequired = errors.New("StorageClientConfig invalid: either a Protobuf descriptor, BigQuery schema or row type is required")
+
+	// ErrCreateTableSchemaRequired is an error used in case a CreateTable config was defined, yet no BigQuery schema
+	// could be resolved for it, neither explicitly nor from the client configs, making it impossible to create the table.
+	ErrCreateTableSchemaRequired = errors.New("CreateTableConfig invalid: a BigQuery schema, RowType or a client config with a schema is required")
+
+	// ErrEvolveSchemaRequiresSchema is an error used in case a Storage client config was created with schema evolution enabled,
+	// while using a protobuf descriptor rather than a BigQuery schema.
+	ErrEvolveSchemaRequiresSchema = errors.New("StorageClientConfig invalid: schema evolution requires a BigQuery schema and cannot be used with a Protobuf descriptor")
+
+	// ErrStructEncoderRequiresRowType is an error used in case a Storage client config was created with the struct encoder enabled,
+	// while defining no row type or while using a protobuf descriptor.
+	ErrStructEncoderRequiresRowType = errors.New("StorageClientConfig invalid: the struct encoder requires a row type and cannot be used with a Protobuf descriptor")
+
+	// ErrValidateSchemaRequiresSchema is an error used in case schema validation was requested,
+	// yet no schema could be resolved from the client or create table configs to validate the table schema against.
+	ErrValidateSchemaRequiresSchema = errors.New("StreamerConfig invalid: schema validation requires a client or create table config with a schema")
+
+	// ErrUnsupportedCompression is an error used in case a batch client config was defined with a compression
+	// which is not supported for its source format, e.g. Gzip for Avro files, which only support block compression.
+	ErrUnsupportedCompression = errors.New("BatchClientConfig invalid: compression is not supported for the source format")
+
+	// ErrStagingBucketOrStoreRequired is an error used in case a staging config was defined with neither
+	// or both a bucket and object store defined, making it ambiguous or impossible to know where to stage the files.
+	ErrStagingBucketOrStoreRequired = errors.New("StagingConfig invalid: either a bucket or an object store is required")
+
+	// ErrLocalStagingStore is an error used in case a staging config was defined with an objectstore.LocalStore,
+	// of which the file:// URIs of the staged objects cannot be loaded by BigQuery.
+	ErrLocalStagingStore = errors.New("StagingConfig invalid: a local object store cannot be loaded from by BigQuery")
+
+	// ErrUnsupportedRowType is an error used in case the row type of a TypedStreamer
+	// cannot be written by the client configured for it.
+	ErrUnsupportedRowType = errors.New("TypedStreamer invalid: row type is not supported by the configured client")
+
+	// ErrInvalidSchemaUpdateOption is an error used in case a schema update option is not supported by BigQuery.
+	ErrInvalidSchemaUpdateOption = errors.New("BatchClientConfig invalid: unsupported schema update option")
+
+	// ErrInvalidJobIDPrefix is an error used in case a job ID prefix contains characters not allowed in a job ID,
+	// or is too long to leave room for the suffix of the job ID.
+	ErrInvalidJobIDPrefix = errors.New("BatchClientConfig invalid: job ID prefix can only contain letters, numbers, underscores and dashes")
 )
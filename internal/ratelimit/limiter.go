@@ -0,0 +1,196 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides the token bucket based rate limiter used by the streamer,
+// slowing down adaptively in case the BigQuery quota is exceeded nonetheless.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// minRateFactor is the lowest fraction of the configured rates a limiter slows down to.
+	minRateFactor = 1.0 / 16
+	// rateRecoveryInterval is the interval in which a slowed down limiter doubles its rates,
+	// until these are restored to the configured rates, in case no quota is exceeded.
+	rateRecoveryInterval = 10 * time.Second
+	// initialQuotaBackoff is the time a limiter pauses once the quota is exceeded,
+	// doubling each time the quota is exceeded again, while not yet recovered.
+	initialQuotaBackoff = time.Second
+	// maxQuotaBackoff is the maximum time a limiter pauses once the quota is exceeded.
+	maxQuotaBackoff = 32 * time.Second
+)
+
+// Limiter limits the rows and bytes written per second, using a token bucket for each defined limit.
+// A limiter without limits defined can still be used for its adaptive slow-down.
+//
+// Once the quota is exceeded, as reported using QuotaExceeded, the limiter pauses for a backoff delay,
+// after which it continues at half of its rates, recovering gradually in case the quota is not exceeded again.
+type Limiter struct {
+	mu sync.Mutex
+
+	rows  *bucket
+	bytes *bucket
+
+	factor       float64
+	lastAdjusted time.Time
+	lastExceeded time.Time
+	backoff      time.Duration
+	pausedUntil  time.Time
+
+	now func() time.Time
+}
+
+// NewLimiter creates a new Limiter, limiting the rows and/or bytes written per second,
+// with a limit <= 0 meaning that it is not limited.
+//
+// Each bucket can burst up to a second worth of its rate,
+// while a single reservation exceeding that burst is delayed as if the bucket was in debt.
+func NewLimiter(rowsPerSecond, bytesPerSecond float64) *Limiter {
+	return &Limiter{
+		rows:   newBucket(rowsPerSecond),
+		bytes:  newBucket(bytesPerSecond),
+		factor: 1,
+		now:    time.Now,
+	}
+}
+
+// LimitsBytes returns true in case the limiter limits the bytes written per second,
+// and thus requires the size of the rows to be reserved.
+func (l *Limiter) LimitsBytes() bool {
+	return l.bytes != nil
+}
+
+// Reserve reserves the given rows and bytes, returning the delay
+// after which they can be written according to the limiter.
+func (l *Limiter) Reserve(rows, bytes int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.recoverLocked(now)
+
+	var delay time.Duration
+	if now.Before(l.pausedUntil) {
+		delay = l.pausedUntil.Sub(now)
+	}
+	if l.rows != nil {
+		if d := l.rows.reserve(now, float64(rows), l.factor); d > delay {
+			delay = d
+		}
+	}
+	if l.bytes != nil {
+		if d := l.bytes.reserve(now, float64(bytes), l.factor); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// Wait reserves the given rows and bytes, and waits until they can be written
+// according to the limiter, or until the context is done, returning its error in that case.
+func (l *Limiter) Wait(ctx context.Context, rows, bytes int) (time.Duration, error) {
+	delay := l.Reserve(rows, bytes)
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return delay, ctx.Err()
+	}
+}
+
+// QuotaExceeded reports that the quota has been exceeded, pausing the limiter and halving its rates.
+// Returns the time the limiter is paused for, with reports made while already paused being ignored,
+// as these are likely the result of the same burst of writes.
+func (l *Limiter) QuotaExceeded() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	if l.backoff == 0 || now.Sub(l.lastExceeded) > rateRecoveryInterval {
+		l.backoff = initialQuotaBackoff
+	} else {
+		l.backoff *= 2
+		if l.backoff > maxQuotaBackoff {
+			l.backoff = maxQuotaBackoff
+		}
+	}
+	l.factor /= 2
+	if l.factor < minRateFactor {
+		l.factor = minRateFactor
+	}
+	l.lastExceeded = now
+	l.lastAdjusted = now
+	l.pausedUntil = now.Add(l.backoff)
+	return l.backoff
+}
+
+// recoverLocked doubles the rates of a slowed down limiter
+// for each recovery interval passed since they were last adjusted.
+func (l *Limiter) recoverLocked(now time.Time) {
+	for l.factor < 1 && now.Sub(l.lastAdjusted) >= rateRecoveryInterval {
+		l.factor *= 2
+		if l.factor > 1 {
+			l.factor = 1
+		}
+		l.lastAdjusted = l.lastAdjusted.Add(rateRecoveryInterval)
+	}
+}
+
+// bucket is a token bucket, refilled at its rate (tokens per second) up to its burst.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket creates a new (full) bucket for the given rate, returning nil in case the rate is not limited.
+func newBucket(rate float64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+	}
+}
+
+// reserve takes n tokens from the bucket, refilled at the rate multiplied by the given factor,
+// returning the delay until the bucket is no longer in debt in case not enough tokens were available.
+func (b *bucket) reserve(now time.Time, n, factor float64) time.Duration {
+	rate := b.rate * factor
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+// newTestLimiter creates a limiter using a fake clock, which can be advanced using the returned function
+func newTestLimiter(rowsPerSecond, bytesPerSecond float64) (*Limiter, func(time.Duration)) {
+	now := time.Date(2021, 11, 12, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(rowsPerSecond, bytesPerSecond)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l, _ := newTestLimiter(0, -1)
+	test.AssertFalse(t, l.LimitsBytes())
+	for i := 0; i < 100; i++ {
+		test.AssertEqual(t, time.Duration(0), l.Reserve(1000, 1<<20))
+	}
+}
+
+func TestLimiterRows(t *testing.T) {
+	l, advance := newTestLimiter(10, 0)
+
+	// a second worth of rows can be written at once
+	test.AssertEqual(t, time.Duration(0), l.Reserve(10, 0))
+	// after which rows are delayed according to the rate
+	test.AssertEqual(t, 500*time.Millisecond, l.Reserve(5, 0))
+	advance(500 * time.Millisecond)
+	test.AssertEqual(t, 100*time.Millisecond, l.Reserve(1, 0))
+
+	// the bucket does not refill beyond its burst
+	advance(time.Hour)
+	test.AssertEqual(t, time.Duration(0), l.Reserve(10, 0))
+	test.AssertEqual(t, 100*time.Millisecond, l.Reserve(1, 0))
+}
+
+func TestLimiterBytes(t *testing.T) {
+	l, advance := newTestLimiter(0, 1000)
+	test.AssertTrue(t, l.LimitsBytes())
+
+	// a reservation exceeding the burst is delayed as if the bucket was in debt
+	test.AssertEqual(t, 2*time.Second, l.Reserve(1, 3000))
+	advance(2 * time.Second)
+	test.AssertEqual(t, 500*time.Millisecond, l.Reserve(1, 500))
+}
+
+func TestLimiterQuotaExceeded(t *testing.T) {
+	l, advance := newTestLimiter(10, 0)
+
+	// the limiter pauses, ignoring reports made while paused
+	test.AssertEqual(t, time.Second, l.QuotaExceeded())
+	test.AssertEqual(t, time.Second, l.Reserve(1, 0))
+	advance(500 * time.Millisecond)
+	test.AssertEqual(t, 500*time.Millisecond, l.QuotaExceeded())
+
+	// after which the rate is halved
+	advance(500 * time.Millisecond)
+	test.AssertEqual(t, time.Duration(0), l.Reserve(10, 0))
+	test.AssertEqual(t, 200*time.Millisecond, l.Reserve(1, 0))
+
+	// the backoff doubles when exceeding the quota again prior to recovering
+	advance(time.Second)
+	test.AssertEqual(t, 2*time.Second, l.QuotaExceeded())
+	test.AssertEqual(t, 2.5/10, l.factor)
+
+	// the rate recovers gradually, restoring the configured rate
+	advance(rateRecoveryInterval)
+	l.Reserve(0, 0)
+	test.AssertEqual(t, 0.5, l.factor)
+	advance(rateRecoveryInterval)
+	l.Reserve(0, 0)
+	test.AssertEqual(t, 1.0, l.factor)
+
+	// the backoff resets once recovered
+	advance(time.Second)
+	test.AssertEqual(t, time.Second, l.QuotaExceeded())
+}
+
+func TestLimiterMinRate(t *testing.T) {
+	l, advance := newTestLimiter(10, 0)
+	for i := 0; i < 10; i++ {
+		l.QuotaExceeded()
+		advance(maxQuotaBackoff)
+	}
+	test.AssertEqual(t, minRateFactor, l.factor)
+}
+
+func TestLimiterWaitContextDone(t *testing.T) {
+	l := NewLimiter(1, 0)
+	_, err := l.Wait(context.Background(), 1, 0)
+	test.AssertNoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	delay, err := l.Wait(ctx, 1, 0)
+	test.AssertIsError(t, err, context.Canceled)
+	test.AssertTrue(t, delay > 0)
+}
@@ -156,13 +156,13 @@ func main() {
 					if !ok {
 						return
 					}
-					// nolint: govet
-					testCtx, _ := context.WithTimeout(ctx, time.Second*10)
+					testCtx, cancelTestCtx := context.WithTimeout(ctx, time.Second*10)
 					err := test(
 						testCtx,
 						iterations, logger,
 						bqProject, bqDataset, bqTable,
 					)
+					cancelTestCtx()
 					if err != nil {
 						select {
 						case <-ctx.Done():
@@ -0,0 +1,40 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+// Throttle is a single call recorded by a Throttler.
+type Throttle struct {
+	Rows  int
+	Bytes int
+}
+
+// Throttler is a `bqwriter/internal/bigquery.Throttler` implementation for (unit) testing purposes only,
+// recording the calls to Throttle without ever waiting.
+type Throttler struct {
+	// LimitBytes is returned by LimitsBytes
+	LimitBytes bool
+	// Calls are the recorded calls to Throttle
+	Calls []Throttle
+}
+
+// Throttle implements bqwriter/internal/bigquery.Throttler.Throttle
+func (t *Throttler) Throttle(rows, bytes int) {
+	t.Calls = append(t.Calls, Throttle{Rows: rows, Bytes: bytes})
+}
+
+// LimitsBytes implements bqwriter/internal/bigquery.Throttler.LimitsBytes
+func (t *Throttler) LimitsBytes() bool {
+	return t.LimitBytes
+}
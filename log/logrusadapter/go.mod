@@ -0,0 +1,14 @@
+module github.com/OTA-Insight/bqwriter/log/logrusadapter
+
+go 1.18
+
+require (
+	github.com/OTA-Insight/bqwriter v0.6.1-0.20261018213319-9db919172c03
+	github.com/sirupsen/logrus v1.8.1
+)
+
+require golang.org/x/sys v0.0.0-20211110154304-99a53858aa08 // indirect
+
+// the local bqwriter module is only used while developing within the bqwriter repository,
+// dependents of this module use the required bqwriter version, the first to define the structured logger API
+replace github.com/OTA-Insight/bqwriter => ../..
@@ -0,0 +1,86 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logrusadapter provides a bqwriter StructuredLogger
+// implementation for a github.com/sirupsen/logrus (Field)Logger.
+//
+// It is defined as a separate Go module, such that the bqwriter module
+// itself doesn't depend on the logrus module.
+package logrusadapter
+
+import (
+	"fmt"
+
+	"github.com/OTA-Insight/bqwriter/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Logger adapts a logrus.FieldLogger, such that it can be used as the Logger of a bqwriter Streamer.
+type Logger struct {
+	logger logrus.FieldLogger
+}
+
+// compile-time interface compliance
+var _ log.StructuredLogger = (*Logger)(nil)
+
+// New creates a new Logger, adapting the given logrus.FieldLogger
+// (e.g. a *logrus.Logger or *logrus.Entry), using logrus.StandardLogger()
+// in case no logger is given.
+func New(logger logrus.FieldLogger) *Logger {
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	return &Logger{
+		logger: logger,
+	}
+}
+
+// Debug implements log.Logger::Debug
+func (l *Logger) Debug(args ...interface{}) {
+	l.Log(log.LevelDebug, fmt.Sprint(args...))
+}
+
+// Debugf implements log.Logger::Debugf
+func (l *Logger) Debugf(template string, args ...interface{}) {
+	l.Log(log.LevelDebug, fmt.Sprintf(template, args...))
+}
+
+// Error implements log.Logger::Error
+func (l *Logger) Error(args ...interface{}) {
+	l.Log(log.LevelError, fmt.Sprint(args...))
+}
+
+// Errorf implements log.Logger::Errorf
+func (l *Logger) Errorf(template string, args ...interface{}) {
+	l.Log(log.LevelError, fmt.Sprintf(template, args...))
+}
+
+// Log implements log.StructuredLogger::Log
+func (l *Logger) Log(level log.Level, msg string, fields ...log.Field) {
+	logrusFields := make(logrus.Fields, len(fields))
+	for _, field := range fields {
+		logrusFields[field.Key] = field.Value
+	}
+	entry := l.logger.WithFields(logrusFields)
+	switch level {
+	case log.LevelDebug:
+		entry.Debug(msg)
+	case log.LevelInfo:
+		entry.Info(msg)
+	case log.LevelWarn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logrusadapter
+
+import (
+	"testing"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func newTestLogger() (*Logger, *logrustest.Hook) {
+	logrusLogger, hook := logrustest.NewNullLogger()
+	logrusLogger.SetLevel(logrus.DebugLevel)
+	return New(logrusLogger), hook
+}
+
+func TestLoggerLevels(t *testing.T) {
+	logger, hook := newTestLogger()
+	logger.Log(log.LevelDebug, "a")
+	logger.Log(log.LevelInfo, "b")
+	logger.Log(log.LevelWarn, "c")
+	logger.Log(log.LevelError, "d")
+	logger.Debug("e", 1)
+	logger.Debugf("f%d", 2)
+	logger.Error("g", 3)
+	logger.Errorf("h%d", 4)
+
+	expected := []struct {
+		Level logrus.Level
+		Msg   string
+	}{
+		{logrus.DebugLevel, "a"},
+		{logrus.InfoLevel, "b"},
+		{logrus.WarnLevel, "c"},
+		{logrus.ErrorLevel, "d"},
+		{logrus.DebugLevel, "e1"},
+		{logrus.DebugLevel, "f2"},
+		{logrus.ErrorLevel, "g3"},
+		{logrus.ErrorLevel, "h4"},
+	}
+	entries := hook.AllEntries()
+	test.AssertEqual(t, len(expected), len(entries))
+	for i, entry := range entries {
+		test.AssertEqual(t, expected[i].Level, entry.Level, "entry #%d", i)
+		test.AssertEqual(t, expected[i].Msg, entry.Message, "entry #%d", i)
+	}
+}
+
+func TestLoggerFields(t *testing.T) {
+	logger, hook := newTestLogger()
+	logger.Log(log.LevelInfo, "a", log.F(log.FieldTable, "p.d.t"), log.F(log.FieldRowCount, 42))
+
+	entry := hook.LastEntry()
+	test.AssertTrue(t, entry != nil)
+	test.AssertEqual(t, logrus.Fields{
+		log.FieldTable:    "p.d.t",
+		log.FieldRowCount: 42,
+	}, entry.Data)
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+// +build go1.21
+
+// Package slogadapter provides a bqwriter StructuredLogger
+// implementation for a standard library log/slog Logger.
+package slogadapter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+// Logger adapts a *slog.Logger, such that it can be used as the Logger of a bqwriter Streamer.
+type Logger struct {
+	logger *slog.Logger
+}
+
+// compile-time interface compliance
+var _ log.StructuredLogger = (*Logger)(nil)
+
+// New creates a new Logger, adapting the given *slog.Logger,
+// using slog.Default() in case no logger is given.
+func New(logger *slog.Logger) *Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Logger{
+		logger: logger,
+	}
+}
+
+// Debug implements log.Logger::Debug
+func (l *Logger) Debug(args ...interface{}) {
+	l.Log(log.LevelDebug, fmt.Sprint(args...))
+}
+
+// Debugf implements log.Logger::Debugf
+func (l *Logger) Debugf(template string, args ...interface{}) {
+	l.Log(log.LevelDebug, fmt.Sprintf(template, args...))
+}
+
+// Error implements log.Logger::Error
+func (l *Logger) Error(args ...interface{}) {
+	l.Log(log.LevelError, fmt.Sprint(args...))
+}
+
+// Errorf implements log.Logger::Errorf
+func (l *Logger) Errorf(template string, args ...interface{}) {
+	l.Log(log.LevelError, fmt.Sprintf(template, args...))
+}
+
+// Log implements log.StructuredLogger::Log
+func (l *Logger) Log(level log.Level, msg string, fields ...log.Field) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	l.logger.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level log.Level) slog.Level {
+	switch level {
+	case log.LevelDebug:
+		return slog.LevelDebug
+	case log.LevelInfo:
+		return slog.LevelInfo
+	case log.LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+// +build go1.21
+
+package slogadapter
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+// recordingHandler is a slog.Handler which records all handled records.
+type recordingHandler struct {
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	h.records = append(h.records, record)
+	return nil
+}
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler      { return h }
+
+func newTestLogger() (*Logger, *recordingHandler) {
+	handler := new(recordingHandler)
+	return New(slog.New(handler)), handler
+}
+
+func TestLoggerLevels(t *testing.T) {
+	logger, handler := newTestLogger()
+	logger.Log(log.LevelDebug, "a")
+	logger.Log(log.LevelInfo, "b")
+	logger.Log(log.LevelWarn, "c")
+	logger.Log(log.LevelError, "d")
+	logger.Debug("e", 1)
+	logger.Debugf("f%d", 2)
+	logger.Error("g", 3)
+	logger.Errorf("h%d", 4)
+
+	expected := []struct {
+		Level slog.Level
+		Msg   string
+	}{
+		{slog.LevelDebug, "a"},
+		{slog.LevelInfo, "b"},
+		{slog.LevelWarn, "c"},
+		{slog.LevelError, "d"},
+		{slog.LevelDebug, "e1"},
+		{slog.LevelDebug, "f2"},
+		{slog.LevelError, "g3"},
+		{slog.LevelError, "h4"},
+	}
+	test.AssertEqual(t, len(expected), len(handler.records))
+	for i, record := range handler.records {
+		test.AssertEqual(t, expected[i].Level, record.Level, "record #%d", i)
+		test.AssertEqual(t, expected[i].Msg, record.Message, "record #%d", i)
+	}
+}
+
+func TestLoggerFields(t *testing.T) {
+	logger, handler := newTestLogger()
+	logger.Log(log.LevelInfo, "a", log.F(log.FieldTable, "p.d.t"), log.F(log.FieldRowCount, 42))
+
+	test.AssertEqual(t, 1, len(handler.records))
+	attrs := make(map[string]interface{})
+	handler.records[0].Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value.Any()
+		return true
+	})
+	test.AssertEqual(t, map[string]interface{}{
+		log.FieldTable:    "p.d.t",
+		log.FieldRowCount: int64(42),
+	}, attrs)
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level defines the severity of a structured log message.
+type Level int
+
+const (
+	// LevelDebug is used for verbose messages, only useful while debugging.
+	LevelDebug Level = iota
+	// LevelInfo is used for messages that are informative, but require no action.
+	LevelInfo
+	// LevelWarn is used for messages that indicate something unexpected
+	// happened, which the streamer could recover from however.
+	LevelWarn
+	// LevelError is used for messages of errors that could not be handled
+	// and for which data might have been lost.
+	LevelError
+)
+
+// String implements fmt.Stringer::String
+func (lvl Level) String() string {
+	switch lvl {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(lvl))
+	}
+}
+
+// Keys of the fields attached by this module to its structured log messages.
+const (
+	// FieldTable is the key used for the fully qualified BigQuery table ID (project.dataset.table).
+	FieldTable = "table"
+	// FieldWorker is the key used for the (1-based) index of the worker goroutine logging the message.
+	FieldWorker = "worker"
+	// FieldClientType is the key used for the kind of client used by the streamer (insertAll, storage or batch).
+	FieldClientType = "client_type"
+	// FieldRowCount is the key used for the amount of rows the message relates to.
+	FieldRowCount = "row_count"
+	// FieldBatchSize is the key used for the (target) amount of rows written by a client at once.
+	FieldBatchSize = "batch_size"
+	// FieldError is the key used for the error the message relates to.
+	FieldError = "error"
+)
+
+// Field is a key-value pair which can be attached to a structured log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a new Field, a short-hand for the Field literal.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// StructuredLogger is an optional extension of the Logger interface.
+// When the Logger injected into a Streamer implements this interface as well,
+// the streamer will use it to log messages with a level (Debug, Info, Warn or Error)
+// and with key-value fields attached (e.g. table, worker, client_type and row_count)
+// rather than flattening it all into a single string.
+//
+// Adapters for popular logging packages can be found in the subpackages of this package.
+//
+// NOTE that it is assumed by this module for a StructuredLogger implementation to be safe for concurrent use.
+type StructuredLogger interface {
+	Logger
+
+	// Log a message with the given level and fields.
+	Log(level Level, msg string, fields ...Field)
+}
+
+// Log a message with the given level and fields using the given Logger.
+//
+// The message is passed as-is to the logger in case it implements the StructuredLogger interface.
+// Otherwise the fields are appended in a key=value format to the message, with the Debug and Info levels
+// logged as a debug message and the Warn and Error levels logged as an error message.
+func Log(logger Logger, level Level, msg string, fields ...Field) {
+	if sl, ok := logger.(StructuredLogger); ok {
+		sl.Log(level, msg, fields...)
+		return
+	}
+	msg = appendFields(msg, fields)
+	if level >= LevelWarn {
+		logger.Error(msg)
+	} else {
+		logger.Debug(msg)
+	}
+}
+
+// appendFields formats the given fields, in order, as key=value pairs
+// and appends them to the message, separated by a space.
+func appendFields(msg string, fields []Field) string {
+	if len(fields) == 0 {
+		return msg
+	}
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, field := range fields {
+		fmt.Fprintf(&sb, " %s=%v", field.Key, field.Value)
+	}
+	return sb.String()
+}
+
+// WithFields returns a StructuredLogger which attaches the given fields to all messages logged by it,
+// prior to any fields given for a specific message. The given Logger is used to actually
+// log the messages, see Log for more information on how this is done for a Logger that doesn't
+// implement the StructuredLogger interface.
+func WithFields(logger Logger, fields ...Field) StructuredLogger {
+	if fl, ok := logger.(*fieldLogger); ok {
+		// flatten, such that we do not need to walk a chain of loggers for every message
+		merged := make([]Field, 0, len(fl.fields)+len(fields))
+		merged = append(merged, fl.fields...)
+		merged = append(merged, fields...)
+		return &fieldLogger{
+			logger: fl.logger,
+			fields: merged,
+		}
+	}
+	return &fieldLogger{
+		logger: logger,
+		fields: fields,
+	}
+}
+
+// fieldLogger is the StructuredLogger implementation returned by WithFields.
+type fieldLogger struct {
+	logger Logger
+	fields []Field
+}
+
+// Debug implements Logger::Debug
+func (fl *fieldLogger) Debug(args ...interface{}) {
+	fl.Log(LevelDebug, fmt.Sprint(args...))
+}
+
+// Debugf implements Logger::Debugf
+func (fl *fieldLogger) Debugf(template string, args ...interface{}) {
+	fl.Log(LevelDebug, fmt.Sprintf(template, args...))
+}
+
+// Error implements Logger::Error
+func (fl *fieldLogger) Error(args ...interface{}) {
+	fl.Log(LevelError, fmt.Sprint(args...))
+}
+
+// Errorf implements Logger::Errorf
+func (fl *fieldLogger) Errorf(template string, args ...interface{}) {
+	fl.Log(LevelError, fmt.Sprintf(template, args...))
+}
+
+// Log implements StructuredLogger::Log
+func (fl *fieldLogger) Log(level Level, msg string, fields ...Field) {
+	if len(fields) == 0 {
+		Log(fl.logger, level, msg, fl.fields...)
+		return
+	}
+	merged := make([]Field, 0, len(fl.fields)+len(fields))
+	merged = append(merged, fl.fields...)
+	merged = append(merged, fields...)
+	Log(fl.logger, level, msg, merged...)
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type recordedMessage struct {
+	Level  Level
+	Msg    string
+	Fields []Field
+}
+
+// plainLogger is a Logger which doesn't implement the StructuredLogger interface.
+type plainLogger struct {
+	debug []string
+	error []string
+}
+
+func (l *plainLogger) Debug(args ...interface{}) { l.debug = append(l.debug, fmt.Sprint(args...)) }
+func (l *plainLogger) Debugf(template string, args ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(template, args...))
+}
+func (l *plainLogger) Error(args ...interface{}) { l.error = append(l.error, fmt.Sprint(args...)) }
+func (l *plainLogger) Errorf(template string, args ...interface{}) {
+	l.error = append(l.error, fmt.Sprintf(template, args...))
+}
+
+// structuredLogger is a StructuredLogger which records all messages logged via Log.
+type structuredLogger struct {
+	plainLogger
+	messages []recordedMessage
+}
+
+func (l *structuredLogger) Log(level Level, msg string, fields ...Field) {
+	l.messages = append(l.messages, recordedMessage{Level: level, Msg: msg, Fields: fields})
+}
+
+func TestLogPlainLogger(t *testing.T) {
+	logger := new(plainLogger)
+	Log(logger, LevelDebug, "a")
+	Log(logger, LevelInfo, "b", F("x", 1))
+	Log(logger, LevelWarn, "c", F("x", 1), F("y", "z"))
+	Log(logger, LevelError, "d")
+
+	if expected := []string{"a", "b x=1"}; !reflect.DeepEqual(expected, logger.debug) {
+		t.Errorf("unexpected debug messages, expected %v, got %v", expected, logger.debug)
+	}
+	if expected := []string{"c x=1 y=z", "d"}; !reflect.DeepEqual(expected, logger.error) {
+		t.Errorf("unexpected error messages, expected %v, got %v", expected, logger.error)
+	}
+}
+
+func TestLogStructuredLogger(t *testing.T) {
+	logger := new(structuredLogger)
+	Log(logger, LevelWarn, "c", F("x", 1))
+	expected := []recordedMessage{{Level: LevelWarn, Msg: "c", Fields: []Field{F("x", 1)}}}
+	if !reflect.DeepEqual(expected, logger.messages) {
+		t.Errorf("unexpected messages, expected %v, got %v", expected, logger.messages)
+	}
+	if len(logger.debug) != 0 || len(logger.error) != 0 {
+		t.Errorf("unexpected unstructured messages: %v, %v", logger.debug, logger.error)
+	}
+}
+
+func TestWithFieldsStructuredLogger(t *testing.T) {
+	logger := new(structuredLogger)
+	fl := WithFields(WithFields(logger, F("a", 1)), F("b", 2))
+	fl.Debugf("hello %s", "world")
+	fl.Error("oops")
+	fl.Log(LevelInfo, "info", F("c", 3))
+
+	expected := []recordedMessage{
+		{Level: LevelDebug, Msg: "hello world", Fields: []Field{F("a", 1), F("b", 2)}},
+		{Level: LevelError, Msg: "oops", Fields: []Field{F("a", 1), F("b", 2)}},
+		{Level: LevelInfo, Msg: "info", Fields: []Field{F("a", 1), F("b", 2), F("c", 3)}},
+	}
+	if !reflect.DeepEqual(expected, logger.messages) {
+		t.Errorf("unexpected messages, expected %v, got %v", expected, logger.messages)
+	}
+}
+
+func TestWithFieldsPlainLogger(t *testing.T) {
+	logger := new(plainLogger)
+	fl := WithFields(logger, F(FieldWorker, 1))
+	fl.Debug("hello")
+	fl.Log(LevelWarn, "warn", F(FieldRowCount, 42))
+
+	if expected := []string{"hello worker=1"}; !reflect.DeepEqual(expected, logger.debug) {
+		t.Errorf("unexpected debug messages, expected %v, got %v", expected, logger.debug)
+	}
+	if expected := []string{"warn worker=1 row_count=42"}; !reflect.DeepEqual(expected, logger.error) {
+		t.Errorf("unexpected error messages, expected %v, got %v", expected, logger.error)
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/OTA-Insight/bqwriter/log/zapadapter
+
+go 1.18
+
+require (
+	github.com/OTA-Insight/bqwriter v0.6.1-0.20261018213319-9db919172c03
+	go.uber.org/zap v1.19.1
+)
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
+
+// the local bqwriter module is only used while developing within the bqwriter repository,
+// dependents of this module use the required bqwriter version, the first to define the structured logger API
+replace github.com/OTA-Insight/bqwriter => ../..
@@ -0,0 +1,74 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zapadapter
+
+import (
+	"testing"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestLogger() (*Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return New(zap.New(core)), logs
+}
+
+func TestLoggerLevels(t *testing.T) {
+	logger, logs := newTestLogger()
+	logger.Log(log.LevelDebug, "a")
+	logger.Log(log.LevelInfo, "b")
+	logger.Log(log.LevelWarn, "c")
+	logger.Log(log.LevelError, "d")
+	logger.Debug("e", 1)
+	logger.Debugf("f%d", 2)
+	logger.Error("g", 3)
+	logger.Errorf("h%d", 4)
+
+	expected := []struct {
+		Level zapcore.Level
+		Msg   string
+	}{
+		{zapcore.DebugLevel, "a"},
+		{zapcore.InfoLevel, "b"},
+		{zapcore.WarnLevel, "c"},
+		{zapcore.ErrorLevel, "d"},
+		{zapcore.DebugLevel, "e1"},
+		{zapcore.DebugLevel, "f2"},
+		{zapcore.ErrorLevel, "g3"},
+		{zapcore.ErrorLevel, "h4"},
+	}
+	entries := logs.AllUntimed()
+	test.AssertEqual(t, len(expected), len(entries))
+	for i, entry := range entries {
+		test.AssertEqual(t, expected[i].Level, entry.Level, "entry #%d", i)
+		test.AssertEqual(t, expected[i].Msg, entry.Message, "entry #%d", i)
+	}
+}
+
+func TestLoggerFields(t *testing.T) {
+	logger, logs := newTestLogger()
+	logger.Log(log.LevelInfo, "a", log.F(log.FieldTable, "p.d.t"), log.F(log.FieldRowCount, 42))
+
+	entries := logs.AllUntimed()
+	test.AssertEqual(t, 1, len(entries))
+	test.AssertEqual(t, map[string]interface{}{
+		log.FieldTable:    "p.d.t",
+		log.FieldRowCount: int64(42),
+	}, entries[0].ContextMap())
+}
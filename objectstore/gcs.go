@@ -0,0 +1,75 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// GCSStore is an ObjectStore storing its objects in a Google Cloud Storage bucket.
+type GCSStore struct {
+	service *storage.Service
+	bucket  string
+}
+
+// interface compile-time compliance check
+var _ ObjectStore = (*GCSStore)(nil)
+
+// NewGCSStore creates a new GCSStore, storing the objects in the given (existing) bucket.
+// The client options can be used to configure the authorization of the underlying Cloud Storage client,
+// which uses the application default credentials by default, the same way as the BigQuery clients.
+func NewGCSStore(ctx context.Context, bucket string, opts ...option.ClientOption) (*GCSStore, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("new GCS object store: validate bucket: %w", ErrInvalidName)
+	}
+	service, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("new GCS object store: create storage service: %w", err)
+	}
+	return &GCSStore{
+		service: service,
+		bucket:  bucket,
+	}, nil
+}
+
+// Put implements ObjectStore::Put
+func (s *GCSStore) Put(ctx context.Context, name string, r io.Reader) error {
+	if name == "" {
+		return fmt.Errorf("GCS object store: put object: %w: empty name", ErrInvalidName)
+	}
+	_, err := s.service.Objects.Insert(s.bucket, &storage.Object{Name: name}).Media(r).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("GCS object store: put object %s: %w", s.URI(name), err)
+	}
+	return nil
+}
+
+// Delete implements ObjectStore::Delete
+func (s *GCSStore) Delete(ctx context.Context, name string) error {
+	if err := s.service.Objects.Delete(s.bucket, name).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("GCS object store: delete object %s: %w", s.URI(name), err)
+	}
+	return nil
+}
+
+// URI implements ObjectStore::URI
+func (s *GCSStore) URI(name string) string {
+	return "gs://" + s.bucket + "/" + name
+}
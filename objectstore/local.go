@@ -0,0 +1,113 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore is an ObjectStore storing its objects as files within a directory of the local filesystem,
+// with the (slash-separated) object names used as their path relative to that directory.
+//
+// As BigQuery cannot load files from the local filesystem, it is meant to be used for (offline) testing only,
+// e.g. to test code staging files in an ObjectStore without Cloud Storage. For the same reason
+// it is rejected as the ObjectStore of the StagingConfig of a Streamer, which loads its files using BigQuery.
+type LocalStore struct {
+	dir string
+}
+
+// interface compile-time compliance check
+var _ ObjectStore = (*LocalStore)(nil)
+
+// NewLocalStore creates a new LocalStore, storing the objects within the given directory,
+// which is created in case it does not exist yet.
+func NewLocalStore(dir string) (*LocalStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("new local object store: resolve directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("new local object store: create directory: %w", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+// Put implements ObjectStore::Put
+//
+// The object is written to a temporary file first, which is renamed once it is written completely,
+// such that an object is never observed partially written.
+func (s *LocalStore) Put(_ context.Context, name string, r io.Reader) error {
+	path, err := s.path(name)
+	if err != nil {
+		return fmt.Errorf("local object store: put object: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("local object store: put object %s: create directory: %w", name, err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("local object store: put object %s: create file: %w", name, err)
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("local object store: put object %s: write file: %w", name, err)
+	}
+	return nil
+}
+
+// Delete implements ObjectStore::Delete
+func (s *LocalStore) Delete(_ context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return fmt.Errorf("local object store: delete object: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("local object store: delete object %s: %w", name, err)
+	}
+	return nil
+}
+
+// URI implements ObjectStore::URI
+//
+// The returned file:// URI cannot be loaded by BigQuery, it only identifies the file of the object.
+func (s *LocalStore) URI(name string) string {
+	return "file://" + filepath.ToSlash(filepath.Join(s.dir, filepath.FromSlash(name)))
+}
+
+// path returns the path of the file of the object with the given name,
+// which has to be a relative path within the directory of the store.
+func (s *LocalStore) path(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	path := filepath.Join(s.dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(path, s.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q is not within the directory of the store", ErrInvalidName, name)
+	}
+	return path, nil
+}
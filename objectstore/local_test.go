@@ -0,0 +1,69 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OTA-Insight/bqwriter/internal/test"
+)
+
+func TestLocalStorePutDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bqwriter-objectstore")
+	test.AssertNoErrorFatal(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(filepath.Join(dir, "bucket"))
+	test.AssertNoErrorFatal(t, err)
+	ctx := context.Background()
+
+	test.AssertNoError(t, store.Put(ctx, "foo/bar.avro", strings.NewReader("data")))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "bucket", "foo", "bar.avro"))
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, "data", string(b))
+	test.AssertEqual(t, "file://"+filepath.ToSlash(filepath.Join(dir, "bucket", "foo", "bar.avro")), store.URI("foo/bar.avro"))
+
+	// an existing object is replaced, without leaving any temporary files behind
+	test.AssertNoError(t, store.Put(ctx, "foo/bar.avro", strings.NewReader("new data")))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "bucket", "foo", "bar.avro"))
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, "new data", string(b))
+	files, err := ioutil.ReadDir(filepath.Join(dir, "bucket", "foo"))
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, 1, len(files))
+
+	test.AssertNoError(t, store.Delete(ctx, "foo/bar.avro"))
+	_, err = os.Stat(filepath.Join(dir, "bucket", "foo", "bar.avro"))
+	test.AssertTrue(t, os.IsNotExist(err))
+	test.AssertError(t, store.Delete(ctx, "foo/bar.avro"))
+}
+
+func TestLocalStoreInvalidNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bqwriter-objectstore")
+	test.AssertNoErrorFatal(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir)
+	test.AssertNoErrorFatal(t, err)
+	for _, name := range []string{"", "/foo", "../foo", "foo/../../bar", "."} {
+		test.AssertIsError(t, store.Put(context.Background(), name, strings.NewReader("data")), ErrInvalidName)
+		test.AssertIsError(t, store.Delete(context.Background(), name), ErrInvalidName)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package objectstore provides the ObjectStore interface used by the batch client
+// to stage its load files as objects, prior to loading them into BigQuery,
+// with a Google Cloud Storage implementation and a local filesystem implementation for testing.
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ObjectStore is the interface used by the batch client in order to stage the files it loads as objects,
+// such that these can be loaded by BigQuery from Cloud Storage, rather than uploading them inline as part of the load job.
+//
+// NOTE that it is assumed by this module for an ObjectStore implementation to be safe for concurrent use.
+type ObjectStore interface {
+	// Put writes the data read from the given reader as the object with the given name,
+	// replacing the object in case it already exists.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Delete the object with the given name.
+	Delete(ctx context.Context, name string) error
+	// URI returns the URI of the object with the given name, used to load the object
+	// (e.g. gs://bucket/name for objects stored in Cloud Storage).
+	URI(name string) string
+}
+
+// ErrInvalidName is an error returned in case an object name is invalid for the ObjectStore.
+var ErrInvalidName = errors.New("invalid object name")
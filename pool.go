@@ -0,0 +1,200 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"google.golang.org/api/option"
+)
+
+type (
+	// Pool holds the Google API clients of a project, allowing them to be shared by the workers
+	// of one or multiple Streamers (see the Pool property of the StreamerConfig), rather than each worker
+	// creating its own clients, with their own connections and credentials to refresh.
+	//
+	// The clients are created once used, and closed once the pool as well as all
+	// the Streamers using it are closed, regardless of the order in which they are closed.
+	Pool struct {
+		projectID  string
+		bqOpts     []option.ClientOption
+		writerOpts []option.ClientOption
+
+		mu sync.Mutex
+		// refs counts the references to the pool, that is one per client acquired
+		// from it by the Streamers, as well as the one of the pool itself until it is closed
+		refs     int
+		closed   bool
+		bigQuery *bq.Client
+		writer   *managedwriter.Client
+	}
+
+	// PoolConfig is used to configure a Pool.
+	// All configurations found in this structure are optional and have sane defaults.
+	PoolConfig struct {
+		// GRPCConnectionPoolSize defines the amount of gRPC connections
+		// of the Storage Write API client, shared by all Storage API driven Streamers using the pool.
+		//
+		// Defaults to the default of the Google API client if 0.
+		GRPCConnectionPoolSize int
+
+		// ClientOptions are used to create all clients of the pool,
+		// e.g. in order to define the credentials used by them.
+		ClientOptions []option.ClientOption
+	}
+)
+
+// NewPool creates a new Pool for the given project. PoolConfig is optional.
+// The Pool is to be closed once it is no longer used to create new Streamers.
+func NewPool(projectID string, cfg *PoolConfig) (*Pool, error) {
+	if projectID == "" {
+		return nil, fmt.Errorf("pool creation: validate projectID: %w: missing", internal.ErrInvalidParam)
+	}
+	if cfg == nil {
+		cfg = new(PoolConfig)
+	}
+	if cfg.GRPCConnectionPoolSize < 0 {
+		return nil, fmt.Errorf("pool creation: validate GRPCConnectionPoolSize: %w: %d", internal.ErrInvalidParam, cfg.GRPCConnectionPoolSize)
+	}
+	p := &Pool{
+		projectID: projectID,
+		bqOpts:    append([]option.ClientOption(nil), cfg.ClientOptions...),
+		refs:      1,
+	}
+	p.writerOpts = append([]option.ClientOption(nil), cfg.ClientOptions...)
+	if cfg.GRPCConnectionPoolSize > 0 {
+		p.writerOpts = append(p.writerOpts, option.WithGRPCConnectionPool(cfg.GRPCConnectionPoolSize))
+	}
+	return p, nil
+}
+
+// Close closes the pool, after which it can no longer be used by new Streamers.
+// The clients of the pool are closed once all Streamers using it are closed as well.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	return p.releaseLocked()
+}
+
+// bigQueryClient returns the BigQuery client of the pool, creating it if required,
+// acquiring a reference to the pool.
+func (p *Pool) bigQueryClient() (*bq.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, internal.ErrPoolClosed
+	}
+	if p.bigQuery == nil {
+		// NOTE: we are using the background Context,
+		// as the client is to outlive the Streamers using it
+		client, err := bq.NewClient(context.Background(), p.projectID, p.bqOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create BQ client: %w", err)
+		}
+		p.bigQuery = client
+	}
+	p.refs++
+	return p.bigQuery, nil
+}
+
+// writerClient returns the Storage Write API client of the pool, creating it if required,
+// acquiring a reference to the pool.
+func (p *Pool) writerClient() (*managedwriter.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, internal.ErrPoolClosed
+	}
+	if p.writer == nil {
+		// NOTE: we are using the background Context,
+		// as the client is to outlive the Streamers using it
+		client, err := managedwriter.NewClient(context.Background(), p.projectID, p.writerOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create BQ Storage writer client: %w", err)
+		}
+		p.writer = client
+	}
+	p.refs++
+	return p.writer, nil
+}
+
+// release releases a reference to the pool, acquired by one of its clients.
+func (p *Pool) release() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.releaseLocked()
+}
+
+// releaseLocked releases a reference to the pool, closing its clients in case it was the last one.
+func (p *Pool) releaseLocked() error {
+	p.refs--
+	if p.refs > 0 {
+		return nil
+	}
+	var err error
+	if p.bigQuery != nil {
+		if closeErr := p.bigQuery.Close(); closeErr != nil {
+			err = fmt.Errorf("close pool: close BQ client: %w", closeErr)
+		}
+		p.bigQuery = nil
+	}
+	if p.writer != nil {
+		if closeErr := p.writer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close pool: close BQ Storage writer client: %w", closeErr)
+		}
+		p.writer = nil
+	}
+	return err
+}
+
+// clientPool returns the pool as used by the internal clients,
+// returning nil in case the pool itself is nil.
+func (p *Pool) clientPool() bigquery.ClientPool {
+	if p == nil {
+		return nil
+	}
+	return poolClients{pool: p}
+}
+
+// poolClients implements bigquery.ClientPool for a Pool,
+// without exposing the internal API as part of the Pool.
+type poolClients struct {
+	pool *Pool
+}
+
+// BigQueryClient implements bigquery.ClientPool::BigQueryClient
+func (pc poolClients) BigQueryClient() (*bq.Client, error) {
+	return pc.pool.bigQueryClient()
+}
+
+// WriterClient implements bigquery.ClientPool::WriterClient
+func (pc poolClients) WriterClient() (*managedwriter.Client, error) {
+	return pc.pool.writerClient()
+}
+
+// Release implements bigquery.ClientPool::Release
+func (pc poolClients) Release() error {
+	return pc.pool.release()
+}
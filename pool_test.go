@@ -0,0 +1,103 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+func newTestPool(t *testing.T) *Pool {
+	pool, err := NewPool("a", &PoolConfig{
+		GRPCConnectionPoolSize: 2,
+		ClientOptions: []option.ClientOption{
+			option.WithoutAuthentication(),
+			option.WithEndpoint("localhost:1"),
+			option.WithGRPCDialOption(grpc.WithInsecure()),
+		},
+	})
+	test.AssertNoErrorFatal(t, err)
+	return pool
+}
+
+func TestNewPoolInputErrors(t *testing.T) {
+	_, err := NewPool("", nil)
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+	_, err = NewPool("a", &PoolConfig{GRPCConnectionPoolSize: -1})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+}
+
+func TestPoolSharesClients(t *testing.T) {
+	pool := newTestPool(t)
+	defer pool.Close()
+	clients := pool.clientPool()
+
+	bqClientA, err := clients.BigQueryClient()
+	test.AssertNoErrorFatal(t, err)
+	bqClientB, err := clients.BigQueryClient()
+	test.AssertNoErrorFatal(t, err)
+	test.AssertTrue(t, bqClientA == bqClientB)
+
+	writerClientA, err := clients.WriterClient()
+	test.AssertNoErrorFatal(t, err)
+	writerClientB, err := clients.WriterClient()
+	test.AssertNoErrorFatal(t, err)
+	test.AssertTrue(t, writerClientA == writerClientB)
+
+	for i := 0; i < 4; i++ {
+		test.AssertNoError(t, clients.Release())
+	}
+}
+
+func TestPoolRefCountedClose(t *testing.T) {
+	pool := newTestPool(t)
+	clients := pool.clientPool()
+	_, err := clients.BigQueryClient()
+	test.AssertNoErrorFatal(t, err)
+	_, err = clients.WriterClient()
+	test.AssertNoErrorFatal(t, err)
+
+	// the clients outlive the pool for as long as they are in use,
+	// but no new clients can be acquired once the pool is closed
+	test.AssertNoError(t, pool.Close())
+	test.AssertNoError(t, pool.Close())
+	_, err = clients.BigQueryClient()
+	test.AssertIsError(t, err, internal.ErrPoolClosed)
+	test.AssertTrue(t, pool.bigQuery != nil)
+	test.AssertTrue(t, pool.writer != nil)
+
+	// the clients are closed once the last one is released
+	test.AssertNoError(t, clients.Release())
+	test.AssertTrue(t, pool.bigQuery != nil)
+	test.AssertNoError(t, clients.Release())
+	test.AssertTrue(t, pool.bigQuery == nil)
+	test.AssertTrue(t, pool.writer == nil)
+}
+
+func TestNewStreamerPoolProjectMismatch(t *testing.T) {
+	pool := newTestPool(t)
+	defer pool.Close()
+	_, err := newStreamerWithClientBuilder(
+		context.Background(), nil, nil,
+		"other", "b", "c",
+		&StreamerConfig{Pool: pool},
+	)
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+}
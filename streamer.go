@@ -20,18 +20,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"strings"
 	"sync"
 	"time"
 
-	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	bq "cloud.google.com/go/bigquery"
 	"github.com/OTA-Insight/bqwriter/internal"
 	"github.com/OTA-Insight/bqwriter/internal/bigquery"
 	"github.com/OTA-Insight/bqwriter/internal/bigquery/batch"
+	batchencoding "github.com/OTA-Insight/bqwriter/internal/bigquery/batch/encoding"
 	"github.com/OTA-Insight/bqwriter/internal/bigquery/insertall"
 	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage"
 	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding"
+	"github.com/OTA-Insight/bqwriter/internal/ratelimit"
 	"github.com/OTA-Insight/bqwriter/log"
-	"google.golang.org/protobuf/reflect/protoreflect"
+	"github.com/OTA-Insight/bqwriter/objectstore"
 )
 
 // Streamer is a simple BQ stream-writer, allowing you
@@ -39,15 +43,36 @@ import (
 type Streamer struct {
 	logger log.Logger
 
-	workerWg       sync.WaitGroup
-	workerCh       chan streamerJob
+	projectID string
+	dataSetID string
+	tableID   string
+	cfg       *StreamerConfig
+
+	workerWg sync.WaitGroup
+	workerCh chan streamerJob
+	// shardChs defines the job queue per worker in case the rows are sharded,
+	// in which case the (shared) workerCh is not used
+	shardChs       []chan streamerJob
 	workerCtx      context.Context
 	workerCancelFn func()
+
+	// used to spawn the workers, each with their own client
+	clientBuilder clientBuilderFunc
+	workerLogger  log.StructuredLogger
+
+	// limiters respected by the workers prior to writing their rows
+	limiters       []*ratelimit.Limiter
+	rateLimitStats *rateLimitStats
+
+	// dedup drops duplicate rows prior to writing them, nil in case deduplication is disabled
+	dedup *deduplicator
 }
 
-// streamerJob is all info required in order to write a row of data to BQ, the job of this streamer.
+// streamerJob is all info required in order to write a row (or batch of rows) of data to BQ, the job of this streamer.
 type streamerJob struct {
 	Data interface{}
+	// Rows is defined instead of Data for a batch of rows
+	Rows []interface{}
 }
 
 // NewStreamer creates a new Streamer Client. StreamerConfig is optional,
@@ -56,6 +81,16 @@ type streamerJob struct {
 // An error is returned in case the Streamer Client couldn't be created for some unexpected reason,
 // most likely something going wrong within the layer of actually interacting with GCloud.
 func NewStreamer(ctx context.Context, projectID, dataSetID, tableID string, cfg *StreamerConfig) (*Streamer, error) {
+	// the load jobs in flight are bounded for the streamer as a whole,
+	// and thus shared by the batch clients of all its workers (created sequentially)
+	var batchJobs *batch.JobLimiter
+	// load jobs are submitted one at a time per worker in case the rows are sharded
+	orderedLoads := cfg != nil && cfg.ShardKey != nil
+	// the clients of all workers are acquired from the pool, if defined
+	var pool bigquery.ClientPool
+	if cfg != nil {
+		pool = cfg.Pool.clientPool()
+	}
 	return newStreamerWithClientBuilder(
 		ctx,
 		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
@@ -67,37 +102,37 @@ func NewStreamer(ctx context.Context, projectID, dataSetID, tableID string, cfg
 				protobufDescriptor := storageCfg.ProtobufDescriptor
 				var encoder encoding.Encoder
 				if protobufDescriptor != nil {
-					encoder = encoding.NewProtobufEncoder()
+					var err error
+					encoder, protobufDescriptor, err = encoding.NewProtobufEncoderForDescriptor(protobufDescriptor)
+					if err != nil {
+						return nil, fmt.Errorf("BigQuery: NewStreamer: New Protobuf encoding Storage client: create protobuf encoder: %w", err)
+					}
 				} else {
 					// if no protobuf descriptor is given we can assume, thanks to the stream config,
 					// that the big query schema is given if no protobuf scriptor is given
-					var err error
-					encoder, err = encoding.NewSchemaEncoder(*storageCfg.BigQuerySchema)
-					if err != nil {
-						return nil, fmt.Errorf("BigQuery: NewStreamer: New BigQuery-Schema encoding Storage client: create schema encoder: %w", err)
+					var (
+						schemaEncoder encoding.SchemaBasedEncoder
+						err           error
+					)
+					if storageCfg.UseStructEncoder {
+						schemaEncoder, err = encoding.NewStructEncoder(storageCfg.RowType, *storageCfg.BigQuerySchema)
+					} else {
+						schemaEncoder, err = encoding.NewSchemaEncoder(*storageCfg.BigQuerySchema)
 					}
-					convertedSchema, err := adapt.BQSchemaToStorageTableSchema(*storageCfg.BigQuerySchema)
 					if err != nil {
-						return nil, fmt.Errorf("BigQuery: NewStreamer: adapt.BQSchemaToStorageTableSchema: %w", err)
-					}
-
-					descriptor, err := adapt.StorageSchemaToProto2Descriptor(convertedSchema, "root")
-					if err != nil {
-						return nil, fmt.Errorf("BigQuery: NewStreamer: adapt.StorageSchemaToDescriptor: %w", err)
-					}
-					messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
-					if !ok {
-						// nolint: goerr113
-						return nil, errors.New("BigQuery: NewStreamer: adapted descriptor is not a message descriptor")
+						return nil, fmt.Errorf("BigQuery: NewStreamer: New BigQuery-Schema encoding Storage client: create schema encoder: %w", err)
 					}
-					protobufDescriptor, err = adapt.NormalizeDescriptor(messageDescriptor)
+					protobufDescriptor, err = schemaEncoder.NormalizedDescriptorProto()
 					if err != nil {
-						return nil, fmt.Errorf("BigQuery: NewStreamer: adapt.NormalizeDescriptor: %w", err)
+						return nil, fmt.Errorf("BigQuery: NewStreamer: New BigQuery-Schema encoding Storage client: %w", err)
 					}
+					encoder = schemaEncoder
 				}
 				client, err := storage.NewClient(
 					projectID, dataSetID, tableID,
 					encoder, protobufDescriptor,
+					storageCfg.EvolveSchema,
+					pool,
 					logger,
 				)
 				if err != nil {
@@ -107,11 +142,75 @@ func NewStreamer(ctx context.Context, projectID, dataSetID, tableID string, cfg
 			}
 
 			if batchCfg != nil {
+				// rows (other than an io.Reader) are encoded by the client itself for Avro and Parquet files,
+				// as the sanitized config guarantees a BigQuery schema is defined for these formats,
+				// as well as for CSV files in case a BigQuery schema is defined
+				var (
+					newRowWriter batchencoding.NewRowWriterFunc
+					err          error
+				)
+				switch batchCfg.SourceFormat {
+				case bq.Avro:
+					newRowWriter, err = batchencoding.NewAvroWriterFunc(*batchCfg.BigQuerySchema, batchCfg.Compression)
+				case bq.Parquet:
+					newRowWriter, err = batchencoding.NewParquetWriterFunc(*batchCfg.BigQuerySchema, batchCfg.Compression)
+				case bq.CSV:
+					if batchCfg.BigQuerySchema != nil {
+						var csvOptions bq.CSVOptions
+						if batchCfg.CSVOptions != nil {
+							csvOptions = *batchCfg.CSVOptions
+						}
+						newRowWriter, err = batchencoding.NewCSVWriterFunc(*batchCfg.BigQuerySchema, csvOptions)
+					}
+				}
+				if err != nil {
+					return nil, fmt.Errorf("BigQuery: NewStreamer: New BigQuery-Schema Batch client: create %s row writer: %w", batchCfg.SourceFormat, err)
+				}
+				var staging *batch.StagingOptions
+				if batchCfg.Staging != nil {
+					staging = &batch.StagingOptions{
+						Store:           batchCfg.Staging.ObjectStore,
+						Prefix:          batchCfg.Staging.Prefix,
+						DeleteAfterLoad: batchCfg.Staging.DeleteAfterLoad,
+					}
+					if staging.Store == nil {
+						staging.Store, err = objectstore.NewGCSStore(ctx, batchCfg.Staging.Bucket)
+						if err != nil {
+							return nil, fmt.Errorf("BigQuery: NewStreamer: New BigQuery-Schema Batch client: create staging object store: %w", err)
+						}
+					}
+				}
+				if batchJobs == nil {
+					batchJobs = batch.NewJobLimiter(batchCfg.MaxInFlightJobs)
+				}
 				client, err := batch.NewClient(
 					projectID, dataSetID, tableID,
 					!batchCfg.FailForUnknownValues,
 					batchCfg.SourceFormat, batchCfg.WriteDisposition,
-					batchCfg.BigQuerySchema, logger,
+					batchCfg.BigQuerySchema,
+					batch.LoadOptions{
+						Staging:                     staging,
+						CSVOptions:                  batchCfg.CSVOptions,
+						ParquetOptions:              batchCfg.ParquetOptions,
+						IgnoreAvroLogicalTypes:      batchCfg.IgnoreAvroLogicalTypes,
+						DecimalTargetTypes:          batchCfg.DecimalTargetTypes,
+						CreateDisposition:           batchCfg.CreateDisposition,
+						TimePartitioning:            batchCfg.TimePartitioning,
+						RangePartitioning:           batchCfg.RangePartitioning,
+						Clustering:                  batchCfg.Clustering,
+						SchemaUpdateOptions:         batchCfg.SchemaUpdateOptions,
+						DestinationEncryptionConfig: batchCfg.DestinationEncryptionConfig,
+						Labels:                      batchCfg.Labels,
+						MaxBadRecords:               batchCfg.MaxBadRecords,
+						JobIDPrefix:                 batchCfg.JobIDPrefix,
+						IdempotentLoads:             batchCfg.IdempotentLoads,
+						MaxRetryDeadlineOffset:      batchCfg.MaxRetryDeadlineOffset,
+						Ordered:                     orderedLoads,
+					},
+					newRowWriter, batchCfg.BatchSize,
+					batchJobs,
+					pool,
+					logger,
 				)
 
 				if err != nil {
@@ -120,11 +219,24 @@ func NewStreamer(ctx context.Context, projectID, dataSetID, tableID string, cfg
 				return client, nil
 			}
 
+			var adaptive *insertall.AdaptiveBatchOptions
+			if adaptiveCfg := insertAllCfg.AdaptiveBatchSize; adaptiveCfg != nil {
+				adaptive = &insertall.AdaptiveBatchOptions{
+					MinBatchSize:       adaptiveCfg.MinBatchSize,
+					MaxBatchSize:       adaptiveCfg.MaxBatchSize,
+					TargetFlushLatency: adaptiveCfg.TargetFlushLatency,
+					MaxBatchBytes:      adaptiveCfg.MaxBatchBytes,
+					BatchSizeHook:      adaptiveCfg.BatchSizeHook,
+				}
+			}
 			client, err := insertall.NewClient(
 				projectID, dataSetID, tableID,
 				!insertAllCfg.FailOnInvalidRows,
 				!insertAllCfg.FailForUnknownValues,
+				insertAllCfg.EvolveSchema,
 				insertAllCfg.BatchSize, insertAllCfg.MaxRetryDeadlineOffset,
+				adaptive,
+				pool,
 				logger,
 			)
 			if err != nil {
@@ -132,6 +244,7 @@ func NewStreamer(ctx context.Context, projectID, dataSetID, tableID string, cfg
 			}
 			return client, nil
 		},
+		setupTable,
 		projectID, dataSetID, tableID,
 		cfg,
 	)
@@ -139,7 +252,11 @@ func NewStreamer(ctx context.Context, projectID, dataSetID, tableID string, cfg
 
 type clientBuilderFunc func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error)
 
-func newStreamerWithClientBuilder(ctx context.Context, clientBuilder clientBuilderFunc, projectID, dataSetID, tableID string, cfg *StreamerConfig) (*Streamer, error) {
+// tableSetupFunc is called with the sanitized config prior to creating any worker clients,
+// allowing the destination table to be prepared (e.g. created) for the streamer.
+type tableSetupFunc func(ctx context.Context, projectID, dataSetID, tableID string, cfg *StreamerConfig) error
+
+func newStreamerWithClientBuilder(ctx context.Context, clientBuilder clientBuilderFunc, tableSetup tableSetupFunc, projectID, dataSetID, tableID string, cfg *StreamerConfig) (*Streamer, error) {
 	if projectID == "" {
 		return nil, fmt.Errorf("streamer client creation: validate projectID: %w: missing", internal.ErrInvalidParam)
 	}
@@ -156,44 +273,135 @@ func newStreamerWithClientBuilder(ctx context.Context, clientBuilder clientBuild
 		return nil, fmt.Errorf("streamer client creation: sanitize streamer config: %w", err)
 	}
 
+	if cfg.Pool != nil && cfg.Pool.projectID != projectID {
+		return nil, fmt.Errorf("streamer client creation: validate pool: %w: pool is for project %q", internal.ErrInvalidParam, cfg.Pool.projectID)
+	}
+
+	// prepare the destination table, if required
+	if tableSetup != nil {
+		if err := tableSetup(ctx, projectID, dataSetID, tableID, cfg); err != nil {
+			return nil, fmt.Errorf("streamer client creation: setup table: %w", err)
+		}
+	}
+
+	// with autoscaling enabled the streamer starts with its min amount of workers,
+	// with the queue sized for these workers, as its fill is what triggers additional workers
+	workerCount := cfg.WorkerCount
+	if cfg.MaxWorkers > 0 {
+		workerCount = cfg.MinWorkers
+	}
+
 	// create streamer
 	workerCtx, workerCtxCancelFn := context.WithCancel(ctx)
 	s := &Streamer{
 		logger: cfg.Logger,
 
-		workerCh:       make(chan streamerJob, cfg.WorkerCount*cfg.WorkerQueueSize),
+		projectID: projectID,
+		dataSetID: dataSetID,
+		tableID:   tableID,
+		cfg:       cfg,
+
+		workerCh:       make(chan streamerJob, workerCount*cfg.WorkerQueueSize),
 		workerCtx:      workerCtx,
 		workerCancelFn: workerCtxCancelFn,
+
+		clientBuilder:  clientBuilder,
+		limiters:       newStreamerLimiters(cfg, tableName(projectID, dataSetID, tableID)),
+		rateLimitStats: new(rateLimitStats),
+		dedup:          newDeduplicator(cfg.Dedup),
+		// fields attached to all messages logged by the workers (and their clients)
+		workerLogger: log.WithFields(
+			cfg.Logger,
+			log.F(log.FieldTable, tableName(projectID, dataSetID, tableID)),
+			log.F(log.FieldClientType, clientTypeForConfig(cfg)),
+		),
+	}
+	// rows sharded by key are hashed to a specific worker, each with their own queue,
+	// as to preserve the order of the rows per key
+	if cfg.ShardKey != nil {
+		s.workerCh = nil
+		s.shardChs = make([]chan streamerJob, workerCount)
+		for i := range s.shardChs {
+			s.shardChs[i] = make(chan streamerJob, cfg.WorkerQueueSize)
+		}
 	}
 	// create & spawn all worker threads
-	for i := 0; i < cfg.WorkerCount; i++ {
-		cfg.Logger.Debugf("starting streamer worker thread #%d", i+1)
-		s.workerWg.Add(1)
-		// each worker thread has its own client
-		client, err := clientBuilder(
-			workerCtx,
-			projectID, dataSetID, tableID,
-			cfg.Logger,
-			cfg.InsertAllClient, cfg.StorageClient, cfg.BatchClient,
-		)
-		if err != nil {
+	for i := 0; i < workerCount; i++ {
+		jobs := s.workerCh
+		if s.shardChs != nil {
+			jobs = s.shardChs[i]
+		}
+		if err := s.spawnWorker(workerCtx, i+1, jobs); err != nil {
 			workerCtxCancelFn()
+			s.workerWg.Wait()
 			return nil, fmt.Errorf("create streamer client: create client for worker thread: %w", err)
 		}
+	}
+	// scale the workers between their min and max amount, if enabled
+	if workerCount < cfg.MaxWorkers {
+		scaler := newAutoscaler(s, workerCount, cfg)
+		s.workerWg.Add(1)
 		go func() {
 			defer s.workerWg.Done()
-			defer func() {
-				err := client.Close()
-				if err != nil {
-					cfg.Logger.Errorf("streamer: failed to close worker's BQ client: %v", err)
-				}
-			}()
-			s.doWork(client, cfg.MaxBatchDelay)
+			scaler.run(workerCtx)
 		}()
 	}
 	return s, nil
 }
 
+// spawnWorker creates a client for a new worker, identified by the given index,
+// and spawns the worker on its own goroutine, handling the jobs of the given queue
+// until the given context is done.
+func (s *Streamer) spawnWorker(ctx context.Context, index int, jobs <-chan streamerJob) error {
+	workerLogger := log.WithFields(s.workerLogger, log.F(log.FieldWorker, index))
+	workerLogger.Log(log.LevelInfo, "starting streamer worker thread")
+	// each worker thread has its own client
+	client, err := s.clientBuilder(
+		ctx,
+		s.projectID, s.dataSetID, s.tableID,
+		workerLogger,
+		s.cfg.InsertAllClient, s.cfg.StorageClient, s.cfg.BatchClient,
+	)
+	if err != nil {
+		return err
+	}
+	// the rate limits are respected by the client itself, as it knows when (and how much) it actually sends
+	if throttled, ok := client.(bigquery.Throttled); ok {
+		throttled.SetThrottler(&workerThrottler{s: s, ctx: ctx, logger: workerLogger})
+	}
+	// quota errors received asynchronously by the client slow down the workers as well
+	if notifier, ok := client.(bigquery.QuotaNotifier); ok {
+		notifier.NotifyQuotaExceeded(func(err error) {
+			s.reportQuotaExceeded(workerLogger, err)
+		})
+	}
+	s.workerWg.Add(1)
+	go func() {
+		defer s.workerWg.Done()
+		defer func() {
+			err := client.Close()
+			if err != nil {
+				workerLogger.Errorf("streamer: failed to close worker's BQ client: %v", err)
+			}
+		}()
+		s.doWork(ctx, jobs, client, workerLogger, s.cfg.MaxBatchDelay)
+	}()
+	return nil
+}
+
+// clientTypeForConfig returns the name of the kind of client,
+// used by the workers of a streamer created for the given (sanitized) config.
+func clientTypeForConfig(cfg *StreamerConfig) string {
+	switch {
+	case cfg.StorageClient != nil:
+		return "storage"
+	case cfg.BatchClient != nil:
+		return "batch"
+	default:
+		return "insertAll"
+	}
+}
+
 // Write a row of data to a BQ table within the streamer's project.
 // The row will be written as soon as all previous rows has been written
 // and a worker goroutine becomes available to write it.
@@ -211,23 +419,106 @@ func (s *Streamer) Write(data interface{}) error {
 	if err := s.workerCtx.Err(); errors.Is(err, context.Canceled) {
 		return fmt.Errorf("write data into BQ streamer: streamer worker context: %w", err)
 	}
-	select {
-	case s.workerCh <- job:
-		s.logger.Debug("inserted write job into bq streamer")
-	case <-s.workerCtx.Done():
+	if !s.enqueue(s.queueFor(data), job) {
 		return fmt.Errorf("write data into BQ streamer: worker is busy: streamer worker context: %w", context.Canceled)
 	}
+	s.logger.Debug("inserted write job into bq streamer")
+	return nil
+}
+
+// WriteBatch writes multiple rows of data to a BQ table within the streamer's project,
+// as a single job, which is cheaper than writing each row using Write.
+// The rows are written as soon as all previous rows have been written
+// and a worker goroutine becomes available to write them.
+//
+// All rows are validated prior to writing any of them, with no rows being written
+// in case one or multiple rows are rejected (e.g. because they are nil).
+// Rows rejected by the client itself (e.g. rows which could not be encoded) are reported as
+// (async) worker errors, without affecting the other rows of the batch.
+//
+// In case the rows are sharded (see ShardKey) the batch is split into one job per worker,
+// each with the rows hashed to that worker, in the order they were given. These jobs are enqueued
+// one at a time, and thus the jobs of some workers might already be enqueued in case an error
+// is returned because the streamer was closed while enqueueing the batch.
+func (s *Streamer) WriteBatch(rows []interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var nilRows []string
+	for i, row := range rows {
+		if row == nil {
+			nilRows = append(nilRows, fmt.Sprintf("#%d", i))
+		}
+	}
+	if len(nilRows) > 0 {
+		return fmt.Errorf("streamer client write batch: validate rows: %w: nil data for row(s) %s", internal.ErrInvalidParam, strings.Join(nilRows, ", "))
+	}
+	if err := s.workerCtx.Err(); errors.Is(err, context.Canceled) {
+		return fmt.Errorf("write batch into BQ streamer: streamer worker context: %w", err)
+	}
+	if s.shardChs == nil {
+		if !s.enqueue(s.workerCh, streamerJob{Rows: rows}) {
+			return fmt.Errorf("write batch into BQ streamer: worker is busy: streamer worker context: %w", context.Canceled)
+		}
+		s.logger.Debugf("inserted write job for a batch of %d rows into bq streamer", len(rows))
+		return nil
+	}
+	shardRows := make([][]interface{}, len(s.shardChs))
+	for _, row := range rows {
+		shard := s.shardFor(row)
+		shardRows[shard] = append(shardRows[shard], row)
+	}
+	for shard, rows := range shardRows {
+		if len(rows) == 0 {
+			continue
+		}
+		if !s.enqueue(s.shardChs[shard], streamerJob{Rows: rows}) {
+			return fmt.Errorf("write batch into BQ streamer: worker #%d is busy: streamer worker context: %w", shard+1, context.Canceled)
+		}
+		s.logger.Debugf("inserted write job for a batch of %d rows into bq streamer for worker #%d", len(rows), shard+1)
+	}
 	return nil
 }
 
-// doWork defines the main loop of a Streamer's worker goroutine.
-func (s *Streamer) doWork(client bigquery.Client, maxBatchDelay time.Duration) {
+// enqueue inserts the job into the given queue,
+// returning false in case the streamer was closed before it could be inserted.
+func (s *Streamer) enqueue(queue chan<- streamerJob, job streamerJob) bool {
+	select {
+	case queue <- job:
+		return true
+	case <-s.workerCtx.Done():
+		return false
+	}
+}
+
+// queueFor returns the queue of the job for the given row,
+// which is the queue of the worker the row is hashed to in case the rows are sharded.
+func (s *Streamer) queueFor(row interface{}) chan streamerJob {
+	if s.shardChs == nil {
+		return s.workerCh
+	}
+	return s.shardChs[s.shardFor(row)]
+}
+
+// shardFor returns the index of the worker the given row is hashed to, using its shard key.
+func (s *Streamer) shardFor(row interface{}) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s.cfg.ShardKey(row)))
+	return int(h.Sum32() % uint32(len(s.shardChs)))
+}
+
+// doWork defines the main loop of a Streamer's worker goroutine,
+// handling the jobs of the given queue until the given (worker) context is done.
+func (s *Streamer) doWork(ctx context.Context, jobs <-chan streamerJob, client bigquery.Client, logger log.StructuredLogger, maxBatchDelay time.Duration) {
+	pending := s.newPendingKeys()
 	defer func() {
 		err := client.Flush()
+		pending.settle(err)
 		if err != nil {
-			s.logger.Errorf("streamer worker thread is closing: context is done: flush worker client: failure: %v", err)
+			s.reportQuotaExceeded(logger, err)
+			logger.Errorf("streamer worker thread is closing: context is done: flush worker client: failure: %v", err)
 		} else {
-			s.logger.Debug("streamer worker thread is closing: context is done: flushed worker client successfully")
+			logger.Log(log.LevelInfo, "streamer worker thread is closing: context is done: flushed worker client successfully")
 		}
 	}()
 
@@ -235,25 +526,50 @@ func (s *Streamer) doWork(client bigquery.Client, maxBatchDelay time.Duration) {
 
 	for {
 		select {
-		case <-s.workerCtx.Done():
-			s.logger.Debug("streamer worker thread is closing: context is done: exit worker thread")
+		case <-ctx.Done():
+			logger.Debug("streamer worker thread is closing: context is done: exit worker thread")
 			return
 
 		case <-batchDelayTicker.C:
 			err := client.Flush()
+			pending.settle(err)
 			if err != nil {
-				s.logger.Errorf("worker thread max batch delay interval: flush worker client: failure: %v", err)
+				s.reportQuotaExceeded(logger, err)
+				logger.Errorf("worker thread max batch delay interval: flush worker client: failure: %v", err)
 			} else {
-				s.logger.Debug("worker thread max batch delay interval: flushed worker client successfully")
+				logger.Debug("worker thread max batch delay interval: flushed worker client successfully")
 			}
 
-		case job := <-s.workerCh:
-			flushed, err := client.Put(job.Data)
+		case job := <-jobs:
+			job, keys, ok := s.deduplicate(logger, job)
+			if ok {
+				var dropped []int
+				job, dropped, ok = s.intercept(ctx, logger, job)
+				// the keys of rows dropped by the interceptors are released, as these rows are never written
+				keys = s.releaseKeys(keys, dropped)
+			}
+			if !ok {
+				continue // all rows of the job were dropped
+			}
+			pending.add(keys)
+			var (
+				flushed bool
+				err     error
+			)
+			if job.Rows != nil {
+				flushed, err = client.PutBatch(job.Rows)
+			} else {
+				flushed, err = client.Put(job.Data)
+			}
+			if flushed || err != nil {
+				pending.settle(err)
+			}
 			if err != nil {
-				s.logger.Errorf("worker thread data job received: put data to client: failure: %v", err)
+				s.reportQuotaExceeded(logger, err)
+				logger.Errorf("worker thread data job received: put data to client: failure: %v", err)
 			} else if flushed {
 				batchDelayTicker.Reset(maxBatchDelay)
-				s.logger.Debug("worker thread data job received: put data to client: flushed all batched rows")
+				logger.Debug("worker thread data job received: put data to client: flushed all batched rows")
 			}
 		}
 	}
@@ -0,0 +1,113 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"time"
+)
+
+// autoscaleInterval defines the interval at which the autoscaler
+// samples the fill of the job queue of its streamer.
+const autoscaleInterval = time.Second
+
+// autoscaler scales the amount of workers of a Streamer between its MinWorkers and MaxWorkers,
+// based on the fill of the job queue shared by all its workers.
+//
+// An additional worker is spawned each time the queue is filled above the ScaleUpThreshold
+// for two consecutive samples, while the most recently spawned worker is retired
+// each time the queue has remained empty for the ScaleDownCooldown.
+type autoscaler struct {
+	streamer *Streamer
+
+	minWorkers int
+	maxWorkers int
+	threshold  float64
+	cooldown   time.Duration
+
+	// cancel functions of the workers spawned by the autoscaler, most recent one last
+	workers        []context.CancelFunc
+	aboveThreshold bool
+	idleSince      time.Time
+}
+
+func newAutoscaler(s *Streamer, minWorkers int, cfg *StreamerConfig) *autoscaler {
+	return &autoscaler{
+		streamer:   s,
+		minWorkers: minWorkers,
+		maxWorkers: cfg.MaxWorkers,
+		threshold:  cfg.ScaleUpThreshold,
+		cooldown:   cfg.ScaleDownCooldown,
+	}
+}
+
+// run samples the job queue until the given context is done,
+// which also stops all the workers spawned by the autoscaler.
+func (a *autoscaler) run(ctx context.Context) {
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.scale(ctx, now)
+		}
+	}
+}
+
+// scale spawns or retires a worker, if required by the current fill of the job queue.
+func (a *autoscaler) scale(ctx context.Context, now time.Time) {
+	queued := len(a.streamer.workerCh)
+	if capacity := cap(a.streamer.workerCh); capacity > 0 && float64(queued)/float64(capacity) >= a.threshold {
+		a.idleSince = time.Time{}
+		if !a.aboveThreshold {
+			a.aboveThreshold = true
+			return
+		}
+		workerCount := a.minWorkers + len(a.workers)
+		if workerCount >= a.maxWorkers {
+			return
+		}
+		workerCtx, cancel := context.WithCancel(ctx)
+		if err := a.streamer.spawnWorker(workerCtx, workerCount+1, a.streamer.workerCh); err != nil {
+			cancel()
+			a.streamer.workerLogger.Errorf("streamer autoscaler: spawn additional worker: failure: %v", err)
+			return
+		}
+		a.workers = append(a.workers, cancel)
+		a.streamer.workerLogger.Debugf("streamer autoscaler: scaled up to %d workers", workerCount+1)
+		return
+	}
+
+	a.aboveThreshold = false
+	if queued > 0 {
+		a.idleSince = time.Time{}
+		return
+	}
+	if a.idleSince.IsZero() {
+		a.idleSince = now
+		return
+	}
+	if len(a.workers) == 0 || now.Sub(a.idleSince) < a.cooldown {
+		return
+	}
+	// retire the most recently spawned worker, which flushes and closes its client
+	last := len(a.workers) - 1
+	a.workers[last]()
+	a.workers = a.workers[:last]
+	a.idleSince = now
+	a.streamer.workerLogger.Debugf("streamer autoscaler: scaled down to %d workers", a.minWorkers+len(a.workers))
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+func TestAutoscalerScaleUpAndDown(t *testing.T) {
+	client := new(stubBQClient)
+	putSignalCh := make(chan struct{}, 3)
+	client.SubscribeToPutSignal(putSignalCh)
+	var clientCount int32
+
+	// a streamer without any workers of its own, such that the queue is only drained by the autoscaled workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &Streamer{
+		logger:         test.Logger{},
+		projectID:      "a",
+		dataSetID:      "b",
+		tableID:        "c",
+		cfg:            &StreamerConfig{MaxBatchDelay: time.Hour},
+		workerCh:       make(chan streamerJob, 4),
+		workerCtx:      ctx,
+		workerCancelFn: cancel,
+		clientBuilder: func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			atomic.AddInt32(&clientCount, 1)
+			return client, nil
+		},
+		workerLogger: log.WithFields(test.Logger{}),
+	}
+	scaler := newAutoscaler(s, 1, &StreamerConfig{
+		MaxWorkers:        1,
+		ScaleUpThreshold:  0.75,
+		ScaleDownCooldown: time.Minute,
+	})
+
+	for _, row := range []string{"a", "b", "c"} {
+		s.workerCh <- streamerJob{Data: row}
+	}
+	now := time.Now()
+
+	// the queue has to remain filled above the threshold, and cannot scale beyond the max workers
+	scaler.scale(ctx, now)
+	scaler.scale(ctx, now.Add(time.Second))
+	test.AssertEqual(t, int32(0), atomic.LoadInt32(&clientCount))
+	test.AssertEqual(t, 0, len(scaler.workers))
+
+	// an additional worker is spawned once more workers are allowed
+	scaler.maxWorkers = 2
+	scaler.scale(ctx, now.Add(2*time.Second))
+	test.AssertEqual(t, int32(1), atomic.LoadInt32(&clientCount))
+	test.AssertEqual(t, 1, len(scaler.workers))
+	for i := 0; i < 3; i++ {
+		<-putSignalCh
+	}
+
+	// the additional worker is retired once the queue has been empty for the cooldown
+	idleSince := now.Add(3 * time.Second)
+	scaler.scale(ctx, idleSince)
+	scaler.scale(ctx, idleSince.Add(time.Minute-time.Nanosecond))
+	test.AssertEqual(t, 1, len(scaler.workers))
+	scaler.scale(ctx, idleSince.Add(time.Minute))
+	test.AssertEqual(t, 0, len(scaler.workers))
+
+	// the retired worker flushed its rows and closed its client
+	s.workerWg.Wait()
+	client.AssertStringSlice(t, []string{"a", "b", "c"})
+	client.AssertFlushCount(t, 1)
+}
+
+func TestStreamerAutoscalingStartsWithMinWorkers(t *testing.T) {
+	client := new(stubBQClient)
+	var clientCount int32
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			atomic.AddInt32(&clientCount, 1)
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{
+			WorkerCount:     4,
+			WorkerQueueSize: 10,
+			MinWorkers:      1,
+			MaxWorkers:      3,
+		},
+	)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, int32(1), atomic.LoadInt32(&clientCount))
+	// the queue is sized for the min amount of workers
+	test.AssertEqual(t, 10, cap(streamer.workerCh))
+
+	putSignalCh := make(chan struct{}, 1)
+	client.SubscribeToPutSignal(putSignalCh)
+	test.AssertNoError(t, streamer.Write("hello"))
+	<-putSignalCh
+	streamer.Close()
+	client.AssertStringSlice(t, []string{"hello"})
+}
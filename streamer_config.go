@@ -15,15 +15,29 @@
 package bqwriter
 
 import (
+	"fmt"
+	"io"
+	"reflect"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/OTA-Insight/bqwriter/constant"
 	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding"
 	"github.com/OTA-Insight/bqwriter/log"
+	"github.com/OTA-Insight/bqwriter/objectstore"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
+// The schema update options which can be used for the SchemaUpdateOptions of the BatchClientConfig.
+const (
+	// SchemaUpdateAllowFieldAddition allows a load job to add (nullable) fields to the schema of the table.
+	SchemaUpdateAllowFieldAddition = "ALLOW_FIELD_ADDITION"
+	// SchemaUpdateAllowFieldRelaxation allows a load job to relax required fields of the table to nullable.
+	SchemaUpdateAllowFieldRelaxation = "ALLOW_FIELD_RELAXATION"
+)
+
 type (
 	// StreamerConfig is used to build a Streamer (client).
 	// All configurations found in this structure are optional and have sane defaults
@@ -37,6 +51,33 @@ type (
 		// Defaults to constant.DefaultWorkerCount if not defined explicitly.
 		WorkerCount int
 
+		// MinWorkers defines the amount of workers the Streamer starts with, and scales down to,
+		// in case autoscaling is enabled by defining MaxWorkers. Use a negative value in order to
+		// scale down to a single worker (same as defining it as 1 explicitly).
+		//
+		// Defaults to the (sanitized) WorkerCount if not defined explicitly.
+		MinWorkers int
+
+		// MaxWorkers enables the autoscaling of the workers, defining the amount of workers
+		// the Streamer can scale up to. Each additional worker has its own client,
+		// and is spawned in case the job queue remains filled above the ScaleUpThreshold,
+		// while idle workers are retired again once the queue has been empty for the ScaleDownCooldown.
+		//
+		// Defaults to 0, in which case autoscaling is disabled and the Streamer uses WorkerCount workers.
+		MaxWorkers int
+
+		// ScaleUpThreshold defines the fill (as a fraction between 0 and 1) of the job queue
+		// above which the Streamer spawns additional workers, in case autoscaling is enabled.
+		//
+		// Defaults to constant.DefaultScaleUpThreshold if <= 0, with values above 1 being treated as 1.
+		ScaleUpThreshold float64
+
+		// ScaleDownCooldown defines the amount of time the job queue has to remain empty
+		// before the Streamer retires an additional worker, in case autoscaling is enabled.
+		//
+		// Defaults to constant.DefaultScaleDownCooldown if d <= 0.
+		ScaleDownCooldown time.Duration
+
 		// WorkerQueueSize defines the size of the job queue per worker used
 		// in order to allow the Streamer's users to write rows even if all workers are currently
 		// too busy to accept new incoming rows.
@@ -47,17 +88,79 @@ type (
 		// Defaults to constant.MaxTotalElapsedRetryTime if not defined explicitly
 		WorkerQueueSize int
 
+		// ShardKey enables the ordered delivery of rows, returning the key of a row.
+		// Each worker has its own queue, with the rows being hashed to a worker by their key,
+		// such that the rows of a key are delivered to the client of a single worker in the order they were written.
+		//
+		// Only this order of delivery to the client is guaranteed, not the order in which the rows end up in BigQuery.
+		// The insertAll client retries a batch before writing the next one, except for the rows it retries after
+		// evolving the schema (see InsertAllClientConfig.EvolveSchema), which are written after the accepted rows
+		// of their batch. The storage client only supports the default stream, committed streams are not supported,
+		// and thus no order is guaranteed across the retries of its appends. The load jobs of the batch client are
+		// submitted one at a time per worker. Sharding cannot be combined with autoscaling, as the workers of a key would change.
+		//
+		// Defaults to nil, in which case all workers share a single queue and rows can be written out of order.
+		ShardKey func(row interface{}) string
+
 		// MaxBatchDelay defines the max amount of time a worker batches rows,
 		// prior to writing the batched rows, even when not yet full.
 		//
 		// Defaults to constant.DefaultMaxBatchDelay if d == 0.
 		MaxBatchDelay time.Duration
 
+		// Interceptors defines a chain of RowInterceptors called by the workers for each row,
+		// in the given order, prior to writing the row to their client. Interceptors can transform rows,
+		// drop rows (e.g. test traffic, see DropRow) or replace a row by multiple rows (see FanOut).
+		// See TimestampInterceptor and SampleInterceptor for the interceptors provided by this package.
+		//
+		// Rows are sharded (see ShardKey) prior to being intercepted, and thus using their original value.
+		//
+		// Defaults to nil, in which case the rows are written as-is.
+		Interceptors []RowInterceptor
+
+		// Dedup optionally enables the client-side deduplication of the written rows,
+		// with the workers dropping the rows of which the key was already written within a (bounded) window.
+		// Rows are deduplicated prior to being intercepted (see Interceptors).
+		// The amount of dropped duplicates is returned by (*Streamer).SuppressedDuplicates.
+		//
+		// Defaults to nil, in which case the rows are not deduplicated by the Streamer.
+		Dedup *DedupConfig
+
 		// Logger allows you to attach a logger to be used by the streamer,
 		// instead of the default built-in STDERR logging implementation,
 		// with the latter being used as the default in case this logger isn't defined explicitly.
 		Logger log.Logger
 
+		// RateLimit allows you to limit the rows and/or bytes written per second to the table of the Streamer,
+		// respected by the clients of all its workers each time they send their rows to BigQuery.
+		//
+		// Regardless of this config the workers slow down once the API reports that a quota is exceeded,
+		// pausing all workers before continuing at a gradually recovering rate.
+		//
+		// Defaults to nil, in which case the rows are not limited.
+		RateLimit *RateLimitConfig
+
+		// GlobalRateLimiter allows you to limit the rows and/or bytes written per second
+		// by all Streamers sharing the same RateLimiter, in addition to the RateLimit of each Streamer.
+		//
+		// Defaults to nil, in which case the rows are only limited by the RateLimit of the Streamer.
+		GlobalRateLimiter *RateLimiter
+
+		// QuotaExceededHook is called by the workers each time the API reports that a quota is exceeded,
+		// with the reported error and the time the writes are paused for, after the Streamer slowed down.
+		// See (*Streamer).RateLimitStats for the counters of the rate limiting of a Streamer.
+		//
+		// The hook is called concurrently by the workers and thus has to be thread-safe.
+		// Defaults to nil, in which case an exceeded quota is only logged as a warning.
+		QuotaExceededHook func(err error, pause time.Duration)
+
+		// Pool allows you to share the underlying Google API clients (and their connections)
+		// among all workers of the Streamer, as well as with any other Streamer using the same Pool.
+		// The Pool has to be created for the same project as the Streamer.
+		//
+		// Defaults to nil, in which case each worker creates its own clients.
+		Pool *Pool
+
 		// InsertAllClient allows you to overwrite any or all of the defaults used to configure an
 		// InsertAll client API driven Streamer Client. Note that this optional configuration is ignored
 		// all together in case StorageClient is defined as a non-nil value.
@@ -72,6 +175,70 @@ type (
 		// You can do so using `new(BatchClientConfig)` in order to create a BatchClient
 		// with all possible configurations configured using their defaults as defined by this Go package.
 		BatchClient *BatchClientConfig
+
+		// CreateIfNotExists allows you to have the Streamer create the destination table,
+		// as well as its dataset, in case it does not exist yet at the time the Streamer is created.
+		// The Streamer will fail to be created in case the table could not be made ready.
+		//
+		// Defaults to nil, in which case the table is assumed to exist,
+		// resulting in (async) worker errors in case it doesn't.
+		CreateIfNotExists *CreateTableConfig
+
+		// ValidateSchema allows you to have the Streamer validate, at the time it is created,
+		// the configured schema against the schema of the destination table, using (*Streamer).ValidateSchema.
+		// The Streamer will fail to be created in case any incompatibility is found.
+		//
+		// The configured schema is the BigQuerySchema or ProtobufDescriptor of the StorageClientConfig,
+		// the BigQuerySchema of the BatchClientConfig or else the (resolved) schema of the CreateTableConfig.
+		//
+		// Defaults to false, in which case incompatibilities only surface as (async) worker errors.
+		ValidateSchema bool
+	}
+
+	// CreateTableConfig is used to configure how the destination table (and its dataset)
+	// is created by the Streamer, in case it does not exist yet. Existing tables are left untouched.
+	//
+	// A non-nil CreateTableConfig instance has to be passed in to the CreateIfNotExists property of
+	// a StreamerConfig in order to indicate the table (and its dataset) should be created if needed.
+	CreateTableConfig struct {
+		// Schema defines the BigQuery schema used to create the table.
+		//
+		// Optional, in case it isn't defined the schema is derived from, in order of priority:
+		//   1. the RowType of this config;
+		//   2. the BigQuerySchema of the StorageClientConfig;
+		//   3. the ProtobufDescriptor of the StorageClientConfig;
+		//   4. the BigQuerySchema of the BatchClientConfig;
+		//
+		// An error is returned upon creation of the Streamer in case no schema could be resolved.
+		Schema *bigquery.Schema
+
+		// RowType can be used to infer the BigQuery schema from a Go struct type,
+		// honoring its `bigquery` field tags, as to not have to define the schema explicitly.
+		// The schema is derived the same way as for the RowType of the StorageClientConfig.
+		//
+		// This config is ignored in case Schema is defined.
+		RowType reflect.Type
+
+		// DataSetLocation defines the geo location of the dataset,
+		// only used in case the dataset has to be created.
+		//
+		// Defaults to the default location of BigQuery (US) if not defined explicitly.
+		DataSetLocation string
+
+		// TimePartitioning optionally defines the time-based partitioning of the table.
+		TimePartitioning *bigquery.TimePartitioning
+
+		// Clustering optionally defines the clustering specification of the table.
+		Clustering *bigquery.Clustering
+
+		// Expiration optionally defines, relative to its creation, when the table expires.
+		// An expired table is deleted by BigQuery, together with all its data.
+		//
+		// Defaults to 0, meaning the table never expires.
+		Expiration time.Duration
+
+		// Labels optionally defines the labels attached to the table.
+		Labels map[string]string
 	}
 
 	// InsertAllClientConfig is used to configure an InsertAll client API driven Streamer Client.
@@ -90,6 +257,17 @@ type (
 		// and publishing the rows with the unknown values removed from them.
 		FailForUnknownValues bool
 
+		// EvolveSchema enables schema evolution, adding the fields of rows which are unknown
+		// to the table schema as NULLABLE columns, after which the rejected rows are retried.
+		// The type of the new columns is inferred from the (Go) values of the rejected rows.
+		//
+		// Enabling this mode implies FailForUnknownValues, as otherwise the unknown fields
+		// would be silently dropped by BigQuery instead. Note that it might take a little while
+		// for the new columns to be available for streaming inserts.
+		//
+		// Defaults to false, disabling schema evolution.
+		EvolveSchema bool
+
 		// BatchSize defines the amount of rows (data) by a worker, prior to a worker
 		// actually writing it to BQ. Should a worker have rows left in its local cache when closing,
 		// it will flush/write these rows prior to closing.
@@ -105,6 +283,51 @@ type (
 		//
 		// Defaults to constant.DefaultMaxRetryDeadlineOffset if MaxRetryDeadlineOffset == 0.
 		MaxRetryDeadlineOffset time.Duration
+
+		// AdaptiveBatchSize optionally enables adaptive batch sizing, in which case each worker
+		// adjusts its batch size after each flush, using the latency, payload size and errors of its recent flushes.
+		// The BatchSize is in that case only used as the initial batch size of each worker.
+		//
+		// Defaults to nil, in which case the BatchSize is used as-is for all flushes.
+		AdaptiveBatchSize *AdaptiveBatchSizeConfig
+	}
+
+	// AdaptiveBatchSizeConfig is used to configure the adaptive batch sizing of an InsertAll client API driven Streamer Client.
+	// All properties have sane defaults as defined and used by this Go package.
+	//
+	// A worker grows its batch size as long as its flushes are faster than the TargetFlushLatency,
+	// and shrinks it when they are slower, or when they fail.
+	AdaptiveBatchSizeConfig struct {
+		// MinBatchSize defines the minimum amount of rows a worker batches prior to writing them,
+		// unless the rows are flushed earlier because of the MaxBatchDelay or closing of the Streamer.
+		//
+		// Defaults to constant.DefaultMinAdaptiveBatchSize if n == 0,
+		// use a negative value in case you want to allow each row to be written directly.
+		MinBatchSize int
+
+		// MaxBatchSize defines the maximum amount of rows a worker batches prior to writing them.
+		//
+		// Defaults to constant.DefaultMaxAdaptiveBatchSize if n == 0 (or negative),
+		// and to MinBatchSize in case it is lower than MinBatchSize.
+		MaxBatchSize int
+
+		// TargetFlushLatency defines the time the writing of a single batch should take.
+		//
+		// Defaults to constant.DefaultTargetFlushLatency if d == 0 (or negative).
+		TargetFlushLatency time.Duration
+
+		// MaxBatchBytes defines the maximum size (in bytes) of a batch,
+		// estimated using the JSON encoding of the written rows.
+		// The insertAll API rejects requests larger than 10 MB.
+		//
+		// Defaults to constant.DefaultMaxAdaptiveBatchBytes if n == 0 (or negative).
+		MaxBatchBytes int
+
+		// BatchSizeHook is optionally called with the new batch size of a worker
+		// each time it is adapted, e.g. to export it as a metric.
+		//
+		// It is called from the worker goroutines and is thus expected to be safe for concurrent use.
+		BatchSizeHook func(batchSize int)
 	}
 
 	// StorageClientConfig is used to configure a storage client API driven Streamer Client.
@@ -127,10 +350,46 @@ type (
 		// based on a pre-compiled protobuf schema in order to be able to encode any proto Message
 		// adhering to this descriptor.
 		//
+		// Fields of a well-known type (e.g. google.protobuf.Timestamp) are normalized to the wire type
+		// expected by the Storage Write API, for both the descriptor and the messages written,
+		// see NormalizeProtobufDescriptor for more information. The same goes for proto3 messages,
+		// for which the descriptor is converted to an equivalent proto2 descriptor.
+		//
 		// This config is required only if BigQuerySchema is not defined.
 		// It is however recommended to use the The ProtobufDescriptor
 		// as a BigQuerySchema based encoder has a possible performance penalty.
 		ProtobufDescriptor *descriptorpb.DescriptorProto
+
+		// RowType can be used in order to have the BigQuerySchema derived from a Go struct (pointer) type,
+		// rather than hand-maintaining a BigQuerySchema or ProtobufDescriptor next to the struct.
+		//
+		// Fields are named after their Go field name, unless a name is defined using the `bigquery:"name"` tag,
+		// and skipped when tagged with `bigquery:"-"`. Fields are REQUIRED, unless they are a pointer,
+		// a bigquery.NullX type or tagged with the `bigquery:",nullable"` option, in which case they are NULLABLE.
+		// Nested structs are mapped to RECORD fields, slices to REPEATED fields, time.Time to TIMESTAMP,
+		// civil.Date, civil.Time and civil.DateTime to DATE, TIME and DATETIME and *big.Rat to NUMERIC.
+		//
+		// This config is ignored in case BigQuerySchema or ProtobufDescriptor is defined.
+		RowType reflect.Type
+
+		// UseStructEncoder can be used in order to encode rows of the RowType using a compiled struct encoder,
+		// which writes the protobuf wire bytes of a row directly, rather than taking the (much) more expensive road
+		// of the BigQuerySchema encoder, which encodes structs via a bigquery.StructSaver and Json. Rows of any other type
+		// are still encoded by the latter. The BigQuerySchema, or the schema derived from the RowType, is used as the schema.
+		//
+		// Defaults to false, requires RowType to be defined and cannot be used in case a ProtobufDescriptor is defined.
+		UseStructEncoder bool
+
+		// EvolveSchema enables schema evolution, adding the fields of rows which are unknown
+		// to the BigQuerySchema as NULLABLE columns to the table, after which the encoder and
+		// write stream are rebuilt using the updated schema. The type of the new columns is inferred
+		// from the (Go) values of the rows.
+		//
+		// Schema evolution is only supported in combination with a BigQuerySchema,
+		// and cannot be used in case a ProtobufDescriptor is defined.
+		//
+		// Defaults to false, disabling schema evolution.
+		EvolveSchema bool
 	}
 
 	// BatchClientConfig is used to configure a batch (load) driven Streamer Client.
@@ -155,6 +414,10 @@ type (
 		//   - bigquery.Parquet
 		//   - bigquery.ORC
 		//
+		// For bigquery.Avro, bigquery.Parquet and bigquery.CSV (if a BigQuerySchema is defined) rows (structs and maps)
+		// can be written directly, rather than an io.Reader, in which case they are encoded as files of that format
+		// based on the BigQuerySchema, buffered per BatchSize rows.
+		//
 		// The default SourceFormat is bigquery.JSON
 		SourceFormat bigquery.DataFormat
 
@@ -173,6 +436,173 @@ type (
 		//
 		// Defaults to bigquery.WriteAppend, which will append the data to the table.
 		WriteDisposition bigquery.TableWriteDisposition
+
+		// Compression defines the compression of the files written by the batch client,
+		// in case the SourceFormat is bigquery.Avro (compressing its blocks) or bigquery.Parquet (compressing its pages).
+		// Possible options are:
+		//   - bigquery.None
+		//   - bigquery.Deflate (Avro only)
+		//   - bigquery.Snappy
+		//   - bigquery.Gzip (Parquet only)
+		//
+		// Defaults to constant.DefaultCompression if "".
+		Compression bigquery.Compression
+
+		// BatchSize defines the amount of rows buffered by a worker, prior to loading them
+		// as a single file into BigQuery. Only used for rows which are encoded by the batch client itself,
+		// which is the case for rows written using an Avro, Parquet or CSV SourceFormat, any io.Reader is loaded as-is.
+		// Should a worker have rows left in its buffer when closing, it will load these rows prior to closing.
+		//
+		// Defaults to constant.DefaultBatchLoadSize if n == 0,
+		// use a negative value or an explicit value of 1
+		// in case you want to load each row directly.
+		BatchSize int
+
+		// MaxInFlightJobs defines the maximum amount of load jobs which can be in flight at once for the streamer,
+		// shared by all its workers. The load jobs are submitted asynchronously and awaited in the background,
+		// allowing a worker to continue buffering rows while its previous files are being loaded. A worker blocks
+		// prior to submitting a new load job in case this maximum is reached, and waits for all its jobs
+		// in flight to finish when it is flushed (e.g. when closing). Failed jobs are logged with all their status errors.
+		//
+		// Defaults to constant.DefaultMaxInFlightLoadJobs if n == 0,
+		// use a negative value or an explicit value of 1
+		// in case you want to allow only a single load job in flight at once.
+		MaxInFlightJobs int
+
+		// CSVOptions defines the options used to load CSV files, such as the FieldDelimiter, Quote,
+		// AllowQuotedNewlines, AllowJaggedRows, SkipLeadingRows and Encoding. In case a BigQuerySchema is defined,
+		// rows can be written directly for the bigquery.CSV SourceFormat, which are then written as CSV files
+		// using the same options, with the values in the order of the columns of the schema.
+		//
+		// Defaults to the BigQuery defaults if nil.
+		CSVOptions *bigquery.CSVOptions
+
+		// ParquetOptions defines the options used to load Parquet files,
+		// enabling the inference of the ENUM (as STRING) and LIST logical types.
+		//
+		// Defaults to the BigQuery defaults if nil.
+		ParquetOptions *bigquery.ParquetOptions
+
+		// IgnoreAvroLogicalTypes causes the logical types of the Avro files written as an io.Reader
+		// to be ignored, loading their values as the underlying Avro types instead
+		// (e.g. an INTEGER rather than a TIMESTAMP for a timestamp-micros value).
+		// The Avro files written by the batch client itself always use the logical types.
+		//
+		// Defaults to false, using the logical types of Avro files.
+		IgnoreAvroLogicalTypes bool
+
+		// DecimalTargetTypes defines the types Avro and Parquet decimal values can be converted to,
+		// in the order of NUMERIC, BIGNUMERIC and STRING, the first type which is listed
+		// and supports the precision and scale of the values is used.
+		//
+		// Defaults to the BigQuery defaults if empty.
+		DecimalTargetTypes []bigquery.DecimalTargetType
+
+		// Staging allows you to stage the files loaded by the batch client as objects in a (Cloud Storage) bucket,
+		// loading them from there rather than uploading them inline as part of the load job,
+		// which is limited in size and cannot be retried as efficiently.
+		//
+		// Defaults to nil, uploading the files inline as part of the load job.
+		Staging *StagingConfig
+
+		// CreateDisposition defines whether the load jobs can create the table in case it does not exist yet.
+		// Possible options are:
+		//   - bigquery.CreateIfNeeded
+		//   - bigquery.CreateNever
+		//
+		// Defaults to the BigQuery default (bigquery.CreateIfNeeded) if "".
+		CreateDisposition bigquery.TableCreateDisposition
+
+		// TimePartitioning, RangePartitioning and Clustering define the partitioning and clustering
+		// of the table, in case it is created by a load job, and have to match the table otherwise.
+		//
+		// Defaults to an unpartitioned and unclustered table if nil.
+		TimePartitioning  *bigquery.TimePartitioning
+		RangePartitioning *bigquery.RangePartitioning
+		Clustering        *bigquery.Clustering
+
+		// SchemaUpdateOptions allows the load jobs to update the schema of the table as a side effect.
+		// Possible options are:
+		//   - SchemaUpdateAllowFieldAddition
+		//   - SchemaUpdateAllowFieldRelaxation
+		//
+		// Defaults to no schema updates if empty.
+		SchemaUpdateOptions []string
+
+		// DestinationEncryptionConfig defines the Cloud KMS key used to encrypt the table,
+		// in case it is created by a load job.
+		//
+		// Defaults to Google-managed encryption if nil.
+		DestinationEncryptionConfig *bigquery.EncryptionConfig
+
+		// Labels are attached to each load job, e.g. to attribute them in the billing exports.
+		//
+		// Defaults to no labels if empty.
+		Labels map[string]string
+
+		// MaxBadRecords defines the maximum amount of bad records a load job can skip,
+		// prior to failing the job. The skipped records are logged as the status errors of the job.
+		//
+		// Defaults to 0, failing a load job for any bad record.
+		MaxBadRecords int64
+
+		// JobIDPrefix is used as the prefix of the IDs of the load jobs, followed by a random suffix,
+		// e.g. to recognize the jobs of a streamer. It can only contain letters, numbers, underscores and dashes.
+		//
+		// Defaults to the BigQuery generated job IDs if "".
+		JobIDPrefix string
+
+		// IdempotentLoads derives the IDs of the load jobs from the loaded files, rather than generating random IDs,
+		// such that a file is loaded only once, even when submitting its load job is retried after a transient failure
+		// of which BigQuery did accept the job. The ID is derived from the key of a KeyedReader (or any io.Reader with a
+		// LoadKey() string method), or from the content of the file otherwise, which requires the file to be buffered
+		// in memory in case it is not staged. Note that job IDs are unique per project, so a file with the same key or
+		// content is never loaded again into the same table, not even when it is written again at a later time.
+		// The job IDs are prefixed with the JobIDPrefix, or constant.DefaultJobIDPrefix if not defined.
+		//
+		// Defaults to false, using random job IDs and only retrying transient failures while awaiting the load jobs.
+		IdempotentLoads bool
+
+		// MaxRetryDeadlineOffset is the max amount of time the back off algorithm is allowed to take
+		// for its initial as well as all retry attempts of submitting or awaiting a load job.
+		//
+		// Defaults to constant.DefaultMaxRetryDeadlineOffset if MaxRetryDeadlineOffset == 0.
+		MaxRetryDeadlineOffset time.Duration
+	}
+
+	// KeyedReader is an io.Reader with a Key, which can be written to a batch-driven Streamer
+	// with IdempotentLoads enabled, in order to derive the ID of its load job from this key
+	// rather than the digest of its content.
+	KeyedReader struct {
+		io.Reader
+
+		// Key identifies the file read from the Reader, e.g. its (unique) name.
+		Key string
+	}
+
+	// StagingConfig is used to configure how the files loaded by a batch client are staged as objects.
+	// Either a Bucket or ObjectStore is required.
+	StagingConfig struct {
+		// Bucket is the name of the Cloud Storage bucket the files are staged in,
+		// using an objectstore.GCSStore with the application default credentials.
+		Bucket string
+
+		// ObjectStore can be used instead of a Bucket in order to stage the files in any object store
+		// of which the objects can be loaded by BigQuery, e.g. an objectstore.GCSStore with custom client options.
+		// An objectstore.LocalStore is not supported, as BigQuery cannot load files from the local filesystem.
+		ObjectStore objectstore.ObjectStore
+
+		// Prefix is prepended to the names of the staged objects, which are named
+		// as <Prefix><dataset>/<table>/<timestamp>-<random>.<format extension>.
+		//
+		// Defaults to constant.DefaultStagingPrefix if "".
+		Prefix string
+
+		// DeleteAfterLoad deletes the staged objects once they are loaded successfully,
+		// objects which failed to load are kept either way, e.g. to be inspected or loaded manually.
+		//
+		// Defaults to false, keeping all objects, e.g. to be cleaned up using a lifecycle policy of the bucket.
+		DeleteAfterLoad bool
 	}
 )
 
@@ -222,6 +652,17 @@ func sanitizeStreamerConfig(cfg *StreamerConfig) (sanCfg *StreamerConfig, err er
 		sanCfg.WorkerQueueSize = cfg.WorkerQueueSize
 	}
 
+	// autoscaling is only enabled (and thus sanitized) if a MaxWorkers is defined
+	sanCfg.ShardKey = cfg.ShardKey
+	if cfg.MaxWorkers != 0 {
+		if cfg.ShardKey != nil {
+			return nil, fmt.Errorf("%w: rows cannot be sharded", internal.ErrInvalidWorkerScaling)
+		}
+		if err := sanitizeWorkerScaling(cfg, sanCfg); err != nil {
+			return nil, err
+		}
+	}
+
 	// an insanely low value of `1` can be used to check constantly
 	// if rows can be written. And while this is possible, it is not recommended.
 	if cfg.MaxBatchDelay == 0 {
@@ -230,6 +671,26 @@ func sanitizeStreamerConfig(cfg *StreamerConfig) (sanCfg *StreamerConfig, err er
 		sanCfg.MaxBatchDelay = cfg.MaxBatchDelay
 	}
 
+	sanCfg.Pool = cfg.Pool
+
+	for i, interceptor := range cfg.Interceptors {
+		if interceptor == nil {
+			return nil, fmt.Errorf("streamer config: validate interceptor #%d: %w: nil", i+1, internal.ErrInvalidParam)
+		}
+	}
+	sanCfg.Interceptors = cfg.Interceptors
+
+	// deduplication is optional, and thus only sanitized if defined
+	if cfg.Dedup != nil {
+		sanCfg.Dedup, err = sanitizeDedupConfig(cfg.Dedup)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sanCfg.RateLimit = cfg.RateLimit
+	sanCfg.GlobalRateLimiter = cfg.GlobalRateLimiter
+	sanCfg.QuotaExceededHook = cfg.QuotaExceededHook
+
 	// use default logger if none was defined
 	if cfg.Logger == nil {
 		sanCfg.Logger = internal.Logger{}
@@ -251,6 +712,21 @@ func sanitizeStreamerConfig(cfg *StreamerConfig) (sanCfg *StreamerConfig, err er
 		return nil, err
 	}
 
+	// only sanitize the CreateTable Config if it is actually defined
+	// otherwise nil will be returned
+	sanCfg.CreateIfNotExists, err = sanitizeCreateTableConfig(cfg.CreateIfNotExists, sanCfg.StorageClient, sanCfg.BatchClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure a schema can be resolved to validate the table schema against, if required
+	sanCfg.ValidateSchema = cfg.ValidateSchema
+	if sanCfg.ValidateSchema {
+		if _, _, err := resolveValidationSchema(sanCfg); err != nil {
+			return nil, err
+		}
+	}
+
 	// return the sanitized named output config
 	return sanCfg, nil
 }
@@ -273,7 +749,8 @@ func sanitizeInsertAllClientConfig(cfg *InsertAllClientConfig) (sanCfg *InsertAl
 	// simply assign the bool properties,
 	// no need for any validation there
 	sanCfg.FailOnInvalidRows = cfg.FailOnInvalidRows
-	sanCfg.FailForUnknownValues = cfg.FailForUnknownValues
+	sanCfg.FailForUnknownValues = cfg.FailForUnknownValues || cfg.EvolveSchema
+	sanCfg.EvolveSchema = cfg.EvolveSchema
 
 	// default the batch size to a sane default,
 	// with the user setting it to a value of 1 if no batching is desired.
@@ -294,10 +771,113 @@ func sanitizeInsertAllClientConfig(cfg *InsertAllClientConfig) (sanCfg *InsertAl
 		sanCfg.MaxRetryDeadlineOffset = cfg.MaxRetryDeadlineOffset
 	}
 
+	// adaptive batch sizing is optional, and thus only sanitized if defined
+	sanCfg.AdaptiveBatchSize = sanitizeAdaptiveBatchSizeConfig(cfg.AdaptiveBatchSize)
+
 	// return the sanitized named output config
 	return sanCfg
 }
 
+// sanitizeAdaptiveBatchSizeConfig is used to fill in all properties
+// of the AdaptiveBatchSizeConfig with their default values,
+// returning nil in case no config was defined.
+func sanitizeAdaptiveBatchSizeConfig(cfg *AdaptiveBatchSizeConfig) *AdaptiveBatchSizeConfig {
+	if cfg == nil {
+		return nil
+	}
+	sanCfg := new(AdaptiveBatchSizeConfig)
+
+	// default the min batch size to a sane default,
+	// with the user setting it to a negative value to allow rows to be written directly
+	if cfg.MinBatchSize < 0 {
+		sanCfg.MinBatchSize = 1
+	} else if cfg.MinBatchSize == 0 {
+		sanCfg.MinBatchSize = constant.DefaultMinAdaptiveBatchSize
+	} else {
+		sanCfg.MinBatchSize = cfg.MinBatchSize
+	}
+
+	// the max batch size cannot be lower than the min batch size
+	if cfg.MaxBatchSize <= 0 {
+		sanCfg.MaxBatchSize = constant.DefaultMaxAdaptiveBatchSize
+	} else {
+		sanCfg.MaxBatchSize = cfg.MaxBatchSize
+	}
+	if sanCfg.MaxBatchSize < sanCfg.MinBatchSize {
+		sanCfg.MaxBatchSize = sanCfg.MinBatchSize
+	}
+
+	if cfg.TargetFlushLatency <= 0 {
+		sanCfg.TargetFlushLatency = constant.DefaultTargetFlushLatency
+	} else {
+		sanCfg.TargetFlushLatency = cfg.TargetFlushLatency
+	}
+
+	if cfg.MaxBatchBytes <= 0 {
+		sanCfg.MaxBatchBytes = constant.DefaultMaxAdaptiveBatchBytes
+	} else {
+		sanCfg.MaxBatchBytes = cfg.MaxBatchBytes
+	}
+
+	sanCfg.BatchSizeHook = cfg.BatchSizeHook
+
+	return sanCfg
+}
+
+// sanitizeWorkerScaling is used to fill in the autoscaling properties of the sanitized StreamerConfig,
+// validating that the workers can be scaled using the already sanitized WorkerCount and WorkerQueueSize.
+func sanitizeWorkerScaling(cfg *StreamerConfig, sanCfg *StreamerConfig) error {
+	if cfg.MinWorkers < 0 {
+		sanCfg.MinWorkers = 1
+	} else if cfg.MinWorkers == 0 {
+		sanCfg.MinWorkers = sanCfg.WorkerCount
+	} else {
+		sanCfg.MinWorkers = cfg.MinWorkers
+	}
+	if cfg.MaxWorkers < sanCfg.MinWorkers {
+		return fmt.Errorf("%w: MaxWorkers (%d) is lower than MinWorkers (%d)", internal.ErrInvalidWorkerScaling, cfg.MaxWorkers, sanCfg.MinWorkers)
+	}
+	if sanCfg.WorkerQueueSize == 0 {
+		return fmt.Errorf("%w: no worker queue", internal.ErrInvalidWorkerScaling)
+	}
+	sanCfg.MaxWorkers = cfg.MaxWorkers
+
+	if cfg.ScaleUpThreshold <= 0 {
+		sanCfg.ScaleUpThreshold = constant.DefaultScaleUpThreshold
+	} else if cfg.ScaleUpThreshold > 1 {
+		sanCfg.ScaleUpThreshold = 1
+	} else {
+		sanCfg.ScaleUpThreshold = cfg.ScaleUpThreshold
+	}
+
+	if cfg.ScaleDownCooldown <= 0 {
+		sanCfg.ScaleDownCooldown = constant.DefaultScaleDownCooldown
+	} else {
+		sanCfg.ScaleDownCooldown = cfg.ScaleDownCooldown
+	}
+	return nil
+}
+
+// sanitizeDedupConfig is used to fill in all properties of the DedupConfig with their default values,
+// returning an error in case no key function was defined.
+func sanitizeDedupConfig(cfg *DedupConfig) (*DedupConfig, error) {
+	if cfg.Key == nil {
+		return nil, fmt.Errorf("streamer config: validate dedup key: %w: missing", internal.ErrInvalidParam)
+	}
+	sanCfg := &DedupConfig{
+		Key:     cfg.Key,
+		Window:  cfg.Window,
+		MaxKeys: cfg.MaxKeys,
+	}
+	if sanCfg.Window <= 0 {
+		sanCfg.Window = constant.DefaultDedupWindow
+	}
+	if sanCfg.MaxKeys <= 0 {
+		sanCfg.MaxKeys = constant.DefaultDedupMaxKeys
+	}
+	return sanCfg, nil
+}
+
 // sanitizeStorageClientConfig is used to fill in some or all properties
 // with sane default values for the StorageClientConfig.
 // Defined as a function to keep its logic contained and well tested.
@@ -308,9 +888,15 @@ func sanitizeStorageClientConfig(cfg *StorageClientConfig) (sanCfg *StorageClien
 		// Streamer rather than a Storage API client driven streamer.
 		return
 	}
-	if cfg.ProtobufDescriptor == nil && cfg.BigQuerySchema == nil {
+	if cfg.ProtobufDescriptor == nil && cfg.BigQuerySchema == nil && cfg.RowType == nil {
 		return nil, internal.ErrProtobufOrSChemaRequired
 	}
+	if cfg.EvolveSchema && cfg.ProtobufDescriptor != nil {
+		return nil, internal.ErrEvolveSchemaRequiresSchema
+	}
+	if cfg.UseStructEncoder && (cfg.RowType == nil || cfg.ProtobufDescriptor != nil) {
+		return nil, internal.ErrStructEncoderRequiresRowType
+	}
 
 	// we want to create a new config, as to not mutate an input param (the cfg),
 	// this comes at the cost of allocating extra memory, but as this is only expected
@@ -321,6 +907,18 @@ func sanitizeStorageClientConfig(cfg *StorageClientConfig) (sanCfg *StorageClien
 	// no need for any validation there
 	sanCfg.BigQuerySchema = cfg.BigQuerySchema
 	sanCfg.ProtobufDescriptor = cfg.ProtobufDescriptor
+	sanCfg.RowType = cfg.RowType
+	sanCfg.UseStructEncoder = cfg.UseStructEncoder
+
+	// derive the BigQuery schema from the row type if no encoder config is defined explicitly
+	if sanCfg.ProtobufDescriptor == nil && sanCfg.BigQuerySchema == nil {
+		derivedSchema, err := schema.FromStructType(sanCfg.RowType)
+		if err != nil {
+			return nil, fmt.Errorf("StorageClientConfig invalid: derive schema from RowType %v: %w", sanCfg.RowType, err)
+		}
+		sanCfg.BigQuerySchema = &derivedSchema
+	}
+	sanCfg.EvolveSchema = cfg.EvolveSchema
 
 	// return the sanitized named output non-nil config
 	return sanCfg, nil
@@ -343,6 +941,40 @@ func sanitizeBatchClientConfig(cfg *BatchClientConfig) (batchCfg *BatchClientCon
 	// no need for any validation there.
 	batchCfg.BigQuerySchema = cfg.BigQuerySchema
 	batchCfg.FailForUnknownValues = cfg.FailForUnknownValues
+	batchCfg.CSVOptions = cfg.CSVOptions
+	batchCfg.ParquetOptions = cfg.ParquetOptions
+	batchCfg.IgnoreAvroLogicalTypes = cfg.IgnoreAvroLogicalTypes
+	batchCfg.DecimalTargetTypes = cfg.DecimalTargetTypes
+	batchCfg.CreateDisposition = cfg.CreateDisposition
+	batchCfg.TimePartitioning = cfg.TimePartitioning
+	batchCfg.RangePartitioning = cfg.RangePartitioning
+	batchCfg.Clustering = cfg.Clustering
+	batchCfg.DestinationEncryptionConfig = cfg.DestinationEncryptionConfig
+	batchCfg.Labels = cfg.Labels
+	batchCfg.MaxBadRecords = cfg.MaxBadRecords
+
+	for _, option := range cfg.SchemaUpdateOptions {
+		if option != SchemaUpdateAllowFieldAddition && option != SchemaUpdateAllowFieldRelaxation {
+			return nil, fmt.Errorf("%w: %q", internal.ErrInvalidSchemaUpdateOption, option)
+		}
+	}
+	batchCfg.SchemaUpdateOptions = cfg.SchemaUpdateOptions
+
+	if !validJobIDPrefix(cfg.JobIDPrefix) {
+		return nil, fmt.Errorf("%w: %q", internal.ErrInvalidJobIDPrefix, cfg.JobIDPrefix)
+	}
+	batchCfg.JobIDPrefix = cfg.JobIDPrefix
+	batchCfg.IdempotentLoads = cfg.IdempotentLoads
+
+	if cfg.MaxRetryDeadlineOffset == 0 {
+		batchCfg.MaxRetryDeadlineOffset = constant.DefaultMaxRetryDeadlineOffset
+	} else {
+		batchCfg.MaxRetryDeadlineOffset = cfg.MaxRetryDeadlineOffset
+	}
+
+	if batchCfg.Staging, err = sanitizeStagingConfig(cfg.Staging); err != nil {
+		return nil, err
+	}
 
 	if cfg.SourceFormat != "" {
 		batchCfg.SourceFormat = cfg.SourceFormat
@@ -361,5 +993,165 @@ func sanitizeBatchClientConfig(cfg *BatchClientConfig) (batchCfg *BatchClientCon
 		return nil, internal.ErrAutoDetectSchemaNotSupported
 	}
 
+	if cfg.Compression != "" {
+		batchCfg.Compression = cfg.Compression
+	} else {
+		batchCfg.Compression = constant.DefaultCompression
+	}
+	if !batchCompressionSupported(batchCfg.SourceFormat, batchCfg.Compression) {
+		return nil, fmt.Errorf("%w: %s for %s", internal.ErrUnsupportedCompression, batchCfg.Compression, batchCfg.SourceFormat)
+	}
+
+	if cfg.BatchSize < 0 {
+		batchCfg.BatchSize = 1
+	} else if cfg.BatchSize == 0 {
+		batchCfg.BatchSize = constant.DefaultBatchLoadSize
+	} else {
+		batchCfg.BatchSize = cfg.BatchSize
+	}
+
+	if cfg.MaxInFlightJobs < 0 {
+		batchCfg.MaxInFlightJobs = 1
+	} else if cfg.MaxInFlightJobs == 0 {
+		batchCfg.MaxInFlightJobs = constant.DefaultMaxInFlightLoadJobs
+	} else {
+		batchCfg.MaxInFlightJobs = cfg.MaxInFlightJobs
+	}
+
 	return batchCfg, nil
 }
+
+// LoadKey returns the Key of the KeyedReader,
+// used to derive the ID of its load job in case IdempotentLoads are enabled.
+func (r KeyedReader) LoadKey() string {
+	return r.Key
+}
+
+// validJobIDPrefix returns true in case the given prefix only contains the characters allowed in a job ID,
+// letters, numbers, underscores and dashes, and leaves room for the suffix of the job ID.
+func validJobIDPrefix(prefix string) bool {
+	if len(prefix) > maxJobIDPrefixLength {
+		return false
+	}
+	for _, r := range prefix {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// maxJobIDPrefixLength defines the maximum length of a job ID prefix,
+// leaving room for the suffix within the maximum job ID length of 1024 characters.
+const maxJobIDPrefixLength = 512
+
+// sanitizeStagingConfig is used to fill in some or all properties
+// with sane default values for the StagingConfig.
+// Defined as a function to keep its logic contained and well tested.
+func sanitizeStagingConfig(cfg *StagingConfig) (*StagingConfig, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if (cfg.Bucket == "") == (cfg.ObjectStore == nil) {
+		return nil, internal.ErrStagingBucketOrStoreRequired
+	}
+	if _, ok := cfg.ObjectStore.(*objectstore.LocalStore); ok {
+		// the files are loaded by the batch client using BigQuery, which cannot load local files
+		return nil, internal.ErrLocalStagingStore
+	}
+	stagingCfg := new(StagingConfig)
+	*stagingCfg = *cfg
+	if stagingCfg.Prefix == "" {
+		stagingCfg.Prefix = constant.DefaultStagingPrefix
+	}
+	return stagingCfg, nil
+}
+
+// batchCompressions defines the compressions supported for the files written by the batch client itself,
+// Avro files only support block compression and Parquet files only page compression.
+var batchCompressions = map[bigquery.DataFormat][]bigquery.Compression{
+	bigquery.Avro:    {bigquery.None, bigquery.Deflate, bigquery.Snappy},
+	bigquery.Parquet: {bigquery.None, bigquery.Snappy, bigquery.Gzip},
+}
+
+// batchCompressionSupported returns true in case the compression is supported for the given source format,
+// which is always the case for formats of which the files are not written by the batch client itself.
+func batchCompressionSupported(format bigquery.DataFormat, compression bigquery.Compression) bool {
+	compressions, ok := batchCompressions[format]
+	if !ok {
+		return true
+	}
+	for _, supported := range compressions {
+		if compression == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeCreateTableConfig is used to fill in some or all properties
+// with sane default values for the CreateTableConfig, resolving the schema
+// using the (already sanitized) client configs if not defined explicitly.
+// Defined as a function to keep its logic contained and well tested.
+func sanitizeCreateTableConfig(cfg *CreateTableConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (sanCfg *CreateTableConfig, err error) {
+	if cfg == nil {
+		// Nothing to do, table is expected to exist already.
+		return
+	}
+
+	// we want to create a new config, as to not mutate an input param (the cfg),
+	// this comes at the cost of allocating extra memory, but as this is only expected
+	// to be used at setup time it should be ok, the memory gods will forgive us I'm sure
+	sanCfg = new(CreateTableConfig)
+
+	// resolve the schema to be used to create the table, if not defined explicitly
+	sanCfg.Schema, err = resolveCreateTableSchema(cfg, storageCfg, batchCfg)
+	if err != nil {
+		return nil, err
+	}
+	sanCfg.RowType = cfg.RowType
+
+	// simply assign all other properties,
+	// no need for any validation there
+	sanCfg.DataSetLocation = cfg.DataSetLocation
+	sanCfg.TimePartitioning = cfg.TimePartitioning
+	sanCfg.Clustering = cfg.Clustering
+	sanCfg.Expiration = cfg.Expiration
+	sanCfg.Labels = cfg.Labels
+
+	// return the sanitized named output non-nil config
+	return sanCfg, nil
+}
+
+// resolveCreateTableSchema resolves the schema to be used to create the table,
+// see the documentation of CreateTableConfig::Schema for more information.
+func resolveCreateTableSchema(cfg *CreateTableConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (*bigquery.Schema, error) {
+	if cfg.Schema != nil {
+		return cfg.Schema, nil
+	}
+	if cfg.RowType != nil {
+		inferredSchema, err := schema.FromStructType(cfg.RowType)
+		if err != nil {
+			return nil, fmt.Errorf("CreateTableConfig invalid: infer schema from RowType %v: %w", cfg.RowType, err)
+		}
+		return &inferredSchema, nil
+	}
+	if storageCfg != nil {
+		if storageCfg.BigQuerySchema != nil {
+			return storageCfg.BigQuerySchema, nil
+		}
+		md, err := encoding.NewMessageDescriptor(storageCfg.ProtobufDescriptor)
+		if err != nil {
+			return nil, fmt.Errorf("CreateTableConfig invalid: resolve ProtobufDescriptor of StorageClientConfig: %w", err)
+		}
+		derivedSchema, err := schema.FromMessageDescriptor(md)
+		if err != nil {
+			return nil, fmt.Errorf("CreateTableConfig invalid: derive schema from ProtobufDescriptor of StorageClientConfig: %w", err)
+		}
+		return &derivedSchema, nil
+	}
+	if batchCfg != nil && batchCfg.BigQuerySchema != nil {
+		return batchCfg.BigQuerySchema, nil
+	}
+	return nil, internal.ErrCreateTableSchemaRequired
+}
@@ -15,14 +15,19 @@
 package bqwriter
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/OTA-Insight/bqwriter/constant"
 	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding/testdata"
 	"github.com/OTA-Insight/bqwriter/internal/test"
 	"github.com/OTA-Insight/bqwriter/log"
+	"github.com/OTA-Insight/bqwriter/objectstore"
+	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
@@ -58,6 +63,11 @@ var (
 	expectedDefaultBatchClient = BatchClientConfig{
 		SourceFormat:     constant.DefaultSourceFormat,
 		WriteDisposition: constant.DefaultWriteDisposition,
+		Compression:      constant.DefaultCompression,
+		BatchSize:        constant.DefaultBatchLoadSize,
+		MaxInFlightJobs:  constant.DefaultMaxInFlightLoadJobs,
+
+		MaxRetryDeadlineOffset: constant.DefaultMaxRetryDeadlineOffset,
 	}
 )
 
@@ -137,6 +147,97 @@ func TestSanitizeStreamerConfigFullDefault(t *testing.T) {
 	}
 }
 
+func TestSanitizeStreamerConfigWorkerScaling(t *testing.T) {
+	testCases := []struct {
+		InputCfg    StreamerConfig
+		ExpectedCfg StreamerConfig
+		ExpectedErr error
+	}{
+		// autoscaling disabled
+		{
+			InputCfg:    StreamerConfig{MinWorkers: 3, ScaleUpThreshold: 0.5},
+			ExpectedCfg: StreamerConfig{},
+		},
+		// defaults
+		{
+			InputCfg: StreamerConfig{MaxWorkers: 8},
+			ExpectedCfg: StreamerConfig{
+				MinWorkers:        constant.DefaultWorkerCount,
+				MaxWorkers:        8,
+				ScaleUpThreshold:  constant.DefaultScaleUpThreshold,
+				ScaleDownCooldown: constant.DefaultScaleDownCooldown,
+			},
+		},
+		{
+			InputCfg: StreamerConfig{WorkerCount: 3, MaxWorkers: 8},
+			ExpectedCfg: StreamerConfig{
+				MinWorkers:        3,
+				MaxWorkers:        8,
+				ScaleUpThreshold:  constant.DefaultScaleUpThreshold,
+				ScaleDownCooldown: constant.DefaultScaleDownCooldown,
+			},
+		},
+		{
+			InputCfg: StreamerConfig{MinWorkers: -1, MaxWorkers: 8, ScaleUpThreshold: -1, ScaleDownCooldown: -1},
+			ExpectedCfg: StreamerConfig{
+				MinWorkers:        1,
+				MaxWorkers:        8,
+				ScaleUpThreshold:  constant.DefaultScaleUpThreshold,
+				ScaleDownCooldown: constant.DefaultScaleDownCooldown,
+			},
+		},
+		// explicit values
+		{
+			InputCfg: StreamerConfig{MinWorkers: 4, MaxWorkers: 4, ScaleUpThreshold: 0.5, ScaleDownCooldown: time.Minute},
+			ExpectedCfg: StreamerConfig{
+				MinWorkers:        4,
+				MaxWorkers:        4,
+				ScaleUpThreshold:  0.5,
+				ScaleDownCooldown: time.Minute,
+			},
+		},
+		{
+			InputCfg: StreamerConfig{MinWorkers: 1, MaxWorkers: 2, ScaleUpThreshold: 1.5},
+			ExpectedCfg: StreamerConfig{
+				MinWorkers:        1,
+				MaxWorkers:        2,
+				ScaleUpThreshold:  1,
+				ScaleDownCooldown: constant.DefaultScaleDownCooldown,
+			},
+		},
+		// invalid configs
+		{
+			InputCfg:    StreamerConfig{MinWorkers: 4, MaxWorkers: 2},
+			ExpectedErr: internal.ErrInvalidWorkerScaling,
+		},
+		{
+			InputCfg:    StreamerConfig{MaxWorkers: -1},
+			ExpectedErr: internal.ErrInvalidWorkerScaling,
+		},
+		{
+			InputCfg:    StreamerConfig{MaxWorkers: 8, WorkerQueueSize: -1},
+			ExpectedErr: internal.ErrInvalidWorkerScaling,
+		},
+		{
+			InputCfg:    StreamerConfig{MaxWorkers: 8, ShardKey: func(interface{}) string { return "" }},
+			ExpectedErr: internal.ErrInvalidWorkerScaling,
+		},
+	}
+	for i, testCase := range testCases {
+		inputCfg := testCase.InputCfg
+		sanCfg, err := sanitizeStreamerConfig(&inputCfg)
+		if testCase.ExpectedErr != nil {
+			test.AssertIsError(t, err, testCase.ExpectedErr, "test case #%d", i)
+			continue
+		}
+		test.AssertNoErrorFatal(t, err, "test case #%d", i)
+		test.AssertEqual(t, testCase.ExpectedCfg.MinWorkers, sanCfg.MinWorkers, "test case #%d", i)
+		test.AssertEqual(t, testCase.ExpectedCfg.MaxWorkers, sanCfg.MaxWorkers, "test case #%d", i)
+		test.AssertEqual(t, testCase.ExpectedCfg.ScaleUpThreshold, sanCfg.ScaleUpThreshold, "test case #%d", i)
+		test.AssertEqual(t, testCase.ExpectedCfg.ScaleDownCooldown, sanCfg.ScaleDownCooldown, "test case #%d", i)
+	}
+}
+
 func TestSanitizeStreamerConfigSharedDefaults(t *testing.T) {
 	testCases := []struct {
 		InputWorkerCount    int
@@ -410,6 +511,127 @@ func testSanitizeStreamerConfigStorageDefaultsForEncoder(t *testing.T, schema *b
 	}
 }
 
+func TestSanitizeInsertAllClientConfigEvolveSchema(t *testing.T) {
+	sanCfg := sanitizeInsertAllClientConfig(&InsertAllClientConfig{EvolveSchema: true})
+	test.AssertTrue(t, sanCfg.EvolveSchema)
+	// unknown values are never ignored when evolving the schema
+	test.AssertTrue(t, sanCfg.FailForUnknownValues)
+}
+
+func TestSanitizeInsertAllClientConfigAdaptiveBatchSize(t *testing.T) {
+	// adaptive batch sizing is disabled by default
+	sanCfg := sanitizeInsertAllClientConfig(nil)
+	test.AssertNil(t, sanCfg.AdaptiveBatchSize)
+
+	sanCfg = sanitizeInsertAllClientConfig(&InsertAllClientConfig{
+		AdaptiveBatchSize: new(AdaptiveBatchSizeConfig),
+	})
+	test.AssertEqual(t, &AdaptiveBatchSizeConfig{
+		MinBatchSize:       constant.DefaultMinAdaptiveBatchSize,
+		MaxBatchSize:       constant.DefaultMaxAdaptiveBatchSize,
+		TargetFlushLatency: constant.DefaultTargetFlushLatency,
+		MaxBatchBytes:      constant.DefaultMaxAdaptiveBatchBytes,
+	}, sanCfg.AdaptiveBatchSize)
+
+	sanCfg = sanitizeInsertAllClientConfig(&InsertAllClientConfig{
+		AdaptiveBatchSize: &AdaptiveBatchSizeConfig{
+			MinBatchSize:       -1,
+			MaxBatchSize:       -1,
+			TargetFlushLatency: -1,
+			MaxBatchBytes:      -1,
+		},
+	})
+	test.AssertEqual(t, &AdaptiveBatchSizeConfig{
+		MinBatchSize:       1,
+		MaxBatchSize:       constant.DefaultMaxAdaptiveBatchSize,
+		TargetFlushLatency: constant.DefaultTargetFlushLatency,
+		MaxBatchBytes:      constant.DefaultMaxAdaptiveBatchBytes,
+	}, sanCfg.AdaptiveBatchSize)
+
+	// the max batch size cannot be lower than the min batch size
+	sanCfg = sanitizeInsertAllClientConfig(&InsertAllClientConfig{
+		AdaptiveBatchSize: &AdaptiveBatchSizeConfig{
+			MinBatchSize:       100,
+			MaxBatchSize:       50,
+			TargetFlushLatency: time.Millisecond * 500,
+			MaxBatchBytes:      1024,
+		},
+	})
+	test.AssertEqual(t, &AdaptiveBatchSizeConfig{
+		MinBatchSize:       100,
+		MaxBatchSize:       100,
+		TargetFlushLatency: time.Millisecond * 500,
+		MaxBatchBytes:      1024,
+	}, sanCfg.AdaptiveBatchSize)
+}
+
+func TestSanitizeStorageClientConfigEvolveSchema(t *testing.T) {
+	sanCfg, err := sanitizeStorageClientConfig(&StorageClientConfig{
+		BigQuerySchema: new(bigquery.Schema),
+		EvolveSchema:   true,
+	})
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, sanCfg.EvolveSchema)
+
+	sanCfg, err = sanitizeStorageClientConfig(&StorageClientConfig{
+		ProtobufDescriptor: new(descriptorpb.DescriptorProto),
+		EvolveSchema:       true,
+	})
+	test.AssertIsError(t, err, internal.ErrEvolveSchemaRequiresSchema)
+	test.AssertNil(t, sanCfg)
+}
+
+type testStorageRow struct {
+	Name  string `bigquery:"name"`
+	Value *int64 `bigquery:"value"`
+}
+
+func TestSanitizeStorageClientConfigRowType(t *testing.T) {
+	sanCfg, err := sanitizeStorageClientConfig(&StorageClientConfig{
+		RowType: reflect.TypeOf(testStorageRow{}),
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, &bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "value", Type: bigquery.IntegerFieldType},
+	}, sanCfg.BigQuerySchema)
+	test.AssertNil(t, sanCfg.ProtobufDescriptor)
+
+	// an explicit encoder config takes priority over the row type
+	explicitSchema := new(bigquery.Schema)
+	sanCfg, err = sanitizeStorageClientConfig(&StorageClientConfig{
+		BigQuerySchema: explicitSchema,
+		RowType:        reflect.TypeOf(testStorageRow{}),
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, explicitSchema, sanCfg.BigQuerySchema)
+
+	sanCfg, err = sanitizeStorageClientConfig(&StorageClientConfig{
+		RowType: reflect.TypeOf(42),
+	})
+	test.AssertError(t, err)
+	test.AssertNil(t, sanCfg)
+}
+
+func TestSanitizeStorageClientConfigUseStructEncoder(t *testing.T) {
+	sanCfg, err := sanitizeStorageClientConfig(&StorageClientConfig{
+		RowType:          reflect.TypeOf(testStorageRow{}),
+		UseStructEncoder: true,
+	})
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, sanCfg.UseStructEncoder)
+
+	testCases := []*StorageClientConfig{
+		{BigQuerySchema: new(bigquery.Schema), UseStructEncoder: true},
+		{ProtobufDescriptor: new(descriptorpb.DescriptorProto), RowType: reflect.TypeOf(testStorageRow{}), UseStructEncoder: true},
+	}
+	for _, testCase := range testCases {
+		sanCfg, err := sanitizeStorageClientConfig(testCase)
+		test.AssertIsError(t, err, internal.ErrStructEncoderRequiresRowType)
+		test.AssertNil(t, sanCfg)
+	}
+}
+
 func TestSanitizeBatchConfigDefaults(t *testing.T) {
 	schema := new(bigquery.Schema)
 	testCases := []struct {
@@ -481,6 +703,11 @@ func TestSanitizeBatchConfigDefaults(t *testing.T) {
 			SourceFormat:         testCase.ExpectedSourceFormat,
 			FailForUnknownValues: testCase.ExpectedFailForUnknownValues,
 			WriteDisposition:     testCase.ExpectedWriteDisposition,
+			Compression:          expectedDefaultBatchClient.Compression,
+			BatchSize:            expectedDefaultBatchClient.BatchSize,
+			MaxInFlightJobs:      expectedDefaultBatchClient.MaxInFlightJobs,
+
+			MaxRetryDeadlineOffset: expectedDefaultBatchClient.MaxRetryDeadlineOffset,
 		}
 		// and finally piggy-back on our other logic
 		assertStreamerConfig(t, inputCfg, expectedOutputCfg)
@@ -508,3 +735,291 @@ func TestSanitizeBatchConfigAutoDetectErr(t *testing.T) {
 		test.AssertNil(t, outCfg)
 	}
 }
+
+func TestSanitizeBatchConfigCompressionAndBatchSize(t *testing.T) {
+	schema := &bigquery.Schema{{Name: "foo", Type: bigquery.StringFieldType}}
+	testCases := []struct {
+		InputCompression    bigquery.Compression
+		ExpectedCompression bigquery.Compression
+		InputBatchSize      int
+		ExpectedBatchSize   int
+	}{
+		{"", constant.DefaultCompression, 0, constant.DefaultBatchLoadSize},
+		{bigquery.None, bigquery.None, -1, 1},
+		{bigquery.Deflate, bigquery.Deflate, 1, 1},
+		{bigquery.Snappy, bigquery.Snappy, 42, 42},
+	}
+	for _, testCase := range testCases {
+		cfg, err := sanitizeBatchClientConfig(&BatchClientConfig{
+			BigQuerySchema: schema,
+			SourceFormat:   bigquery.Avro,
+			Compression:    testCase.InputCompression,
+			BatchSize:      testCase.InputBatchSize,
+		})
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, testCase.ExpectedCompression, cfg.Compression)
+		test.AssertEqual(t, testCase.ExpectedBatchSize, cfg.BatchSize)
+	}
+
+	// Avro files only support block compression and Parquet files only page compression
+	for _, testCase := range []struct {
+		SourceFormat bigquery.DataFormat
+		Compression  bigquery.Compression
+	}{
+		{bigquery.Avro, bigquery.Gzip},
+		{bigquery.Parquet, bigquery.Deflate},
+		{bigquery.Parquet, "foo"},
+	} {
+		cfg, err := sanitizeBatchClientConfig(&BatchClientConfig{
+			BigQuerySchema: schema,
+			SourceFormat:   testCase.SourceFormat,
+			Compression:    testCase.Compression,
+		})
+		test.AssertIsError(t, err, internal.ErrUnsupportedCompression)
+		test.AssertNil(t, cfg)
+	}
+	cfg, err := sanitizeBatchClientConfig(&BatchClientConfig{
+		BigQuerySchema: schema,
+		SourceFormat:   bigquery.Parquet,
+		Compression:    bigquery.Gzip,
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, bigquery.Gzip, cfg.Compression)
+}
+
+func TestSanitizeBatchConfigMaxInFlightJobs(t *testing.T) {
+	testCases := []struct {
+		Input    int
+		Expected int
+	}{
+		{0, constant.DefaultMaxInFlightLoadJobs},
+		{-1, 1},
+		{1, 1},
+		{16, 16},
+	}
+	for _, testCase := range testCases {
+		cfg, err := sanitizeBatchClientConfig(&BatchClientConfig{
+			MaxInFlightJobs: testCase.Input,
+		})
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, testCase.Expected, cfg.MaxInFlightJobs)
+	}
+}
+
+func TestSanitizeBatchConfigFormatOptions(t *testing.T) {
+	csvOptions := &bigquery.CSVOptions{FieldDelimiter: "|", SkipLeadingRows: 1}
+	parquetOptions := &bigquery.ParquetOptions{EnableListInference: true}
+	decimalTargetTypes := []bigquery.DecimalTargetType{bigquery.NumericTargetType, bigquery.StringTargetType}
+	cfg, err := sanitizeBatchClientConfig(&BatchClientConfig{
+		SourceFormat:           bigquery.CSV,
+		CSVOptions:             csvOptions,
+		ParquetOptions:         parquetOptions,
+		IgnoreAvroLogicalTypes: true,
+		DecimalTargetTypes:     decimalTargetTypes,
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, csvOptions, cfg.CSVOptions)
+	test.AssertEqual(t, parquetOptions, cfg.ParquetOptions)
+	test.AssertTrue(t, cfg.IgnoreAvroLogicalTypes)
+	test.AssertEqual(t, decimalTargetTypes, cfg.DecimalTargetTypes)
+}
+
+func TestSanitizeBatchConfigLoadJobOptions(t *testing.T) {
+	inputCfg := &BatchClientConfig{
+		CreateDisposition: bigquery.CreateNever,
+		TimePartitioning:  &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "ts"},
+		RangePartitioning: &bigquery.RangePartitioning{Field: "id"},
+		Clustering:        &bigquery.Clustering{Fields: []string{"name"}},
+		SchemaUpdateOptions: []string{
+			SchemaUpdateAllowFieldAddition,
+			SchemaUpdateAllowFieldRelaxation,
+		},
+		DestinationEncryptionConfig: &bigquery.EncryptionConfig{KMSKeyName: "key"},
+		Labels:                      map[string]string{"team": "data"},
+		MaxBadRecords:               10,
+		JobIDPrefix:                 "bqwriter_my-table_",
+		IdempotentLoads:             true,
+		MaxRetryDeadlineOffset:      time.Minute,
+	}
+	cfg, err := sanitizeBatchClientConfig(inputCfg)
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, inputCfg.CreateDisposition, cfg.CreateDisposition)
+	test.AssertEqual(t, inputCfg.TimePartitioning, cfg.TimePartitioning)
+	test.AssertEqual(t, inputCfg.RangePartitioning, cfg.RangePartitioning)
+	test.AssertEqual(t, inputCfg.Clustering, cfg.Clustering)
+	test.AssertEqual(t, inputCfg.SchemaUpdateOptions, cfg.SchemaUpdateOptions)
+	test.AssertEqual(t, inputCfg.DestinationEncryptionConfig, cfg.DestinationEncryptionConfig)
+	test.AssertEqual(t, inputCfg.Labels, cfg.Labels)
+	test.AssertEqual(t, inputCfg.MaxBadRecords, cfg.MaxBadRecords)
+	test.AssertEqual(t, inputCfg.JobIDPrefix, cfg.JobIDPrefix)
+	test.AssertTrue(t, cfg.IdempotentLoads)
+	test.AssertEqual(t, time.Minute, cfg.MaxRetryDeadlineOffset)
+
+	cfg, err = sanitizeBatchClientConfig(&BatchClientConfig{
+		SchemaUpdateOptions: []string{SchemaUpdateAllowFieldAddition, "ALLOW_FIELD_REMOVAL"},
+	})
+	test.AssertIsError(t, err, internal.ErrInvalidSchemaUpdateOption)
+	test.AssertNil(t, cfg)
+
+	for _, prefix := range []string{"my.table", "job id", "table/", strings.Repeat("a", 513)} {
+		cfg, err = sanitizeBatchClientConfig(&BatchClientConfig{JobIDPrefix: prefix})
+		test.AssertIsError(t, err, internal.ErrInvalidJobIDPrefix)
+		test.AssertNil(t, cfg)
+	}
+}
+
+func TestSanitizeStagingConfig(t *testing.T) {
+	cfg, err := sanitizeStagingConfig(nil)
+	test.AssertNoError(t, err)
+	test.AssertNil(t, cfg)
+
+	// bucket with default prefix
+	cfg, err = sanitizeStagingConfig(&StagingConfig{Bucket: "bucket", DeleteAfterLoad: true})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, &StagingConfig{
+		Bucket:          "bucket",
+		Prefix:          constant.DefaultStagingPrefix,
+		DeleteAfterLoad: true,
+	}, cfg)
+
+	// object store with custom prefix
+	store := new(objectstore.GCSStore)
+	cfg, err = sanitizeStagingConfig(&StagingConfig{ObjectStore: store, Prefix: "staging/"})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, &StagingConfig{ObjectStore: store, Prefix: "staging/"}, cfg)
+
+	// a local store cannot be loaded from by BigQuery
+	localStore, err := objectstore.NewLocalStore(t.TempDir())
+	test.AssertNoErrorFatal(t, err)
+	cfg, err = sanitizeStagingConfig(&StagingConfig{ObjectStore: localStore})
+	test.AssertIsError(t, err, internal.ErrLocalStagingStore)
+	test.AssertNil(t, cfg)
+
+	// neither or both
+	for _, stagingCfg := range []*StagingConfig{
+		{},
+		{Bucket: "bucket", ObjectStore: store},
+	} {
+		cfg, err = sanitizeStagingConfig(stagingCfg)
+		test.AssertIsError(t, err, internal.ErrStagingBucketOrStoreRequired)
+		test.AssertNil(t, cfg)
+	}
+
+	// also validate it is returned from within the batch config sanitization
+	batchCfg, err := sanitizeBatchClientConfig(&BatchClientConfig{Staging: &StagingConfig{}})
+	test.AssertIsError(t, err, internal.ErrStagingBucketOrStoreRequired)
+	test.AssertNil(t, batchCfg)
+}
+
+func TestSanitizeCreateTableConfigNil(t *testing.T) {
+	cfg, err := sanitizeCreateTableConfig(nil, nil, nil)
+	test.AssertNoError(t, err)
+	test.AssertNil(t, cfg)
+}
+
+func TestSanitizeCreateTableConfigSchemaResolution(t *testing.T) {
+	explicitSchema := &bigquery.Schema{{Name: "explicit", Type: bigquery.StringFieldType}}
+	storageSchema := &bigquery.Schema{{Name: "storage", Type: bigquery.StringFieldType}}
+	batchSchema := &bigquery.Schema{{Name: "batch", Type: bigquery.StringFieldType}}
+	type row struct {
+		Name  string `bigquery:"name"`
+		Value int64
+	}
+	rowSchema := &bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "Value", Type: bigquery.IntegerFieldType, Required: true},
+	}
+	descriptorSchema := &bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "value", Type: bigquery.IntegerFieldType},
+	}
+
+	testCases := []struct {
+		Cfg            *CreateTableConfig
+		StorageCfg     *StorageClientConfig
+		BatchCfg       *BatchClientConfig
+		ExpectedSchema *bigquery.Schema
+	}{
+		{
+			Cfg:            &CreateTableConfig{Schema: explicitSchema, RowType: reflect.TypeOf(row{})},
+			StorageCfg:     &StorageClientConfig{BigQuerySchema: storageSchema},
+			ExpectedSchema: explicitSchema,
+		},
+		{
+			Cfg:            &CreateTableConfig{RowType: reflect.TypeOf(row{})},
+			StorageCfg:     &StorageClientConfig{BigQuerySchema: storageSchema},
+			ExpectedSchema: rowSchema,
+		},
+		{
+			Cfg:            &CreateTableConfig{RowType: reflect.TypeOf(&row{})},
+			ExpectedSchema: rowSchema,
+		},
+		{
+			Cfg:            new(CreateTableConfig),
+			StorageCfg:     &StorageClientConfig{BigQuerySchema: storageSchema},
+			ExpectedSchema: storageSchema,
+		},
+		{
+			Cfg:            new(CreateTableConfig),
+			StorageCfg:     &StorageClientConfig{ProtobufDescriptor: protodesc.ToDescriptorProto((&testdata.SimpleMessageProto2{}).ProtoReflect().Descriptor())},
+			ExpectedSchema: descriptorSchema,
+		},
+		{
+			Cfg:            new(CreateTableConfig),
+			BatchCfg:       &BatchClientConfig{BigQuerySchema: batchSchema},
+			ExpectedSchema: batchSchema,
+		},
+	}
+	for i, testCase := range testCases {
+		cfg, err := sanitizeCreateTableConfig(testCase.Cfg, testCase.StorageCfg, testCase.BatchCfg)
+		test.AssertNoError(t, err, "test case #%d", i)
+		test.AssertNotEqualShallow(t, testCase.Cfg, cfg, "test case #%d", i)
+		test.AssertEqual(t, testCase.ExpectedSchema, cfg.Schema, "test case #%d", i)
+		test.AssertEqual(t, testCase.Cfg.RowType, cfg.RowType, "test case #%d", i)
+	}
+}
+
+func TestSanitizeCreateTableConfigSchemaRequired(t *testing.T) {
+	testCases := []*BatchClientConfig{
+		nil,
+		new(BatchClientConfig),
+	}
+	for _, batchCfg := range testCases {
+		cfg, err := sanitizeCreateTableConfig(new(CreateTableConfig), nil, batchCfg)
+		test.AssertIsError(t, err, internal.ErrCreateTableSchemaRequired)
+		test.AssertNil(t, cfg)
+	}
+	// also validate it is returned from within the streamer config sanitization
+	cfg, err := sanitizeStreamerConfig(&StreamerConfig{
+		CreateIfNotExists: new(CreateTableConfig),
+	})
+	test.AssertIsError(t, err, internal.ErrCreateTableSchemaRequired)
+	test.AssertNil(t, cfg)
+}
+
+func TestCreateTableMetadata(t *testing.T) {
+	schema := bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}
+	now := time.Now()
+	cfg := &CreateTableConfig{
+		Schema:           &schema,
+		DataSetLocation:  "EU",
+		TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType},
+		Clustering:       &bigquery.Clustering{Fields: []string{"name"}},
+		Expiration:       time.Hour,
+		Labels:           map[string]string{"team": "data"},
+	}
+	datasetMD, tableMD := createTableMetadata(cfg, now)
+	test.AssertEqual(t, &bigquery.DatasetMetadata{Location: "EU"}, datasetMD)
+	test.AssertEqual(t, &bigquery.TableMetadata{
+		Schema:           schema,
+		TimePartitioning: cfg.TimePartitioning,
+		Clustering:       cfg.Clustering,
+		ExpirationTime:   now.Add(time.Hour),
+		Labels:           cfg.Labels,
+	}, tableMD)
+
+	// no expiration
+	cfg.Expiration = 0
+	_, tableMD = createTableMetadata(cfg, now)
+	test.AssertTrue(t, tableMD.ExpirationTime.IsZero())
+}
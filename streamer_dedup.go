@@ -0,0 +1,191 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal/dedup"
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+// DedupConfig enables the client-side deduplication of the rows written by a Streamer,
+// dropping rows of which the key was already written within the window, regardless of the client used.
+//
+// The keys are remembered in memory by the Streamer, bounded in time (Window) and size (MaxKeys),
+// with the least recently seen keys (written or dropped as a duplicate) being forgotten first
+// in case more keys are written within the window. Duplicates written by different Streamers,
+// or by a restarted Streamer, are therefore not detected.
+//
+// The key of a row is reserved, shared by all workers, as soon as a worker receives the row,
+// with its duplicates dropped from then on. It is only remembered once the client of the worker
+// returns no error for the put or flush which sends the row, and released otherwise, such that a row can
+// be written again in case it failed to be written. The rows sent together with a row that failed are released
+// as well, even though some of them may have been written. Note that this does not guarantee the row is written:
+// the Storage client only sends the rows without awaiting the result of the append, and the batch client only
+// submits the load job without awaiting its completion. Rows of which the append or load job fails afterwards
+// are still remembered, and their duplicates thus still dropped within the window.
+type DedupConfig struct {
+	// Key returns the key of a row, identifying its duplicates.
+	// Rows for which an empty key is returned are never deduplicated.
+	//
+	// The key is computed for the rows as written, prior to any of the Interceptors.
+	// Required, the key is called concurrently by the workers and thus has to be thread-safe.
+	Key func(row interface{}) string
+
+	// Window defines for how long the key of a row is remembered since it was last seen,
+	// duplicates dropped within the window thus extend it.
+	//
+	// Defaults to constant.DefaultDedupWindow if d == 0 (or negative).
+	Window time.Duration
+
+	// MaxKeys defines the maximum amount of keys remembered at once.
+	//
+	// Defaults to constant.DefaultDedupMaxKeys if n == 0 (or negative).
+	MaxKeys int
+}
+
+// deduplicator drops the rows of which the key was already seen within its window.
+type deduplicator struct {
+	// suppressed is the first field, as to be 64-bit aligned for atomic operations
+	suppressed uint64
+
+	key    func(row interface{}) string
+	window *dedup.Window
+}
+
+// newDeduplicator creates a new deduplicator for the given (sanitized) config,
+// returning nil in case deduplication is disabled.
+func newDeduplicator(cfg *DedupConfig) *deduplicator {
+	if cfg == nil {
+		return nil
+	}
+	return &deduplicator{
+		key:    cfg.Key,
+		window: dedup.NewWindow(cfg.Window, cfg.MaxKeys),
+	}
+}
+
+// reserve reserves the key of the row, returning the key (empty for rows without a key)
+// and false in case the row is a duplicate, counting it as suppressed if so.
+func (d *deduplicator) reserve(row interface{}) (string, bool) {
+	key := d.key(row)
+	if key == "" || d.window.Reserve(key) {
+		return key, true
+	}
+	atomic.AddUint64(&d.suppressed, 1)
+	return "", false
+}
+
+// pendingKeys are the keys reserved by a worker for the rows it put to its client,
+// remembered by the window of the deduplicator once the client reports them as sent.
+type pendingKeys struct {
+	window *dedup.Window
+	keys   []string
+}
+
+// newPendingKeys creates the pendingKeys of a worker, nil in case deduplication is disabled.
+func (s *Streamer) newPendingKeys() *pendingKeys {
+	if s.dedup == nil {
+		return nil
+	}
+	return &pendingKeys{window: s.dedup.window}
+}
+
+// add the reserved keys of the rows put to the client.
+func (p *pendingKeys) add(keys []string) {
+	if p == nil {
+		return
+	}
+	for _, key := range keys {
+		if key != "" {
+			p.keys = append(p.keys, key)
+		}
+	}
+}
+
+// settle remembers the pending keys in case the rows were sent without error,
+// and releases them otherwise, such that rows which failed to be sent can be written again.
+func (p *pendingKeys) settle(err error) {
+	if p == nil || len(p.keys) == 0 {
+		return
+	}
+	if err == nil {
+		p.window.Commit(p.keys...)
+	} else {
+		p.window.Release(p.keys...)
+	}
+	p.keys = p.keys[:0]
+}
+
+// deduplicate drops the duplicate rows of the job, returning the job of the remaining rows
+// together with their reserved keys (in the same order), and false in case all rows were dropped.
+func (s *Streamer) deduplicate(logger log.StructuredLogger, job streamerJob) (streamerJob, []string, bool) {
+	if s.dedup == nil {
+		return job, nil, true
+	}
+	if job.Rows == nil {
+		key, ok := s.dedup.reserve(job.Data)
+		if !ok {
+			logger.Log(log.LevelDebug, "worker thread data job received: dropped duplicate row", log.F(log.FieldRowCount, 1))
+			return streamerJob{}, nil, false
+		}
+		return job, []string{key}, true
+	}
+	rows := make([]interface{}, 0, len(job.Rows))
+	keys := make([]string, 0, len(job.Rows))
+	for _, row := range job.Rows {
+		if key, ok := s.dedup.reserve(row); ok {
+			rows = append(rows, row)
+			keys = append(keys, key)
+		}
+	}
+	if dropped := len(job.Rows) - len(rows); dropped > 0 {
+		logger.Log(log.LevelDebug, "worker thread data job received: dropped duplicate rows", log.F(log.FieldRowCount, dropped))
+	}
+	if len(rows) == 0 {
+		return streamerJob{}, nil, false
+	}
+	return streamerJob{Rows: rows}, keys, true
+}
+
+// releaseKeys releases the reserved keys at the given indices, returning the remaining keys.
+func (s *Streamer) releaseKeys(keys []string, indices []int) []string {
+	if s.dedup == nil || len(indices) == 0 {
+		return keys
+	}
+	released := make([]string, 0, len(indices))
+	remaining := make([]string, 0, len(keys)-len(indices))
+	for i, key := range keys {
+		if len(indices) > 0 && indices[0] == i {
+			released = append(released, key)
+			indices = indices[1:]
+		} else {
+			remaining = append(remaining, key)
+		}
+	}
+	s.dedup.window.Release(released...)
+	return remaining
+}
+
+// SuppressedDuplicates returns the amount of rows dropped as duplicates
+// since the Streamer was created, always 0 in case deduplication is disabled (see the Dedup property of the StreamerConfig).
+func (s *Streamer) SuppressedDuplicates() uint64 {
+	if s.dedup == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.dedup.suppressed)
+}
@@ -0,0 +1,242 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/constant"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+func TestStreamerDedup(t *testing.T) {
+	client := new(stubBQClient)
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{
+			WorkerCount: 1,
+			Logger:      test.Logger{},
+			Dedup: &DedupConfig{
+				Key: func(row interface{}) string {
+					if row == "no-key" {
+						return ""
+					}
+					return row.(string)
+				},
+			},
+		},
+	)
+	test.AssertNoErrorFatal(t, err)
+	putSignalCh := make(chan struct{}, 3)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	test.AssertNoError(t, streamer.Write("a"))
+	test.AssertNoError(t, streamer.Write("a"))
+	test.AssertNoError(t, streamer.WriteBatch([]interface{}{"a", "a"}))
+	// duplicates are dropped within a batch as well
+	test.AssertNoError(t, streamer.WriteBatch([]interface{}{"b", "a", "b", "no-key", "no-key"}))
+	test.AssertNoError(t, streamer.Write("c"))
+	<-putSignalCh
+	<-putSignalCh
+	<-putSignalCh
+	streamer.Close()
+
+	test.AssertEqual(t, []interface{}{"a", "b", "no-key", "no-key", "c"}, client.Rows())
+	test.AssertEqual(t, uint64(5), streamer.SuppressedDuplicates())
+}
+
+func TestStreamerDedupRetryFailedWrite(t *testing.T) {
+	client := new(stubBQClient)
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{
+			WorkerCount: 1,
+			Logger:      test.Logger{},
+			Dedup: &DedupConfig{
+				Key: func(row interface{}) string {
+					return row.(string)
+				},
+			},
+		},
+	)
+	test.AssertNoErrorFatal(t, err)
+	putSignalCh := make(chan struct{}, 1)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	// a row which failed to be written is not remembered, and can thus be written again
+	client.AddNextError(test.ErrStatic)
+	test.AssertNoError(t, streamer.Write("a"))
+	<-putSignalCh
+	client.FlushNextPut()
+	test.AssertNoError(t, streamer.Write("a"))
+	<-putSignalCh
+	// once written it is remembered
+	test.AssertNoError(t, streamer.Write("a"))
+	test.AssertNoError(t, streamer.Write("b"))
+	<-putSignalCh
+	streamer.Close()
+
+	test.AssertEqual(t, []interface{}{"a", "b"}, client.Rows())
+	test.AssertEqual(t, uint64(1), streamer.SuppressedDuplicates())
+	test.AssertEqual(t, 2, streamer.dedup.window.Len())
+}
+
+func TestStreamerDedupMultipleWorkers(t *testing.T) {
+	client := new(stubBQClient)
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{
+			WorkerCount: 4,
+			Logger:      test.Logger{},
+			Dedup: &DedupConfig{
+				Key: func(row interface{}) string {
+					return row.(string)
+				},
+			},
+		},
+	)
+	test.AssertNoErrorFatal(t, err)
+	putSignalCh := make(chan struct{}, 1)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	// the rows are not flushed until the streamer is closed,
+	// the key reserved by one worker is thus what prevents the others from writing the row
+	for i := 0; i < 20; i++ {
+		test.AssertNoError(t, streamer.Write("a"))
+	}
+	<-putSignalCh
+	deadline := time.Now().Add(5 * time.Second)
+	for streamer.SuppressedDuplicates() < 19 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	streamer.Close()
+
+	test.AssertEqual(t, []interface{}{"a"}, client.Rows())
+	test.AssertEqual(t, uint64(19), streamer.SuppressedDuplicates())
+}
+
+func TestStreamerDedupInterceptorDroppedRow(t *testing.T) {
+	client := new(stubBQClient)
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{
+			WorkerCount: 1,
+			Logger:      test.Logger{},
+			Interceptors: []RowInterceptor{
+				func(ctx context.Context, row interface{}) (interface{}, error) {
+					if row == "drop-me" {
+						return nil, DropRow("test")
+					}
+					return row, nil
+				},
+			},
+			Dedup: &DedupConfig{
+				Key: func(row interface{}) string {
+					if row == "c" {
+						return "c"
+					}
+					return "key"
+				},
+			},
+		},
+	)
+	test.AssertNoErrorFatal(t, err)
+	putSignalCh := make(chan struct{}, 1)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	// the key of a row dropped by an interceptor is not remembered,
+	// such that a next row with the same key is still written
+	test.AssertNoError(t, streamer.Write("drop-me"))
+	test.AssertNoError(t, streamer.Write("a"))
+	<-putSignalCh
+	test.AssertNoError(t, streamer.Write("b"))
+	test.AssertNoError(t, streamer.Write("c"))
+	<-putSignalCh
+	streamer.Close()
+
+	test.AssertEqual(t, []interface{}{"a", "c"}, client.Rows())
+	test.AssertEqual(t, uint64(1), streamer.SuppressedDuplicates())
+}
+
+func TestStreamerDedupDisabled(t *testing.T) {
+	client := new(stubBQClient)
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{WorkerCount: 1},
+	)
+	test.AssertNoErrorFatal(t, err)
+	defer streamer.Close()
+	test.AssertNil(t, streamer.dedup)
+	test.AssertEqual(t, uint64(0), streamer.SuppressedDuplicates())
+}
+
+func TestSanitizeStreamerConfigDedup(t *testing.T) {
+	_, err := sanitizeStreamerConfig(&StreamerConfig{
+		Dedup: &DedupConfig{},
+	})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+
+	sanCfg, err := sanitizeStreamerConfig(&StreamerConfig{
+		Dedup: &DedupConfig{
+			Key:     func(row interface{}) string { return "" },
+			Window:  -1,
+			MaxKeys: -1,
+		},
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, constant.DefaultDedupWindow, sanCfg.Dedup.Window)
+	test.AssertEqual(t, constant.DefaultDedupMaxKeys, sanCfg.Dedup.MaxKeys)
+
+	sanCfg, err = sanitizeStreamerConfig(&StreamerConfig{
+		Dedup: &DedupConfig{
+			Key:     func(row interface{}) string { return "" },
+			Window:  time.Second,
+			MaxKeys: 10,
+		},
+	})
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, time.Second, sanCfg.Dedup.Window)
+	test.AssertEqual(t, 10, sanCfg.Dedup.MaxKeys)
+}
@@ -0,0 +1,235 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+type (
+	// RowInterceptor is called by a worker for each row, in the order the interceptors are defined,
+	// prior to writing the row to its client (see the Interceptors property of the StreamerConfig).
+	//
+	// An interceptor can return:
+	//   - the (transformed) row, passed to the next interceptor;
+	//   - a FanOut, each row of which is passed to the next interceptor;
+	//   - a nil row, or an error wrapping ErrRowDropped (see DropRow), to drop the row on purpose.
+	//
+	// The row is dropped as well in case any other error is returned, with this error being logged.
+	// Interceptors are called concurrently by the workers and thus have to be thread-safe.
+	RowInterceptor func(ctx context.Context, row interface{}) (interface{}, error)
+
+	// FanOut can be returned by a RowInterceptor in order to replace a row by multiple rows.
+	FanOut []interface{}
+)
+
+// ErrRowDropped is the error wrapped by the errors returned by DropRow.
+var ErrRowDropped = errors.New("row dropped")
+
+// DropRow returns an error which can be returned by a RowInterceptor
+// in order to drop a row on purpose, for the given reason.
+func DropRow(reason string) error {
+	return fmt.Errorf("%w: %s", ErrRowDropped, reason)
+}
+
+// intercept passes the rows of the job through the interceptors of the streamer,
+// returning the job of the resulting rows, the (ordered) indices of the rows of the job
+// which were dropped entirely, and false in case all rows were dropped.
+func (s *Streamer) intercept(ctx context.Context, logger log.StructuredLogger, job streamerJob) (streamerJob, []int, bool) {
+	interceptors := s.cfg.Interceptors
+	if len(interceptors) == 0 {
+		return job, nil, true
+	}
+	var (
+		rows    []interface{}
+		dropped []int
+	)
+	if job.Rows == nil {
+		rows = interceptRow(ctx, logger, interceptors, job.Data, nil)
+		if len(rows) == 0 {
+			dropped = append(dropped, 0)
+		}
+	} else {
+		for i, row := range job.Rows {
+			n := len(rows)
+			rows = interceptRow(ctx, logger, interceptors, row, rows)
+			if len(rows) == n {
+				dropped = append(dropped, i)
+			}
+		}
+	}
+	switch {
+	case len(rows) == 0:
+		return streamerJob{}, dropped, false
+	case job.Rows == nil && len(rows) == 1:
+		return streamerJob{Data: rows[0]}, dropped, true
+	default:
+		return streamerJob{Rows: rows}, dropped, true
+	}
+}
+
+// interceptRow passes the row through the given interceptors,
+// appending the resulting row(s) to the given rows, which are returned.
+func interceptRow(ctx context.Context, logger log.StructuredLogger, interceptors []RowInterceptor, row interface{}, rows []interface{}) []interface{} {
+	for i, interceptor := range interceptors {
+		result, err := interceptor(ctx, row)
+		if err != nil {
+			if errors.Is(err, ErrRowDropped) {
+				logger.Log(log.LevelDebug, fmt.Sprintf("worker thread data job received: row interceptor: %v", err), log.F(log.FieldRowCount, 1))
+			} else {
+				logger.Log(log.LevelError, fmt.Sprintf("worker thread data job received: row interceptor: dropping row due to error: %v", err), log.F(log.FieldRowCount, 1), log.F(log.FieldError, err))
+			}
+			return rows
+		}
+		if fanOut, ok := result.(FanOut); ok {
+			for _, row := range fanOut {
+				if row != nil {
+					rows = interceptRow(ctx, logger, interceptors[i+1:], row, rows)
+				}
+			}
+			return rows
+		}
+		if result == nil {
+			logger.Log(log.LevelDebug, "worker thread data job received: row interceptor: row dropped", log.F(log.FieldRowCount, 1))
+			return rows
+		}
+		row = result
+	}
+	return append(rows, row)
+}
+
+// TimestampInterceptor returns a RowInterceptor which sets the given field of each row
+// to the time the row is intercepted by a worker, e.g. to stamp an `ingested_at` column.
+//
+// Supported rows are maps (map[string]interface{} and map[string]bigquery.Value) and bigquery.ValueSavers,
+// for which the field is added to the (saved) values, as well as structs (or pointers to structs)
+// with a time.Time (or *time.Time) field named as the given field, or with a bigquery tag of that name.
+// The rows are copied rather than modified, as the written rows might still be used by the caller.
+// Rows of any other type are dropped with an error.
+func TimestampInterceptor(field string) RowInterceptor {
+	return func(_ context.Context, row interface{}) (interface{}, error) {
+		return setRowField(row, field, time.Now())
+	}
+}
+
+// SampleInterceptor returns a RowInterceptor which keeps a random sample of the rows,
+// keeping each row with the given probability (between 0 and 1), dropping all other rows.
+func SampleInterceptor(rate float64) RowInterceptor {
+	return func(_ context.Context, row interface{}) (interface{}, error) {
+		if rate >= 1 || rand.Float64() < rate {
+			return row, nil
+		}
+		return nil, DropRow("sampled out")
+	}
+}
+
+// timestampedValueSaver adds a timestamp field to the values saved by the wrapped ValueSaver.
+type timestampedValueSaver struct {
+	bq.ValueSaver
+	field string
+	ts    time.Time
+}
+
+// Save implements bigquery.ValueSaver::Save
+func (s timestampedValueSaver) Save() (map[string]bq.Value, string, error) {
+	values, insertID, err := s.ValueSaver.Save()
+	if err != nil {
+		return nil, "", err
+	}
+	if values == nil {
+		values = make(map[string]bq.Value, 1)
+	}
+	values[s.field] = s.ts
+	return values, insertID, nil
+}
+
+// setRowField returns a copy of the row with the given field set to the given time.
+func setRowField(row interface{}, field string, ts time.Time) (interface{}, error) {
+	switch v := row.(type) {
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v)+1)
+		for key, value := range v {
+			values[key] = value
+		}
+		values[field] = ts
+		return values, nil
+	case map[string]bq.Value:
+		values := make(map[string]bq.Value, len(v)+1)
+		for key, value := range v {
+			values[key] = value
+		}
+		values[field] = ts
+		return values, nil
+	case bq.ValueSaver:
+		return timestampedValueSaver{ValueSaver: v, field: field, ts: ts}, nil
+	}
+
+	rv := reflect.ValueOf(row)
+	isPtr := rv.Kind() == reflect.Ptr && !rv.IsNil()
+	if isPtr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("timestamp interceptor: %w: unsupported row type %T", internal.ErrInvalidParam, row)
+	}
+	index, ok := timeFieldIndex(rv.Type(), field)
+	if !ok {
+		return nil, fmt.Errorf("timestamp interceptor: %w: row type %T has no time field %q", internal.ErrInvalidParam, row, field)
+	}
+	cp := reflect.New(rv.Type()).Elem()
+	cp.Set(rv)
+	if fv := cp.Field(index); fv.Kind() == reflect.Ptr {
+		fv.Set(reflect.ValueOf(&ts))
+	} else {
+		fv.Set(reflect.ValueOf(ts))
+	}
+	if isPtr {
+		return cp.Addr().Interface(), nil
+	}
+	return cp.Interface(), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// timeFieldIndex returns the index of the exported time.Time (or *time.Time) field
+// of the given struct type, named as the given field or with a bigquery tag of that name.
+func timeFieldIndex(t reflect.Type, field string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || (f.Type != timeType && f.Type != reflect.PtrTo(timeType)) {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("bigquery"); ok {
+			if tagName := strings.Split(tag, ",")[0]; tagName != "" {
+				name = tagName
+			}
+		}
+		if name == field {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+type testValueSaverRow struct{}
+
+func (testValueSaverRow) Save() (map[string]bq.Value, string, error) {
+	return map[string]bq.Value{"a": "bc"}, "", nil
+}
+
+func TestStreamerInterceptors(t *testing.T) {
+	client := new(stubBQClient)
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{
+			WorkerCount: 1,
+			Logger:      test.Logger{},
+			Interceptors: []RowInterceptor{
+				func(_ context.Context, row interface{}) (interface{}, error) {
+					switch row {
+					case "test":
+						return nil, DropRow("test traffic")
+					case "fail":
+						return nil, test.ErrStatic
+					case "double":
+						return FanOut{"double-1", nil, "double-2"}, nil
+					case "nil":
+						return nil, nil
+					}
+					return row, nil
+				},
+				func(_ context.Context, row interface{}) (interface{}, error) {
+					return strings.ToUpper(row.(string)), nil
+				},
+			},
+		},
+	)
+	test.AssertNoErrorFatal(t, err)
+	putSignalCh := make(chan struct{}, 2)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	// dropped rows are never written to the client
+	test.AssertNoError(t, streamer.Write("test"))
+	test.AssertNoError(t, streamer.Write("fail"))
+	test.AssertNoError(t, streamer.Write("nil"))
+	test.AssertNoError(t, streamer.WriteBatch([]interface{}{"test", "nil"}))
+	// rows are transformed, with the rows of a fan out each passed to the remaining interceptors
+	test.AssertNoError(t, streamer.Write("a"))
+	test.AssertNoError(t, streamer.WriteBatch([]interface{}{"double", "test", "b"}))
+	<-putSignalCh
+	<-putSignalCh
+	streamer.Close()
+
+	test.AssertEqual(t, []interface{}{"A", "DOUBLE-1", "DOUBLE-2", "B"}, client.Rows())
+	test.AssertEqual(t, 1, client.BatchCount())
+}
+
+func TestSanitizeStreamerConfigInterceptors(t *testing.T) {
+	_, err := sanitizeStreamerConfig(&StreamerConfig{
+		Interceptors: []RowInterceptor{SampleInterceptor(1), nil},
+	})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+}
+
+func TestDropRow(t *testing.T) {
+	err := DropRow("test traffic")
+	test.AssertIsError(t, err, ErrRowDropped)
+	test.AssertEqual(t, "row dropped: test traffic", err.Error())
+}
+
+type testTimestampRow struct {
+	Name       string
+	IngestedAt time.Time `bigquery:"ingested_at"`
+	SeenAt     *time.Time
+}
+
+func TestTimestampInterceptor(t *testing.T) {
+	start := time.Now()
+	assertTimestamp := func(value interface{}, contextArgs ...interface{}) {
+		if ts, ok := value.(*time.Time); ok {
+			value = *ts
+		}
+		ts, ok := value.(time.Time)
+		test.AssertTrue(t, ok, contextArgs...)
+		test.AssertFalse(t, ts.Before(start), contextArgs...)
+	}
+
+	row, err := TimestampInterceptor("ingested_at")(context.Background(), map[string]interface{}{"name": "a"})
+	test.AssertNoError(t, err)
+	assertTimestamp(row.(map[string]interface{})["ingested_at"], "map")
+
+	input := map[string]bq.Value{"name": "a"}
+	row, err = TimestampInterceptor("ingested_at")(context.Background(), input)
+	test.AssertNoError(t, err)
+	assertTimestamp(row.(map[string]bq.Value)["ingested_at"], "bigquery map")
+	// the input row is never modified
+	test.AssertEqual(t, 1, len(input))
+
+	row, err = TimestampInterceptor("ingested_at")(context.Background(), testValueSaverRow{})
+	test.AssertNoError(t, err)
+	values, _, err := row.(bq.ValueSaver).Save()
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, "bc", values["a"])
+	assertTimestamp(values["ingested_at"], "value saver")
+
+	structRow := &testTimestampRow{Name: "a"}
+	row, err = TimestampInterceptor("ingested_at")(context.Background(), structRow)
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, "a", row.(*testTimestampRow).Name)
+	assertTimestamp(row.(*testTimestampRow).IngestedAt, "struct pointer")
+	test.AssertTrue(t, structRow.IngestedAt.IsZero())
+
+	row, err = TimestampInterceptor("SeenAt")(context.Background(), testTimestampRow{Name: "a"})
+	test.AssertNoError(t, err)
+	assertTimestamp(row.(testTimestampRow).SeenAt, "struct")
+
+	for _, testCase := range []struct {
+		Field string
+		Row   interface{}
+	}{
+		{"ingested_at", "a"},
+		{"ingested_at", 42},
+		{"Name", testTimestampRow{}},
+		{"unknown", &testTimestampRow{}},
+	} {
+		_, err = TimestampInterceptor(testCase.Field)(context.Background(), testCase.Row)
+		test.AssertIsError(t, err, internal.ErrInvalidParam, fmt.Sprintf("%s: %T", testCase.Field, testCase.Row))
+	}
+}
+
+func TestSampleInterceptor(t *testing.T) {
+	row, err := SampleInterceptor(1)(context.Background(), "a")
+	test.AssertNoError(t, err)
+	test.AssertEqual(t, "a", row)
+
+	row, err = SampleInterceptor(0)(context.Background(), "a")
+	test.AssertIsError(t, err, ErrRowDropped)
+	test.AssertNil(t, row)
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		if _, err := SampleInterceptor(0.5)(context.Background(), "a"); err == nil {
+			kept++
+		}
+	}
+	test.AssertTrue(t, kept > 400 && kept < 600, "kept %d rows", kept)
+}
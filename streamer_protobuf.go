@@ -0,0 +1,42 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// NormalizeProtobufDescriptor builds a self-contained descriptor proto for the given message descriptor,
+// to be used as the ProtobufDescriptor of a StorageClientConfig. It nests all referenced message types,
+// the same as adapt.NormalizeDescriptor does, but normalizes the fields of a well-known type
+// to the scalar (wire) type expected by the BigQuery Storage Write API:
+// - google.protobuf.Timestamp as an int64 with the microseconds since the unix epoch (TIMESTAMP);
+// - google.protobuf.Duration as an int64 with its amount of microseconds (INTEGER);
+// - the wrapper types (e.g. google.protobuf.Int64Value) as their (optional) scalar type;
+// - google.protobuf.Struct, Value and ListValue as their Json encoded string (STRING).
+//
+// The descriptor of a proto3 message is converted to an equivalent proto2 descriptor, as the Storage Write API
+// decodes rows using proto2 semantics, with its (open) enum fields defined as int32 fields.
+//
+// Messages written using a Streamer with such a ProtobufDescriptor are normalized the same way
+// prior to being encoded, with the singular fields of a proto3 message without presence written
+// with their zero value rather than being omitted (which would result in a NULL value). A ProtobufDescriptor normalized using adapt.NormalizeDescriptor instead
+// is supported as well, as long as the message does not define a google.protobuf.Struct field,
+// which cannot be normalized by the adapt package due to it being a recursive message.
+func NormalizeProtobufDescriptor(md protoreflect.MessageDescriptor) (*descriptorpb.DescriptorProto, error) {
+	return encoding.NormalizeDescriptor(md)
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/ratelimit"
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+type (
+	// RateLimitConfig defines the client-side rate limits of the rows written to BigQuery,
+	// as to stay within the (throughput) quotas of the API used by the Streamer.
+	//
+	// Each limit can burst up to a second worth of its rate.
+	// Writes are never dropped due to these limits, instead the workers wait until they can write.
+	RateLimitConfig struct {
+		// RowsPerSecond defines the max amount of rows written per second.
+		//
+		// Defaults to 0, in which case the rows are not limited.
+		RowsPerSecond float64
+
+		// BytesPerSecond defines the max amount of bytes written per second,
+		// with the size of the rows being determined by the client when it sends them:
+		// the size of the encoded rows appended by the storage client and of the files loaded by the batch client
+		// (or the remaining length of an io.Reader defining a Len method), while the insertAll client estimates
+		// the size of its batch using the Json encoding of a sample of its rows.
+		//
+		// Defaults to 0, in which case the bytes are not limited.
+		BytesPerSecond float64
+
+		// PerTable optionally defines the limits of each table written by the Streamers sharing a RateLimiter,
+		// shared by all these Streamers writing to the same table, in addition to the limits of the RateLimiter itself.
+		// Only used by NewRateLimiter, as the RateLimit of a Streamer only applies to its own table already.
+		//
+		// Defaults to nil, in which case the tables are not limited individually by the RateLimiter.
+		PerTable *RateLimitConfig
+	}
+
+	// RateLimiter limits the rows written by all Streamers using it (see the GlobalRateLimiter property
+	// of the StreamerConfig), e.g. in order to stay within the quotas of a project as a whole,
+	// as well as per table in case PerTable limits are defined.
+	RateLimiter struct {
+		limiter *ratelimit.Limiter
+
+		perTable *RateLimitConfig
+		mu       sync.Mutex
+		tables   map[string]*ratelimit.Limiter
+	}
+
+	// RateLimitStats contains the statistics of the rate limiting of a Streamer,
+	// see (*Streamer).RateLimitStats.
+	RateLimitStats struct {
+		// Throttled is the total time the clients of the workers waited prior to sending their rows.
+		Throttled time.Duration
+		// ThrottledRows is the amount of rows of which the sending was delayed.
+		ThrottledRows uint64
+		// QuotaExceeded is the amount of errors received reporting that a quota was exceeded.
+		QuotaExceeded uint64
+	}
+)
+
+// NewRateLimiter creates a new RateLimiter, which can be shared by multiple Streamers.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		limiter:  ratelimit.NewLimiter(cfg.RowsPerSecond, cfg.BytesPerSecond),
+		perTable: cfg.PerTable,
+		tables:   make(map[string]*ratelimit.Limiter),
+	}
+}
+
+// tableLimiter returns the limiter of the given (fully qualified) table,
+// created on first use, or nil in case the tables are not limited individually.
+func (rl *RateLimiter) tableLimiter(table string) *ratelimit.Limiter {
+	if rl.perTable == nil {
+		return nil
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	limiter, ok := rl.tables[table]
+	if !ok {
+		limiter = ratelimit.NewLimiter(rl.perTable.RowsPerSecond, rl.perTable.BytesPerSecond)
+		rl.tables[table] = limiter
+	}
+	return limiter
+}
+
+// rateLimitStats contains the counters of the RateLimitStats of a streamer,
+// allocated on its own as to ensure the 64-bit alignment required for atomic operations.
+type rateLimitStats struct {
+	throttled     int64
+	throttledRows uint64
+	quotaExceeded uint64
+}
+
+// RateLimitStats returns the statistics of the rate limiting of the Streamer since it was created.
+func (s *Streamer) RateLimitStats() RateLimitStats {
+	return RateLimitStats{
+		Throttled:     time.Duration(atomic.LoadInt64(&s.rateLimitStats.throttled)),
+		ThrottledRows: atomic.LoadUint64(&s.rateLimitStats.throttledRows),
+		QuotaExceeded: atomic.LoadUint64(&s.rateLimitStats.quotaExceeded),
+	}
+}
+
+// newStreamerLimiters creates the rate limiters respected by the workers of a streamer
+// writing to the given (fully qualified) table, for the given (sanitized) config.
+// The limiter of the streamer itself is always defined, even if it limits nothing,
+// as it is also used to slow down once the quota of the table is exceeded.
+func newStreamerLimiters(cfg *StreamerConfig, table string) []*ratelimit.Limiter {
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit != nil {
+		limiter = ratelimit.NewLimiter(cfg.RateLimit.RowsPerSecond, cfg.RateLimit.BytesPerSecond)
+	} else {
+		limiter = ratelimit.NewLimiter(0, 0)
+	}
+	limiters := []*ratelimit.Limiter{limiter}
+	if cfg.GlobalRateLimiter != nil {
+		limiters = append(limiters, cfg.GlobalRateLimiter.limiter)
+		if tableLimiter := cfg.GlobalRateLimiter.tableLimiter(table); tableLimiter != nil {
+			limiters = append(limiters, tableLimiter)
+		}
+	}
+	return limiters
+}
+
+// workerThrottler implements bigquery.Throttler for the client of a worker,
+// waiting on the rate limiters of its streamer until the worker is closed.
+type workerThrottler struct {
+	s      *Streamer
+	ctx    context.Context
+	logger log.StructuredLogger
+}
+
+// Throttle implements bigquery.Throttler::Throttle
+//
+// The rows are sent regardless in case the worker is closed while waiting,
+// as the worker flushes its client once more while closing.
+func (t *workerThrottler) Throttle(rows, bytes int) {
+	var throttled time.Duration
+	for _, limiter := range t.s.limiters {
+		delay, err := limiter.Wait(t.ctx, rows, bytes)
+		if err != nil {
+			break
+		}
+		throttled += delay
+	}
+	if throttled > 0 {
+		atomic.AddInt64(&t.s.rateLimitStats.throttled, int64(throttled))
+		atomic.AddUint64(&t.s.rateLimitStats.throttledRows, uint64(rows))
+		t.logger.Log(log.LevelDebug, fmt.Sprintf("worker client: throttled by rate limit for %v", throttled), log.F(log.FieldRowCount, rows))
+	}
+}
+
+// LimitsBytes implements bigquery.Throttler::LimitsBytes
+func (t *workerThrottler) LimitsBytes() bool {
+	for _, limiter := range t.s.limiters {
+		if limiter.LimitsBytes() {
+			return true
+		}
+	}
+	return false
+}
+
+// reportQuotaExceeded slows down the rate limiters of the streamer
+// in case the given error reports that a quota was exceeded,
+// counting and reporting it to the QuotaExceededHook, if defined.
+func (s *Streamer) reportQuotaExceeded(logger log.StructuredLogger, err error) {
+	if !bigquery.IsQuotaError(err) {
+		return
+	}
+	var pause time.Duration
+	for _, limiter := range s.limiters {
+		if d := limiter.QuotaExceeded(); d > pause {
+			pause = d
+		}
+	}
+	atomic.AddUint64(&s.rateLimitStats.quotaExceeded, 1)
+	logger.Log(log.LevelWarn, fmt.Sprintf("quota exceeded: slowing down: writes paused for %v", pause), log.F(log.FieldError, err))
+	if hook := s.cfg.QuotaExceededHook; hook != nil {
+		hook(err, pause)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"github.com/OTA-Insight/bqwriter/log"
+	"google.golang.org/api/googleapi"
+)
+
+func newTestRateLimitedStreamer(t *testing.T, cfg *StreamerConfig) (*stubBQClient, *Streamer) {
+	client := new(stubBQClient)
+	cfg.WorkerCount = 1
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		cfg,
+	)
+	test.AssertNoErrorFatal(t, err)
+	return client, streamer
+}
+
+func TestStreamerRateLimit(t *testing.T) {
+	globalLimiter := NewRateLimiter(RateLimitConfig{RowsPerSecond: 1000})
+	client, streamer := newTestRateLimitedStreamer(t, &StreamerConfig{
+		RateLimit:         &RateLimitConfig{RowsPerSecond: 20},
+		GlobalRateLimiter: globalLimiter,
+	})
+	defer streamer.Close()
+	test.AssertEqual(t, 2, len(streamer.limiters))
+	putSignalCh := make(chan struct{}, 30)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	// a second worth of rows is written at once, after which the rows are written at the limited rate
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		test.AssertNoError(t, streamer.Write("row"))
+	}
+	for i := 0; i < 30; i++ {
+		<-putSignalCh
+	}
+	elapsed := time.Since(start)
+	test.AssertTrue(t, elapsed >= 400*time.Millisecond, "rows written too fast: %v", elapsed)
+
+	stats := streamer.RateLimitStats()
+	test.AssertTrue(t, stats.Throttled >= 400*time.Millisecond, "throttled too little: %v", stats.Throttled)
+	test.AssertEqual(t, uint64(10), stats.ThrottledRows)
+	test.AssertEqual(t, uint64(0), stats.QuotaExceeded)
+}
+
+func TestStreamerRateLimitPerTable(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{
+		PerTable: &RateLimitConfig{RowsPerSecond: 10},
+	})
+	cfg := &StreamerConfig{GlobalRateLimiter: limiter}
+	limitersA := newStreamerLimiters(cfg, "p.d.a")
+	test.AssertEqual(t, 3, len(limitersA))
+	// streamers writing to the same table share the limiter of that table
+	limitersB := newStreamerLimiters(cfg, "p.d.a")
+	test.AssertTrue(t, limitersA[2] == limitersB[2])
+	limitersC := newStreamerLimiters(cfg, "p.d.c")
+	test.AssertTrue(t, limitersA[2] != limitersC[2])
+
+	// the per table limits are respected by all streamers of the table
+	test.AssertEqual(t, time.Duration(0), limitersA[2].Reserve(10, 0))
+	test.AssertTrue(t, limitersB[2].Reserve(1, 0) > 0)
+	test.AssertEqual(t, time.Duration(0), limitersC[2].Reserve(1, 0))
+
+	// without per table limits only the global limiter is used
+	test.AssertEqual(t, 2, len(newStreamerLimiters(&StreamerConfig{GlobalRateLimiter: NewRateLimiter(RateLimitConfig{})}, "p.d.a")))
+}
+
+func TestStreamerQuotaExceededSlowsDown(t *testing.T) {
+	globalLimiter := NewRateLimiter(RateLimitConfig{})
+	hookCh := make(chan time.Duration, 1)
+	client, streamer := newTestRateLimitedStreamer(t, &StreamerConfig{
+		GlobalRateLimiter: globalLimiter,
+		QuotaExceededHook: func(err error, pause time.Duration) {
+			hookCh <- pause
+		},
+	})
+	defer streamer.Close()
+	putSignalCh := make(chan struct{}, 2)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	// other errors do not slow down the streamer
+	client.AddNextError(test.ErrStatic)
+	test.AssertNoError(t, streamer.Write("a"))
+	<-putSignalCh
+	test.AssertEqual(t, time.Duration(0), streamer.limiters[0].Reserve(0, 0))
+
+	// quota errors pause the limiters of the streamer, including the global one
+	client.AddNextError(&googleapi.Error{Code: http.StatusTooManyRequests})
+	test.AssertNoError(t, streamer.Write("b"))
+	<-putSignalCh
+	test.AssertTrue(t, streamer.limiters[0].Reserve(0, 0) > 0)
+	test.AssertTrue(t, globalLimiter.limiter.Reserve(0, 0) > 0)
+
+	// quota errors are counted and reported to the hook
+	test.AssertEqual(t, time.Second, <-hookCh)
+	test.AssertEqual(t, uint64(1), streamer.RateLimitStats().QuotaExceeded)
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/table"
+)
+
+// SchemaMismatchKind defines the kind of incompatibility found between
+// the configured schema and the schema of the destination table.
+type SchemaMismatchKind string
+
+const (
+	// SchemaMismatchMissingRequired indicates that a REQUIRED column of the table
+	// is not defined by the configured schema, making it impossible to write any row.
+	SchemaMismatchMissingRequired = SchemaMismatchKind(schema.MismatchMissingRequired)
+	// SchemaMismatchUnknownField indicates that a field of the configured schema
+	// is not defined as a column of the table.
+	SchemaMismatchUnknownField = SchemaMismatchKind(schema.MismatchUnknownField)
+	// SchemaMismatchType indicates that a field of the configured schema
+	// has a type which cannot be written to the type of its table column.
+	SchemaMismatchType = SchemaMismatchKind(schema.MismatchType)
+	// SchemaMismatchMode indicates that the mode (NULLABLE, REQUIRED or REPEATED)
+	// of a field of the configured schema conflicts with the mode of its table column.
+	SchemaMismatchMode = SchemaMismatchKind(schema.MismatchMode)
+)
+
+// SchemaMismatch is a single incompatibility found between a field of the configured schema
+// and its column in the schema of the destination table.
+type SchemaMismatch struct {
+	// Field is the (dot-separated) path of the field.
+	Field string
+	// Kind defines what kind of incompatibility it is.
+	Kind SchemaMismatchKind
+	// Configured describes the mode and type of the field as defined in the configured schema
+	// (e.g. "REQUIRED STRING"), empty in case the field isn't defined in the configured schema.
+	Configured string
+	// Table describes the mode and type of the field as defined in the table schema
+	// (e.g. "REPEATED INTEGER"), empty in case the field isn't defined in the table schema.
+	Table string
+}
+
+// String implements fmt.Stringer::String
+func (m SchemaMismatch) String() string {
+	return schema.Mismatch{
+		Field:      m.Field,
+		Kind:       schema.MismatchKind(m.Kind),
+		Configured: m.Configured,
+		Table:      m.Table,
+	}.String()
+}
+
+// SchemaMismatchError is the error returned by (*Streamer).ValidateSchema,
+// as well as NewStreamer in case StreamerConfig.ValidateSchema is enabled,
+// in case the configured schema is incompatible with the schema of the destination table.
+type SchemaMismatchError struct {
+	// Table is the fully qualified name of the destination table.
+	Table string
+	// Mismatches contains all incompatibilities found, field by field.
+	Mismatches []SchemaMismatch
+}
+
+// Error implements error::Error
+func (e *SchemaMismatchError) Error() string {
+	mismatches := make([]string, 0, len(e.Mismatches))
+	for _, mismatch := range e.Mismatches {
+		mismatches = append(mismatches, mismatch.String())
+	}
+	return fmt.Sprintf(
+		"schema of table %s is incompatible with the configured schema (%d mismatch(es)): %s",
+		e.Table, len(e.Mismatches), strings.Join(mismatches, "; "),
+	)
+}
+
+// ValidateSchema fetches the schema of the destination table and validates it
+// against the configured schema, returning a *SchemaMismatchError reporting
+// all incompatibilities found, field by field, in case they are not compatible.
+//
+// The configured schema is the BigQuerySchema or ProtobufDescriptor of the StorageClientConfig,
+// the BigQuerySchema of the BatchClientConfig or else the (resolved) schema of the CreateTableConfig.
+// Fields unknown to the table are not reported in case schema evolution is enabled.
+//
+// Other errors are returned in case no schema is configured or the table schema couldn't be fetched.
+func (s *Streamer) ValidateSchema(ctx context.Context) error {
+	return withTableManager(ctx, s.projectID, s.dataSetID, s.tableID, s.cfg.Pool, s.cfg.Logger, func(manager *table.Manager) error {
+		return validateTableSchema(ctx, manager, tableName(s.projectID, s.dataSetID, s.tableID), s.cfg)
+	})
+}
+
+// validateTableSchema validates the schema of the table managed by the given manager
+// against the schema configured in the given (sanitized) config.
+func validateTableSchema(ctx context.Context, manager *table.Manager, name string, cfg *StreamerConfig) error {
+	configuredSchema, opts, err := resolveValidationSchema(cfg)
+	if err != nil {
+		return fmt.Errorf("validate schema: %w", err)
+	}
+	tableSchema, err := manager.Schema(ctx)
+	if err != nil {
+		return fmt.Errorf("validate schema: %w", err)
+	}
+	if err := checkSchema(name, configuredSchema, tableSchema, opts); err != nil {
+		return fmt.Errorf("validate schema: %w", err)
+	}
+	return nil
+}
+
+// checkSchema compares the configured schema against the table schema,
+// returning a *SchemaMismatchError in case they are not compatible.
+func checkSchema(name string, configuredSchema, tableSchema bigquery.Schema, opts schema.CompareOptions) error {
+	mismatches := schema.Compare(configuredSchema, tableSchema, opts)
+	if len(mismatches) == 0 {
+		return nil
+	}
+	err := &SchemaMismatchError{
+		Table:      name,
+		Mismatches: make([]SchemaMismatch, 0, len(mismatches)),
+	}
+	for _, mismatch := range mismatches {
+		err.Mismatches = append(err.Mismatches, SchemaMismatch{
+			Field:      mismatch.Field,
+			Kind:       SchemaMismatchKind(mismatch.Kind),
+			Configured: mismatch.Configured,
+			Table:      mismatch.Table,
+		})
+	}
+	return err
+}
+
+// resolveValidationSchema resolves the schema, configured in the given (sanitized) config,
+// to validate the table schema against, as well as the options to compare them with.
+func resolveValidationSchema(cfg *StreamerConfig) (bigquery.Schema, schema.CompareOptions, error) {
+	opts := schema.CompareOptions{
+		AllowUnknownFields: (cfg.InsertAllClient != nil && cfg.InsertAllClient.EvolveSchema) ||
+			(cfg.StorageClient != nil && cfg.StorageClient.EvolveSchema),
+	}
+	if cfg.StorageClient != nil {
+		if cfg.StorageClient.BigQuerySchema != nil {
+			return *cfg.StorageClient.BigQuerySchema, opts, nil
+		}
+		md, err := encoding.NewMessageDescriptor(cfg.StorageClient.ProtobufDescriptor)
+		if err != nil {
+			return nil, opts, fmt.Errorf("resolve ProtobufDescriptor of StorageClientConfig: %w", err)
+		}
+		derivedSchema, err := schema.FromMessageDescriptor(md)
+		if err != nil {
+			return nil, opts, fmt.Errorf("derive schema from ProtobufDescriptor of StorageClientConfig: %w", err)
+		}
+		// proto values are converted by the Storage Write API to the type of their column
+		opts.StorageWireTypes = true
+		return derivedSchema, opts, nil
+	}
+	if cfg.BatchClient != nil && cfg.BatchClient.BigQuerySchema != nil {
+		return *cfg.BatchClient.BigQuerySchema, opts, nil
+	}
+	if cfg.CreateIfNotExists != nil && cfg.CreateIfNotExists.Schema != nil {
+		return *cfg.CreateIfNotExists.Schema, opts, nil
+	}
+	return nil, opts, internal.ErrValidateSchemaRequiresSchema
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/schema"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding/testdata"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+func TestResolveValidationSchema(t *testing.T) {
+	storageSchema := bigquery.Schema{{Name: "storage", Type: bigquery.StringFieldType}}
+	batchSchema := bigquery.Schema{{Name: "batch", Type: bigquery.StringFieldType}}
+	createSchema := bigquery.Schema{{Name: "create", Type: bigquery.StringFieldType}}
+	testCases := []struct {
+		Cfg             *StreamerConfig
+		ExpectedSchema  bigquery.Schema
+		ExpectedOptions schema.CompareOptions
+	}{
+		{
+			Cfg:            &StreamerConfig{StorageClient: &StorageClientConfig{BigQuerySchema: &storageSchema}},
+			ExpectedSchema: storageSchema,
+		},
+		{
+			Cfg: &StreamerConfig{StorageClient: &StorageClientConfig{
+				ProtobufDescriptor: protodesc.ToDescriptorProto((&testdata.SimpleMessageProto2{}).ProtoReflect().Descriptor()),
+			}},
+			ExpectedSchema: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType, Required: true},
+				{Name: "value", Type: bigquery.IntegerFieldType},
+			},
+			ExpectedOptions: schema.CompareOptions{StorageWireTypes: true},
+		},
+		{
+			Cfg:            &StreamerConfig{BatchClient: &BatchClientConfig{BigQuerySchema: &batchSchema}},
+			ExpectedSchema: batchSchema,
+		},
+		{
+			Cfg: &StreamerConfig{
+				InsertAllClient:   &InsertAllClientConfig{EvolveSchema: true},
+				CreateIfNotExists: &CreateTableConfig{Schema: &createSchema},
+			},
+			ExpectedSchema:  createSchema,
+			ExpectedOptions: schema.CompareOptions{AllowUnknownFields: true},
+		},
+	}
+	for _, testCase := range testCases {
+		resolvedSchema, opts, err := resolveValidationSchema(testCase.Cfg)
+		test.AssertNoError(t, err)
+		test.AssertEqual(t, testCase.ExpectedSchema, resolvedSchema)
+		test.AssertEqual(t, testCase.ExpectedOptions, opts)
+	}
+}
+
+func TestSanitizeStreamerConfigValidateSchemaRequiresSchema(t *testing.T) {
+	_, err := sanitizeStreamerConfig(&StreamerConfig{ValidateSchema: true})
+	test.AssertIsError(t, err, internal.ErrValidateSchemaRequiresSchema)
+
+	sanCfg, err := sanitizeStreamerConfig(&StreamerConfig{
+		ValidateSchema: true,
+		StorageClient:  &StorageClientConfig{BigQuerySchema: new(bigquery.Schema)},
+	})
+	test.AssertNoError(t, err)
+	test.AssertTrue(t, sanCfg.ValidateSchema)
+}
+
+func TestCheckSchema(t *testing.T) {
+	tableSchema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "value", Type: bigquery.IntegerFieldType},
+	}
+	test.AssertNoError(t, checkSchema("p.d.t", tableSchema, tableSchema, schema.CompareOptions{}))
+
+	err := checkSchema("p.d.t", bigquery.Schema{
+		{Name: "value", Type: bigquery.StringFieldType},
+	}, tableSchema, schema.CompareOptions{})
+	test.AssertError(t, err)
+	var mismatchErr *SchemaMismatchError
+	test.AssertTrue(t, errors.As(err, &mismatchErr))
+	test.AssertEqual(t, "p.d.t", mismatchErr.Table)
+	test.AssertEqual(t, []SchemaMismatch{
+		{Field: "value", Kind: SchemaMismatchType, Configured: "NULLABLE STRING", Table: "NULLABLE INTEGER"},
+		{Field: "name", Kind: SchemaMismatchMissingRequired, Table: "REQUIRED STRING"},
+	}, mismatchErr.Mismatches)
+	test.AssertEqual(
+		t,
+		"schema of table p.d.t is incompatible with the configured schema (2 mismatch(es)): "+
+			"value: type incompatibility (configured: NULLABLE STRING, table: NULLABLE INTEGER); "+
+			"name: missing required column (table: REQUIRED STRING)",
+		err.Error(),
+	)
+}
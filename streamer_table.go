@@ -0,0 +1,92 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	bqbase "github.com/OTA-Insight/bqwriter/internal/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/table"
+	"github.com/OTA-Insight/bqwriter/log"
+)
+
+// setupTable prepares the destination table of a Streamer,
+// creating it (and its dataset) in case it does not exist yet
+// and validating its schema, in case the (sanitized) config requests it to do so.
+func setupTable(ctx context.Context, projectID, dataSetID, tableID string, cfg *StreamerConfig) error {
+	if cfg.CreateIfNotExists == nil && !cfg.ValidateSchema {
+		return nil // nothing to do
+	}
+	return withTableManager(ctx, projectID, dataSetID, tableID, cfg.Pool, cfg.Logger, func(manager *table.Manager) error {
+		if cfg.CreateIfNotExists != nil {
+			datasetMD, tableMD := createTableMetadata(cfg.CreateIfNotExists, time.Now())
+			if err := manager.EnsureExists(ctx, datasetMD, tableMD); err != nil {
+				return fmt.Errorf("setup table: %w", err)
+			}
+		}
+		if cfg.ValidateSchema {
+			if err := validateTableSchema(ctx, manager, tableName(projectID, dataSetID, tableID), cfg); err != nil {
+				return fmt.Errorf("setup table: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// withTableManager calls the given function with a table manager for the given table,
+// closing (or releasing, in case it was acquired from the given optional pool)
+// the BigQuery client used by that manager once the function returns.
+func withTableManager(ctx context.Context, projectID, dataSetID, tableID string, pool *Pool, logger log.Logger, f func(manager *table.Manager) error) error {
+	client, closeClient, err := bqbase.NewBigQueryClient(ctx, pool.clientPool(), projectID)
+	if err != nil {
+		return fmt.Errorf("create BQ client: %w", err)
+	}
+	defer func() {
+		if err := closeClient(); err != nil {
+			logger.Errorf("close BQ client used for table management: %v", err)
+		}
+	}()
+	manager, err := table.NewManager(client, dataSetID, tableID, logger)
+	if err != nil {
+		return fmt.Errorf("create table manager: %w", err)
+	}
+	return f(manager)
+}
+
+// tableName returns the fully qualified name of a table.
+func tableName(projectID, dataSetID, tableID string) string {
+	return fmt.Sprintf("%s.%s.%s", projectID, dataSetID, tableID)
+}
+
+// createTableMetadata creates the metadata used to create the dataset and table,
+// based on the given (sanitized) config, relative to the given (creation) time.
+func createTableMetadata(cfg *CreateTableConfig, now time.Time) (*bigquery.DatasetMetadata, *bigquery.TableMetadata) {
+	datasetMD := &bigquery.DatasetMetadata{
+		Location: cfg.DataSetLocation,
+	}
+	tableMD := &bigquery.TableMetadata{
+		Schema:           *cfg.Schema,
+		TimePartitioning: cfg.TimePartitioning,
+		Clustering:       cfg.Clustering,
+		Labels:           cfg.Labels,
+	}
+	if cfg.Expiration > 0 {
+		tableMD.ExpirationTime = now.Add(cfg.Expiration)
+	}
+	return datasetMD, tableMD
+}
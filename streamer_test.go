@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -58,25 +60,52 @@ func TestNewStreamerInputErrors(t *testing.T) {
 }
 
 // stubBQClient is an in-memory stub client for the bigquery.Client interface,
-// allowing us to see what data is written into it
+// allowing us to see what data is written into it.
+//
+// It is safe for concurrent use, as it can be shared by multiple workers.
 type stubBQClient struct {
+	mu sync.Mutex
+
 	rows         []interface{}
+	batchCount   int
 	flushCount   int
 	flushNextPut bool
 	nextErrors   []error
 	putSignal    chan<- struct{}
+	throttler    bigquery.Throttler
 }
 
 // Put implements bigquery.Client::Put
 func (sbqc *stubBQClient) Put(data interface{}) (bool, error) {
-	defer func() {
-		if sbqc.putSignal != nil {
-			sbqc.putSignal <- struct{}{}
+	// the stub sends its rows when they are put, so that is when it is throttled
+	sbqc.mu.Lock()
+	throttler := sbqc.throttler
+	sbqc.mu.Unlock()
+	if throttler != nil {
+		rows := []interface{}{data}
+		if batch, ok := data.([]interface{}); ok {
+			rows = batch
 		}
-	}()
-	if len(sbqc.nextErrors) > 0 {
-		err := sbqc.nextErrors[0]
-		sbqc.nextErrors = sbqc.nextErrors[1:]
+		size := 0
+		if throttler.LimitsBytes() {
+			for _, row := range rows {
+				size += len(fmt.Sprint(row))
+			}
+		}
+		throttler.Throttle(len(rows), size)
+	}
+	sbqc.mu.Lock()
+	putSignal := sbqc.putSignal
+	flushed, err := sbqc.putLocked(data)
+	sbqc.mu.Unlock()
+	if putSignal != nil {
+		putSignal <- struct{}{}
+	}
+	return flushed, err
+}
+
+func (sbqc *stubBQClient) putLocked(data interface{}) (bool, error) {
+	if err := sbqc.nextErrorLocked(); err != nil {
 		return false, err
 	}
 	if rows, ok := data.([]interface{}); ok {
@@ -86,15 +115,27 @@ func (sbqc *stubBQClient) Put(data interface{}) (bool, error) {
 	}
 	if sbqc.flushNextPut {
 		sbqc.flushNextPut = false
-		return true, sbqc.Flush()
+		return true, sbqc.flushLocked()
 	}
 	return false, nil
 }
 
+// PutBatch implements bigquery.Client::PutBatch
+func (sbqc *stubBQClient) PutBatch(rows []interface{}) (bool, error) {
+	sbqc.mu.Lock()
+	sbqc.batchCount++
+	sbqc.mu.Unlock()
+	return sbqc.Put(rows)
+}
+
 func (sbqc *stubBQClient) Flush() error {
-	if len(sbqc.nextErrors) > 0 {
-		err := sbqc.nextErrors[0]
-		sbqc.nextErrors = sbqc.nextErrors[1:]
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
+	return sbqc.flushLocked()
+}
+
+func (sbqc *stubBQClient) flushLocked() error {
+	if err := sbqc.nextErrorLocked(); err != nil {
 		return err
 	}
 	sbqc.flushCount += 1
@@ -103,33 +144,69 @@ func (sbqc *stubBQClient) Flush() error {
 
 // Close implements bigquery.Client::Close
 func (sbqc *stubBQClient) Close() error {
-	if len(sbqc.nextErrors) > 0 {
-		err := sbqc.nextErrors[0]
-		sbqc.nextErrors = sbqc.nextErrors[1:]
-		return err
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
+	return sbqc.nextErrorLocked()
+}
+
+func (sbqc *stubBQClient) nextErrorLocked() error {
+	if len(sbqc.nextErrors) == 0 {
+		return nil
 	}
-	return nil
+	err := sbqc.nextErrors[0]
+	sbqc.nextErrors = sbqc.nextErrors[1:]
+	return err
+}
+
+// SetThrottler implements bigquery.Throttled::SetThrottler
+func (sbqc *stubBQClient) SetThrottler(throttler bigquery.Throttler) {
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
+	sbqc.throttler = throttler
 }
 
 func (sbqc *stubBQClient) AddNextError(err error) {
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
 	sbqc.nextErrors = append(sbqc.nextErrors, err)
 }
 
 func (sbqc *stubBQClient) FlushNextPut() {
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
 	sbqc.flushNextPut = true
 }
 
 func (sbqc *stubBQClient) SubscribeToPutSignal(ch chan<- struct{}) {
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
 	sbqc.putSignal = ch
 }
 
+// Rows returns a copy of all rows written to the client.
+func (sbqc *stubBQClient) Rows() []interface{} {
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
+	return append([]interface{}(nil), sbqc.rows...)
+}
+
+// BatchCount returns the amount of times PutBatch was called.
+func (sbqc *stubBQClient) BatchCount() int {
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
+	return sbqc.batchCount
+}
+
 func (sbqc *stubBQClient) AssertFlushCount(t *testing.T, expected int) {
+	sbqc.mu.Lock()
+	defer sbqc.mu.Unlock()
 	test.AssertEqual(t, sbqc.flushCount, expected)
 }
 
 func (sbqc *stubBQClient) AssertStringSlice(t *testing.T, expected []string) {
-	got := make([]string, 0, len(sbqc.rows))
-	for _, row := range sbqc.rows {
+	rows := sbqc.Rows()
+	got := make([]string, 0, len(rows))
+	for _, row := range rows {
 		s, ok := row.(string)
 		if !ok {
 			t.Errorf("unexpected value (non-string): %v", row)
@@ -145,8 +222,9 @@ func (sbqc *stubBQClient) AssertStringSlice(t *testing.T, expected []string) {
 }
 
 func (sbqc *stubBQClient) AssertAnyStringSlice(t *testing.T, expectedSlice ...[]string) {
-	got := make([]string, 0, len(sbqc.rows))
-	for _, row := range sbqc.rows {
+	rows := sbqc.Rows()
+	got := make([]string, 0, len(rows))
+	for _, row := range rows {
 		s, ok := row.(string)
 		if !ok {
 			t.Errorf("unexpected value (non-string): %v", row)
@@ -177,7 +255,7 @@ func newTestStreamer(ctx context.Context, t *testing.T, cfg testStreamerConfig)
 		return client, nil
 	}
 	streamer, err := newStreamerWithClientBuilder(
-		ctx, clientBuilder,
+		ctx, clientBuilder, nil,
 		"a", "b", "c",
 		&StreamerConfig{
 			WorkerCount:     cfg.WorkerCount,
@@ -204,6 +282,111 @@ func TestStreamerFlowStandard(t *testing.T) {
 	client.AssertStringSlice(t, []string{"hello", "world"})
 }
 
+func TestStreamerWriteBatch(t *testing.T) {
+	client, streamer := newTestStreamer(context.Background(), t, testStreamerConfig{})
+	defer streamer.Close()
+	putSignalCh := make(chan struct{}, 1)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	// the rows are written as a single batch
+	test.AssertNoError(t, streamer.WriteBatch([]interface{}{"hello", "batched", "world"}))
+	<-putSignalCh
+	client.AssertStringSlice(t, []string{"hello", "batched", "world"})
+	test.AssertEqual(t, 1, client.BatchCount())
+
+	// an empty batch is a no-op
+	test.AssertNoError(t, streamer.WriteBatch(nil))
+	test.AssertNoError(t, streamer.WriteBatch([]interface{}{}))
+}
+
+func TestStreamerWriteBatchErrorNilRows(t *testing.T) {
+	_, streamer := newTestStreamer(context.Background(), t, testStreamerConfig{})
+	defer streamer.Close()
+	err := streamer.WriteBatch([]interface{}{"hello", nil, "world", nil})
+	test.AssertIsError(t, err, internal.ErrInvalidParam)
+	test.AssertTrue(t, strings.Contains(err.Error(), "row(s) #1, #3"))
+}
+
+func TestStreamerWriteBatchErrorAlreadyClosed(t *testing.T) {
+	_, streamer := newTestStreamer(context.Background(), t, testStreamerConfig{})
+	streamer.Close()
+	test.AssertError(t, streamer.WriteBatch([]interface{}{"hello"}))
+}
+
+func TestStreamerShardKeyPreservesOrderPerKey(t *testing.T) {
+	var clients []*stubBQClient
+	putSignalCh := make(chan struct{}, 100)
+	streamer, err := newStreamerWithClientBuilder(
+		context.Background(),
+		func(ctx context.Context, projectID, dataSetID, tableID string, logger log.Logger, insertAllCfg *InsertAllClientConfig, storageCfg *StorageClientConfig, batchCfg *BatchClientConfig) (bigquery.Client, error) {
+			// workers are created sequentially, each with their own client
+			client := new(stubBQClient)
+			client.SubscribeToPutSignal(putSignalCh)
+			clients = append(clients, client)
+			return client, nil
+		},
+		nil,
+		"a", "b", "c",
+		&StreamerConfig{
+			WorkerCount: 4,
+			ShardKey: func(row interface{}) string {
+				return strings.SplitN(row.(string), ":", 2)[0]
+			},
+		},
+	)
+	test.AssertNoErrorFatal(t, err)
+	test.AssertEqual(t, 4, len(clients))
+
+	keys := []string{"alpha", "beta", "gamma", "delta", "epsilon"}
+	var batch []interface{}
+	for i := 0; i < 20; i++ {
+		for _, key := range keys {
+			row := fmt.Sprintf("%s:%02d", key, i)
+			if i%2 == 0 {
+				test.AssertNoError(t, streamer.Write(row))
+			} else {
+				batch = append(batch, row)
+			}
+		}
+		if i%2 == 1 {
+			test.AssertNoError(t, streamer.WriteBatch(batch))
+			batch = nil
+		}
+	}
+	// each batch is split into a job per worker the keys are hashed to
+	shards := make(map[int]struct{})
+	for _, key := range keys {
+		shards[streamer.shardFor(key+":")] = struct{}{}
+	}
+	for i := 0; i < 10*len(keys)+10*len(shards); i++ {
+		<-putSignalCh
+	}
+	streamer.Close()
+
+	// all rows of a key are written by a single worker, in the order they were written
+	rowCount := 0
+	for _, key := range keys {
+		owner := -1
+		for i, client := range clients {
+			var keyRows []string
+			for _, row := range client.Rows() {
+				if strings.HasPrefix(row.(string), key+":") {
+					keyRows = append(keyRows, row.(string))
+				}
+			}
+			if len(keyRows) == 0 {
+				continue
+			}
+			test.AssertEqual(t, -1, owner, "rows of key %s written by multiple workers", key)
+			owner = i
+			test.AssertEqual(t, 20, len(keyRows), key)
+			test.AssertTrue(t, sort.StringsAreSorted(keyRows), "rows of key %s out of order: %v", key, keyRows)
+			rowCount += len(keyRows)
+		}
+	}
+	test.AssertEqual(t, 100, rowCount)
+}
+
 func TestStreamerWriteErrorAlreadyClosed(t *testing.T) {
 	_, streamer := newTestStreamer(context.Background(), t, testStreamerConfig{})
 	streamer.Close()
@@ -0,0 +1,177 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"google.golang.org/protobuf/proto"
+)
+
+// TypedStreamer is a Streamer which only accepts rows of type T, allowing the compiler to catch rows
+// of the wrong type, with the type T itself being validated against the configured client
+// when the streamer is created, rather than type mismatches only surfacing later as (async) worker errors.
+type TypedStreamer[T any] struct {
+	streamer *Streamer
+}
+
+// NewTypedStreamer creates a new TypedStreamer Client, see NewStreamer for more information.
+//
+// An error is returned in case rows of type T cannot be written using the client configured by the StreamerConfig,
+// which requires T to be a proto.Message for a Storage client with a ProtobufDescriptor, a struct (pointer) or
+// bigquery.ValueSaver for an InsertAll client and an io.Reader for a Batch client (or a row, such as a struct (pointer),
+// in case the rows are encoded by the batch client itself). Interface types are only accepted in case
+// every value of that type is accepted, so T cannot be interface{}.
+func NewTypedStreamer[T any](ctx context.Context, projectID, dataSetID, tableID string, cfg *StreamerConfig) (*TypedStreamer[T], error) {
+	// the config is sanitized here only to validate the row type against it,
+	// while the original config is passed to the streamer, as sanitizing it twice is not guaranteed to be a no-op
+	sanCfg, err := sanitizeStreamerConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("typed streamer client creation: sanitize streamer config: %w", err)
+	}
+	if err := validateRowType(reflect.TypeOf((*T)(nil)).Elem(), sanCfg); err != nil {
+		return nil, fmt.Errorf("typed streamer client creation: %w", err)
+	}
+	streamer, err := NewStreamer(ctx, projectID, dataSetID, tableID, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedStreamer[T]{streamer: streamer}, nil
+}
+
+// Write a row of data to a BQ table within the streamer's project,
+// see (*Streamer).Write for more information.
+func (s *TypedStreamer[T]) Write(data T) error {
+	return s.streamer.Write(data)
+}
+
+// WriteBatch writes multiple rows of data to a BQ table within the streamer's project,
+// see (*Streamer).WriteBatch for more information.
+func (s *TypedStreamer[T]) WriteBatch(data []T) error {
+	if len(data) == 0 {
+		return nil
+	}
+	rows := make([]interface{}, len(data))
+	for i, row := range data {
+		rows[i] = row
+	}
+	return s.streamer.WriteBatch(rows)
+}
+
+// SuppressedDuplicates returns the amount of rows dropped as duplicates,
+// see (*Streamer).SuppressedDuplicates for more information.
+func (s *TypedStreamer[T]) SuppressedDuplicates() uint64 {
+	return s.streamer.SuppressedDuplicates()
+}
+
+// Close closes the streamer and all its worker goroutines.
+func (s *TypedStreamer[T]) Close() {
+	s.streamer.Close()
+}
+
+var (
+	protoMessageType  = reflect.TypeOf((*proto.Message)(nil)).Elem()
+	valueSaverType    = reflect.TypeOf((*bq.ValueSaver)(nil)).Elem()
+	readerType        = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	valueMapType      = reflect.TypeOf(map[string]bq.Value(nil))
+	interfaceMapType  = reflect.TypeOf(map[string]interface{}(nil))
+	bytesType         = reflect.TypeOf([]byte(nil))
+)
+
+// validateRowType validates whether or not rows of the given type can be written using
+// the client configured by the given (sanitized) config, returning an error if not.
+// Defined as a function to keep its logic contained and well tested.
+func validateRowType(rowType reflect.Type, cfg *StreamerConfig) error {
+	switch {
+	case cfg.StorageClient != nil && cfg.StorageClient.ProtobufDescriptor != nil:
+		if rowType.Implements(protoMessageType) {
+			return nil
+		}
+		return fmt.Errorf("%w: %v is not a proto.Message, as required by the Storage client", internal.ErrUnsupportedRowType, rowType)
+
+	case cfg.StorageClient != nil:
+		// the BigQuery schema based encoder accepts a wide variety of rows
+		if isRowType(rowType) || rowType == bytesType || rowType.Kind() == reflect.String ||
+			rowType.Implements(jsonMarshalerType) || rowType.Implements(stringerType) {
+			return nil
+		}
+		return fmt.Errorf(
+			"%w: %v is not a struct (pointer), value map, bigquery.ValueSaver, Json or text proto message, as required by the Storage client",
+			internal.ErrUnsupportedRowType, rowType,
+		)
+
+	case cfg.BatchClient != nil:
+		if rowType.Implements(readerType) {
+			return nil
+		}
+		if batchClientEncodesRows(cfg.BatchClient) && isRowType(rowType) {
+			return nil
+		}
+		return fmt.Errorf("%w: %v is not an io.Reader (or row encoded by the client), as required by the Batch client", internal.ErrUnsupportedRowType, rowType)
+
+	default:
+		if rowType.Implements(valueSaverType) {
+			return nil
+		}
+		if structType, ok := structRowType(rowType); ok {
+			// validate the struct upfront, as it would otherwise fail for each row
+			if _, err := bq.InferSchema(reflect.New(structType).Elem().Interface()); err != nil {
+				return fmt.Errorf("%w: %v cannot be written by the InsertAll client: %v", internal.ErrUnsupportedRowType, rowType, err)
+			}
+			return nil
+		}
+		return fmt.Errorf("%w: %v is not a struct (pointer) or bigquery.ValueSaver, as required by the InsertAll client", internal.ErrUnsupportedRowType, rowType)
+	}
+}
+
+// isRowType returns true in case the given type is a struct (pointer), value map or bigquery.ValueSaver,
+// the types which can be converted to a row using a BigQuery schema.
+func isRowType(rowType reflect.Type) bool {
+	if rowType == valueMapType || rowType == interfaceMapType || rowType.Implements(valueSaverType) {
+		return true
+	}
+	_, ok := structRowType(rowType)
+	return ok
+}
+
+// structRowType returns the struct type of the given struct (pointer) type,
+// returning false in case it is not a struct (pointer) type.
+func structRowType(rowType reflect.Type) (reflect.Type, bool) {
+	if rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	return rowType, rowType.Kind() == reflect.Struct
+}
+
+// batchClientEncodesRows returns true in case the batch client configured by the given (sanitized) config
+// encodes rows itself, which is the case for the Avro and Parquet formats, and CSV in case a BigQuery schema is defined.
+func batchClientEncodesRows(cfg *BatchClientConfig) bool {
+	switch cfg.SourceFormat {
+	case bq.Avro, bq.Parquet:
+		return true
+	case bq.CSV:
+		return cfg.BigQuerySchema != nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 OTA Insight Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqwriter
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/OTA-Insight/bqwriter/internal"
+	"github.com/OTA-Insight/bqwriter/internal/bigquery/storage/encoding/testdata"
+	"github.com/OTA-Insight/bqwriter/internal/test"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+type typedTestRow struct {
+	Name  string
+	Count int64
+}
+
+type typedTestUnsupportedRow struct {
+	Channel chan int
+}
+
+type typedTestValueSaver struct{}
+
+func (typedTestValueSaver) Save() (map[string]bq.Value, string, error) {
+	return nil, "", nil
+}
+
+func TestValidateRowType(t *testing.T) {
+	schema := &bq.Schema{{Name: "Name", Type: bq.StringFieldType}}
+	protoCfg := &StreamerConfig{StorageClient: &StorageClientConfig{
+		ProtobufDescriptor: protodesc.ToDescriptorProto((&testdata.SimpleMessageProto2{}).ProtoReflect().Descriptor()),
+	}}
+	storageCfg := &StreamerConfig{StorageClient: &StorageClientConfig{BigQuerySchema: schema}}
+	insertAllCfg := &StreamerConfig{InsertAllClient: new(InsertAllClientConfig)}
+	jsonBatchCfg := &StreamerConfig{BatchClient: &BatchClientConfig{SourceFormat: bq.JSON}}
+	avroBatchCfg := &StreamerConfig{BatchClient: &BatchClientConfig{SourceFormat: bq.Avro, BigQuerySchema: schema}}
+	csvBatchCfg := &StreamerConfig{BatchClient: &BatchClientConfig{SourceFormat: bq.CSV}}
+
+	testCases := []struct {
+		Config    *StreamerConfig
+		RowType   reflect.Type
+		Supported bool
+	}{
+		// storage client using a protobuf descriptor
+		{protoCfg, reflect.TypeOf(&testdata.SimpleMessageProto2{}), true},
+		{protoCfg, reflect.TypeOf((*proto.Message)(nil)).Elem(), true},
+		{protoCfg, reflect.TypeOf(testdata.SimpleMessageProto2{}), false},
+		{protoCfg, reflect.TypeOf(typedTestRow{}), false},
+		{protoCfg, reflect.TypeOf((*interface{})(nil)).Elem(), false},
+		// storage client using a BigQuery schema
+		{storageCfg, reflect.TypeOf(typedTestRow{}), true},
+		{storageCfg, reflect.TypeOf(&typedTestRow{}), true},
+		{storageCfg, reflect.TypeOf(map[string]bq.Value{}), true},
+		{storageCfg, reflect.TypeOf(map[string]interface{}{}), true},
+		{storageCfg, reflect.TypeOf(typedTestValueSaver{}), true},
+		{storageCfg, reflect.TypeOf([]byte{}), true},
+		{storageCfg, reflect.TypeOf(""), true},
+		{storageCfg, reflect.TypeOf(42), false},
+		{storageCfg, reflect.TypeOf(map[string]int{}), false},
+		// insertAll client
+		{insertAllCfg, reflect.TypeOf(typedTestRow{}), true},
+		{insertAllCfg, reflect.TypeOf(&typedTestRow{}), true},
+		{insertAllCfg, reflect.TypeOf(typedTestValueSaver{}), true},
+		{insertAllCfg, reflect.TypeOf((*bq.ValueSaver)(nil)).Elem(), true},
+		{insertAllCfg, reflect.TypeOf(typedTestUnsupportedRow{}), false},
+		{insertAllCfg, reflect.TypeOf(map[string]bq.Value{}), false},
+		{insertAllCfg, reflect.TypeOf(""), false},
+		// batch client
+		{jsonBatchCfg, reflect.TypeOf((*io.Reader)(nil)).Elem(), true},
+		{jsonBatchCfg, reflect.TypeOf(&strings.Reader{}), true},
+		{jsonBatchCfg, reflect.TypeOf(KeyedReader{}), true},
+		{jsonBatchCfg, reflect.TypeOf(typedTestRow{}), false},
+		{avroBatchCfg, reflect.TypeOf((*io.Reader)(nil)).Elem(), true},
+		{avroBatchCfg, reflect.TypeOf(&typedTestRow{}), true},
+		{avroBatchCfg, reflect.TypeOf(map[string]interface{}{}), true},
+		{avroBatchCfg, reflect.TypeOf([]byte{}), false},
+		{csvBatchCfg, reflect.TypeOf(typedTestRow{}), false},
+	}
+	for _, testCase := range testCases {
+		err := validateRowType(testCase.RowType, testCase.Config)
+		if testCase.Supported {
+			test.AssertNoError(t, err, testCase.RowType)
+		} else {
+			test.AssertIsError(t, err, internal.ErrUnsupportedRowType, testCase.RowType)
+		}
+	}
+}
+
+func TestNewTypedStreamerUnsupportedRowType(t *testing.T) {
+	streamer, err := NewTypedStreamer[*typedTestRow](
+		context.Background(),
+		"a", "b", "c",
+		&StreamerConfig{BatchClient: new(BatchClientConfig)},
+	)
+	test.AssertIsError(t, err, internal.ErrUnsupportedRowType)
+	test.AssertNil(t, streamer)
+
+	// invalid configs are still reported as such
+	_, err = NewTypedStreamer[io.Reader](
+		context.Background(),
+		"a", "b", "c",
+		&StreamerConfig{BatchClient: &BatchClientConfig{SourceFormat: bq.Avro}},
+	)
+	test.AssertIsError(t, err, internal.ErrAutoDetectSchemaNotSupported)
+}
+
+func TestTypedStreamerWrite(t *testing.T) {
+	client, streamer := newTestStreamer(context.Background(), t, testStreamerConfig{WorkerCount: 1})
+	typedStreamer := &TypedStreamer[string]{streamer: streamer}
+	putSignalCh := make(chan struct{}, 2)
+	client.SubscribeToPutSignal(putSignalCh)
+
+	test.AssertNoError(t, typedStreamer.Write("hello"))
+	test.AssertNoError(t, typedStreamer.WriteBatch([]string{"typed", "world"}))
+	test.AssertNoError(t, typedStreamer.WriteBatch(nil))
+	for i := 0; i < 2; i++ {
+		<-putSignalCh
+	}
+	client.AssertStringSlice(t, []string{"hello", "typed", "world"})
+	test.AssertEqual(t, 1, client.BatchCount())
+
+	typedStreamer.Close()
+	test.AssertError(t, typedStreamer.Write("closed"))
+	test.AssertError(t, typedStreamer.WriteBatch([]string{"closed"}))
+}